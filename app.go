@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"embed"
 	"flag"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/yhat/middleware"
@@ -15,6 +22,12 @@ import (
 
 var defaultAddr = ":4040"
 
+// assets holds the static content and templates, so the binary can be run
+// without them unless -public and -templates are given.
+//
+//go:embed public templates
+var assets embed.FS
+
 func main() {
 
 	httpAddr := flag.String("http", defaultAddr, "HTTP address for the app")
@@ -23,28 +36,187 @@ func main() {
 	tlsCert := flag.String("tlscert", "", "cert.crt file for TLS")
 	tlsKey := flag.String("tlskey", "", "cert.key file for TLS")
 
-	public := flag.String("public", "./public", "`path` of the directory holding static content")
-	templates := flag.String("templates", "./templates", "`path` of the directory holding app templates")
+	basePath := flag.String("base-path", "", "`path` prefix the app is served under behind a reverse proxy, e.g. /resize")
+	public := flag.String("public", "", "`path` of the directory holding static content (default embedded)")
+	templates := flag.String("templates", "", "`path` of the directory holding app templates (default embedded)")
+	locales := flag.String("locales", "", "`path` of a directory holding a JSON message catalog per locale, e.g. fr.json")
 	reloadTmpl := flag.Bool("reload-templates", false, "should the app recompile templates on each request")
+	dryRun := flag.Bool("dryrun", false, "simulate changes to instances instead of making them")
+	readOnly := flag.Bool("read-only", false, "disable all changes to instances, even simulated ones, e.g. for demos")
+	ec2Endpoint := flag.String("ec2-endpoint", "", "`URL` to send all EC2 requests to instead of AWS, e.g. LocalStack")
+	regionEndpoints := flag.String("region-endpoints", "", "comma separated `list` of region=URL pairs overriding the EC2 endpoint of single regions")
+	typesURL := flag.String("types-url", resize.DefaultInstanceTypeURL, "`URL` of the page to scrape instance types from")
+	typesFallbackURL := flag.String("types-fallback-url", "", "`URL` of an archived copy of the instance types page, scraped if the page can't be parsed")
+	typesSource := flag.String("types-source", "scraper", "`source` of instance types, \"scraper\" or \"pricelist\" for the AWS Price List offer file")
+	priceListRegion := flag.String("pricelist-region", "us-east-1", "`region` whose offer file instance types are read from with -types-source=pricelist")
+	priceListURL := flag.String("pricelist-url", "", "`URL` of offer files with %s in place of the region, instead of the AWS Price List API")
+	scrapeProxy := flag.String("scrape-proxy", "", "`URL` of the proxy instance types and prices are fetched through (default $HTTPS_PROXY)")
+	disableScraper := flag.Bool("disable-scraper", false, "never scrape instance types, offering only those of -types-file")
+	refreshTypes := flag.Bool("refresh-types", false, "fetch instance types in the background before the cached ones expire")
+	typesFile := flag.String("types-file", "", "JSON `file` holding the instance types offered when -disable-scraper is set")
+	typesBaseline := flag.String("types-baseline", "", "JSON `file` of known-good instance types to compare the current types against at /diagnostics/drift")
+	awsIdleConns := flag.Int("aws-idle-conns", resize.DefaultTransportOptions.MaxIdleConns, "idle connections to AWS kept for reuse")
+	awsIdleConnsPerHost := flag.Int("aws-idle-conns-per-host", resize.DefaultTransportOptions.MaxIdleConnsPerHost, "idle connections kept for reuse to each AWS endpoint")
+	awsTimeout := flag.Duration("aws-timeout", resize.DefaultTransportOptions.ResponseHeaderTimeout, "`duration` waited for AWS to respond to a request")
+	regions := flag.String("regions", "", "comma separated `list` of regions to list instances in (default all)")
+	recommendLookback := flag.Duration("recommend-lookback", resize.DefaultRecommendLookback, "`duration` of utilization history resize recommendations are based on")
+
+	sessionkey := flag.String("sessionkey", "", "secret key for session cookies and API tokens, at least 32 bytes long (default $"+resize.SessionKeyEnv+", or random)")
+	sessionkeyFile := flag.String("sessionkey-file", "", "`file` holding the -sessionkey")
+	secureCookies := flag.Bool("secure-cookies", false, "only send session cookies over HTTPS")
+	sessionMaxAge := flag.Duration("session-maxage", 30*24*time.Hour, "idle `duration` after which sessions expire")
+	tokenMaxAge := flag.Duration("token-maxage", resize.DefaultTokenMaxAge, "`duration` for which API tokens are valid")
+	redisAddr := flag.String("redis", "", "`address` of a Redis server to store sessions in")
+	loginAttempts := flag.Int("login-attempts", resize.DefaultLoginRateLimit.Attempts, "failed logins allowed per client IP within -login-window")
+	loginWindow := flag.Duration("login-window", resize.DefaultLoginRateLimit.Window, "`duration` over which failed logins are counted")
+	allowServerCreds := flag.Bool("allow-server-creds", false, "offer logging in with the server's own AWS credentials, only if access to the app is otherwise restricted")
+	skipCredentialCheck := flag.Bool("skip-credential-check", false, "accept login credentials without checking them with AWS, for testing offline")
+	trustProxy := flag.Bool("trust-proxy", false, "read client IPs from X-Forwarded-For, when behind a reverse proxy")
 
-	sessionkey := flag.String("sessionkey", "", "secret key for session cookies")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "`duration` to wait for in-flight requests when shutting down")
 
 	accessLog := flag.String("accesslog", "", "file for access log")
+	tagResized := flag.Bool("tag-resized", false, "tag instances with who resized them, when and from which type")
+	requireReason := flag.Bool("require-reason", false, "require a reason to be given for every resize")
+	minReasonLength := flag.Int("min-reason-length", 0, "number of characters a required resize reason must have")
+	auditLog := flag.String("auditlog", "", "file to append a JSON record of every resize to")
+	jsonLog := flag.Bool("jsonlog", false, "write app logs as JSON records")
 
 	flag.Parse()
 
-	var store *sessions.CookieStore
-	if *sessionkey != "" {
-		store = sessions.NewCookieStore([]byte(*sessionkey))
+	key, err := sessionKey(*sessionkey, *sessionkeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var store sessions.Store
+	if *redisAddr != "" {
+		if key == nil {
+			log.Fatal("a -sessionkey is required when storing sessions in Redis")
+		}
+		store = resize.NewRedisStore(*redisAddr, key)
+	} else if key != nil {
+		store = sessions.NewCookieStore(key)
+	} else {
+		log.Printf("no -sessionkey given, sessions won't survive a restart")
 	}
 
-	app, err := resize.NewApp(*public, *templates, store)
+	app, err := newApp(*public, *templates, store)
 	if err != nil {
 		log.Fatal(err)
 	}
+	switch *typesSource {
+	case "scraper":
+		src, err := resize.NewWebScraperSource(*typesURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *typesFallbackURL != "" {
+			fallback, err := resize.NewWebScraperSource(*typesFallbackURL)
+			if err != nil {
+				log.Fatal(err)
+			}
+			src.FallbackURL = fallback.URL
+		}
+		app.Source = src
+	case "pricelist":
+		src, err := resize.NewPriceListSource(*priceListRegion, *priceListURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Source = src
+	default:
+		log.Fatalf("unknown -types-source '%s': expected scraper or pricelist", *typesSource)
+	}
+	app.DisableScraper = *disableScraper
+	if *typesFile != "" {
+		if !*disableScraper {
+			log.Fatal("-types-file requires -disable-scraper")
+		}
+		file, err := os.Open(*typesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.StaticTypes, err = resize.ReadInstanceTypes(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *typesBaseline != "" {
+		file, err := os.Open(*typesBaseline)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Baseline, err = resize.ReadInstanceTypes(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("-types-baseline: %v", err)
+		}
+	}
+	if *locales != "" {
+		app.Catalogs, err = resize.LoadCatalogs(os.DirFS(*locales))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	app.ReloadTemplates = *reloadTmpl
-	h := middleware.GZip(app)
-
+	app.BasePath = *basePath
+	app.DryRun = *dryRun
+	app.ReadOnly = *readOnly
+	sessionOpts := resize.DefaultSessionOptions
+	sessionOpts.Secure = *secureCookies
+	sessionOpts.MaxAge = int(sessionMaxAge.Seconds())
+	app.SessionOptions = &sessionOpts
+	app.Endpoint = *ec2Endpoint
+	app.TokenKey = key
+	app.TokenMaxAge = *tokenMaxAge
+	app.LoginRateLimit = &resize.LoginRateLimit{Attempts: *loginAttempts, Window: *loginWindow}
+	app.TrustProxy = *trustProxy
+	app.SkipCredentialCheck = *skipCredentialCheck
+	transport := resize.DefaultTransportOptions
+	transport.MaxIdleConns = *awsIdleConns
+	transport.MaxIdleConnsPerHost = *awsIdleConnsPerHost
+	transport.ResponseHeaderTimeout = *awsTimeout
+	app.Transport = &transport
+	if *scrapeProxy != "" {
+		proxy, err := url.Parse(*scrapeProxy)
+		if err != nil {
+			log.Fatalf("invalid -scrape-proxy: %v", err)
+		}
+		scrapeTransport := http.DefaultTransport.(*http.Transport).Clone()
+		scrapeTransport.Proxy = http.ProxyURL(proxy)
+		scrapeTransport.ResponseHeaderTimeout = *awsTimeout
+		app.ScrapeClient = &http.Client{Transport: scrapeTransport}
+	}
+	app.RecommendLookback = *recommendLookback
+	app.AllowServerCreds = *allowServerCreds
+	if *regions != "" {
+		app.Regions = strings.Split(*regions, ",")
+	}
+	if *regionEndpoints != "" {
+		app.RegionEndpoints = make(map[string]string)
+		for _, pair := range strings.Split(*regionEndpoints, ",") {
+			i := strings.Index(pair, "=")
+			if i < 0 {
+				log.Fatalf("expected region=URL in -region-endpoints, got '%s'", pair)
+			}
+			app.RegionEndpoints[pair[:i]] = pair[i+1:]
+		}
+	}
+	app.JSONLog = *jsonLog
+	app.TagResized = *tagResized
+	app.RequireReason = *requireReason
+	app.MinReasonLength = *minReasonLength
+	if *auditLog != "" {
+		file, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.AuditSink = resize.NewJSONAuditSink(file)
+	}
+	if *refreshTypes {
+		app.StartBackgroundRefresh(0)
+	}
 	var logDest io.Writer
 	if *accessLog == "" {
 		logDest = os.Stderr
@@ -55,29 +227,65 @@ func main() {
 		}
 		logDest = file
 	}
-	h = middleware.Log(logDest, h)
+	h := handler(app, logDest)
 
 	httpURL := (&url.URL{Scheme: "http", Host: expandHost(*httpAddr), Path: "/"}).String()
 
+	// serve runs srv in the background until it's shut down
+	serve := func(srv *http.Server, listen func() error) {
+		go func() {
+			if err := listen(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	var servers []*http.Server
 	if *httpsAddr == "" {
+		srv := &http.Server{Addr: *httpAddr, Handler: h}
 		log.Println("listening on " + httpURL)
-		log.Fatal(http.ListenAndServe(*httpAddr, h))
-	}
+		serve(srv, srv.ListenAndServe)
+		servers = append(servers, srv)
+	} else {
+		httpsURL := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: "/"}).String()
 
-	httpsURL := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: "/"}).String()
+		// redirect all HTTP requests to HTTPS
+		redirect := func(w http.ResponseWriter, r *http.Request) {
+			to := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: r.URL.Path}).String()
+			http.Redirect(w, r, to, http.StatusMovedPermanently)
+		}
+		redirectSrv := &http.Server{Addr: *httpAddr, Handler: http.HandlerFunc(redirect)}
+		serve(redirectSrv, redirectSrv.ListenAndServe)
 
-	// redirect all HTTP requests to HTTPS
-	redirect := func(w http.ResponseWriter, r *http.Request) {
-		to := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: r.URL.Path}).String()
-		http.Redirect(w, r, to, http.StatusMovedPermanently)
+		srv := &http.Server{Addr: *httpsAddr, Handler: h}
+		log.Println("listening on " + httpsURL)
+		serve(srv, func() error { return srv.ListenAndServeTLS(*tlsCert, *tlsKey) })
+		servers = append(servers, redirectSrv, srv)
 	}
 
-	go func() {
-		log.Fatal(http.ListenAndServe(*httpAddr, http.HandlerFunc(redirect)))
-	}()
+	// on SIGINT or SIGTERM stop accepting connections, wait for in-flight
+	// requests, then stop the app's background work
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Println("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("could not drain requests to %s: %v", srv.Addr, err)
+		}
+	}
+	if err := app.Shutdown(ctx); err != nil {
+		log.Printf("could not shut down app: %v", err)
+	}
+}
 
-	log.Println("listening on " + httpsURL)
-	log.Fatal(http.ListenAndServeTLS(*httpsAddr, *tlsCert, *tlsKey, h))
+// handler wraps the app in request logging to logDest. The app compresses
+// its own responses, so it isn't wrapped in middleware.GZip, which would
+// compress every response before the app saw the client's Accept-Encoding.
+func handler(app *resize.App, logDest io.Writer) http.Handler {
+	return middleware.Log(logDest, app)
 }
 
 // expand ':4040' to '0.0.0.0:4040'
@@ -90,3 +298,52 @@ func expandHost(addr string) string {
 	}
 	return addr
 }
+
+// sessionKey returns the secret given by -sessionkey, read from
+// -sessionkey-file, or held by the environment variable resize.SessionKeyEnv,
+// in that order. It's nil if none is set, in which case the app generates a
+// random secret, and an error is returned if it's too short.
+func sessionKey(flagKey, file string) ([]byte, error) {
+	key := []byte(flagKey)
+	switch {
+	case flagKey != "":
+	case file != "":
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(strings.TrimRight(string(b), "\r\n"))
+	default:
+		key = []byte(os.Getenv(resize.SessionKeyEnv))
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if err := resize.CheckSessionKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// newApp creates the app from the public and templates directories, falling
+// back to the embedded copy of either when its path is empty.
+func newApp(public, templates string, store sessions.Store) (*resize.App, error) {
+	if public != "" && templates != "" {
+		return resize.NewApp(public, templates, store)
+	}
+	dirFS := func(path, embedded string) (fs.FS, error) {
+		if path != "" {
+			return os.DirFS(path), nil
+		}
+		return fs.Sub(assets, embedded)
+	}
+	staticFS, err := dirFS(public, "public")
+	if err != nil {
+		return nil, err
+	}
+	tmplFS, err := dirFS(templates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return resize.NewAppFS(staticFS, tmplFS, store)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerCompression(t *testing.T) {
+	app, err := newApp("public", "templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	h := handler(app, &logs)
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/about"); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected the about page to be compressed")
+	}
+	w := get("/healthz")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.Len() == 0 {
+		t.Errorf("expected a small response not to be compressed, got %q encoded %q", w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(logs.String(), "GET 200 /healthz") {
+		t.Errorf("expected requests to be logged, got %q", logs.String())
+	}
+}
@@ -0,0 +1,164 @@
+// Command resize-types prints the EC2 instance types offered by the resize
+// app as a table, or as JSON with -json, without running the web server.
+//
+// Types are scraped from the AWS website unless -source=pricelist is given,
+// and are priced from the AWS Price List of -region. Requests honor the
+// HTTP_PROXY and HTTPS_PROXY environment variables.
+//
+// With -baseline, the types are compared against a JSON file of known-good
+// types, such as the output of an earlier run with -json, and the types
+// added, removed or changed since are printed instead. The command exits
+// with status 1 if there are any, so it can be run as a periodic check.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/yhat/resize/resize"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the instance types as a JSON array")
+	region := flag.String("region", "", "`region` to price instance types in, unpriced if empty")
+	source := flag.String("source", "scraper", "`source` of instance types, \"scraper\" or \"pricelist\" for the offer file of -region")
+	typesURL := flag.String("types-url", resize.DefaultInstanceTypeURL, "`URL` of the page to scrape instance types from")
+	baseline := flag.String("baseline", "", "JSON `file` of known-good instance types to compare the types against")
+	flag.Parse()
+
+	if err := run(os.Stdout, *source, *typesURL, *region, *baseline, *asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "resize-types: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run fetches the instance types and writes them to w, or their drift from
+// the types in the baseline file if it's given.
+func run(w io.Writer, source, typesURL, region, baseline string, asJSON bool) error {
+	var src resize.PriceSource
+	switch source {
+	case "scraper":
+		s, err := resize.NewWebScraperSource(typesURL)
+		if err != nil {
+			return err
+		}
+		src = s
+	case "pricelist":
+		if region == "" {
+			return fmt.Errorf("-source=pricelist requires a -region")
+		}
+		s, err := resize.NewPriceListSource(region, "")
+		if err != nil {
+			return err
+		}
+		src = s
+	default:
+		return fmt.Errorf("unknown -source '%s': expected scraper or pricelist", source)
+	}
+
+	// the default client's transport reads proxies from the environment
+	client := http.DefaultClient
+	types, err := src.Fetch(client)
+	var challenge *resize.ChallengeError
+	if errors.As(err, &challenge) {
+		return fmt.Errorf("%v\ntry -source=pricelist with a -region", err)
+	}
+	if err != nil {
+		return err
+	}
+	if region != "" {
+		prices, err := src.Prices(client, region)
+		if err != nil {
+			return fmt.Errorf("could not get prices for %s: %v", region, err)
+		}
+		types = resize.MergePrices(types, prices)
+	}
+
+	if baseline != "" {
+		return compareBaseline(w, baseline, types, asJSON)
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(types)
+	}
+	return printTable(w, types)
+}
+
+// errDrift is returned when the types differ from the baseline, after their
+// differences have been printed.
+var errDrift = errors.New("instance types differ from the baseline")
+
+// compareBaseline writes the drift of types from the types in the baseline
+// file to w.
+func compareBaseline(w io.Writer, baseline string, types []resize.InstanceType, asJSON bool) error {
+	file, err := os.Open(baseline)
+	if err != nil {
+		return err
+	}
+	known, err := resize.ReadInstanceTypes(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", baseline, err)
+	}
+	d := resize.CompareBaseline(known, types)
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else if err := printDrift(w, d); err != nil {
+		return err
+	}
+	if !d.Empty() {
+		return errDrift
+	}
+	return nil
+}
+
+// printDrift writes the differences of d to w, one per line.
+func printDrift(w io.Writer, d resize.Drift) error {
+	if d.Empty() {
+		_, err := fmt.Fprintln(w, "no differences from the baseline")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, field := range d.Blank {
+		fmt.Fprintf(tw, "blank\t%s\tset in the baseline, but on no current type\n", field)
+	}
+	for _, name := range d.Added {
+		fmt.Fprintf(tw, "added\t%s\n", name)
+	}
+	for _, name := range d.Removed {
+		fmt.Fprintf(tw, "removed\t%s\n", name)
+	}
+	for _, c := range d.Changed {
+		for _, f := range c.Fields {
+			fmt.Fprintf(tw, "changed\t%s\t%s: %q -> %q\n", c.Name, f.Field, f.Baseline, f.Current)
+		}
+	}
+	return tw.Flush()
+}
+
+// printTable writes types to w as an aligned table.
+func printTable(w io.Writer, types []resize.InstanceType) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVCPUS\tMEMORY (GIB)\tSTORAGE\tNETWORK\tPRICE (USD)")
+	for _, t := range types {
+		price := "n/a"
+		if t.Price > 0 {
+			price = strconv.FormatFloat(t.Price, 'f', 4, 64) + "/" + t.PriceUnit
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%g\t%s\t%s\t%s\n",
+			t.Name, t.CPUs, t.Memory, t.Storage, t.NetworkSpec, price)
+	}
+	return tw.Flush()
+}
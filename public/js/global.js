@@ -1,13 +1,21 @@
 $(function() {
+    var csrfField = $('meta[name="csrf-field"]').attr('content'),
+        csrfToken = $('meta[name="csrf-token"]').attr('content'),
+        basePath = $('meta[name="base-path"]').attr('content') || '';
+
+    if (csrfToken) {
+        $.ajaxSetup({ headers: { "X-CSRF-Token": csrfToken } });
+    }
+
     if ( $("#awsRegion").length ) {
         $("#awsRegion").on("change", function() {
             var formData = {};
             formData["region"] = this.value;
 
             $('#instances').addClass('disabled-div');
-            $.post("/region", formData)
+            $.post(basePath + "/region", formData)
             .success(function (data) {
-                window.location.href = "/";
+                window.location.href = basePath + "/";
             })
             .fail(function(xhr, textStatus, errorThrown) {
                 alert(xhr.reponseText);
@@ -17,6 +25,10 @@ $(function() {
         });
     }
 
+    $("#locale").on("change", function() {
+        $("#localeForm").submit();
+    });
+
     var scheme = window.location.protocol;
 
     $('#instance-state').on('click', function(e) {
@@ -34,8 +46,13 @@ $(function() {
 
         var $form = $(this);
 
-        var wsUrl = $form.prop('action').replace(scheme, wsScheme),
-            newVal = $form.find('option:selected').val(),
+        var wsUrl = $form.prop('action').replace(scheme, wsScheme);
+        if (csrfToken) {
+            wsUrl += (wsUrl.indexOf('?') === -1 ? '?' : '&') +
+                encodeURIComponent(csrfField) + '=' + encodeURIComponent(csrfToken);
+        }
+
+        var newVal = $form.find('option:selected').val(),
             ws = new WebSocket(wsUrl);
 
         ws.onopen = function() {
@@ -70,6 +87,59 @@ $(function() {
         }
     });
 
+    // suggest the instance types the resize form offers as the search box is
+    // typed in, and select the one picked
+    var searchTimer;
+    $('#type-search').on('input', function() {
+        var q = $.trim(this.value),
+            $select = $('#change-type');
+        if ($select.find('option').filter(function() { return this.value == q; }).length) {
+            $select.val(q);
+            return;
+        }
+        clearTimeout(searchTimer);
+        if (!q) {
+            return;
+        }
+        searchTimer = setTimeout(function() {
+            $.getJSON(basePath + '/api/instance-types/search', { q: q, fuzzy: true, limit: 20 })
+            .done(function(data) {
+                var $list = $('#type-suggestions').empty();
+                $.each(data.instance_types, function(i, t) {
+                    if ($select.find('option').filter(function() { return this.value == t.Name; }).length) {
+                        $('<option>').val(t.Name).appendTo($list);
+                    }
+                });
+            });
+        }, 200);
+    });
+
+    // follow the progress of a resize submitted as a form, which only
+    // responds once the resize is done
+    $('#confirm-resize').on('submit', function() {
+        var $progress = $('#resize-progress'),
+            wsUrl = (scheme == "https:" ? "wss:" : "ws:") + "//" +
+                window.location.host + $(this).data('progress');
+        if (csrfToken) {
+            wsUrl += '?' + encodeURIComponent(csrfField) + '=' + encodeURIComponent(csrfToken);
+        }
+        // give the resize a moment to start before watching it
+        setTimeout(function() {
+            var ws = new WebSocket(wsUrl);
+            ws.onmessage = function(event) {
+                var ev = JSON.parse(event.data);
+                switch (ev.Status) {
+                case "state":
+                    $progress.removeAttr('hidden').text("Instance is " + ev.Message);
+                    break;
+                case "error":
+                    $progress.removeAttr('hidden').addClass('text-danger').text(ev.Message);
+                    break;
+                }
+            };
+        }, 500);
+    });
+
     function colorForState(state) {
         switch(state) {
             case "running":
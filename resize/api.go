@@ -0,0 +1,360 @@
+package resize
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// renderJSON writes v to the ResponseWriter as JSON with the given status code.
+func (app *App) renderJSON(w http.ResponseWriter, v interface{}, status int) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		app.Logf("error marshalling JSON: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// renderJSONError writes an error message as a JSON object.
+func (app *App) renderJSONError(w http.ResponseWriter, msg string, status int) {
+	app.renderJSON(w, map[string]string{"error": msg}, status)
+}
+
+// typeFilter holds the minimum requirements parsed from a request's query.
+type typeFilter struct {
+	minCPUs   int
+	minMemory float64
+}
+
+// parseTypeFilter reads the vcpu_min and memory_min query parameters.
+func parseTypeFilter(r *http.Request) (typeFilter, error) {
+	var f typeFilter
+	q := r.URL.Query()
+	if s := q.Get("vcpu_min"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return f, fmt.Errorf("expected number for vcpu_min, got '%s'", s)
+		}
+		f.minCPUs = n
+	}
+	if s := q.Get("memory_min"); s != "" {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return f, fmt.Errorf("expected number for memory_min, got '%s'", s)
+		}
+		f.minMemory = n
+	}
+	return f, nil
+}
+
+// apply returns the instance types which meet the filter's requirements.
+func (f typeFilter) apply(types []InstanceType) []InstanceType {
+	filtered := []InstanceType{}
+	for _, t := range types {
+		if t.CPUs >= f.minCPUs && t.Memory >= f.minMemory {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// instanceTypesResponse is the JSON response of handleAPIInstanceTypes.
+// Stale is true if the types couldn't be refreshed, so they're older than the
+// cache's TTL. Fallback is true if they came from the fallback of the
+// source, such as an archived copy of the AWS page.
+type instanceTypesResponse struct {
+	FetchedAt     time.Time      `json:"fetched_at"`
+	Stale         bool           `json:"stale"`
+	Fallback      bool           `json:"fallback"`
+	InstanceTypes []InstanceType `json:"instance_types"`
+}
+
+// Path: /api/instance-types
+//
+// handleAPIInstanceTypes serves the instance types as JSON. Responses carry
+// an ETag, and requests whose If-None-Match header matches it are answered
+// with 304 Not Modified.
+func (app *App) handleAPIInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		app.renderJSONError(w, errNoTypes.Error(), http.StatusNotFound)
+		return
+	}
+	filter, err := parseTypeFilter(r)
+	if err != nil {
+		app.renderJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	types = filter.apply(types)
+	etag, err := typesETag(types)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not compute ETag: %v", err)
+	} else {
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	fetchedAt, stale := app.TypeCache.FetchedAt()
+	resp := instanceTypesResponse{
+		FetchedAt:     fetchedAt,
+		Stale:         stale,
+		Fallback:      app.TypeCache.FromFallback(),
+		InstanceTypes: types,
+	}
+	app.renderJSON(w, resp, http.StatusOK)
+}
+
+// typesETag returns an entity tag for a list of instance types. It only
+// depends on the types, not when they were fetched, so clients polling the
+// API see a change only once the types themselves change.
+func typesETag(types []InstanceType) (string, error) {
+	b, err := json.Marshal(types)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`, nil
+}
+
+// etagMatches reports if an If-None-Match header matches etag. Weak tags
+// match their strong counterparts, as GET only needs weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// csvHeader is the header row of the CSV export of instance types.
+var csvHeader = []string{
+	"Name", "vCPUs", "Memory (GiB)", "Storage (GB)", "Network", "Processor",
+	"Clock Speed (GHz)", "Intel AVX", "Intel AVX2", "Intel Turbo", "EBS Optimized",
+	"EBS Bandwidth (Mbps)", "Enhanced Networking", "Accelerators",
+	"Price (USD)", "Price Unit",
+}
+
+// csvRecord formats an instance type as a row of the CSV export.
+func csvRecord(t InstanceType) []string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return []string{
+		t.Name,
+		strconv.Itoa(t.CPUs),
+		strconv.FormatFloat(t.Memory, 'f', 2, 64),
+		t.Storage,
+		t.NetworkSpec,
+		t.Processor,
+		strconv.FormatFloat(t.ClockSpeed, 'f', 2, 64),
+		yesNo(t.IntelAVX),
+		yesNo(t.IntelAVX2),
+		yesNo(t.IntelTurbo),
+		yesNo(t.EBSOPT),
+		strconv.Itoa(t.EBSBandwidthMbps),
+		yesNo(t.EnhancedNetworking),
+		strconv.Itoa(t.Accelerators),
+		strconv.FormatFloat(t.Price, 'f', 4, 64),
+		t.PriceUnit,
+	}
+}
+
+// Path: /api/instance-types.csv
+//
+// handleAPIInstanceTypesCSV serves the same instance types as
+// handleAPIInstanceTypes as a CSV file.
+func (app *App) handleAPIInstanceTypesCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		http.Error(w, errNoTypes.Error(), http.StatusNotFound)
+		return
+	}
+	filter, err := parseTypeFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="instance-types.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, t := range filter.apply(types) {
+		cw.Write(csvRecord(t))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.LogfContext(r.Context(), "error writing CSV: %v", err)
+	}
+}
+
+// ndjsonFlushEvery is the number of instance types written to an NDJSON
+// stream between flushes.
+const ndjsonFlushEvery = 100
+
+// Path: /api/instance-types.ndjson
+//
+// handleAPIInstanceTypesNDJSON streams the same instance types as
+// handleAPIInstanceTypes as newline delimited JSON, one object per line, so
+// clients can process them as they arrive. Each type is encoded straight to
+// the response, which is flushed every ndjsonFlushEvery types. The stream is
+// abandoned once the client disconnects.
+func (app *App) handleAPIInstanceTypesNDJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		app.renderJSONError(w, errNoTypes.Error(), http.StatusNotFound)
+		return
+	}
+	filter, err := parseTypeFilter(r)
+	if err != nil {
+		app.renderJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	ctx := r.Context()
+	written := 0
+	for _, t := range filter.apply(types) {
+		if err := ctx.Err(); err != nil {
+			app.LogfContext(r.Context(), "abandoning instance types stream after %d types: %v", written, err)
+			return
+		}
+		if err := enc.Encode(t); err != nil {
+			app.LogfContext(r.Context(), "error writing instance types stream: %v", err)
+			return
+		}
+		written++
+		if flusher != nil && written%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// resizeTarget is an instance type an instance can be resized to, as served
+// by handleAPITargets.
+type resizeTarget struct {
+	Type         InstanceType `json:"type"`
+	Price        float64      `json:"price"`
+	CurrentPrice float64      `json:"current_price"`
+	PriceUnit    string       `json:"price_unit"`
+}
+
+// resizeTargets is the JSON response of handleAPITargets.
+type resizeTargets struct {
+	InstanceID     string         `json:"instance_id"`
+	InstanceType   string         `json:"instance_type"`
+	Virtualization string         `json:"virtualization"`
+	Architecture   string         `json:"architecture"`
+	Targets        []resizeTarget `json:"targets"`
+}
+
+// Path: /api/instance/{instance}/targets
+//
+// handleAPITargets lists the instance types an instance in the selected
+// region can be resized to, which are those with the same architecture and
+// support for its virtualization type. Prices are zero if unknown.
+func (app *App) handleAPITargets(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.renderJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance %s: %v", instanceId, err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		app.renderJSONError(w, "no instance with ID "+instanceId, http.StatusNotFound)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	prices, ok, err := app.prices(ec2Cli.Region.Name)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get prices for %s: %v", ec2Cli.Region.Name, err)
+	} else if ok {
+		types = MergePrices(types, prices)
+	}
+	current, _ := FindType(types, instance.InstanceType)
+	resp := resizeTargets{
+		InstanceID:     instance.InstanceId,
+		InstanceType:   instance.InstanceType,
+		Virtualization: instance.VirtType,
+		Architecture:   ArchitectureOf(current),
+		Targets:        []resizeTarget{},
+	}
+	for _, t := range compatibleTypes(instance, types) {
+		unit := t.PriceUnit
+		if unit == "" {
+			unit = current.PriceUnit
+		}
+		resp.Targets = append(resp.Targets, resizeTarget{
+			Type:         t,
+			Price:        t.Price,
+			CurrentPrice: current.Price,
+			PriceUnit:    unit,
+		})
+	}
+	app.renderJSON(w, resp, http.StatusOK)
+}
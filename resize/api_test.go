@@ -0,0 +1,307 @@
+package resize
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestAPIInstanceTypes(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = sourceFunc(func(*http.Client) ([]InstanceType, error) {
+		return []InstanceType{
+			{Name: "t2.micro", CPUs: 1, Memory: 1},
+			{Name: "m3.large", CPUs: 2, Memory: 7.5},
+			{Name: "c4.2xlarge", CPUs: 8, Memory: 15},
+		}, nil
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"t2.micro", "m3.large", "c4.2xlarge"}},
+		{"?vcpu_min=2", []string{"m3.large", "c4.2xlarge"}},
+		{"?memory_min=8", []string{"c4.2xlarge"}},
+		{"?vcpu_min=2&memory_min=7.5", []string{"m3.large", "c4.2xlarge"}},
+		{"?vcpu_min=64", []string{}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/instance-types"+tt.query, nil)
+		app.handleAPIInstanceTypes(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", tt.query, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected JSON content type, got %s", tt.query, ct)
+		}
+		var resp instanceTypesResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: decoding response: %v", tt.query, err)
+			continue
+		}
+		if resp.FetchedAt.IsZero() || resp.Stale {
+			t.Errorf("%s: expected fresh types with their fetch time, got %v (stale %v)", tt.query, resp.FetchedAt, resp.Stale)
+		}
+		types := resp.InstanceTypes
+		if len(types) != len(tt.want) {
+			t.Errorf("%s: expected %d types, got %d", tt.query, len(tt.want), len(types))
+			continue
+		}
+		for i := range types {
+			if types[i].Name != tt.want[i] {
+				t.Errorf("%s: expected %s, got %s", tt.query, tt.want[i], types[i].Name)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/instance-types?vcpu_min=many", nil)
+	app.handleAPIInstanceTypes(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for bad filter, got %d", w.Code)
+	}
+}
+
+func TestAPIInstanceTypesScrapeError(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = sourceFunc(func(*http.Client) ([]InstanceType, error) {
+		return nil, errors.New("scrape failed")
+	})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/instance-types", nil)
+	app.handleAPIInstanceTypes(w, r)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] == "" {
+		t.Errorf("expected error message in response body")
+	}
+}
+
+func TestAPIInstanceTypesCSV(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "t2.micro", CPUs: 1, Memory: 1, ClockSpeed: 2.5, IntelTurbo: true},
+		{Name: "c4.2xlarge", CPUs: 8, Memory: 15, Storage: "EBS Only", EBSOPT: true, Price: 0.398, PriceUnit: "Hrs"},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/instance-types.csv?vcpu_min=2", nil)
+	app.handleAPIInstanceTypesCSV(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("expected attachment, got Content-Disposition %q", cd)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected header and 1 record, got %d rows", len(records))
+	}
+	if !reflect.DeepEqual(records[0], csvHeader) {
+		t.Errorf("unexpected header %v", records[0])
+	}
+	want := []string{"c4.2xlarge", "8", "15.00", "EBS Only", "", "", "0.00",
+		"no", "no", "no", "yes", "0", "no", "0", "0.3980", "Hrs"}
+	if !reflect.DeepEqual(records[1], want) {
+		t.Errorf("expected record %v, got %v", want, records[1])
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/instance-types.csv?memory_min=lots", nil)
+	app.handleAPIInstanceTypesCSV(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for bad filter, got %d", w.Code)
+	}
+}
+
+func TestAPIInstanceTypesNDJSON(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []InstanceType
+	for i := 0; i < 250; i++ {
+		types = append(types, InstanceType{Name: fmt.Sprintf("m%d.large", i), CPUs: 1 + i%2, Memory: 8})
+	}
+	app.Source = NewStaticSource(types, nil)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/instance-types.ndjson?vcpu_min=2", nil)
+	app.handleAPIInstanceTypesNDJSON(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected Content-Type application/x-ndjson, got %q", ct)
+	}
+	if !w.Flushed {
+		t.Errorf("expected the stream to be flushed")
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != 125 {
+		t.Fatalf("expected 125 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		var it InstanceType
+		if err := json.Unmarshal([]byte(line), &it); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if it != types[2*i+1] {
+			t.Errorf("line %d: expected %v, got %v", i, types[2*i+1], it)
+		}
+	}
+
+	// a disconnected client's stream is abandoned, the types are already
+	// cached so they're found despite the canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/instance-types.ndjson", nil)
+	cancel()
+	app.handleAPIInstanceTypesNDJSON(w, r.WithContext(ctx))
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no types streamed after the client disconnected, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestAPITargets(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("InstanceId.1") == "i-missing" {
+			fmt.Fprint(w, noInstancesResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-targets")
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m1.small", CPUs: 1, Memory: 1.7, Price: 0.044, PriceUnit: "Hrs"},
+		{Name: "m1.large", CPUs: 2, Memory: 7.5, Price: 0.175, PriceUnit: "Hrs"},
+		{Name: "a1.large", CPUs: 2, Memory: 4, Price: 0.051, PriceUnit: "Hrs"},
+	}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(id string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/api/instance/"+id+"/targets", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	w = get("i-targets")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp resizeTargets
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.InstanceType != "m1.small" || resp.Architecture != "x86_64" {
+		t.Errorf("expected an x86_64 m1.small, got %s %s", resp.Architecture, resp.InstanceType)
+	}
+	want := []resizeTarget{{
+		Type:         InstanceType{Name: "m1.large", CPUs: 2, Memory: 7.5, Price: 0.175, PriceUnit: "Hrs"},
+		Price:        0.175,
+		CurrentPrice: 0.044,
+		PriceUnit:    "Hrs",
+	}}
+	if !reflect.DeepEqual(resp.Targets, want) {
+		t.Errorf("expected targets %+v, got %+v", want, resp.Targets)
+	}
+
+	w = get("i-missing")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing instance, got %d", w.Code)
+	}
+}
+
+func TestAPIInstanceTypesETag(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	app.Source = sourceFunc(func(*http.Client) ([]InstanceType, error) {
+		fetches++
+		return []InstanceType{
+			{Name: "t2.micro", CPUs: 1, Memory: 1},
+			{Name: "m3.large", CPUs: 2, Memory: 7.5},
+		}, nil
+	})
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/instance-types"+query, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		app.handleAPIInstanceTypes(w, r)
+		return w
+	}
+
+	w := get("", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected status 200 with an ETag, got %d %q", w.Code, etag)
+	}
+	w = get("", etag)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304 for a matching If-None-Match, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no body with 304, got %q", w.Body.String())
+	}
+	if w = get("", `"other", W/`+etag); w.Code != http.StatusNotModified {
+		t.Errorf("expected a weak tag in a list to match, got %d", w.Code)
+	}
+
+	// refetching the same types keeps the ETag
+	app.TypeCache.ForceRefresh()
+	if w = get("", etag); w.Code != http.StatusNotModified || fetches != 2 {
+		t.Errorf("expected status 304 after refetching the same types, got %d after %d fetches", w.Code, fetches)
+	}
+	if w = get("?vcpu_min=2", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected different types to have a different ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,78 @@
+package resize
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// AuditEvent records a resize action. Each resize is recorded when it starts
+// and again with its outcome.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"` // prefix of the access key ID
+	InstanceID string    `json:"instance_id"`
+	OldType    string    `json:"old_type"`
+	NewType    string    `json:"new_type"`
+	Region     string    `json:"region"`
+	DryRun     bool      `json:"dry_run"`
+	Reason     string    `json:"reason,omitempty"`
+	Result     string    `json:"result"` // "started", "success" or "failure"
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"` // of the request making the resize
+}
+
+// An AuditSink keeps a record of resize actions. Record must be safe for
+// concurrent use.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// JSONAuditSink is an AuditSink writing each event to W as a line of JSON.
+// Write errors are logged to the log package's standard logger.
+type JSONAuditSink struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// NewJSONAuditSink returns a JSONAuditSink writing to w, e.g. a file opened
+// with os.O_APPEND.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{W: w}
+}
+
+// Record writes event as a line of JSON.
+func (s *JSONAuditSink) Record(event AuditEvent) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("could not marshal audit event: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.W.Write(append(b, '\n')); err != nil {
+		log.Printf("could not write audit event: %v", err)
+	}
+}
+
+// principal identifies the owner of an access key ID in audit events by its
+// prefix, so the key itself is never recorded.
+func principal(accessKey string) string {
+	n := len(accessKey) / 2
+	if n > 8 {
+		n = 8
+	}
+	return accessKey[:n] + "..."
+}
+
+// audit records event with the app's AuditSink, if set.
+func (app *App) audit(event AuditEvent) {
+	if app.AuditSink == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	app.AuditSink.Record(event)
+}
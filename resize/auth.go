@@ -3,6 +3,8 @@ package resize
 import (
 	"encoding/gob"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
@@ -10,6 +12,7 @@ import (
 
 func init() {
 	gob.Register(&ec2.EC2{})
+	gob.Register(time.Time{})
 }
 
 var defaultRegion = aws.USEast
@@ -17,16 +20,79 @@ var defaultRegion = aws.USEast
 // login attempts to validate the provided credentials with AWS.
 // On an authentication error, error will be of type *ec2.Error
 func (app *App) login(w http.ResponseWriter, r *http.Request, accessKeyID, secretKey string) error {
-	ec2Cli := ec2.NewWithClient(aws.Auth{
+	auth := aws.Auth{
 		AccessKey: accessKeyID,
 		SecretKey: secretKey,
-	}, defaultRegion, app.httpClient())
+	}
+	return app.loginAuth(w, r, accessKeyID, auth, time.Time{})
+}
 
-	_, err := ec2Cli.Instances(nil, nil)
+// loginMFA exchanges the provided credentials and MFA code for temporary
+// credentials, and logs in with those.
+// On an authentication error, error will be of type *ec2.Error
+func (app *App) loginMFA(w http.ResponseWriter, r *http.Request, accessKeyID, secretKey, serial, code string) error {
+	auth := aws.Auth{
+		AccessKey: accessKeyID,
+		SecretKey: secretKey,
+	}
+	creds, err := getSessionToken(app.httpClient(), auth, serial, code)
 	if err != nil {
 		return err
 	}
+	return app.loginAuth(w, r, accessKeyID, creds.auth(), creds.Expiration)
+}
 
+// loginRole assumes the role identified by roleARN using the provided
+// credentials, and logs in with the role's temporary credentials. externalID,
+// serial and code are passed to STS if not empty.
+// On an authentication error, error will be of type *ec2.Error
+func (app *App) loginRole(w http.ResponseWriter, r *http.Request, accessKeyID, secretKey, roleARN, externalID, serial, code string) error {
+	auth := aws.Auth{
+		AccessKey: accessKeyID,
+		SecretKey: secretKey,
+	}
+	creds, err := assumeRole(app.httpClient(), auth, roleARN, externalID, serial, code)
+	if err != nil {
+		return err
+	}
+	return app.loginAuth(w, r, accessKeyID, creds.auth(), creds.Expiration)
+}
+
+// loginAuth validates auth with AWS and associates it with the session.
+// user identifies the owner of the credentials, and the session is only valid
+// until user's sessions are revoked. If expires is non-zero the session's
+// credentials are considered invalid after that time.
+func (app *App) loginAuth(w http.ResponseWriter, r *http.Request, user string, auth aws.Auth, expires time.Time) error {
+	ec2Cli := app.newEC2(auth, defaultRegion)
+	if err := app.validateCreds(ec2Cli); err != nil {
+		return err
+	}
+	return app.startSession(w, r, user, ec2Cli, expires, false)
+}
+
+// startSession associates validated credentials with the session. server
+// records if they are the server's own credentials, see loginServer.
+func (app *App) startSession(w http.ResponseWriter, r *http.Request, user string, ec2Cli *ec2.EC2, expires time.Time, server bool) error {
+	version, err := app.sessionVersions().SessionVersion(user)
+	if err != nil {
+		return err
+	}
+
+	session, _ := app.store.Get(r, "yhat-resize")
+	session.Values["user"] = user
+	session.Values["version"] = version
+	if expires.IsZero() {
+		delete(session.Values, "expires")
+	} else {
+		session.Values["expires"] = expires
+	}
+	if server {
+		session.Values["server"] = true
+	} else {
+		delete(session.Values, "server")
+	}
+	// the new credentials aren't those of a saved profile
+	delete(session.Values, "profile")
 	return app.set(w, r, ec2Cli)
 }
 
@@ -34,40 +100,114 @@ func (app *App) login(w http.ResponseWriter, r *http.Request, accessKeyID, secre
 func (app *App) set(w http.ResponseWriter, r *http.Request, ec2Cli *ec2.EC2) error {
 	// ignore error from decoding an existing session
 	session, _ := app.store.Get(r, "yhat-resize")
+	if server, _ := session.Values["server"].(bool); server {
+		// the server's credentials are looked up again by creds, so they
+		// aren't stored in the session's cookie
+		ec2Cli = &ec2.EC2{Region: ec2Cli.Region}
+	}
 	session.Values["ec2"] = ec2Cli
-	return session.Save(r, w)
+	// the new credentials may not have the same permissions
+	delete(session.Values, "canResize")
+	return app.saveSession(w, r, session, nil)
 }
 
 func (app *App) logout(w http.ResponseWriter, r *http.Request) {
 	session, _ := app.store.Get(r, "yhat-resize")
 	delete(session.Values, "ec2")
-	session.Save(r, w)
+	delete(session.Values, "expires")
+	delete(session.Values, "user")
+	delete(session.Values, "version")
+	delete(session.Values, "server")
+	delete(session.Values, "profile")
+	delete(session.Values, "profiles")
+	delete(session.Values, "canResize")
+	app.saveSession(w, r, session, nil)
+}
+
+// expired reports if the request session holds temporary credentials which
+// have expired.
+func (app *App) expired(r *http.Request) bool {
+	session, _ := app.store.Get(r, "yhat-resize")
+	expires, ok := session.Values["expires"].(time.Time)
+	return ok && !time.Now().Before(expires)
 }
 
 // creds returns the EC2 credentials associated with the request session. If
-// the session does not
+// the session does not have any, its temporary credentials have expired or it
+// has been revoked, ok is false. Requests to the API may present a bearer
+// token instead of a session, see handleAPILogin.
 func (app *App) creds(r *http.Request) (ec2Cli *ec2.EC2, ok bool) {
+	if token := bearerToken(r); token != "" && strings.HasPrefix(r.URL.Path, "/api/") {
+		return app.tokenCreds(token)
+	}
+	if basicAuthRequest(r) {
+		ec2Cli, err := app.basicCreds(r)
+		return ec2Cli, err == nil
+	}
 	session, _ := app.store.Get(r, "yhat-resize")
 	ec2Cli, ok = session.Values["ec2"].(*ec2.EC2)
-	if !ok {
+	if !ok || app.expired(r) || app.revoked(r) {
 		return nil, false
 	}
+	if server, _ := session.Values["server"].(bool); server {
+		if !app.AllowServerCreds {
+			return nil, false
+		}
+		auth, err := app.serverAuth()
+		if err != nil {
+			app.LogfContext(r.Context(), "could not get server credentials: %v", err)
+			return nil, false
+		}
+		return app.newEC2(auth, ec2Cli.Region), true
+	}
 	// github.com/gorilla/sessions uses encoding/gob to store data which does
 	// not capture hidden fields. To recreate the hidden fields call the
 	// constructor.
-	return ec2.NewWithClient(ec2Cli.Auth, ec2Cli.Region, app.httpClient()), ok
+	return app.newEC2(ec2Cli.Auth, ec2Cli.Region), ok
+}
+
+// Region returns the name of the region selected by an authenticated
+// request's session or API token. ok is false if the request isn't
+// authenticated.
+func (app *App) Region(r *http.Request) (name string, ok bool) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		return "", false
+	}
+	return ec2Cli.Region.Name, true
 }
 
 // restrict a handler to only request which have been logged in
 func (app *App) restrict(h http.Handler) http.Handler {
 	hf := func(w http.ResponseWriter, r *http.Request) {
 		if _, ok := app.creds(r); ok {
+			if bearerToken(r) == "" && !basicAuthRequest(r) {
+				app.refreshSession(w, r)
+			}
 			h.ServeHTTP(w, r)
 			return
 		}
+		// clients of the API, or of pages served as JSON, aren't sent to
+		// the login page
+		if strings.HasPrefix(r.URL.Path, "/api/") || wantsJSON(r) {
+			if bearerToken(r) != "" {
+				app.renderJSONError(w, errToken.Error(), http.StatusUnauthorized)
+			} else {
+				app.renderBasicAuthError(w, r)
+			}
+			return
+		}
 
 		if r.Method == "GET" {
-			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			to := app.pathTo("/login")
+			if app.expired(r) {
+				to += "?expired=1"
+			}
+			http.Redirect(w, r, to, http.StatusTemporaryRedirect)
+			return
+		}
+		if app.expired(r) {
+			http.Error(w, "Temporary credentials have expired, please log in again", http.StatusUnauthorized)
 			return
 		}
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
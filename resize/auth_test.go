@@ -7,7 +7,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
 )
 
@@ -111,3 +113,118 @@ func TestLogin(t *testing.T) {
 		t.Errorf("bad response from server %s", resp.Status)
 	}
 }
+
+func TestExpiredCreds(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar", Token: "baz"}, defaultRegion)
+
+	for _, tt := range []struct {
+		expires time.Time
+		ok      bool
+	}{
+		{time.Now().Add(time.Hour), true},
+		{time.Now().Add(-time.Hour), false},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		session, _ := app.store.Get(r, "yhat-resize")
+		session.Values["expires"] = tt.expires
+		if err := app.set(w, r, ec2Cli); err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ = http.NewRequest("GET", "/", nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		if _, ok := app.creds(r); ok != tt.ok {
+			t.Errorf("expires %v: expected creds ok to be %v", tt.expires, tt.ok)
+		}
+
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if !tt.ok && w.Header().Get("Location") != "/login?expired=1" {
+			t.Errorf("expected redirect to login with expired message, got %q", w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRevokedCreds(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)
+
+	// log in from two browsers with the same credentials
+	sessionCookies := func() []*http.Cookie {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		session, _ := app.store.Get(r, "yhat-resize")
+		session.Values["user"] = "foo"
+		session.Values["version"] = int64(0)
+		if err := app.set(w, r, ec2Cli); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()
+	}
+	request := func(cookies []*http.Cookie) *http.Request {
+		r, _ := http.NewRequest("GET", "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+	first, second := sessionCookies(), sessionCookies()
+	if _, ok := app.creds(request(second)); !ok {
+		t.Fatal("expected creds before revoking sessions")
+	}
+
+	if err := app.logoutEverywhere(httptest.NewRecorder(), request(first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.creds(request(second)); ok {
+		t.Errorf("expected second session to be revoked")
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, request(second))
+	if w.Header().Get("Location") != "/login" {
+		t.Errorf("expected redirect to login, got %q", w.Header().Get("Location"))
+	}
+}
+
+func TestRegionHeader(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/about", nil)
+	if _, ok := app.Region(r); ok {
+		t.Error("expected no region without a login")
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if h, ok := w.Result().Header["X-Aws-Region"]; ok {
+		t.Errorf("expected no region header without a login, got %q", h)
+	}
+
+	w = httptest.NewRecorder()
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.EUWest)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/about", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if region, ok := app.Region(r); !ok || region != "eu-west-1" {
+		t.Errorf("expected region eu-west-1, got %q %t", region, ok)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if h := w.Header().Get("X-AWS-Region"); h != "eu-west-1" {
+		t.Errorf("expected region header eu-west-1, got %q", h)
+	}
+}
@@ -1,13 +1,18 @@
 package resize
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/yhat/scrape"
@@ -16,84 +21,505 @@ import (
 	"golang.org/x/net/html/atom"
 )
 
-const instanceTypeURL = "http://aws.amazon.com/ec2/instance-types/"
+// DefaultInstanceTypeURL is the page the instance types matrix is scraped
+// from unless a WebScraperSource is given another URL.
+const DefaultInstanceTypeURL = "http://aws.amazon.com/ec2/instance-types/"
 
 type InstanceType struct {
-	Name               string  // col 0
-	CPUs               int     // col 1
-	Memory             float64 // GiB col 2
-	Storage            string  // GB col 3
-	NetworkSpec        string  // col 4
-	Processor          string  // col 5
-	ClockSpeed         float64 // GHz col 6
-	IntelAVX           bool    // col 7
-	IntelAVX2          bool    // col 8
-	IntelTurbo         bool    // col 9
-	EBSOPT             bool    // col 10
-	EnhancedNetworking bool    // col 11
+	Name               string
+	CPUs               int
+	Memory             float64 // GiB
+	Storage            string  // GB
+	EBSOnly            bool    // no instance store, parsed from Storage
+	InstanceStorageGB  int     // total instance store, parsed from Storage
+	NetworkSpec        string
+	Processor          string
+	ClockSpeed         float64 // GHz
+	IntelAVX           bool
+	IntelAVX2          bool
+	IntelTurbo         bool
+	EBSOPT             bool
+	EnhancedNetworking bool
+	Accelerators       int     // GPUs or FPGAs
+	EBSBandwidthMbps   int     // dedicated EBS bandwidth, zero if unknown
+	Hypervisor         string  // "xen" or "nitro"
+	Architecture       string  // "x86_64" or "arm64"
+	Burstable          bool    // accrues and spends CPU credits, e.g. T3
+	BaselinePercent    float64 // sustained CPU per vCPU of burstable types, zero if unknown
+	Price              float64 // USD on-demand, zero if unknown
+	PriceUnit          string
+	SpotPrice          float64 // USD per hour, latest spot price, zero if unknown
+}
+
+// Fields of InstanceType which can be read from the instance types matrix.
+const (
+	colName = iota
+	colCPUs
+	colMemory
+	colStorage
+	colNetworkSpec
+	colProcessor
+	colClockSpeed
+	colIntelAVX
+	colIntelAVX2
+	colIntelTurbo
+	colEBSOPT
+	colEnhancedNetworking
+	colAccelerators
+	colEBSBandwidth
+	colHypervisor
+	colBaseline
+)
+
+// headerColumns matches the titles of the matrix's header cells to the field
+// they hold. Titles are lower cased before matching against the substrings,
+// and the first match wins, so more specific substrings must come first.
+var headerColumns = []struct {
+	substr string
+	field  int
+}{
+	{"instance type", colName},
+	{"hypervisor", colHypervisor},
+	{"baseline performance", colBaseline},
+	{"baseline cpu", colBaseline},
+	{"vcpu", colCPUs},
+	{"memory", colMemory},
+	{"storage", colStorage},
+	{"enhanced networking", colEnhancedNetworking},
+	{"network", colNetworkSpec},
+	{"processor", colProcessor},
+	{"clock speed", colClockSpeed},
+	{"avx2", colIntelAVX2},
+	{"avx", colIntelAVX},
+	{"turbo", colIntelTurbo},
+	{"ebs bandwidth", colEBSBandwidth},
+	{"ebs throughput", colEBSBandwidth},
+	{"ebs opt", colEBSOPT},
+	{"gpu", colAccelerators},
+	{"fpga", colAccelerators},
+	{"accelerator", colAccelerators},
+}
+
+// columns maps a field of InstanceType to its column index within a row of
+// the instance types matrix.
+type columns map[int]int
+
+// rowCells returns the cells of a table row.
+func rowCells(row *html.Node) []*html.Node {
+	return scrape.Find(row, func(n *html.Node) bool {
+		return n.DataAtom == atom.Td || n.DataAtom == atom.Th
+	})
+}
+
+// cellText returns the text of a table cell. Its text nodes are joined with
+// spaces, and runs of whitespace, including non-breaking spaces and line
+// breaks, are collapsed into a single space, so "Up&nbsp;to 10 Gigabit" and
+// a name split across lines read the same as their plain text.
+//
+// The scraped page isn't trusted, so the result is plain text: the contents
+// of script and style elements are dropped, as is anything reading like a
+// tag once entities are decoded, such as "&lt;img src=x&gt;", along with
+// control characters. Templates escape their output regardless; this keeps
+// markup out of the API and anything else the types are used in.
+func cellText(cell *html.Node) string {
+	var parts []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			parts = append(parts, n.Data)
+		case n.Type == html.ElementNode && unsafeElements[n.DataAtom]:
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(cell)
+	s := markupPattern.ReplaceAllString(strings.Join(parts, " "), " ")
+	s = strings.Map(func(r rune) rune {
+		if r == '\u00a0' || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// unsafeElements are the elements whose text isn't part of a cell's text.
+var unsafeElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+}
+
+// markupPattern matches text which reads like an HTML tag or comment.
+var markupPattern = regexp.MustCompile(`<!--.*?-->|</?[a-zA-Z!][^<>]*>`)
+
+// parseHeader reads the header row of the instance types matrix to determine
+// which column holds each field. Unrecognized columns are ignored.
+func parseHeader(row *html.Node) (columns, error) {
+	cols := make(columns)
+	for i, cell := range rowCells(row) {
+		title := strings.ToLower(cellText(cell))
+		for _, hc := range headerColumns {
+			if !strings.Contains(title, hc.substr) {
+				continue
+			}
+			if _, ok := cols[hc.field]; !ok {
+				cols[hc.field] = i
+			}
+			break
+		}
+	}
+	required := []struct {
+		field int
+		name  string
+	}{
+		{colName, "Instance Type"},
+		{colCPUs, "vCPU"},
+		{colMemory, "Memory"},
+	}
+	for _, req := range required {
+		if _, ok := cols[req.field]; !ok {
+			return nil, fmt.Errorf("no '%s' column in header", req.name)
+		}
+	}
+	return cols, nil
 }
 
 // parseRow parses a row from the instance types matrix into it's given
-// InstanceType
-func parseRow(row *html.Node) (InstanceType, error) {
-	cols := scrape.Find(row, scrape.ByTag(atom.Td))
-	if len(cols) != 12 {
-		return InstanceType{}, fmt.Errorf("expected 12 columns, got %d", len(cols))
+// InstanceType using the column mapping read from the header. Cells of
+// columns the header doesn't map are ignored, and fields whose cell is
+// missing, because the matrix lacks the column or the row is shorter than the
+// header, are left zero valued. Only the name, vCPU and memory cells are
+// required.
+func parseRow(row *html.Node, header columns) (InstanceType, error) {
+	cells := rowCells(row)
+	// text returns the text of the column holding field, or false if the
+	// matrix or the row doesn't contain it
+	text := func(field int) (string, bool) {
+		i, ok := header[field]
+		if !ok || i >= len(cells) {
+			return "", false
+		}
+		return cellText(cells[i]), true
+	}
+	for _, field := range []int{colName, colCPUs, colMemory} {
+		if _, ok := text(field); !ok {
+			return InstanceType{}, fmt.Errorf("row has %d cells, missing column %d", len(cells), header[field]+1)
+		}
 	}
-	yesNo := func(col *html.Node) bool {
-		return strings.ToLower(scrape.Text(col)) == "yes"
+	str := func(field int) string {
+		s, _ := text(field)
+		return s
+	}
+	// a "Yes" may be followed by a footnote, e.g. "Yes (500 Mbps)"
+	yesNo := func(field int) bool {
+		return strings.HasPrefix(strings.ToLower(str(field)), "yes")
 	}
 	t := InstanceType{
-		Name:               scrape.Text(cols[0]),
-		Storage:            scrape.Text(cols[3]),
-		NetworkSpec:        scrape.Text(cols[4]),
-		Processor:          scrape.Text(cols[5]),
-		IntelAVX:           yesNo(cols[7]),
-		IntelAVX2:          yesNo(cols[8]),
-		IntelTurbo:         yesNo(cols[9]),
-		EBSOPT:             yesNo(cols[10]),
-		EnhancedNetworking: yesNo(cols[11]),
+		Name:               str(colName),
+		Storage:            str(colStorage),
+		NetworkSpec:        str(colNetworkSpec),
+		Processor:          str(colProcessor),
+		IntelAVX:           yesNo(colIntelAVX),
+		IntelAVX2:          yesNo(colIntelAVX2),
+		IntelTurbo:         yesNo(colIntelTurbo),
+		EBSOPT:             yesNo(colEBSOPT),
+		EnhancedNetworking: yesNo(colEnhancedNetworking),
 	}
+	t.EBSOnly, t.InstanceStorageGB = parseStorage(t.Storage)
 	var err error
-	t.CPUs, err = strconv.Atoi(scrape.Text(cols[1]))
-	if err != nil {
-		err = fmt.Errorf("expected number for CPUs, got '%s'", scrape.Text(cols[1]))
-		return InstanceType{}, err
+	if s, ok := text(colCPUs); ok {
+		if t.CPUs, err = strconv.Atoi(leadingNumber(s)); err != nil {
+			return InstanceType{}, fmt.Errorf("expected number for CPUs, got '%s'", s)
+		}
+	}
+	if s, ok := text(colMemory); ok {
+		if t.Memory, err = strconv.ParseFloat(leadingNumber(s), 64); err != nil {
+			return InstanceType{}, fmt.Errorf("expected number for Memory, got '%s'", s)
+		}
+	}
+	if s, ok := text(colClockSpeed); ok {
+		if t.ClockSpeed, err = strconv.ParseFloat(leadingNumber(s), 64); err != nil {
+			return InstanceType{}, fmt.Errorf("expected number for ClockSpeed, got '%s'", s)
+		}
+	}
+	// types without accelerators may have a blank or "-" cell
+	if s, ok := text(colAccelerators); ok {
+		if n := leadingNumber(s); n != "" && n != "-" {
+			if t.Accelerators, err = strconv.Atoi(n); err != nil {
+				return InstanceType{}, fmt.Errorf("expected number for Accelerators, got '%s'", s)
+			}
+		}
+	}
+	// not all tables state the EBS bandwidth, so it's left at zero rather
+	// than failing when it can't be found
+	if s, ok := text(colEBSBandwidth); ok {
+		t.EBSBandwidthMbps = parseMbps(s)
+	}
+	if t.EBSBandwidthMbps == 0 && t.EBSOPT {
+		t.EBSBandwidthMbps = parseMbps(str(colEBSOPT))
+	}
+	// the matrix rarely states the hypervisor, so it's usually derived from
+	// the type's family
+	t.Hypervisor = strings.ToLower(str(colHypervisor))
+	if t.Hypervisor != "xen" && t.Hypervisor != "nitro" {
+		t.Hypervisor = typeHypervisor(t.Name)
+	}
+	t.Architecture = ArchitectureOf(t)
+	// burstable types are listed in their own table, with their baseline
+	// as a percentage such as "20%"; it's taken from burstableBaselines
+	// when the table doesn't have it
+	if s, ok := text(colBaseline); ok {
+		t.BaselinePercent, _ = strconv.ParseFloat(leadingNumber(s), 64)
+	}
+	t.Burstable = IsBurstable(t)
+	t.BaselinePercent = BaselineOf(t)
+	return t, nil
+}
+
+// leadingNumber trims surrounding whitespace from a numeric cell and strips
+// anything following its leading number, such as the footnote markers in
+// "2.5*" or "15.25 (1)". If the cell doesn't start with a number it's
+// returned trimmed, so that parsing it fails.
+func leadingNumber(s string) string {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.') {
+		end++
+	}
+	if end == 0 {
+		return s
 	}
-	t.Memory, err = strconv.ParseFloat(scrape.Text(cols[2]), 64)
+	return strings.TrimRight(s[:end], ".")
+}
+
+// parseMbps reads a bandwidth such as "4,750", "Up to 3,500 Mbps" or
+// "10 Gbps" in Mbps, returning zero if s holds no number.
+func parseMbps(s string) int {
+	s = strings.ToLower(strings.Replace(s, ",", "", -1))
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return 0
+	}
+	end := start
+	for end < len(s) && (s[end] == '.' || ('0' <= s[end] && s[end] <= '9')) {
+		end++
+	}
+	n, err := strconv.ParseFloat(s[start:end], 64)
 	if err != nil {
-		err = fmt.Errorf("expected number for Memory, got '%s'", scrape.Text(cols[2]))
-		return InstanceType{}, err
+		return 0
+	}
+	if strings.Contains(s[end:], "gbps") {
+		n *= 1000
 	}
+	return int(n)
+}
 
-	t.ClockSpeed, err = strconv.ParseFloat(scrape.Text(cols[6]), 64)
+// parseStorage reads the instance store of a storage description such as
+// "EBS Only", "1 x 32 SSD", "2 x 1,900 NVMe SSD", "24 x 2TB HDD" or "160", and
+// returns if the type only has EBS storage and the total size of its instance
+// store volumes in GB. Sizes in TB are converted to GB. If s isn't recognized
+// it returns false and zero.
+func parseStorage(s string) (ebsOnly bool, gb int) {
+	s = strings.ToLower(strings.Replace(s, ",", "", -1))
+	if strings.Contains(strings.Replace(s, "-", " ", -1), "ebs only") {
+		return true, 0
+	}
+	count := 1.0
+	if i := strings.Index(s, "x"); i >= 0 {
+		n, err := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+		if err != nil {
+			return false, 0
+		}
+		count, s = n, s[i+1:]
+	}
+	s = strings.TrimSpace(s)
+	num := leadingNumber(s)
+	size, err := strconv.ParseFloat(num, 64)
 	if err != nil {
-		err = fmt.Errorf("expected number for Memory, got '%s'", scrape.Text(cols[2]))
-		return InstanceType{}, err
+		return false, 0
 	}
-	return t, nil
+	if strings.HasPrefix(strings.TrimSpace(s[len(num):]), "tb") {
+		size *= 1000
+	}
+	return false, int(count*size + 0.5)
+}
+
+// ScrapeError is returned when the instance types page could not be
+// retrieved or parsed.
+type ScrapeError struct {
+	// StatusCode of the response from AWS.
+	StatusCode int
+	// Body holds the raw response body. It is only populated by
+	// InstanceTypesDebug.
+	Body []byte
+	// Reason describes why scraping failed.
+	Reason string
+	// Err is the error parsing the page, if any. It's a *ChallengeError if
+	// the page requires JavaScript.
+	Err error
 }
 
+func (e *ScrapeError) Error() string {
+	return "scraping instance types: " + e.Reason
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// ChallengeError is the error of a *ScrapeError for a page which requires
+// JavaScript to render, such as a bot challenge served in place of the
+// instance types page by a CDN. Unlike a page whose structure changed,
+// scraping won't work again until the page can be fetched without running
+// scripts, so another InstanceTypeSource should be used instead.
+type ChallengeError struct {
+	// Marker is the text identifying the page as requiring JavaScript.
+	Marker string
+}
+
+func (e *ChallengeError) Error() string {
+	return fmt.Sprintf("page requires JavaScript (found %q), instance types can't be scraped from it; use another source of instance types", e.Marker)
+}
+
+// challengeMarkers are lower cased substrings of the text, scripts or
+// attributes of pages which require JavaScript, such as the challenges of
+// Cloudflare and AWS WAF.
+var challengeMarkers = []string{
+	"cf-browser-verification",
+	"cf-challenge",
+	"cf_chl_",
+	"challenge-platform",
+	"just a moment...",
+	"awswafintegration",
+	"awswafcookiedomainlist",
+	"enable javascript and cookies to continue",
+	"please enable javascript",
+	"you need to enable javascript",
+	"requires javascript",
+}
+
+// findChallenge looks for any of challengeMarkers in a parsed HTML document,
+// returning a *ChallengeError for the first found.
+func findChallenge(root *html.Node) (*ChallengeError, bool) {
+	match := func(s string) (string, bool) {
+		s = strings.ToLower(s)
+		for _, marker := range challengeMarkers {
+			if strings.Contains(s, marker) {
+				return marker, true
+			}
+		}
+		return "", false
+	}
+	var walk func(n *html.Node) (string, bool)
+	walk = func(n *html.Node) (string, bool) {
+		if n.Type == html.TextNode {
+			return match(n.Data)
+		}
+		for _, attr := range n.Attr {
+			if marker, ok := match(attr.Val); ok {
+				return marker, true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if marker, ok := walk(c); ok {
+				return marker, true
+			}
+		}
+		return "", false
+	}
+	marker, ok := walk(root)
+	if !ok {
+		return nil, false
+	}
+	return &ChallengeError{Marker: marker}, true
+}
+
+// maxChallengeBody bounds how much of an error response is read when
+// looking for the markers of a challenge.
+const maxChallengeBody = 1 << 20
+
 // InstanceTypes makes a request to AWS and parses the current available EC2
 // instance types. Since this information is not available from the EC2 api,
 // we must scrape it ourselves.
+// If the page can't be parsed the error will be of type *ScrapeError.
+//
+// InstanceTypes is equivalent to InstanceTypesContext with a background
+// context.
 func InstanceTypes(client *http.Client) ([]InstanceType, error) {
+	return InstanceTypesContext(context.Background(), client)
+}
+
+// InstanceTypesContext behaves like InstanceTypes, with the request to AWS
+// bound by ctx.
+func InstanceTypesContext(ctx context.Context, client *http.Client) ([]InstanceType, error) {
+	return scrapeInstanceTypes(ctx, client, DefaultInstanceTypeURL, false)
+}
+
+// InstanceTypesDebug behaves like InstanceTypes, but on failure the returned
+// *ScrapeError holds the raw body of the response so the offending HTML can be
+// inspected.
+func InstanceTypesDebug(client *http.Client) ([]InstanceType, error) {
+	return scrapeInstanceTypes(context.Background(), client, DefaultInstanceTypeURL, true)
+}
+
+// scrapeInstanceTypes parses the instance types matrix of the page at
+// pageURL.
+func scrapeInstanceTypes(ctx context.Context, client *http.Client, pageURL string, keepBody bool) ([]InstanceType, error) {
 	if client == nil {
 		client = http.DefaultClient
 	}
-	resp, err := client.Get(instanceTypeURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad response from AWS: %s", resp.Status)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	var raw []byte
+	if keepBody {
+		raw, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(raw)
+	}
+	scrapeErr := func(reason string, err error) error {
+		return &ScrapeError{StatusCode: resp.StatusCode, Body: raw, Reason: reason, Err: err}
 	}
 
-	root, err := html.Parse(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		// challenges are often served with a 403 or 503
+		if root, err := html.Parse(io.LimitReader(body, maxChallengeBody)); err == nil {
+			if challenge, ok := findChallenge(root); ok {
+				return nil, scrapeErr("bad response from AWS: "+resp.Status+": "+challenge.Error(), challenge)
+			}
+		}
+		return nil, scrapeErr("bad response from AWS: "+resp.Status, nil)
+	}
+	root, err := html.Parse(body)
 	if err != nil {
-		return nil, err
+		return nil, scrapeErr(err.Error(), err)
 	}
+	types, err := parseMatrix(root)
+	if err != nil {
+		return nil, scrapeErr(err.Error(), err)
+	}
+	return types, nil
+}
 
+// parseMatrix finds the instance types matrix within a parsed HTML document
+// and parses each of its rows.
+func parseMatrix(root *html.Node) ([]InstanceType, error) {
 	var findMatrix func(node *html.Node) (*html.Node, bool)
 	findMatrix = func(node *html.Node) (*html.Node, bool) {
 		if scrape.Attr(node, "id") == "instance-type-matrix" {
@@ -109,7 +535,11 @@ func InstanceTypes(client *http.Client) ([]InstanceType, error) {
 	}
 	matrixHeader, ok := findMatrix(root)
 	if !ok {
-		return nil, fmt.Errorf("no node with id 'instance-type-matrix'")
+		// a page without the matrix may not have been rendered at all
+		if challenge, ok := findChallenge(root); ok {
+			return nil, challenge
+		}
+		return nil, fmt.Errorf("page structure changed: no node with id 'instance-type-matrix'")
 	}
 
 	contains := func(sli []string, ele string) bool {
@@ -146,10 +576,14 @@ func InstanceTypes(client *http.Client) ([]InstanceType, error) {
 	if len(rows) < 3 {
 		return nil, fmt.Errorf("malformed HTML: could not find table")
 	}
+	header, err := parseHeader(rows[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed HTML: %v", err)
+	}
 	rows = rows[1:]
 	types := make([]InstanceType, len(rows))
 	for i, row := range rows {
-		types[i], err = parseRow(row)
+		types[i], err = parseRow(row, header)
 		if err != nil {
 			return nil, err
 		}
@@ -170,17 +604,19 @@ func openIps(ec2Cli *ec2.EC2) (open []ec2.Address, err error) {
 	return open, nil
 }
 
-func stopAndWait(ec2Cli *ec2.EC2, w io.Writer, id string) error {
-	if _, err := ec2Cli.StopInstances(id); err != nil {
+func stopAndWait(ctx context.Context, ec2Cli *ec2.EC2, w io.Writer, id string, retry RetryPolicy) error {
+	err := retry.do(ctx, func() error {
+		_, err := ec2Cli.StopInstances(id)
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("error stopping instance: %v", err)
 	}
 	for i := 0; i < 20; i++ {
-		time.Sleep(time.Second * 3)
-		opts := ec2.DescribeInstanceStatus{
-			InstanceIds:         []string{id},
-			IncludeAllInstances: true,
+		if err := sleep(ctx, time.Second*3); err != nil {
+			return fmt.Errorf("stopped waiting for instance to stop: %v", err)
 		}
-		resp, err := ec2Cli.DescribeInstanceStatus(&opts, nil)
+		resp, err := describeInstanceStatus(ctx, ec2Cli, id)
 		if err != nil {
 			return fmt.Errorf("error checking instance status: %v", err)
 		}
@@ -203,20 +639,18 @@ func stopAndWait(ec2Cli *ec2.EC2, w io.Writer, id string) error {
 		} else if code == 80 {
 			return nil
 		} else {
-			return fmt.Errorf("unexpected instance state: %s", code)
+			return fmt.Errorf("unexpected instance state: %d", code)
 		}
 	}
 	return fmt.Errorf("timed out waiting for instance to reach 'stopped' state")
 }
 
-func pollUntilRunning(ec2Cli *ec2.EC2, w io.Writer, id string) error {
+func pollUntilRunning(ctx context.Context, ec2Cli *ec2.EC2, w io.Writer, id string) error {
 	for i := 0; i < 20; i++ {
-		time.Sleep(time.Second * 2)
-		opts := ec2.DescribeInstanceStatus{
-			InstanceIds:         []string{id},
-			IncludeAllInstances: true,
+		if err := sleep(ctx, time.Second*2); err != nil {
+			return fmt.Errorf("stopped waiting for instance to start: %v", err)
 		}
-		resp, err := ec2Cli.DescribeInstanceStatus(&opts, nil)
+		resp, err := describeInstanceStatus(ctx, ec2Cli, id)
 		if err != nil {
 			return fmt.Errorf("error getting instance status: %v", err)
 		}
@@ -243,9 +677,39 @@ func pollUntilRunning(ec2Cli *ec2.EC2, w io.Writer, id string) error {
 	return fmt.Errorf("Timed out waiting for instance to reach running state")
 }
 
-func resize(ec2Cli *ec2.EC2, id string, newType string) error {
+// describeInstanceStatus describes the status of instance id, whether or not
+// it's running.
+func describeInstanceStatus(ctx context.Context, ec2Cli *ec2.EC2, id string) (*ec2.DescribeInstanceStatusResp, error) {
+	opts := ec2.DescribeInstanceStatus{
+		InstanceIds:         []string{id},
+		IncludeAllInstances: true,
+	}
+	var resp *ec2.DescribeInstanceStatusResp
+	err := callContext(ctx, func() (err error) {
+		resp, err = ec2Cli.DescribeInstanceStatus(&opts, nil)
+		return err
+	})
+	return resp, err
+}
+
+func startInstance(ctx context.Context, ec2Cli *ec2.EC2, id string, retry RetryPolicy) error {
+	err := retry.do(ctx, func() error {
+		_, err := ec2Cli.StartInstances(id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error starting instance: %v", err)
+	}
+	return nil
+}
+
+func resize(ctx context.Context, ec2Cli *ec2.EC2, id string, newType string, retry RetryPolicy) error {
 	ops := ec2.ModifyInstance{InstanceType: newType}
-	resp, err := ec2Cli.ModifyInstance(id, &ops)
+	var resp *ec2.ModifyInstanceResp
+	err := retry.do(ctx, func() (err error) {
+		resp, err = ec2Cli.ModifyInstance(id, &ops)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("error modifying instance: %v", err)
 	}
@@ -255,6 +719,93 @@ func resize(ec2Cli *ec2.EC2, id string, newType string) error {
 	return nil
 }
 
+// resizeInstance changes the type of an instance. A running instance is
+// stopped first and started again once its type has changed. Progress is
+// written to w as JSON encoded Events. If dryRun is true no changes are made
+// and the steps which would have been taken are reported instead. Transient
+// errors from the stop, modify and start calls are retried according to retry.
+//
+// Once ctx is done no further calls are made and waiting for the instance's
+// state to change stops, so a canceled resize may leave the instance stopped.
+// A call already made to AWS can't be withdrawn, see callContext.
+func resizeInstance(ctx context.Context, ec2Cli *ec2.EC2, w io.Writer, inst ec2.Instance, newType string, dryRun bool, retry RetryPolicy) error {
+	id := inst.InstanceId
+	var running bool
+	switch inst.State.Name {
+	case "running":
+		running = true
+	case "stopped":
+	default:
+		return fmt.Errorf("The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
+	}
+
+	if dryRun {
+		return writeDryRun(w, resizePlan(inst, newType))
+	}
+
+	if running {
+		if err := stopAndWait(ctx, ec2Cli, w, id, retry); err != nil {
+			return err
+		}
+	}
+	if err := writeEvent(w, Event{Status: "message", Message: "modifying"}); err != nil {
+		return err
+	}
+	if err := resize(ctx, ec2Cli, id, newType, retry); err != nil {
+		return err
+	}
+	// if the server was running initially, return it to its original state
+	if running {
+		if err := writeEvent(w, Event{Status: "message", Message: "starting"}); err != nil {
+			return err
+		}
+		if err := startInstance(ctx, ec2Cli, id, retry); err != nil {
+			return err
+		}
+		if err := pollUntilRunning(ctx, ec2Cli, w, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resizePlan describes the steps resizeInstance takes to change the type of
+// inst to newType.
+func resizePlan(inst ec2.Instance, newType string) []string {
+	id := inst.InstanceId
+	running := inst.State.Name == "running"
+	steps := []string{}
+	if running {
+		steps = append(steps, "stop instance "+id)
+	}
+	steps = append(steps, fmt.Sprintf("change type of %s from %s to %s", id, inst.InstanceType, newType))
+	if running {
+		steps = append(steps, "start instance "+id)
+	}
+	return steps
+}
+
+// writeDryRun reports each of the steps a mutating operation would have taken
+// as an Event.
+func writeDryRun(w io.Writer, steps []string) error {
+	for _, step := range steps {
+		if err := writeEvent(w, Event{Status: "message", Message: "dry run: would " + step}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvent writes an Event to w as JSON.
+func writeEvent(w io.Writer, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %v", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
 func allocateIp(ec2Cli *ec2.EC2, instanceId string, allocId string) error {
 	opts := &ec2.AssociateAddress{
 		InstanceId:         instanceId,
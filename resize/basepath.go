@@ -0,0 +1,42 @@
+package resize
+
+import (
+	"net/http"
+	"strings"
+)
+
+// basePath returns the app's BasePath with a leading slash and without a
+// trailing one, or "" if the app is served at the root.
+func (app *App) basePath() string {
+	base := strings.TrimRight(app.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	return base
+}
+
+// pathTo returns the path of the app's page p, which starts with a slash,
+// under the app's BasePath.
+func (app *App) pathTo(p string) string {
+	return app.basePath() + p
+}
+
+// servePrefixed routes r with base stripped from its path. The base path
+// itself is redirected to its root page, and paths outside it aren't found.
+func (app *App) servePrefixed(w http.ResponseWriter, r *http.Request, base string) {
+	switch {
+	case r.URL.Path == base:
+		http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+	case strings.HasPrefix(r.URL.Path, base+"/"):
+		http.StripPrefix(base, http.HandlerFunc(app.route)).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// urlFor is the "url" template helper, which returns the path of the app's
+// page p under the base path of the page being rendered, see App.BasePath.
+func urlFor(data map[string]interface{}, p string) string {
+	base, _ := data["BasePath"].(string)
+	return base + p
+}
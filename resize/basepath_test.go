@@ -0,0 +1,94 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestBasePath(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.BasePath = "/resize/"
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	redirects := []struct{ path, to string }{
+		{"/resize", "/resize/"},
+		{"/resize/", "/resize/login"},
+		{"/resize/instance-types", "/resize/login"},
+		{"/resize/logout", "/resize/"},
+	}
+	for _, tt := range redirects {
+		w := get(tt.path)
+		if w.Code < 300 || w.Code >= 400 || w.Header().Get("Location") != tt.to {
+			t.Errorf("%s: expected a redirect to %s, got %d %q", tt.path, tt.to, w.Code, w.Header().Get("Location"))
+		}
+	}
+	for _, path := range []string{"/login", "/js/global.js", "/resizejs/global.js"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected paths outside the base path not to be found, got %d", path, w.Code)
+		}
+	}
+	if w := get("/resize/js/global.js"); w.Code != http.StatusOK {
+		t.Errorf("expected assets to be served under the base path, got %d", w.Code)
+	}
+
+	w := get("/resize/login")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<meta name="base-path" content="/resize">`,
+		`href="/resize/css/bootstrap.min.css"`,
+		`src="/resize/js/global.js"`,
+		`href="/resize/about"`,
+		`$.post("\/resize\/login"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the login page to include %q", want)
+		}
+	}
+	if strings.Contains(body, `href="/about"`) {
+		t.Errorf("expected no links outside the base path")
+	}
+}
+
+func TestBasePathToken(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.BasePath = "/resize"
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}}, nil)
+	token, err := app.tokenCodec().Encode(tokenName, apiToken{
+		User:    "foo",
+		Auth:    aws.Auth{AccessKey: "foo", SecretKey: "bar"},
+		Region:  "us-west-2",
+		Expires: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/resize/api/instance-types", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if region := w.Header().Get("X-AWS-Region"); region != "us-west-2" {
+		t.Errorf("expected the token's region in the X-AWS-Region header, got %q", region)
+	}
+}
@@ -0,0 +1,184 @@
+package resize
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// basicAuthTTL is how long credentials given through HTTP Basic Auth are
+// trusted after AWS accepted them, so scripts making many requests don't
+// validate them with AWS each time.
+const basicAuthTTL = 5 * time.Minute
+
+// basicAuthFailureTTL is how long credentials rejected by AWS are remembered,
+// so a request is only counted as one failed login however often its
+// credentials are checked.
+const basicAuthFailureTTL = time.Minute
+
+// basicAuthRealm is the realm of the WWW-Authenticate challenge sent to
+// unauthenticated API requests.
+const basicAuthRealm = "EC2 Resize API"
+
+var (
+	errBasicInsecure = errors.New("basic authentication requires HTTPS")
+	errBasicLimited  = errors.New("too many failed login attempts, please try again later")
+	errBasicInvalid  = errors.New("invalid AWS credentials")
+	errBasicRegion   = errors.New("unknown region in X-AWS-Region header")
+)
+
+// basicAuthResult records if AWS accepted a pair of credentials.
+type basicAuthResult struct {
+	err       error
+	checkedAt time.Time
+}
+
+// basicAuthCache holds the outcome of validating credentials given through
+// HTTP Basic Auth, keyed by a hash of the credentials so secrets aren't kept
+// in memory. It is safe for concurrent use.
+type basicAuthCache struct {
+	mu      sync.Mutex
+	results map[[sha256.Size]byte]basicAuthResult
+}
+
+func (c *basicAuthCache) get(key [sha256.Size]byte, now time.Time) (basicAuthResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.results[key]
+	if !ok || now.Sub(res.checkedAt) >= res.ttl() {
+		return basicAuthResult{}, false
+	}
+	return res, true
+}
+
+func (c *basicAuthCache) put(key [sha256.Size]byte, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, res := range c.results {
+		if now.Sub(res.checkedAt) >= res.ttl() {
+			delete(c.results, k)
+		}
+	}
+	if c.results == nil {
+		c.results = make(map[[sha256.Size]byte]basicAuthResult)
+	}
+	c.results[key] = basicAuthResult{err: err, checkedAt: now}
+}
+
+func (res basicAuthResult) ttl() time.Duration {
+	if res.err != nil {
+		return basicAuthFailureTTL
+	}
+	return basicAuthTTL
+}
+
+// basicAuthRequest reports if a request to the API carries HTTP Basic Auth
+// credentials.
+func basicAuthRequest(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return ok && strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// secure reports if a request was made over HTTPS, either to the app itself
+// or, if TrustProxy is set, to the proxy in front of it.
+func (app *App) secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return app.TrustProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// basicCreds returns an EC2 client for the credentials of a request's HTTP
+// Basic Auth header, whose username is an AWS access key and password the
+// matching secret key. No session is involved, the client is built for each
+// request. The region is read from the X-AWS-Region header, us-east-1 by
+// default.
+//
+// Credentials are validated with AWS unless they were recently, and failed
+// validations count against the client's login attempts.
+func (app *App) basicCreds(r *http.Request) (*ec2.EC2, error) {
+	if !app.secure(r) {
+		return nil, errBasicInsecure
+	}
+	accessKey, secretKey, _ := r.BasicAuth()
+	if accessKey == "" || secretKey == "" {
+		return nil, errBasicInvalid
+	}
+	region := defaultRegion
+	if name := r.Header.Get("X-AWS-Region"); name != "" {
+		var ok bool
+		if region, ok = aws.Regions[name]; !ok {
+			return nil, errBasicRegion
+		}
+	}
+	ec2Cli := app.newEC2(aws.Auth{AccessKey: accessKey, SecretKey: secretKey}, region)
+
+	key := sha256.Sum256([]byte(accessKey + "\x00" + secretKey))
+	now := time.Now()
+	if res, ok := app.basicAuth.get(key, now); ok {
+		if res.err != nil {
+			return nil, res.err
+		}
+		return ec2Cli, nil
+	}
+	if !app.allowLogin(r) {
+		app.LogEventContext(r.Context(), "login_rate_limited", Fields{"ip": app.clientIP(r), "basic": true})
+		return nil, errBasicLimited
+	}
+	start := time.Now()
+	_, err := ec2Cli.Instances(nil, nil)
+	app.recordLogin(r, err)
+	fields := Fields{
+		"region":      region.Name,
+		"basic":       true,
+		"duration_ms": durationMS(start),
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	app.LogEventContext(r.Context(), "login", fields)
+	if err != nil {
+		if _, ok := err.(*ec2.Error); !ok {
+			// AWS couldn't be reached, so the credentials may be fine
+			return nil, err
+		}
+		app.basicAuth.put(key, errBasicInvalid, now)
+		return nil, errBasicInvalid
+	}
+	app.basicAuth.put(key, nil, now)
+	return ec2Cli, nil
+}
+
+// renderBasicAuthError rejects an unauthenticated request to the API with a
+// JSON error. Requests without valid credentials are challenged to
+// authenticate with HTTP Basic Auth.
+func (app *App) renderBasicAuthError(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if basicAuthRequest(r) {
+		_, err = app.basicCreds(r)
+	}
+	switch err {
+	case errBasicInsecure:
+		app.renderJSONError(w, err.Error(), http.StatusForbidden)
+	case errBasicLimited:
+		w.Header().Set("Retry-After", app.retryAfter())
+		app.renderJSONError(w, err.Error(), http.StatusTooManyRequests)
+	case errBasicRegion:
+		app.renderJSONError(w, err.Error(), http.StatusBadRequest)
+	case nil, errBasicInvalid:
+		msg := "Unauthorized"
+		if err != nil {
+			msg = err.Error()
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+basicAuthRealm+`", charset="UTF-8"`)
+		app.renderJSONError(w, msg, http.StatusUnauthorized)
+	default:
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,109 @@
+package resize
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	validations := 0
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		validations++
+		if r.FormValue("AWSAccessKeyId") != "good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-basic")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}}, nil)
+
+	do := func(method, path, user, pass string, https bool, header http.Header) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader("{}"))
+		r.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		if https {
+			r.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("GET", "/api/instance-types", "", "", true, nil)
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Errorf("expected 401 with a Basic challenge without credentials, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := do("GET", "/api/instance-types", "good-key", "s3cr3t", false, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected Basic Auth over HTTP to be rejected with 403, got %d", w.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := do("GET", "/api/instance-types", "good-key", "s3cr3t", true, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected Basic Auth to be accepted, got %d: %s", w.Code, w.Body.String())
+		}
+		if region := w.Header().Get("X-AWS-Region"); region != "us-east-1" {
+			t.Errorf("expected the default region, got %q", region)
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("expected no session for Basic Auth, got cookies %v", w.Result().Cookies())
+		}
+	}
+	if validations != 1 {
+		t.Errorf("expected the credentials to be validated once, got %d", validations)
+	}
+
+	header := http.Header{"X-Aws-Region": {"eu-west-1"}}
+	if w := do("GET", "/api/instance-types", "good-key", "s3cr3t", true, header); w.Header().Get("X-AWS-Region") != "eu-west-1" {
+		t.Errorf("expected the region of the X-AWS-Region header, got %q", w.Header().Get("X-AWS-Region"))
+	}
+	header = http.Header{"X-Aws-Region": {"moon-1"}}
+	if w := do("GET", "/api/instance-types", "good-key", "s3cr3t", true, header); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown region to be rejected with 400, got %d", w.Code)
+	}
+
+	validations = 0
+	w = do("GET", "/api/instance-types", "bad-key", "s3cr3t", true, nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 with a challenge for invalid credentials, got %d", w.Code)
+	}
+	if validations != 1 {
+		t.Errorf("expected invalid credentials to be checked once per request, got %d", validations)
+	}
+	b := app.loginLimiter.buckets["192.0.2.1"]
+	if b == nil || b.tokens != float64(DefaultLoginRateLimit.Attempts-1) {
+		t.Errorf("expected one failed login to be counted, got bucket %+v", b)
+	}
+
+	if w := do("GET", "/instance/i-basic", "good-key", "s3cr3t", true, nil); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected Basic Auth to be ignored outside the API, got %d", w.Code)
+	}
+
+	// browsers resend Basic Auth credentials, so cross-site posts still need
+	// a CSRF token
+	if w := do("POST", "/api/resize", "good-key", "s3cr3t", true, nil); w.Code == http.StatusForbidden {
+		t.Errorf("expected a script's post to be exempt from CSRF checks, got %d", w.Code)
+	}
+	header = http.Header{"Sec-Fetch-Site": {"cross-site"}}
+	if w := do("POST", "/api/resize", "good-key", "s3cr3t", true, header); w.Code != http.StatusForbidden {
+		t.Errorf("expected a cross-site post to require a CSRF token, got %d", w.Code)
+	}
+}
@@ -0,0 +1,165 @@
+package resize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// DefaultBulkConcurrency is the number of instances resized at once by a
+// bulk resize.
+const DefaultBulkConcurrency = 4
+
+// maxBulkInstances bounds the number of instances of a single bulk resize.
+const maxBulkInstances = 100
+
+// bulkResizeRequest is the JSON body posted to handleAPIBulkResize.
+type bulkResizeRequest struct {
+	InstanceIDs []string `json:"instance_ids"`
+	NewType     string   `json:"new_type"`
+	DryRun      bool     `json:"dry_run"`
+	Reason      string   `json:"reason"`
+}
+
+// bulkResizeResult is the outcome of resizing a single instance of a bulk
+// resize. Error is set if the instance couldn't be resized.
+type bulkResizeResult struct {
+	InstanceID string   `json:"instance_id"`
+	FromType   string   `json:"from_type,omitempty"`
+	Status     string   `json:"status"` // "success" or "failure"
+	Error      string   `json:"error,omitempty"`
+	Steps      []string `json:"steps"`
+}
+
+// bulkResizeResponse is the JSON response of handleAPIBulkResize.
+type bulkResizeResponse struct {
+	NewType   string             `json:"new_type"`
+	DryRun    bool               `json:"dry_run"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Results   []bulkResizeResult `json:"results"`
+}
+
+func (app *App) bulkConcurrency() int {
+	if app.BulkConcurrency < 1 {
+		return DefaultBulkConcurrency
+	}
+	return app.BulkConcurrency
+}
+
+// bulkResize resizes each of the instances to newType for reason, which may
+// be empty, using at most the app's BulkConcurrency concurrent resizes. The
+// results are returned in the order of ids, and a failure to resize one
+// instance doesn't affect the others.
+func (app *App) bulkResize(ctx context.Context, ec2Cli *ec2.EC2, ids []string, newType, reason string, dryRun bool) []bulkResizeResult {
+	results := make([]bulkResizeResult, len(ids))
+	forEachConcurrently(len(ids), app.bulkConcurrency(), func(i int) {
+		results[i] = app.bulkResizeOne(ctx, ec2Cli, ids[i], newType, reason, dryRun)
+	})
+	return results
+}
+
+// bulkResizeOne validates and performs the resize of a single instance of a
+// bulk resize.
+func (app *App) bulkResizeOne(ctx context.Context, ec2Cli *ec2.EC2, id, newType, reason string, dryRun bool) bulkResizeResult {
+	result := bulkResizeResult{InstanceID: id, Status: "failure", Steps: []string{}}
+	inst, ok, err := findInstance(ec2Cli, id)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !ok {
+		result.Error = "no instance with ID " + id
+		return result
+	}
+	result.FromType = inst.InstanceType
+	if err := app.validateResize(ctx, inst, newType); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var steps eventLog
+	err = app.resizeInstance(ctx, ec2Cli, &steps, inst, newType, reason, dryRun)
+	result.Steps = append(result.Steps, steps...)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "success"
+	return result
+}
+
+// Path: /api/resize
+//
+// handleAPIBulkResize changes the type of several instances in the selected
+// region to the same new type. It accepts a JSON object listing the
+// "instance_ids" and the "new_type", and responds with the result of each
+// resize once all have finished. Instances which can't be resized are
+// reported as failures without affecting the rest. If "dry_run" is set, or
+// the "dryrun" query parameter is, the steps are reported without being
+// taken.
+func (app *App) handleAPIBulkResize(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.renderJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	var req bulkResizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.renderJSONError(w, "expected a JSON object listing instance_ids and a new_type", http.StatusBadRequest)
+		return
+	}
+	if req.NewType == "" {
+		app.renderJSONError(w, "no instance type provided", http.StatusBadRequest)
+		return
+	}
+	// resizing an instance twice at once would fail both resizes
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, id := range req.InstanceIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		app.renderJSONError(w, "no instance IDs provided", http.StatusBadRequest)
+		return
+	}
+	if err := app.validateReason(req.Reason); err != nil {
+		app.renderJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxBulkInstances {
+		app.renderJSONError(w, fmt.Sprintf("at most %d instances can be resized at once", maxBulkInstances), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := app.DryRun || req.DryRun || r.URL.Query().Get("dryrun") != ""
+	resp := bulkResizeResponse{
+		NewType: req.NewType,
+		DryRun:  dryRun,
+		Results: app.bulkResize(r.Context(), ec2Cli, ids, req.NewType, req.Reason, dryRun),
+	}
+	for _, result := range resp.Results {
+		if result.Status == "success" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	app.LogEventContext(r.Context(), "bulk_resize", Fields{
+		"region":    ec2Cli.Region.Name,
+		"to_type":   req.NewType,
+		"dry_run":   dryRun,
+		"succeeded": resp.Succeeded,
+		"failed":    resp.Failed,
+	})
+	app.renderJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,131 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// stoppedInstanceResponse describes a stopped instance, formatted with its ID
+// and type.
+const stoppedInstanceResponse = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2014-02-01/">
+  <requestId>98e3c9a4-848c-4d6d-8e8a-b1bdEXAMPLE</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-b27e30d9</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>%s</instanceId>
+          <instanceState><code>80</code><name>stopped</name></instanceState>
+          <instanceType>%s</instanceType>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`
+
+func TestAPIBulkResize(t *testing.T) {
+	var mu sync.Mutex
+	modified := map[string]string{}
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("Action") {
+		case "DescribeInstances":
+			switch id := r.FormValue("InstanceId.1"); id {
+			case "i-one", "i-two":
+				fmt.Fprintf(w, stoppedInstanceResponse, id, "m1.small")
+			case "i-arm":
+				fmt.Fprintf(w, stoppedInstanceResponse, id, "a1.medium")
+			default:
+				fmt.Fprint(w, noInstancesResponse)
+			}
+		case "ModifyInstanceAttribute":
+			mu.Lock()
+			modified[r.FormValue("InstanceId")] = r.FormValue("InstanceType.Value")
+			mu.Unlock()
+			fmt.Fprint(w, modifyInstanceResponse)
+		default:
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.BulkConcurrency = 2
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m1.small", CPUs: 1, Memory: 1.7},
+		{Name: "m1.large", CPUs: 2, Memory: 7.5},
+		{Name: "a1.medium", CPUs: 1, Memory: 2},
+	}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	post := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/api/resize", strings.NewReader(body))
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		// skip CSRF checks, which are tested separately
+		w := httptest.NewRecorder()
+		app.handleAPIBulkResize(w, r)
+		return w
+	}
+
+	w = post(`{"instance_ids":["i-one","i-missing","i-two","i-arm","i-one"],"new_type":"m1.large"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp bulkResizeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 2 {
+		t.Errorf("expected 2 successes and 2 failures, got %d and %d", resp.Succeeded, resp.Failed)
+	}
+	want := []struct{ id, status string }{
+		{"i-one", "success"},
+		{"i-missing", "failure"},
+		{"i-two", "success"},
+		{"i-arm", "failure"},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), resp.Results)
+	}
+	for i, tt := range want {
+		got := resp.Results[i]
+		if got.InstanceID != tt.id || got.Status != tt.status {
+			t.Errorf("result %d: expected %s %s, got %s %s", i, tt.id, tt.status, got.InstanceID, got.Status)
+		}
+		if (got.Status == "failure") != (got.Error != "") {
+			t.Errorf("%s: expected failures and only failures to have a reason, got %q", got.InstanceID, got.Error)
+		}
+	}
+	if len(modified) != 2 || modified["i-one"] != "m1.large" || modified["i-two"] != "m1.large" {
+		t.Errorf("expected i-one and i-two to be resized, got %v", modified)
+	}
+
+	for _, body := range []string{
+		`not json`,
+		`{"instance_ids":["i-one"]}`,
+		`{"instance_ids":[],"new_type":"m1.large"}`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
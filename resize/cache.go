@@ -0,0 +1,212 @@
+package resize
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTypeCacheTTL is the amount of time a TypeCache serves scraped
+// instance types before fetching them again.
+const DefaultTypeCacheTTL = time.Hour
+
+// DefaultTypeCacheBackoff is the amount of time a TypeCache waits after a
+// failed fetch before fetching again.
+const DefaultTypeCacheBackoff = 30 * time.Second
+
+// TypeCache wraps an InstanceTypeSource, holding on to the last successfully
+// fetched result until its TTL expires. It is safe for concurrent use.
+type TypeCache struct {
+	// TTL specifies how long a fetched result is considered fresh.
+	// If zero, DefaultTypeCacheTTL is used.
+	TTL time.Duration
+
+	// Backoff specifies how long to wait after a failed fetch before
+	// fetching again. Meanwhile the stale result, or the error if there's
+	// none, is served. If zero, DefaultTypeCacheBackoff is used.
+	Backoff time.Duration
+
+	// Source provides the instance types.
+	// If nil, WebScraperSource is used.
+	Source InstanceTypeSource
+
+	// Client is the HTTP client passed to the Source.
+	Client *http.Client
+
+	// Logger specifies an optional logger for scrape failures.
+	// If nil, logging goes to the log package's standard logger.
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	types     []InstanceType
+	fetchedAt time.Time
+	forced    bool          // ForceRefresh was called since the last fetch
+	stale     bool          // the last refresh failed
+	fallback  bool          // types came from the fallback of a FallbackSource
+	fetching  chan struct{} // closed when the fetch in progress finishes
+	failedAt  time.Time     // when the last fetch failed
+	failErr   error         // the error of the last failed fetch
+}
+
+// NewTypeCache returns a TypeCache which scrapes instance types from the AWS
+// website using the provided client and holds them for the ttl.
+func NewTypeCache(client *http.Client, ttl time.Duration) *TypeCache {
+	return &TypeCache{TTL: ttl, Client: client}
+}
+
+// InstanceTypes returns the cached instance types, fetching them if the cache
+// is empty or expired. If a fetch fails after the cache has been populated,
+// the stale result is returned and the error is logged.
+func (c *TypeCache) InstanceTypes() ([]InstanceType, error) {
+	return c.InstanceTypesContext(context.Background())
+}
+
+// InstanceTypesContext behaves like InstanceTypes, with any fetch bound by
+// ctx. The cache isn't locked during the fetch, and only one fetch is made at
+// a time: concurrent calls are served the current result meanwhile, or wait
+// for the fetch if there's none. After a fetch fails, no other is made for
+// the cache's Backoff.
+func (c *TypeCache) InstanceTypesContext(ctx context.Context) ([]InstanceType, error) {
+	c.mu.Lock()
+	if c.types != nil && !c.forced && time.Since(c.fetchedAt) < c.ttl() {
+		defer c.mu.Unlock()
+		return c.types, nil
+	}
+	if !c.failedAt.IsZero() && time.Since(c.failedAt) < c.backoff() {
+		defer c.mu.Unlock()
+		if c.types == nil {
+			return nil, c.failErr
+		}
+		return c.types, nil
+	}
+	if wait := c.fetching; wait != nil {
+		types := c.types
+		c.mu.Unlock()
+		if types != nil {
+			return types, nil
+		}
+		select {
+		case <-wait:
+			return c.InstanceTypesContext(ctx)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	c.fetching = done
+	c.mu.Unlock()
+
+	src := c.Source
+	if src == nil {
+		src = WebScraperSource{}
+	}
+	types, primaryErr, err := fetchTypes(ctx, src, c.Client)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetching = nil
+	close(done)
+	if err != nil {
+		// a canceled request isn't a failure of the source
+		if ctx.Err() == nil {
+			c.failedAt = time.Now()
+			c.failErr = err
+		}
+		if c.types == nil {
+			return nil, err
+		}
+		c.logf("could not refresh instance types, serving stale results: %v", err)
+		c.stale = true
+		return c.types, nil
+	}
+	c.store(types, primaryErr)
+	return types, nil
+}
+
+// Refresh fetches the instance types regardless of their age, replacing the
+// cached result if the fetch succeeds. If it fails, the current result is
+// kept and marked stale, and the error is returned. Unlike InstanceTypes, it
+// fetches regardless of the Backoff or a fetch already in progress. The cache
+// isn't locked during the fetch, so concurrent calls to InstanceTypes are
+// served the current result meanwhile.
+func (c *TypeCache) Refresh(ctx context.Context) error {
+	src := c.Source
+	if src == nil {
+		src = WebScraperSource{}
+	}
+	types, primaryErr, err := fetchTypes(ctx, src, c.Client)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stale = c.types != nil
+		return err
+	}
+	c.store(types, primaryErr)
+	return nil
+}
+
+// store replaces the cached result with freshly fetched types. primaryErr is
+// the error of the primary source if they came from its fallback. c.mu must
+// be held.
+func (c *TypeCache) store(types []InstanceType, primaryErr error) {
+	c.types = types
+	c.fetchedAt = time.Now()
+	c.forced = false
+	c.stale = false
+	c.fallback = primaryErr != nil
+	c.failedAt = time.Time{}
+	c.failErr = nil
+}
+
+// FetchedAt returns when the cached instance types were fetched, which is
+// the zero time if they haven't been. stale reports if the last attempt to
+// refresh them failed, so older results are being served.
+func (c *TypeCache) FetchedAt() (at time.Time, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetchedAt, c.stale
+}
+
+// FromFallback reports if the cached instance types were served by the
+// fallback of a FallbackSource, as its primary data couldn't be used, so they
+// may be out of date.
+func (c *TypeCache) FromFallback() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fallback
+}
+
+// ForceRefresh invalidates the cache, causing the next call to InstanceTypes
+// to fetch new results, even if a fetch failed within the Backoff. The
+// current results are kept in case that fetch fails.
+func (c *TypeCache) ForceRefresh() {
+	c.mu.Lock()
+	c.forced = true
+	c.failedAt = time.Time{}
+	c.mu.Unlock()
+}
+
+func (c *TypeCache) ttl() time.Duration {
+	if c.TTL == 0 {
+		return DefaultTypeCacheTTL
+	}
+	return c.TTL
+}
+
+func (c *TypeCache) backoff() time.Duration {
+	if c.Backoff == 0 {
+		return DefaultTypeCacheBackoff
+	}
+	return c.Backoff
+}
+
+func (c *TypeCache) logf(format string, a ...interface{}) {
+	if c.Logger == nil {
+		log.Printf(format, a...)
+	} else {
+		c.Logger.Printf(format, a...)
+	}
+}
@@ -0,0 +1,192 @@
+package resize
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sourceFunc adapts a function to the InstanceTypeSource interface.
+type sourceFunc func(client *http.Client) ([]InstanceType, error)
+
+func (f sourceFunc) Fetch(client *http.Client) ([]InstanceType, error) {
+	return f(client)
+}
+
+func TestTypeCache(t *testing.T) {
+	calls := 0
+	var fetchErr error
+	c := NewTypeCache(nil, time.Hour)
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	c.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		calls++
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return []InstanceType{{Name: "t2.micro"}}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		types, err := c.InstanceTypes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(types) != 1 {
+			t.Fatalf("expected 1 instance type, got %d", len(types))
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 fetch, got %d", calls)
+	}
+	fetchedAt, stale := c.FetchedAt()
+	if fetchedAt.IsZero() || stale {
+		t.Errorf("expected fresh results with their fetch time, got %v (stale %v)", fetchedAt, stale)
+	}
+
+	c.ForceRefresh()
+	fetchErr = errors.New("scrape failed")
+	types, err := c.InstanceTypes()
+	if err != nil {
+		t.Fatalf("expected stale results to be served, got error: %v", err)
+	}
+	if len(types) != 1 {
+		t.Errorf("expected 1 stale instance type, got %d", len(types))
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 fetches, got %d", calls)
+	}
+	if at, stale := c.FetchedAt(); !at.Equal(fetchedAt) || !stale {
+		t.Errorf("expected stale results fetched at %v, got %v (stale %v)", fetchedAt, at, stale)
+	}
+}
+
+func TestTypeCacheEmptyError(t *testing.T) {
+	c := NewTypeCache(nil, time.Hour)
+	c.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		return nil, errors.New("scrape failed")
+	})
+	if _, err := c.InstanceTypes(); err == nil {
+		t.Errorf("expected error from empty cache")
+	}
+}
+
+func TestTypeCacheRefresh(t *testing.T) {
+	var fetchErr error
+	c := NewTypeCache(nil, time.Hour)
+	c.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		return []InstanceType{{Name: "t2.micro"}}, fetchErr
+	})
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.FetchedAt()
+	if first.IsZero() {
+		t.Fatal("expected the refresh to populate the cache")
+	}
+
+	fetchErr = errors.New("scrape failed")
+	if err := c.Refresh(context.Background()); err != fetchErr {
+		t.Errorf("expected the fetch error, got %v", err)
+	}
+	if at, stale := c.FetchedAt(); at != first || !stale {
+		t.Errorf("expected the earlier results to be kept as stale, got %v (stale %v)", at, stale)
+	}
+	if types, err := c.InstanceTypes(); err != nil || len(types) != 1 {
+		t.Errorf("expected the cached types to be served, got %v, %v", types, err)
+	}
+}
+
+func TestTypeCacheBackoff(t *testing.T) {
+	calls := 0
+	fetchErr := errors.New("scrape failed")
+	c := NewTypeCache(nil, time.Hour)
+	c.Backoff = 50 * time.Millisecond
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	c.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		calls++
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return []InstanceType{{Name: "t2.micro"}}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.InstanceTypes(); err == nil {
+			t.Fatal("expected the fetch error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected failures to be backed off, got %d fetches", calls)
+	}
+	time.Sleep(c.Backoff)
+	fetchErr = nil
+	if types, err := c.InstanceTypes(); err != nil || len(types) != 1 {
+		t.Fatalf("expected a fetch once the backoff passed, got %v, %v", types, err)
+	}
+
+	// stale results are served while backing off
+	c.ForceRefresh()
+	fetchErr = errors.New("scrape failed")
+	calls = 0
+	for i := 0; i < 3; i++ {
+		if types, err := c.InstanceTypes(); err != nil || len(types) != 1 {
+			t.Fatalf("expected stale results, got %v, %v", types, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected failures to be backed off, got %d fetches", calls)
+	}
+	c.ForceRefresh()
+	c.InstanceTypes()
+	if calls != 2 {
+		t.Errorf("expected ForceRefresh to skip the backoff, got %d fetches", calls)
+	}
+}
+
+func TestTypeCacheConcurrentFetch(t *testing.T) {
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := NewTypeCache(nil, time.Hour)
+	c.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		fetching <- struct{}{}
+		<-release
+		return []InstanceType{{Name: "t2.micro"}}, nil
+	})
+
+	// callers without a result wait for the fetch in progress
+	results := make(chan int)
+	for i := 0; i < 2; i++ {
+		go func() {
+			types, _ := c.InstanceTypes()
+			results <- len(types)
+		}()
+	}
+	<-fetching
+	close(release)
+	for i := 0; i < 2; i++ {
+		if n := <-results; n != 1 {
+			t.Errorf("expected the fetched type, got %d types", n)
+		}
+	}
+	select {
+	case <-fetching:
+		t.Errorf("expected a single fetch")
+	default:
+	}
+
+	// callers are served the current result while it's refetched
+	release = make(chan struct{})
+	c.ForceRefresh()
+	go c.InstanceTypes()
+	<-fetching
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if types, err := c.InstanceTypesContext(ctx); err != nil || len(types) != 1 {
+		t.Errorf("expected the current result during the fetch, got %v, %v", types, err)
+	}
+	close(release)
+}
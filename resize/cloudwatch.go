@@ -0,0 +1,61 @@
+package resize
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// The vendored goamz has no CloudWatch client, so requests are made directly
+// like those to STS.
+
+// cloudWatchEndpoint returns the CloudWatch endpoint of a region. If the app
+// has an Endpoint, e.g. LocalStack, CloudWatch requests are sent to it too.
+func (app *App) cloudWatchEndpoint(region string) string {
+	if app.Endpoint != "" {
+		return app.Endpoint
+	}
+	return "https://monitoring." + region + ".amazonaws.com/"
+}
+
+// datapoint is a statistic of a metric over one period.
+type datapoint struct {
+	Timestamp time.Time
+	Average   float64
+	Maximum   float64
+}
+
+// metricQuery selects the datapoints of an instance's metric.
+type metricQuery struct {
+	Namespace  string
+	MetricName string
+	InstanceID string
+	Start, End time.Time
+	Period     time.Duration
+}
+
+// metricStatistics returns the average and maximum of a metric of an
+// instance over each period between the query's start and end, in no order.
+// Metrics are matched by the InstanceId dimension alone.
+// On an error returned by AWS, error will be of type *ec2.Error.
+func (app *App) metricStatistics(auth aws.Auth, region string, q metricQuery) ([]datapoint, error) {
+	params := map[string]string{
+		"Action":                    "GetMetricStatistics",
+		"Namespace":                 q.Namespace,
+		"MetricName":                q.MetricName,
+		"Dimensions.member.1.Name":  "InstanceId",
+		"Dimensions.member.1.Value": q.InstanceID,
+		"StartTime":                 q.Start.UTC().Format(time.RFC3339),
+		"EndTime":                   q.End.UTC().Format(time.RFC3339),
+		"Period":                    strconv.Itoa(int(q.Period / time.Second)),
+		"Statistics.member.1":       "Average",
+		"Statistics.member.2":       "Maximum",
+	}
+	var resp struct {
+		Datapoints []datapoint `xml:"GetMetricStatisticsResult>Datapoints>member"`
+	}
+	svc := awsService{"CloudWatch", "monitoring", "2010-08-01", app.cloudWatchEndpoint(region), region}
+	err := awsQuery(app.httpClient(), auth, svc, params, &resp)
+	return resp.Datapoints, err
+}
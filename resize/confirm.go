@@ -0,0 +1,214 @@
+package resize
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func init() {
+	gob.Register(&resizeConfirmation{})
+}
+
+// resizeConfirmationTTL is how long a confirmed resize may wait before it's
+// executed.
+const resizeConfirmationTTL = 10 * time.Minute
+
+var errResizeConfirmation = errors.New("this resize has not been confirmed, or its confirmation is stale or was already used; please confirm the resize again")
+
+var errStopConfirmation = errors.New("stopping this instance has not been confirmed, or its confirmation is stale or was already used; please confirm again")
+
+// resizeConfirmation records the resize, or other action, a user confirmed,
+// and is stored in the login session until it is executed.
+type resizeConfirmation struct {
+	Nonce      string
+	Action     string // "resize" or "stop"
+	InstanceID string
+	NewType    string
+	State      string // state of the instance when the plan was shown
+	Issued     time.Time
+}
+
+// usedNonces remembers the confirmations which have been executed until they
+// expire. Sessions can't be updated once a websocket is established, so
+// rejecting replays can't rely on removing the confirmation from the session
+// alone.
+type usedNonces struct {
+	mu    sync.Mutex
+	nonce map[string]time.Time
+}
+
+// use marks nonce as used, reporting false if it already was.
+func (u *usedNonces) use(nonce string, expires time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	for n, exp := range u.nonce {
+		if now.After(exp) {
+			delete(u.nonce, n)
+		}
+	}
+	if _, ok := u.nonce[nonce]; ok {
+		return false
+	}
+	if u.nonce == nil {
+		u.nonce = make(map[string]time.Time)
+	}
+	u.nonce[nonce] = expires
+	return true
+}
+
+// confirmResize stores a new confirmation for resizing inst to newType in the
+// request's session, returning its nonce.
+func (app *App) confirmResize(w http.ResponseWriter, r *http.Request, inst ec2.Instance, newType string) (string, error) {
+	return app.confirmAction(w, r, "resize", inst, newType)
+}
+
+// confirmAction stores a new confirmation for taking action on inst in the
+// request's session, returning its nonce. Only the latest confirmation is
+// kept, whatever its action.
+func (app *App) confirmAction(w http.ResponseWriter, r *http.Request, action string, inst ec2.Instance, newType string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	c := &resizeConfirmation{
+		Nonce:      base64.URLEncoding.EncodeToString(b),
+		Action:     action,
+		InstanceID: inst.InstanceId,
+		NewType:    newType,
+		State:      inst.State.Name,
+		Issued:     time.Now(),
+	}
+	session, _ := app.store.Get(r, "yhat-resize")
+	session.Values["resize"] = c
+	if err := app.saveSession(w, r, session, nil); err != nil {
+		return "", err
+	}
+	return c.Nonce, nil
+}
+
+// checkResizeConfirmation verifies that the user confirmed resizing inst to
+// newType with the given nonce, and that the instance is still in the state
+// shown when it was confirmed. A nonce can only be used once. If w is not
+// nil, the confirmation is also removed from the session.
+func (app *App) checkResizeConfirmation(w http.ResponseWriter, r *http.Request, inst ec2.Instance, newType, nonce string) error {
+	return app.checkConfirmation(w, r, "resize", inst, newType, nonce, errResizeConfirmation)
+}
+
+// checkConfirmation is checkResizeConfirmation for any action, returning
+// errConfirm if it wasn't confirmed.
+func (app *App) checkConfirmation(w http.ResponseWriter, r *http.Request, action string, inst ec2.Instance, newType, nonce string, errConfirm error) error {
+	session, _ := app.store.Get(r, "yhat-resize")
+	c, ok := session.Values["resize"].(*resizeConfirmation)
+	if !ok || nonce == "" || subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 {
+		return errConfirm
+	}
+	if w != nil {
+		delete(session.Values, "resize")
+		if err := app.saveSession(w, r, session, nil); err != nil {
+			return err
+		}
+	}
+	expires := c.Issued.Add(resizeConfirmationTTL)
+	if !app.usedNonces.use(c.Nonce, expires) || time.Now().After(expires) {
+		return errConfirm
+	}
+	if c.Action != action || c.InstanceID != inst.InstanceId || c.NewType != newType || c.State != inst.State.Name {
+		return errConfirm
+	}
+	return nil
+}
+
+// Path: /instance/{instance}/resize/confirm
+//
+// handleResizeConfirm shows the steps taken to change the type of an instance
+// to the one in the "new-type" query parameter, and asks the user to confirm
+// them. Confirming posts to handleResize.
+func (app *App) handleResizeConfirm(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+
+	newType := r.URL.Query().Get("new-type")
+	if err := app.validateResize(r.Context(), instance, newType); err != nil {
+		if _, ok := err.(typesUnavailableError); ok {
+			app.render503(w, r, err)
+		} else {
+			app.render400(w, r, err)
+		}
+		return
+	}
+	switch instance.State.Name {
+	case "running", "stopped":
+	default:
+		app.render400(w, r, fmt.Errorf("The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'"))
+		return
+	}
+
+	nonce, err := app.confirmResize(w, r, instance, newType)
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("could not save confirmation: %v", err))
+		return
+	}
+	action := app.pathTo("/instance/" + url.PathEscape(instanceId) + "/resize")
+	if r.URL.Query().Get("dryrun") != "" {
+		action += "?dryrun=1"
+	}
+	steps := resizePlan(instance, newType)
+	if app.TagResized {
+		steps = append(steps, tagStep(instance))
+	}
+	var warnings []string
+	if types, err := app.TypeCache.InstanceTypesContext(r.Context()); err == nil {
+		from, _ := FindType(types, instance.InstanceType)
+		to, _ := FindType(types, newType)
+		if warning := burstableWarning(from, to); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	data := map[string]interface{}{
+		"Instance":   instance,
+		"Name":       nameTag(instance),
+		"NewType":    newType,
+		"Steps":      steps,
+		"Warnings":   warnings,
+		"Nonce":      nonce,
+		"Action":     action,
+		"DryRun":     r.URL.Query().Get("dryrun") != "",
+		"TagResized": app.TagResized,
+		"Reason": map[string]interface{}{
+			"Required":  app.RequireReason,
+			"MinLength": app.minReasonLength(),
+		},
+	}
+	app.render(w, r, "confirm-resize.html", data)
+}
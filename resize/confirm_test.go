@@ -0,0 +1,171 @@
+package resize
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const taggedInstanceResponse = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2014-02-01/">
+  <requestId>1</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-1</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>i-confirm</instanceId>
+          <instanceState><code>16</code><name>%s</name></instanceState>
+          <instanceType>m1.small</instanceType>
+          <tagSet><item><key>Name</key><value>web-1</value></item></tagSet>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`
+
+var nonceField = regexp.MustCompile(`name="nonce" value="([^"]+)"`)
+
+func TestResizeConfirm(t *testing.T) {
+	var mu sync.Mutex
+	state := "running"
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, taggedInstanceResponse, state)
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DryRun = true
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	cli := &http.Client{Jar: jar}
+
+	// confirm returns the nonce and CSRF token of a new confirmation page
+	confirm := func() (nonce, token string) {
+		resp, err := cli.Get(s.URL + "/instance/i-confirm/resize/confirm?new-type=m1.large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected confirmation page, got %s: %s", resp.Status, body)
+		}
+		for _, want := range []string{"web-1", "<td>running</td>", "stop instance i-confirm"} {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("expected %q on confirmation page", want)
+			}
+		}
+		n := nonceField.FindSubmatch(body)
+		m := csrfMeta.FindSubmatch(body)
+		if n == nil || m == nil {
+			t.Fatal("no nonce or CSRF token on confirmation page")
+		}
+		return html.UnescapeString(string(n[1])), html.UnescapeString(string(m[1]))
+	}
+	post := func(client *http.Client, nonce, token string) int {
+		form := url.Values{"new-type": {"m1.large"}, "nonce": {nonce}, DefaultCSRFFieldName: {token}}
+		resp, err := client.PostForm(s.URL+"/instance/i-confirm/resize", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	nonce, token := confirm()
+	if status := post(cli, "", token); status != http.StatusBadRequest {
+		t.Errorf("expected resize without a nonce to be rejected, got %d", status)
+	}
+	// keep a copy of the session holding the confirmation to replay it
+	replayJar, _ := cookiejar.New(nil)
+	replayJar.SetCookies(u, jar.Cookies(u))
+	if status := post(cli, nonce, token); status != http.StatusOK {
+		t.Fatalf("expected confirmed resize to succeed, got %d", status)
+	}
+	if status := post(cli, nonce, token); status != http.StatusBadRequest {
+		t.Errorf("expected a used confirmation to be rejected, got %d", status)
+	}
+	if status := post(&http.Client{Jar: replayJar}, nonce, token); status != http.StatusBadRequest {
+		t.Errorf("expected a replayed confirmation to be rejected, got %d", status)
+	}
+
+	// a confirmation is stale once the instance's state changes
+	nonce, token = confirm()
+	mu.Lock()
+	state = "stopped"
+	mu.Unlock()
+	if status := post(cli, nonce, token); status != http.StatusBadRequest {
+		t.Errorf("expected a stale confirmation to be rejected, got %d", status)
+	}
+}
+
+func TestResizeConfirmBurstable(t *testing.T) {
+	ec2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, taggedInstanceResponse, "stopped")
+	}))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}, {Name: "t3.micro"}}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	confirm := func(newType string) string {
+		r, _ := http.NewRequest("GET", "/instance/i-confirm/resize/confirm?new-type="+newType, nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		cw := httptest.NewRecorder()
+		app.ServeHTTP(cw, r)
+		if cw.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", cw.Code, cw.Body.String())
+		}
+		return cw.Body.String()
+	}
+	if body := confirm("t3.micro"); !strings.Contains(body, "sustains 10% of each vCPU") {
+		t.Errorf("expected a warning about resizing to a burstable type")
+	}
+	if body := confirm("m1.large"); strings.Contains(body, "resize-warning") {
+		t.Errorf("expected no warning when neither type is burstable")
+	}
+}
@@ -0,0 +1,67 @@
+package resize
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// authErrorCodes are the codes of the errors AWS returns for credentials it
+// doesn't accept, as opposed to valid credentials lacking a permission.
+var authErrorCodes = map[string]bool{
+	"AuthFailure":                 true,
+	"IncompleteSignature":         true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"MissingAuthenticationToken":  true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+	"ExpiredToken":                true,
+}
+
+// validateCreds checks that AWS accepts ec2Cli's credentials by describing
+// the regions, which is cheaper than listing instances. Credentials denied
+// DescribeRegions itself are accepted, as AWS authenticated them; what they
+// may do is checked when it's done. On an authentication error, error will be
+// of type *ec2.Error. If App.SkipCredentialCheck is set no request is made.
+func (app *App) validateCreds(ec2Cli *ec2.EC2) error {
+	if app.SkipCredentialCheck {
+		return nil
+	}
+	params := url.Values{
+		"Action":  {"DescribeRegions"},
+		"Version": {"2014-06-15"},
+	}
+	resp, err := ec2Query(app.ec2HTTPClient(), ec2Cli, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = ec2ResponseError(resp)
+	if e, ok := err.(*ec2.Error); ok && e.Code == "UnauthorizedOperation" {
+		return nil
+	}
+	return err
+}
+
+// loginFailure returns the message and status code of the response to a
+// login which failed with err, telling credentials AWS rejected apart from
+// AWS being unreachable.
+func loginFailure(err error) (string, int) {
+	if e, ok := err.(*ec2.Error); ok {
+		if authErrorCodes[e.Code] {
+			return fmt.Sprintf("Invalid credentials: AWS rejected them with '%s'", e.Message), http.StatusUnauthorized
+		}
+		return fmt.Sprintf("bad response from AWS '%s'", e.Message), http.StatusBadRequest
+	}
+	if _, ok := err.(net.Error); ok {
+		return "Could not reach AWS to check the credentials, please try again later", http.StatusBadGateway
+	}
+	return err.Error(), http.StatusInternalServerError
+}
@@ -0,0 +1,94 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const unauthorizedOperationResponse = `<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>You are not authorized to perform this operation.</Message></Error></Errors><RequestID>1</RequestID></Response>`
+
+func TestLoginCredentialCheck(t *testing.T) {
+	var actions []string
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		actions = append(actions, r.FormValue("Action"))
+		switch auth := r.Header.Get("Authorization"); {
+		case strings.Contains(auth, "Credential=valid/"):
+			fmt.Fprint(w, `<DescribeRegionsResponse><regionInfo/></DescribeRegionsResponse>`)
+		case strings.Contains(auth, "Credential=limited/"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, unauthorizedOperationResponse)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+		}
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Transport = &TransportOptions{MaxTries: 1}
+	s := httptest.NewServer(app)
+	defer s.Close()
+	login := func(key string) (int, string) {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cli := &http.Client{Jar: jar}
+		resp, err := cli.Get(s.URL + "/login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		m := csrfMeta.FindStringSubmatch(string(body))
+		if m == nil {
+			t.Fatal("no CSRF token rendered in page")
+		}
+		form := url.Values{"accessKey": {key}, "secretKey": {"s3cr3t"}, DefaultCSRFFieldName: {m[1]}}
+		resp, err = cli.PostForm(s.URL+"/login", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	app.Endpoint = ec2Server.URL
+	if code, body := login("valid"); code != http.StatusOK {
+		t.Errorf("expected valid credentials to log in, got %d: %s", code, body)
+	}
+	if len(actions) != 1 || actions[0] != "DescribeRegions" {
+		t.Errorf("expected a single DescribeRegions call, got %v", actions)
+	}
+	// AWS authenticated credentials denied DescribeRegions
+	if code, body := login("limited"); code != http.StatusOK {
+		t.Errorf("expected credentials lacking the permission to log in, got %d: %s", code, body)
+	}
+	if code, body := login("bogus"); code != http.StatusUnauthorized || !strings.Contains(body, "Invalid credentials") {
+		t.Errorf("expected rejected credentials to be reported as invalid, got %d: %s", code, body)
+	}
+
+	app.Endpoint = offline.URL
+	code, body := login("valid")
+	if code != http.StatusBadGateway || !strings.Contains(body, "Could not reach AWS") || strings.Contains(body, "Invalid credentials") {
+		t.Errorf("expected an unreachable AWS to be reported as such, got %d: %s", code, body)
+	}
+
+	app.SkipCredentialCheck = true
+	if code, body := login("bogus"); code != http.StatusOK {
+		t.Errorf("expected credentials to be accepted unchecked, got %d: %s", code, body)
+	}
+}
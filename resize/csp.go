@@ -0,0 +1,46 @@
+package resize
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+)
+
+// cspNoncePlaceholder is replaced in an App's ContentSecurityPolicy by the
+// nonce of each rendered page.
+const cspNoncePlaceholder = "{nonce}"
+
+// DefaultContentSecurityPolicy is used when an App's ContentSecurityPolicy is
+// empty. Only scripts and styles from the app itself or marked with the page's
+// nonce may run, except for jQuery, which the templates load from Google's
+// CDN.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}' https://ajax.googleapis.com; " +
+	"style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// cspNonce returns a new random nonce for a rendered page. It's encoded
+// without the characters html/template escapes in attributes, so the header
+// and the templates' nonce attributes match.
+func cspNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// contentSecurityPolicy returns the Content-Security-Policy header of a page
+// rendered with nonce.
+func (app *App) contentSecurityPolicy(nonce string) string {
+	policy := app.ContentSecurityPolicy
+	if policy == "" {
+		policy = DefaultContentSecurityPolicy
+	}
+	return strings.Replace(policy, cspNoncePlaceholder, nonce, -1)
+}
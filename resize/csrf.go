@@ -0,0 +1,130 @@
+package resize
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// Defaults for the CSRF settings of an App.
+const (
+	DefaultCSRFFieldName  = "csrf_token"
+	DefaultCSRFCookieName = "yhat-resize-csrf"
+)
+
+// csrfHeader is checked for a token when a request's form has none, allowing
+// AJAX requests to send the token without altering their payload.
+const csrfHeader = "X-CSRF-Token"
+
+var errCSRF = errors.New("invalid or missing CSRF token")
+
+func (app *App) csrfFieldName() string {
+	if app.CSRFFieldName == "" {
+		return DefaultCSRFFieldName
+	}
+	return app.CSRFFieldName
+}
+
+func (app *App) csrfCookieName() string {
+	if app.CSRFCookieName == "" {
+		return DefaultCSRFCookieName
+	}
+	return app.CSRFCookieName
+}
+
+// csrfToken returns the CSRF token associated with the request, creating one
+// and adding it to the response if the request doesn't have one yet.
+func (app *App) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	// ignore error from decoding an existing session
+	session, _ := app.store.Get(r, app.csrfCookieName())
+	if token, ok := session.Values["token"].(string); ok && token != "" {
+		return token, nil
+	}
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	token := base64.URLEncoding.EncodeToString(b)
+	session.Values["token"] = token
+	var opts *sessions.Options
+	if app.CSRFCookieOptions != nil {
+		o := *app.CSRFCookieOptions
+		opts = &o
+	}
+	if err := app.saveSession(w, r, session, opts); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// validCSRF reports if the request carries the CSRF token associated with its
+// session, either as a form value, a query parameter or in the X-CSRF-Token
+// header.
+func (app *App) validCSRF(r *http.Request) bool {
+	session, err := app.store.Get(r, app.csrfCookieName())
+	if err != nil {
+		return false
+	}
+	expected, ok := session.Values["token"].(string)
+	if !ok || expected == "" {
+		return false
+	}
+	token := r.FormValue(app.csrfFieldName())
+	if token == "" {
+		token = r.Header.Get(csrfHeader)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// csrfExempt reports if a request doesn't need a CSRF token. Requests to the
+// API authenticated with a bearer token, and logins to the API, don't rely on
+// cookies and so can't be forged by another site. Browsers resend Basic Auth
+// credentials like cookies though, so requests using them are only exempt
+// if they don't come from another site's page, which browsers report in the
+// Sec-Fetch-Site header. Scripts don't send it.
+func csrfExempt(r *http.Request) bool {
+	if r.URL.Path == "/api/login" {
+		return true
+	}
+	if basicAuthRequest(r) {
+		site := r.Header.Get("Sec-Fetch-Site")
+		return site == "" || site == "same-origin" || site == "none"
+	}
+	return bearerToken(r) != "" && strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// csrf rejects state-changing requests which do not carry a valid CSRF token.
+func (app *App) csrf(h http.Handler) http.Handler {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if !csrfExempt(r) && !app.validCSRF(r) {
+				app.render403(w, r, errCSRF)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(hf)
+}
+
+// csrfField renders a hidden form input holding the CSRF token from a
+// template's data.
+func csrfField(data map[string]interface{}) template.HTML {
+	name, _ := data["CSRFFieldName"].(string)
+	token, _ := data["CSRFToken"].(string)
+	if name == "" || token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` +
+		template.HTMLEscapeString(name) + `" value="` +
+		template.HTMLEscapeString(token) + `">`)
+}
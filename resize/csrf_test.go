@@ -0,0 +1,73 @@
+package resize
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+var csrfMeta = regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)">`)
+
+func TestCSRF(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(app)
+	defer s.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{Jar: jar}
+
+	resp, err := cli.Get(s.URL + "/about")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := csrfMeta.FindSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token rendered in page")
+	}
+	token := string(m[1])
+
+	tests := []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"region": {"us-east-1"}}, http.StatusForbidden},
+		{url.Values{"region": {"us-east-1"}, DefaultCSRFFieldName: {"bad"}}, http.StatusForbidden},
+		// a valid token passes on to restrict, which rejects the anonymous user
+		{url.Values{"region": {"us-east-1"}, DefaultCSRFFieldName: {token}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		resp, err := cli.PostForm(s.URL+"/region", tt.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("POST %v: expected status %d, got %s", tt.form, tt.status, resp.Status)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", s.URL+"/region", nil)
+	req.Header.Set(csrfHeader, token)
+	resp, err = cli.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected token in header to be accepted, got %s", resp.Status)
+	}
+}
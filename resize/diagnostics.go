@@ -0,0 +1,139 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// setting is a row of the diagnostics page.
+type setting struct {
+	Name  string
+	Value string
+}
+
+// redactURL removes any password from rawURL, such as that of a proxy or a
+// mirror requiring basic authentication.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Redacted()
+}
+
+// sourceDescription describes where the app's instance types come from.
+func (app *App) sourceDescription() string {
+	if app.DisableScraper {
+		return fmt.Sprintf("disabled, %d static types", len(app.StaticTypes))
+	}
+	switch src := app.Source.(type) {
+	case nil:
+		return "scraper " + DefaultInstanceTypeURL
+	case WebScraperSource:
+		desc := "scraper " + DefaultInstanceTypeURL
+		if src.URL != "" {
+			desc = "scraper " + redactURL(src.URL)
+		}
+		if src.FallbackURL != "" {
+			desc += ", falling back to " + redactURL(src.FallbackURL)
+		}
+		return desc
+	case *PriceListSource:
+		region := src.Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		if src.URL == "" {
+			return "price list of " + region
+		}
+		return fmt.Sprintf("price list of %s from %s", region, redactURL(src.URL))
+	default:
+		return fmt.Sprintf("%T", src)
+	}
+}
+
+// diagnostics returns the app's effective non-secret configuration. It must
+// never include the session key, API token key or any AWS credentials.
+func (app *App) diagnostics() []setting {
+	client := func(c *http.Client) string {
+		if c == nil {
+			return "default"
+		}
+		return "custom"
+	}
+	regions := "all"
+	if len(app.Regions) > 0 {
+		regions = strings.Join(app.Regions, ", ")
+	}
+	endpoint := "AWS"
+	if app.Endpoint != "" {
+		endpoint = redactURL(app.Endpoint)
+	}
+	overrides := make([]string, 0, len(app.RegionEndpoints))
+	for region, rawURL := range app.RegionEndpoints {
+		overrides = append(overrides, region+"="+redactURL(rawURL))
+	}
+	sort.Strings(overrides)
+	transport := app.transportOptions()
+	sessionOpts := app.sessionOptions()
+	refresh := "off"
+	if app.RefreshStatus().Running {
+		refresh = "running"
+	}
+	reason := "off"
+	if app.RequireReason {
+		reason = fmt.Sprintf("at least %d character(s)", app.minReasonLength())
+	}
+	baseline := "none"
+	if app.Baseline != nil {
+		baseline = fmt.Sprintf("%d types", len(app.Baseline))
+	}
+	return []setting{
+		{"Version", Version},
+		{"Base path", app.pathTo("/")},
+		{"Reload templates", strconv.FormatBool(app.ReloadTemplates)},
+		{"Dry run", strconv.FormatBool(app.DryRun)},
+		{"Read only", strconv.FormatBool(app.ReadOnly)},
+		{"Tag resized instances", strconv.FormatBool(app.TagResized)},
+		{"Require resize reason", reason},
+		{"AWS API HTTP client", client(app.HTTPClient)},
+		{"Scrape HTTP client", client(app.ScrapeClient)},
+		{"AWS request tries", strconv.Itoa(transport.MaxTries)},
+		{"AWS response timeout", transport.ResponseHeaderTimeout.String()},
+		{"Session store", fmt.Sprintf("%T", app.store)},
+		{"Instance locks", fmt.Sprintf("%T", app.instanceLocker())},
+		{"Secure session cookies", strconv.FormatBool(sessionOpts.Secure)},
+		{"Session max age", strconv.Itoa(sessionOpts.MaxAge) + "s"},
+		{"Instance types source", app.sourceDescription()},
+		{"Instance types cache TTL", app.TypeCache.ttl().String()},
+		{"Background refresh", refresh},
+		{"Instance types baseline", baseline},
+		{"Regions", regions},
+		{"EC2 endpoint", endpoint},
+		{"Region endpoints", strings.Join(overrides, ", ")},
+		{"Server credentials allowed", strconv.FormatBool(app.AllowServerCreds)},
+		{"Credential check skipped", strconv.FormatBool(app.SkipCredentialCheck)},
+		{"Trust proxy", strconv.FormatBool(app.TrustProxy)},
+	}
+}
+
+// Path: /diagnostics
+//
+// handleDiagnostics shows the app's effective configuration to logged in
+// users, so operators can tell which options are active. No secrets are
+// shown, see diagnostics.
+func (app *App) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	data := map[string]interface{}{
+		"Settings": app.diagnostics(),
+		"Baseline": app.Baseline != nil,
+	}
+	app.render(w, r, "diagnostics.html", data)
+}
@@ -0,0 +1,152 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// driftIgnored are the fields of InstanceType which aren't compared against
+// a baseline, as they change without the instance types page changing.
+var driftIgnored = map[string]bool{
+	"Price":     true,
+	"PriceUnit": true,
+	"SpotPrice": true,
+}
+
+// FieldChange is a field of an instance type whose value differs from the
+// baseline.
+type FieldChange struct {
+	Field    string `json:"field"`
+	Baseline string `json:"baseline"`
+	Current  string `json:"current"`
+}
+
+// TypeChange lists the fields of an instance type which differ from the
+// baseline.
+type TypeChange struct {
+	Name   string        `json:"name"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// Drift is the difference between freshly fetched instance types and a
+// known-good baseline, see CompareBaseline.
+type Drift struct {
+	// Added are the names of the types missing from the baseline.
+	Added []string `json:"added"`
+	// Removed are the names of the baseline types which weren't fetched.
+	Removed []string `json:"removed"`
+	// Changed are the types whose fields differ from the baseline.
+	Changed []TypeChange `json:"changed"`
+	// Blank are the fields set on some baseline type but on none of the
+	// fetched types, such as ClockSpeed when its column stops parsing.
+	Blank []string `json:"blank"`
+}
+
+// Empty reports if the fetched types match the baseline.
+func (d Drift) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Blank) == 0
+}
+
+// CompareBaseline diffs freshly fetched instance types against a baseline
+// snapshot of known-good types, matching them by name, to catch the
+// instance types page changing in ways which don't fail scraping. Prices
+// aren't compared. Names and fields are reported in order.
+func CompareBaseline(baseline, current []InstanceType) Drift {
+	d := Drift{Added: []string{}, Removed: []string{}, Changed: []TypeChange{}, Blank: []string{}}
+	fields := driftFields()
+	old := make(map[string]InstanceType, len(baseline))
+	for _, t := range baseline {
+		old[t.Name] = t
+	}
+	fetched := make(map[string]bool, len(current))
+	for _, t := range current {
+		fetched[t.Name] = true
+		b, ok := old[t.Name]
+		if !ok {
+			d.Added = append(d.Added, t.Name)
+			continue
+		}
+		var changes []FieldChange
+		bv, cv := reflect.ValueOf(b), reflect.ValueOf(t)
+		for _, i := range fields {
+			was, is := fmt.Sprint(bv.Field(i).Interface()), fmt.Sprint(cv.Field(i).Interface())
+			if was != is {
+				changes = append(changes, FieldChange{Field: bv.Type().Field(i).Name, Baseline: was, Current: is})
+			}
+		}
+		if len(changes) > 0 {
+			d.Changed = append(d.Changed, TypeChange{Name: t.Name, Fields: changes})
+		}
+	}
+	for name := range old {
+		if !fetched[name] {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	if len(current) > 0 {
+		for _, i := range fields {
+			if anySet(baseline, i) && !anySet(current, i) {
+				d.Blank = append(d.Blank, reflect.TypeOf(InstanceType{}).Field(i).Name)
+			}
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Name < d.Changed[j].Name })
+	return d
+}
+
+// driftFields returns the indexes of the fields of InstanceType compared
+// against a baseline.
+func driftFields() []int {
+	typ := reflect.TypeOf(InstanceType{})
+	var fields []int
+	for i := 0; i < typ.NumField(); i++ {
+		if !driftIgnored[typ.Field(i).Name] {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+// anySet reports if the field at index i is set on any of types.
+func anySet(types []InstanceType, i int) bool {
+	for _, t := range types {
+		if !reflect.ValueOf(t).Field(i).IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Path: /diagnostics/drift
+//
+// handleDrift compares the app's current instance types against its
+// Baseline, showing the types added, removed or changed since the baseline
+// was taken.
+func (app *App) handleDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if app.Baseline == nil {
+		data := map[string]interface{}{
+			"Error": "No baseline of instance types is configured to compare against.",
+		}
+		app.renderStatus(w, r, "404.html", data, http.StatusNotFound)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.render503(w, r, err)
+		return
+	}
+	data := map[string]interface{}{
+		"Drift":         CompareBaseline(app.Baseline, types),
+		"BaselineTypes": len(app.Baseline),
+		"CurrentTypes":  len(types),
+	}
+	app.render(w, r, "drift.html", data)
+}
@@ -0,0 +1,98 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestCompareBaseline(t *testing.T) {
+	baseline := []InstanceType{
+		{Name: "m5.large", CPUs: 2, Memory: 8, ClockSpeed: 3.1, Price: 0.096},
+		{Name: "m5.xlarge", CPUs: 4, Memory: 16, ClockSpeed: 3.1},
+		{Name: "t2.micro", CPUs: 1, Memory: 1, ClockSpeed: 3.3},
+	}
+	if d := CompareBaseline(baseline, baseline); !d.Empty() {
+		t.Errorf("expected no drift from the baseline itself, got %+v", d)
+	}
+
+	current := []InstanceType{
+		{Name: "m5.xlarge", CPUs: 4, Memory: 16},
+		// prices aren't compared
+		{Name: "m5.large", CPUs: 2, Memory: 7.5, Price: 0.1},
+		{Name: "m6g.large", CPUs: 2, Memory: 8},
+	}
+	d := CompareBaseline(baseline, current)
+	if d.Empty() {
+		t.Fatal("expected drift")
+	}
+	if !reflect.DeepEqual(d.Added, []string{"m6g.large"}) || !reflect.DeepEqual(d.Removed, []string{"t2.micro"}) {
+		t.Errorf("expected m6g.large added and t2.micro removed, got %v and %v", d.Added, d.Removed)
+	}
+	want := []TypeChange{
+		{Name: "m5.large", Fields: []FieldChange{
+			{Field: "Memory", Baseline: "8", Current: "7.5"},
+			{Field: "ClockSpeed", Baseline: "3.1", Current: "0"},
+		}},
+		{Name: "m5.xlarge", Fields: []FieldChange{{Field: "ClockSpeed", Baseline: "3.1", Current: "0"}}},
+	}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("expected changes %+v, got %+v", want, d.Changed)
+	}
+	// no clock speed was parsed, as if its column had stopped parsing
+	if !reflect.DeepEqual(d.Blank, []string{"ClockSpeed"}) {
+		t.Errorf("expected ClockSpeed to be blank, got %v", d.Blank)
+	}
+
+	if d := CompareBaseline(baseline, nil); len(d.Removed) != 3 || len(d.Blank) != 0 {
+		t.Errorf("expected all types removed and no fields blank, got %+v", d)
+	}
+}
+
+func TestDriftPage(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m5.large", CPUs: 2, Memory: 8}}, nil)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.USEast)); err != nil {
+		t.Fatal(err)
+	}
+	get := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/diagnostics/drift", nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		app.ServeHTTP(rw, r)
+		return rw
+	}
+
+	if rw := get(); rw.Code != http.StatusNotFound {
+		t.Errorf("expected the drift view to be unavailable without a baseline, got %d", rw.Code)
+	}
+
+	app.Baseline = []InstanceType{{Name: "m5.large", CPUs: 2, Memory: 8, ClockSpeed: 3.1}}
+	rw := get()
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+	body := rw.Body.String()
+	for _, want := range []string{`id="drift-blank"`, "<code>ClockSpeed</code>", `id="drift-changed"`, "<code>3.1</code>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the drift view to contain %s", want)
+		}
+	}
+
+	app.Baseline = []InstanceType{{Name: "m5.large", CPUs: 2, Memory: 8}}
+	if body := get().Body.String(); !strings.Contains(body, `id="drift-none"`) {
+		t.Errorf("expected the drift view to report no differences")
+	}
+}
@@ -0,0 +1,54 @@
+package resize
+
+import (
+	"encoding/gob"
+	"net/http"
+)
+
+func init() {
+	gob.Register(flash{})
+}
+
+// Severities of flash messages.
+const (
+	flashSuccess = "success"
+	flashError   = "error"
+)
+
+// flash is a one-time message shown on the next page rendered for a session,
+// such as the page a handler redirects to.
+type flash struct {
+	Severity string
+	Message  string
+}
+
+// addFlash queues a message with the given severity for the next page
+// rendered for the request's session.
+func (app *App) addFlash(w http.ResponseWriter, r *http.Request, severity, message string) {
+	session, _ := app.store.Get(r, "yhat-resize")
+	session.AddFlash(flash{Severity: severity, Message: message})
+	if err := app.saveSession(w, r, session, nil); err != nil {
+		app.LogfContext(r.Context(), "could not save flash message: %v", err)
+	}
+}
+
+// flashes returns the messages queued for the request's session and removes
+// them, so each is only shown once. It must be called before the response's
+// header is written.
+func (app *App) flashes(w http.ResponseWriter, r *http.Request) []flash {
+	session, _ := app.store.Get(r, "yhat-resize")
+	values := session.Flashes()
+	if len(values) == 0 {
+		return nil
+	}
+	if err := app.saveSession(w, r, session, nil); err != nil {
+		app.LogfContext(r.Context(), "could not clear flash messages: %v", err)
+	}
+	var flashes []flash
+	for _, v := range values {
+		if f, ok := v.(flash); ok {
+			flashes = append(flashes, f)
+		}
+	}
+	return flashes
+}
@@ -0,0 +1,47 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlashes(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	app.addFlash(w, r, flashSuccess, "Saved profile prod.")
+	app.addFlash(w, r, flashError, "Could not <remove> profile dev.")
+	// each flash saves the session, browsers keep the last cookie set
+	all := w.Result().Cookies()
+	cookies := all[len(all)-1:]
+	get := func() (*httptest.ResponseRecorder, []*http.Cookie) {
+		r, _ := http.NewRequest("GET", "/about", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w, w.Result().Cookies()
+	}
+
+	w, next := get()
+	body := w.Body.String()
+	if !strings.Contains(body, "alert-success") || !strings.Contains(body, "Saved profile prod.") {
+		t.Errorf("expected the success message to be shown")
+	}
+	if !strings.Contains(body, "alert-danger") || !strings.Contains(body, "Could not &lt;remove&gt; profile dev.") {
+		t.Errorf("expected the escaped error message to be shown")
+	}
+	if len(next) == 0 {
+		t.Fatalf("expected the session to be saved once the messages are shown")
+	}
+	cookies = next
+	if w, _ := get(); strings.Contains(w.Body.String(), "Saved profile prod.") {
+		t.Errorf("expected the message to be shown only once")
+	}
+}
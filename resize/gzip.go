@@ -0,0 +1,208 @@
+package resize
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the size below which response bodies aren't compressed, as
+// the gzip header and the work of compressing outweigh the saving.
+const gzipMinSize = 1024
+
+// compressibleTypes lists the media types, or prefixes of them ending in
+// "/", of responses which are compressed. Images and the like are already
+// compressed.
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/xml",
+	"image/svg+xml",
+}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(ioutil.Discard) },
+}
+
+// compress gzip compresses the responses of h when the client accepts it and
+// the response is of a compressible type and at least gzipMinSize bytes long.
+// HEAD, range and upgrade requests, such as WebSocket handshakes, are passed
+// to h untouched, as their responses either have no body or must not be
+// altered.
+func (app *App) compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == "HEAD" || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports if the Accept-Encoding header of r allows a gzip
+// encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			params := strings.Split(coding, ";")
+			if name := strings.TrimSpace(params[0]); !strings.EqualFold(name, "gzip") {
+				continue
+			}
+			for _, param := range params[1:] {
+				if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+					if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+						return false
+					}
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports if a response of the given content type is
+// compressed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows if the
+// response should be compressed, which is when gzipMinSize bytes have been
+// written, the response is flushed or the handler returns, at which point the
+// header is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= gzipMinSize {
+		if err := w.start(w.shouldCompress()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the header and any buffered data, compressing the response if
+// it's of a compressible type regardless of its size, as a streamed
+// response's size isn't known.
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := w.start(w.shouldCompress()); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response, writing short responses uncompressed.
+func (w *gzipResponseWriter) Close() error {
+	if !w.started && (w.status != 0 || len(w.buf) > 0) {
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+	return err
+}
+
+// shouldCompress reports if the buffered response should be compressed. The
+// content type is sniffed from the buffered data if the handler hasn't set
+// one, as net/http would do.
+func (w *gzipResponseWriter) shouldCompress() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || len(w.buf) == 0 {
+		return false
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	return compressible(h.Get("Content-Type"))
+}
+
+// start writes the header and the buffered data, compressing it and all that
+// follows if compress is set.
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.started = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
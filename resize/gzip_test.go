@@ -0,0 +1,160 @@
+package resize
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"br", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsGzip(r); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("m5.large,2,8\n", 200)
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprint(w, large[:len(large)/2])
+			fmt.Fprint(w, large[len(large)/2:])
+		case "/small":
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprint(w, "m5.large,2,8\n")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, large)
+		case "/stream":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprint(w, "{}\n")
+			w.(http.Flusher).Flush()
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	get := func(path string, accept bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		if accept {
+			r.Header.Set("Accept-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	gunzip := func(w *httptest.ResponseRecorder) string {
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	w := get("/large", true)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a large response to be compressed")
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected the response to vary by Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+	if body := gunzip(w); body != large {
+		t.Errorf("expected the body to survive compression, got %d bytes", len(body))
+	}
+	if w := get("/large", false); w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+		t.Errorf("expected no compression when the client doesn't accept it")
+	}
+	if w := get("/small", true); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "m5.large,2,8\n" {
+		t.Errorf("expected a small response not to be compressed")
+	}
+	if w := get("/image", true); w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+		t.Errorf("expected an image not to be compressed")
+	}
+	w = get("/stream", true)
+	if w.Header().Get("Content-Encoding") != "gzip" || !w.Flushed {
+		t.Fatalf("expected a flushed stream to be compressed")
+	}
+	if body := gunzip(w); body != "{}\n" {
+		t.Errorf("expected the streamed body, got %q", body)
+	}
+	if w := get("/empty", true); w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected an empty response to be passed through, got %d %q", w.Code, w.Body.String())
+	}
+
+	// pages rendered by the app are compressed
+	r, _ := http.NewRequest("GET", "/about", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(gunzip(w), "</html>") {
+		t.Errorf("expected the about page to be compressed")
+	}
+}
+
+func TestCompressUpgrade(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "not a hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, bufrw, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		bufrw.Flush()
+	})))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nAccept-Encoding: gzip\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected the upgrade to reach the handler, got %s", resp.Status)
+	}
+}
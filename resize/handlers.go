@@ -1,8 +1,17 @@
 package resize
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mitchellh/goamz/aws"
@@ -20,6 +29,147 @@ func allInstances(resp *ec2.InstancesResp) []ec2.Instance {
 	return instances
 }
 
+// nameTag returns the value of an instance's Name tag, or an empty string if
+// it isn't named or its name is blank.
+func nameTag(inst ec2.Instance) string {
+	for _, tag := range inst.Tags {
+		if tag.Key == "Name" && strings.TrimSpace(tag.Value) != "" {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// sortByName sorts instances by their Name tag, ignoring case. Instances
+// without a name follow those with one, sorted by ID.
+func sortByName(instances []ec2.Instance) {
+	sort.SliceStable(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		an, bn := nameTag(a), nameTag(b)
+		if (an != "") != (bn != "") {
+			return an != ""
+		}
+		if al, bl := strings.ToLower(an), strings.ToLower(bn); al != bl {
+			return al < bl
+		}
+		return a.InstanceId < b.InstanceId
+	})
+}
+
+// tagFilter matches instances with a tag. If Value is empty, any instance
+// with the tag Key matches.
+type tagFilter struct {
+	Key   string
+	Value string
+}
+
+// parseTagFilters reads the tag-key and tag-value query parameters. The nth
+// tag-value applies to the nth tag-key.
+func parseTagFilters(r *http.Request) []tagFilter {
+	q := r.URL.Query()
+	values := q["tag-value"]
+	filters := []tagFilter{}
+	for i, key := range q["tag-key"] {
+		if key == "" {
+			continue
+		}
+		f := tagFilter{Key: key}
+		if i < len(values) {
+			f.Value = values[i]
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// addTagFilterParams adds tag filters to the parameters of a DescribeInstances
+// request. Instances must match all of the filters.
+func addTagFilterParams(params url.Values, tags []tagFilter) {
+	for i, t := range tags {
+		prefix := "Filter." + strconv.Itoa(i+1) + "."
+		if t.Value == "" {
+			params.Set(prefix+"Name", "tag-key")
+			params.Set(prefix+"Value.1", t.Key)
+		} else {
+			params.Set(prefix+"Name", "tag:"+t.Key)
+			params.Set(prefix+"Value.1", t.Value)
+		}
+	}
+}
+
+// instanceStates lists the states an instance can be in, which the instance
+// list may be filtered by.
+var instanceStates = []string{"pending", "running", "stopping", "stopped", "shutting-down", "terminated"}
+
+func isInstanceState(state string) bool {
+	for _, s := range instanceStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStateFilter reads the state query parameter, a comma separated list
+// of instance states which may be repeated. An empty list matches instances
+// in any state.
+func parseStateFilter(r *http.Request) ([]string, error) {
+	seen := make(map[string]bool)
+	states := []string{}
+	for _, param := range r.URL.Query()["state"] {
+		for _, state := range strings.Split(param, ",") {
+			state = strings.ToLower(strings.TrimSpace(state))
+			if state == "" || seen[state] {
+				continue
+			}
+			if !isInstanceState(state) {
+				return nil, fmt.Errorf("No instance state named %s, expected one of %s", state, strings.Join(instanceStates, ", "))
+			}
+			seen[state] = true
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+// addStateFilterParams adds a filter matching instances in any of states to
+// the parameters of a DescribeInstances request, after its n other filters.
+func addStateFilterParams(params url.Values, states []string, n int) {
+	if len(states) == 0 {
+		return
+	}
+	prefix := "Filter." + strconv.Itoa(n+1) + "."
+	params.Set(prefix+"Name", "instance-state-name")
+	for i, state := range states {
+		params.Set(prefix+"Value."+strconv.Itoa(i+1), state)
+	}
+}
+
+// stateChoice is an entry of the state picker of the instance list.
+type stateChoice struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// stateChoices returns the state picker of the instance list, linking to the
+// list without a state filter and filtered by each commonly used state.
+func (app *App) stateChoices(tags []tagFilter, states []string, max int) []stateChoice {
+	choices := []stateChoice{{
+		Label:  "All",
+		URL:    app.pathTo(pageURL(tags, nil, max, "", nil)),
+		Active: len(states) == 0,
+	}}
+	for _, state := range []string{"running", "stopped"} {
+		choices = append(choices, stateChoice{
+			Label:  state,
+			URL:    app.pathTo(pageURL(tags, []string{state}, max, "", nil)),
+			Active: len(states) == 1 && states[0] == state,
+		})
+	}
+	return choices
+}
+
 // Path: /
 func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 	ec2Cli, ok := app.creds(r)
@@ -31,24 +181,55 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not implemented", http.StatusNotImplemented)
 		return
 	}
-	resp, err := ec2Cli.Instances(nil, nil)
+	tags := parseTagFilters(r)
+	states, err := parseStateFilter(r)
+	if err != nil {
+		app.render400(w, r, err)
+		return
+	}
+	max := parsePageSize(r)
+	token := r.URL.Query().Get("token")
+	instances, next, err := describeInstancesPage(app.ec2HTTPClient(), ec2Cli, tags, states, max, token)
 	if err != nil {
 		app.render500(w, r, err)
 		return
 	}
-	data := map[string]interface{}{"Instances": allInstances(resp)}
+	// EC2 doesn't sort its pages, so only the instances of this page are
+	// sorted
+	sortByName(instances)
+	data := map[string]interface{}{
+		"Instances":    instances,
+		"TagFilters":   tags,
+		"States":       states,
+		"StateParam":   strings.Join(states, ","),
+		"StateChoices": app.stateChoices(tags, states, max),
+		"ClearTags":    app.pathTo(pageURL(nil, states, max, "", nil)),
+	}
+	prev := r.URL.Query()["prev"]
+	if next != "" {
+		data["NextPage"] = app.pathTo(pageURL(tags, states, max, next, append(prev[:len(prev):len(prev)], token)))
+	}
+	if len(prev) > 0 {
+		data["PrevPage"] = app.pathTo(pageURL(tags, states, max, prev[len(prev)-1], prev[:len(prev)-1]))
+	}
 	app.render(w, r, "index.html", data)
 }
 
 // Path: /login
 func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		if _, ok := app.creds(r); ok {
-			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		// logged in users may log in again with other credentials to add
+		// a profile
+		if _, ok := app.creds(r); ok && r.URL.Query().Get("add") == "" {
+			http.Redirect(w, r, app.pathTo("/"), http.StatusTemporaryRedirect)
 			return
 		}
 
-		app.render(w, r, "login.html", nil)
+		data := map[string]interface{}{
+			"Expired":          r.URL.Query().Get("expired") != "",
+			"AllowServerCreds": app.AllowServerCreds,
+		}
+		app.render(w, r, "login.html", data)
 		return
 	}
 	if r.Method != "POST" {
@@ -57,6 +238,10 @@ func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Handle POST
+	if r.FormValue("serverCreds") != "" {
+		app.handleServerLogin(w, r)
+		return
+	}
 	accessKey := r.FormValue("accessKey")
 	secretKey := r.FormValue("secretKey")
 	if accessKey == "" {
@@ -67,17 +252,85 @@ func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No secret key provided", http.StatusBadRequest)
 		return
 	}
-	err := app.login(w, r, accessKey, secretKey)
+	mfaSerial := r.FormValue("mfaSerial")
+	mfaToken := r.FormValue("mfaToken")
+	if (mfaSerial == "") != (mfaToken == "") {
+		http.Error(w, "Both an MFA device serial number and token are required", http.StatusBadRequest)
+		return
+	}
+	roleARN := r.FormValue("roleArn")
+	externalID := r.FormValue("externalId")
+	if externalID != "" && roleARN == "" {
+		http.Error(w, "An external ID requires a role ARN", http.StatusBadRequest)
+		return
+	}
+	if !app.allowLogin(r) {
+		app.LogEventContext(r.Context(), "login_rate_limited", Fields{"ip": app.clientIP(r)})
+		w.Header().Set("Retry-After", app.retryAfter())
+		http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	start := time.Now()
+	var err error
+	switch {
+	case roleARN != "":
+		err = app.loginRole(w, r, accessKey, secretKey, roleARN, externalID, mfaSerial, mfaToken)
+	case mfaSerial != "":
+		err = app.loginMFA(w, r, accessKey, secretKey, mfaSerial, mfaToken)
+	default:
+		err = app.login(w, r, accessKey, secretKey)
+	}
+	app.recordLogin(r, err)
+	fields := Fields{
+		"region":      defaultRegion.Name,
+		"mfa":         mfaSerial != "",
+		"role":        roleARN != "",
+		"duration_ms": durationMS(start),
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	app.LogEventContext(r.Context(), "login", fields)
 	if err == nil {
+		app.metrics.logins.inc()
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err, ok := err.(*ec2.Error); ok {
-		msg := fmt.Sprintf("bad response from AWS '%s'", err.Message)
-		http.Error(w, msg, http.StatusBadRequest)
-	} else {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	msg, status := loginFailure(err)
+	http.Error(w, msg, status)
+}
+
+// handleServerLogin handles a login POST asking to use the server's
+// credentials, see App.AllowServerCreds.
+func (app *App) handleServerLogin(w http.ResponseWriter, r *http.Request) {
+	if !app.AllowServerCreds {
+		http.Error(w, "Logging in with the server's credentials is not enabled", http.StatusForbidden)
+		return
+	}
+	start := time.Now()
+	err := app.loginServer(w, r)
+	fields := Fields{
+		"region":       defaultRegion.Name,
+		"server_creds": true,
+		"duration_ms":  durationMS(start),
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	app.LogEventContext(r.Context(), "login", fields)
+	if err == nil {
+		app.metrics.logins.inc()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	switch err.(type) {
+	case *ec2.Error, net.Error:
+		msg, status := loginFailure(err)
+		http.Error(w, msg, status)
+	default:
+		app.LogfContext(r.Context(), "could not log in with server credentials: %v", err)
+		http.Error(w, "Could not get the server's credentials", http.StatusInternalServerError)
 	}
 }
 
@@ -89,7 +342,26 @@ func (app *App) handleAbout(w http.ResponseWriter, r *http.Request) {
 // Path: /logout
 func (app *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	app.logout(w, r)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	app.metrics.logouts.inc()
+	app.addFlash(w, r, flashSuccess, "You have been logged out.")
+	http.Redirect(w, r, app.pathTo("/"), http.StatusSeeOther)
+}
+
+// Path: /logout-everywhere
+func (app *App) handleLogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if err := app.logoutEverywhere(w, r); err != nil {
+		app.LogfContext(r.Context(), "could not revoke sessions: %v", err)
+		app.addFlash(w, r, flashError, "Your other sessions could not be logged out, please try again.")
+		http.Redirect(w, r, app.pathTo("/"), http.StatusSeeOther)
+		return
+	}
+	app.metrics.logouts.inc()
+	app.addFlash(w, r, flashSuccess, "You have been logged out of all sessions.")
+	http.Redirect(w, r, app.pathTo("/"), http.StatusSeeOther)
 }
 
 // Path: /region
@@ -105,21 +377,22 @@ func (app *App) handleRegion(w http.ResponseWriter, r *http.Request) {
 	}
 	regionName := r.PostFormValue("region")
 	if regionName == "" {
-		http.Error(w, "No region provided", http.StatusBadRequest)
+		app.render400(w, r, fmt.Errorf("No region provided"))
 		return
 	}
 	region, ok := aws.Regions[regionName]
 	if !ok {
-		http.Error(w, "No AWS region named "+regionName, http.StatusBadRequest)
+		app.render400(w, r, fmt.Errorf("No AWS region named %s", regionName))
 		return
 	}
 
 	ec2Cli.Region = region
 	if err := app.set(w, r, ec2Cli); err != nil {
-		app.Logf("could not set region for cookie: %v", err)
+		app.LogfContext(r.Context(), "could not set region for cookie: %v", err)
 		http.Error(w, "internal error setting cookie", http.StatusInternalServerError)
 		return
 	}
+	app.addFlash(w, r, flashSuccess, fmt.Sprintf("Switched to region %s.", region.Name))
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -168,22 +441,359 @@ func (app *App) handleInstance(w http.ResponseWriter, r *http.Request) {
 	if err == nil && (len(addrResp.Addresses) == 1) {
 		data["Address"] = addrResp.Addresses[0]
 	}
-	types, err := InstanceTypes(nil)
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
 	if err != nil {
-		app.render500(w, r, err)
+		app.render503(w, r, err)
 		return
 	}
+	prices, ok, err := app.prices(ec2Cli.Region.Name)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get prices for %s: %v", ec2Cli.Region.Name, err)
+	} else if ok {
+		types = MergePrices(types, prices)
+	}
+	types = FilterByProcessor(compatibleTypes(instance, types), r.URL.Query().Get("vendor"))
+	// spot prices are only a hint, so the page is shown without them if
+	// they can't be described
+	spot, err := app.spotPrices(r.Context(), ec2Cli, instance, types)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get spot prices for %s: %v", ec2Cli.Region.Name, err)
+	} else if len(spot) > 0 {
+		types = MergeSpotPrices(types, spot)
+		data["SpotPrices"] = true
+	}
 	data["InstanceTypes"] = types
+	data["CanResize"] = !app.ReadOnly && app.canResize(w, r, ec2Cli, instance)
 
 	app.render(w, r, "instance.html", data)
 }
 
+// Path: /instance-types
+func (app *App) handleInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		app.render404(w, r)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.render503(w, r, err)
+		return
+	}
+	key := r.URL.Query().Get("sort")
+	if _, ok := typeSortKeys[key]; !ok {
+		key = "name"
+	}
+	order := r.URL.Query().Get("order")
+	if order != "desc" {
+		order = "asc"
+	}
+	networkMin := r.URL.Query().Get("network_min")
+	if networkMin != "" {
+		tier := ParseNetworkTier(networkMin)
+		if tier == NetworkUnknown {
+			app.render400(w, r, fmt.Errorf("unknown network performance '%s'", networkMin))
+			return
+		}
+		types = FilterByNetwork(types, tier)
+		networkMin = tier.String()
+	}
+	types = SortTypes(types, key, order)
+	data := map[string]interface{}{
+		"InstanceTypes": types,
+		"Families":      Families(types),
+		"Sort":          key,
+		"Order":         order,
+		"NetworkMin":    networkMin,
+		"NetworkTiers":  networkTierNames[NetworkLow:],
+	}
+	data["FetchedAt"], data["Stale"] = app.TypeCache.FetchedAt()
+	data["Fallback"] = app.TypeCache.FromFallback()
+	app.render(w, r, "instance-types.html", data)
+}
+
+// Path: /instance/{instance}/compare
+//
+// handleCompare shows the instance's current type and the type given by the
+// "to" query parameter side by side.
+func (app *App) handleCompare(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	instance, ok, err := findInstance(ec2Cli, mux.Vars(r)["instance"])
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.render503(w, r, err)
+		return
+	}
+	prices, ok, err := app.prices(ec2Cli.Region.Name)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get prices for %s: %v", ec2Cli.Region.Name, err)
+	} else if ok {
+		types = MergePrices(types, prices)
+	}
+
+	toName := r.URL.Query().Get("to")
+	to, ok := FindType(types, toName)
+	if !ok {
+		data := map[string]interface{}{
+			"Error": fmt.Sprintf("There is no instance type named '%s' to compare %s with.", toName, instance.InstanceType),
+		}
+		app.renderStatus(w, r, "404.html", data, http.StatusNotFound)
+		return
+	}
+	from, _ := FindType(types, instance.InstanceType)
+	data := map[string]interface{}{
+		"Instance":    instance,
+		"From":        from,
+		"To":          to,
+		"Differences": compareTypes(from, to),
+		"Warning":     burstableWarning(from, to),
+	}
+	app.render(w, r, "compare.html", data)
+}
+
 type Event struct {
 	Status  string
 	Message string
 }
 
-func (app *App) handleResize(ws *websocket.Conn) {
+// eventLog collects the messages of the JSON encoded Events written to it.
+type eventLog []string
+
+func (l *eventLog) Write(b []byte) (int, error) {
+	var e Event
+	if err := json.Unmarshal(b, &e); err != nil {
+		return 0, err
+	}
+	*l = append(*l, e.Message)
+	return len(b), nil
+}
+
+// findInstance looks up a single instance by its ID. If the instance doesn't
+// exist, ok is false.
+func findInstance(ec2Cli *ec2.EC2, id string) (inst ec2.Instance, ok bool, err error) {
+	resp, err := ec2Cli.Instances([]string{id}, nil)
+	if err != nil {
+		return ec2.Instance{}, false, fmt.Errorf("Bad response from AWS %v", err)
+	}
+	instances := allInstances(resp)
+	if len(instances) != 1 {
+		return ec2.Instance{}, false, nil
+	}
+	return instances[0], true, nil
+}
+
+// compatibleTypes returns the types inst can be resized to, see
+// CompatibleTypes. Types which don't support the virtualization type of the
+// instance's AMI are excluded as well.
+func compatibleTypes(inst ec2.Instance, types []InstanceType) []InstanceType {
+	current, _ := FindType(types, inst.InstanceType)
+	compatible := []InstanceType{}
+	for _, t := range CompatibleTypes(current, types) {
+		if inst.VirtType == "" || supportsVirtualization(t.Name, inst.VirtType) {
+			compatible = append(compatible, t)
+		}
+	}
+	return compatible
+}
+
+// validateResize checks that inst can be resized to newType. The type must
+// be offered by the app's instance type source, support the instance's
+// virtualization type and have the same processor architecture.
+func (app *App) validateResize(ctx context.Context, inst ec2.Instance, newType string) error {
+	if newType == "" {
+		return fmt.Errorf("no instance type provided")
+	}
+	if newType == inst.InstanceType {
+		return fmt.Errorf("instance %s is already of type %s", inst.InstanceId, newType)
+	}
+	types, err := app.TypeCache.InstanceTypesContext(ctx)
+	if err != nil {
+		return typesUnavailableError{err}
+	}
+	target, ok := FindType(types, newType)
+	if !ok {
+		return fmt.Errorf("unknown instance type %s", newType)
+	}
+	if target.Name != newType {
+		return fmt.Errorf("unknown instance type %s, did you mean %s?", newType, target.Name)
+	}
+	if inst.VirtType != "" && !supportsVirtualization(newType, inst.VirtType) {
+		return fmt.Errorf("instance type %s does not support %s virtualization", newType, inst.VirtType)
+	}
+	current, _ := FindType(types, inst.InstanceType)
+	if ArchitectureOf(target) != ArchitectureOf(current) {
+		return fmt.Errorf("instance type %s has a different architecture than %s", newType, inst.InstanceType)
+	}
+	return nil
+}
+
+// resizeInstance calls resizeInstance, logging the outcome as a "resize"
+// event. reason is the optional reason given for the resize, which is
+// recorded in the audit log and, if App.TagResized is set, the instance's
+// tags. Unless it's a dry run, the instance is locked and its progress
+// tracked during the resize, and ErrInstanceBusy is returned if another
+// operation holds its lock.
+func (app *App) resizeInstance(ctx context.Context, ec2Cli *ec2.EC2, w io.Writer, inst ec2.Instance, newType, reason string, dryRun bool) error {
+	// dry runs aren't tracked, so they don't disturb a resize in progress
+	done := func(error) {}
+	if !dryRun {
+		unlock, err := app.lockInstance(ctx, ec2Cli, inst)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		w, done = app.trackResize(ec2Cli, w, inst, newType)
+	}
+	start := time.Now()
+	app.metrics.resizeAttempts.inc()
+	app.observeState(ec2Cli, inst, start)
+	event := AuditEvent{
+		Principal:  principal(ec2Cli.Auth.AccessKey),
+		InstanceID: inst.InstanceId,
+		OldType:    inst.InstanceType,
+		NewType:    newType,
+		Region:     ec2Cli.Region.Name,
+		DryRun:     dryRun,
+		Reason:     strings.TrimSpace(reason),
+		Result:     "started",
+		RequestID:  RequestID(ctx),
+	}
+	app.audit(event)
+	err := resizeInstance(ctx, ec2Cli, w, inst, newType, dryRun, app.retryPolicy())
+	if err == nil && app.TagResized {
+		if dryRun {
+			err = writeDryRun(w, []string{tagStep(inst)})
+		} else {
+			app.tagResized(ctx, ec2Cli, w, inst, reason)
+		}
+	}
+	done(err)
+	event.Result = "success"
+	if err != nil {
+		event.Result = "failure"
+		event.Error = err.Error()
+	}
+	app.audit(event)
+	fields := Fields{
+		"region":      ec2Cli.Region.Name,
+		"instance_id": inst.InstanceId,
+		"from_type":   inst.InstanceType,
+		"to_type":     newType,
+		"dry_run":     dryRun,
+		"duration_ms": durationMS(start),
+	}
+	if err != nil {
+		app.metrics.resizeFailures.inc()
+		fields["error"] = err
+	}
+	app.LogEventContext(ctx, "resize", fields)
+	return err
+}
+
+// Path: /instance/{instance}/resize
+//
+// handleResize changes the type of an instance to the one submitted in the
+// "new-type" form field, stopping and starting the instance as needed. The
+// resize must first be confirmed through handleResizeConfirm, whose nonce is
+// submitted in the "nonce" form field, and the reason for it, required if
+// App.RequireReason is set, in the "reason" field. If the "dryrun" query
+// parameter is set, the steps are reported without being taken.
+func (app *App) handleResize(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	instanceId := mux.Vars(r)["instance"]
+	if instanceId == "" {
+		app.render404(w, r)
+		return
+	}
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+
+	newType := r.PostFormValue("new-type")
+	// the reason and type are checked before the confirmation is used up,
+	// so the form can be submitted again with them corrected
+	reason := r.PostFormValue("reason")
+	if err := app.validateReason(reason); err != nil {
+		app.render400(w, r, err)
+		return
+	}
+	if err := app.validateResize(r.Context(), instance, newType); err != nil {
+		if _, ok := err.(typesUnavailableError); ok {
+			app.render503(w, r, err)
+		} else {
+			app.render400(w, r, err)
+		}
+		return
+	}
+	if err := app.checkResizeConfirmation(w, r, instance, newType, r.PostFormValue("nonce")); err != nil {
+		app.render400(w, r, err)
+		return
+	}
+
+	dryRun := app.DryRun || r.URL.Query().Get("dryrun") != ""
+	var steps eventLog
+	if err := app.resizeInstance(r.Context(), ec2Cli, &steps, instance, newType, reason, dryRun); err != nil {
+		if err == ErrInstanceBusy {
+			app.render409(w, r, err)
+		} else {
+			app.render500(w, r, fmt.Errorf("error resizing instance: %v", err))
+		}
+		return
+	}
+	finalState := "stopped"
+	if instance.State.Name == "running" {
+		finalState = "running"
+	}
+	data := map[string]interface{}{
+		"Instance":   instance,
+		"NewType":    newType,
+		"DryRun":     dryRun,
+		"Steps":      steps,
+		"FinalState": finalState,
+	}
+	app.render(w, r, "resize.html", data)
+}
+
+// handleResizeWS performs the same operation as handleResize, streaming
+// progress over a websocket. The new type is sent as the first message, and
+// the nonce confirming the resize in the "nonce" query parameter. The reason
+// for the resize, required if App.RequireReason is set, may be given in the
+// "reason" query parameter.
+func (app *App) handleResizeWS(ws *websocket.Conn) {
 	defer ws.Close()
 
 	r := ws.Request()
@@ -192,6 +802,14 @@ func (app *App) handleResize(ws *websocket.Conn) {
 		app.wsErr(ws, "Unauthorized")
 		return
 	}
+	if !app.validCSRF(r) {
+		app.wsErr(ws, errCSRF.Error())
+		return
+	}
+	if app.resizeDenied(r) {
+		app.wsErr(ws, errResizeDenied.Error())
+		return
+	}
 
 	instanceId := mux.Vars(r)["instance"]
 	if instanceId == "" {
@@ -199,47 +817,65 @@ func (app *App) handleResize(ws *websocket.Conn) {
 		return
 	}
 
-	currentStatus := r.URL.Query().Get("status")
-
 	var newType string
 	if err := websocket.Message.Receive(ws, &newType); err != nil {
 		app.wsErr(ws, fmt.Sprintf("error receiving websocket message: %v", err))
 		return
 	}
 
-	//The instance must be stopped before we can change it
-	switch currentStatus {
-	case "running":
-		if err := stopAndWait(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error stopping instance: %v", err))
-			return
-		}
-	case "stopped":
-		break
-	default:
-		app.wsErr(ws, "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.wsErr(ws, err.Error())
 		return
 	}
-	if err := resize(ec2Cli, instanceId, newType); err != nil {
-		app.wsErr(ws, fmt.Sprintf("error resizing instance: %v", err))
+	if !ok {
+		app.wsErr(ws, "No instance with ID "+instanceId)
 		return
 	}
-	//If the server was running initially, we'll return it to its original
-	//state and keep the user informed of this process
-	if currentStatus == "running" {
-		if _, err := ec2Cli.StartInstances(instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error starting instance: %v", err))
-			return
-		}
-		if err := pollUntilRunning(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error checking instance status: %v", err))
-			return
-		}
+	reason := r.URL.Query().Get("reason")
+	if err := app.validateReason(reason); err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	// the session can't be updated over a websocket, so the confirmation is
+	// left in it and only recorded as used
+	if err := app.checkResizeConfirmation(nil, r, instance, newType, r.URL.Query().Get("nonce")); err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	if err := app.validateResize(r.Context(), instance, newType); err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+
+	dryRun := app.DryRun || r.URL.Query().Get("dryrun") != ""
+	ctx, cancel := wsContext(ws)
+	defer cancel()
+	if err := app.resizeInstance(ctx, ec2Cli, ws, instance, newType, reason, dryRun); err != nil {
+		app.wsErr(ws, fmt.Sprintf("error resizing instance: %v", err))
+		return
 	}
 	e := Event{Status: "success"}
 	websocket.JSON.Send(ws, &e)
 }
 
+// wsContext returns a context of the websocket's request which is canceled
+// once the client closes the connection. It reads from ws to notice, so no
+// further messages may be received from it.
+func wsContext(ws *websocket.Conn) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	go func() {
+		defer cancel()
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
 func (app *App) handleAssignIp(ws *websocket.Conn) {
 	defer ws.Close()
 
@@ -249,6 +885,10 @@ func (app *App) handleAssignIp(ws *websocket.Conn) {
 		app.wsErr(ws, "Unauthorized")
 		return
 	}
+	if !app.validCSRF(r) {
+		app.wsErr(ws, errCSRF.Error())
+		return
+	}
 
 	instanceId := mux.Vars(r)["instance"]
 	if instanceId == "" {
@@ -263,30 +903,55 @@ func (app *App) handleAssignIp(ws *websocket.Conn) {
 		return
 	}
 
-	switch currentStatus {
-	case "running":
-		if err := stopAndWait(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error stopping instance: %v", err))
+	if currentStatus != "running" && currentStatus != "stopped" {
+		app.wsErr(ws, "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
+		return
+	}
+
+	if app.DryRun {
+		steps := []string{}
+		if currentStatus == "running" {
+			steps = append(steps, "stop instance "+instanceId)
+		}
+		steps = append(steps, fmt.Sprintf("associate address %s with %s", allocId, instanceId))
+		if currentStatus == "running" {
+			steps = append(steps, "start instance "+instanceId)
+		}
+		if err := writeDryRun(ws, steps); err != nil {
+			app.wsErr(ws, err.Error())
 			return
 		}
-	case "stopped":
-		break
-	default:
-		app.wsErr(ws, "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
+		websocket.JSON.Send(ws, &Event{Status: "success"})
 		return
 	}
 
-	err := allocateIp(ec2Cli, instanceId, allocId)
+	ctx, cancel := wsContext(ws)
+	defer cancel()
+	// the instance may be stopped and started, so it's locked like a resize
+	unlock, err := app.lockInstance(ctx, ec2Cli, ec2.Instance{InstanceId: instanceId})
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	defer unlock()
+	if currentStatus == "running" {
+		if err := stopAndWait(ctx, ec2Cli, ws, instanceId, app.retryPolicy()); err != nil {
+			app.wsErr(ws, fmt.Sprintf("error stopping instance: %v", err))
+			return
+		}
+	}
+
+	err = callContext(ctx, func() error { return allocateIp(ec2Cli, instanceId, allocId) })
 	if err != nil {
 		app.wsErr(ws, fmt.Sprintf("could not allocate elastic IP: %v", err))
 		return
 	}
 	if currentStatus == "running" {
-		if _, err := ec2Cli.StartInstances(instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error starting instance: %v", err))
+		if err := startInstance(ctx, ec2Cli, instanceId, app.retryPolicy()); err != nil {
+			app.wsErr(ws, err.Error())
 			return
 		}
-		if err := pollUntilRunning(ec2Cli, ws, instanceId); err != nil {
+		if err := pollUntilRunning(ctx, ec2Cli, ws, instanceId); err != nil {
 			app.wsErr(ws, fmt.Sprintf("error checking instance status: %v", err))
 			return
 		}
@@ -0,0 +1,455 @@
+package resize
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestParseTagFilters(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/?tag-key=Team&tag-value=platform&tag-key=Env&tag-key=&tag-value=", nil)
+	got := parseTagFilters(r)
+	want := []tagFilter{{Key: "Team", Value: "platform"}, {Key: "Env"}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d filters, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("filter %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestIndexTagFilters(t *testing.T) {
+	var query url.Values
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprintf(w, describeInstancesResponse, "i-platform")
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ = http.NewRequest("GET", "/?tag-key=Team&tag-value=platform&tag-key=Env", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	filters := map[string]string{}
+	for i := 1; query.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		filters[query.Get(fmt.Sprintf("Filter.%d.Name", i))] = query.Get(fmt.Sprintf("Filter.%d.Value.1", i))
+	}
+	if filters["tag:Team"] != "platform" || filters["tag-key"] != "Env" || len(filters) != 2 {
+		t.Errorf("unexpected DescribeInstances filters %v", filters)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "i-platform") {
+		t.Errorf("expected instance to be listed")
+	}
+	if !strings.Contains(body, "Team=platform") {
+		t.Errorf("expected active filter to be displayed")
+	}
+}
+
+func TestParseStateFilter(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/?state=running,%20Stopped&state=running&state=", nil)
+	got, err := parseStateFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"running", "stopped"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected states %v, got %v", want, got)
+	}
+	r, _ = http.NewRequest("GET", "/?state=sleeping", nil)
+	if _, err := parseStateFilter(r); err == nil {
+		t.Errorf("expected an unknown state to be rejected")
+	}
+}
+
+func TestIndexStateFilter(t *testing.T) {
+	var query url.Values
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprintf(w, describeInstancesResponse, "i-running")
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	w = get("/?tag-key=Team&state=running,stopped")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if query.Get("Filter.1.Name") != "tag-key" || query.Get("Filter.2.Name") != "instance-state-name" ||
+		query.Get("Filter.2.Value.1") != "running" || query.Get("Filter.2.Value.2") != "stopped" {
+		t.Errorf("unexpected DescribeInstances filters %v", query)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `<span class="label label-primary">stopped</span>`) {
+		t.Errorf("expected the active state filter to be displayed")
+	}
+	if !strings.Contains(body, `name="state" value="running,stopped"`) {
+		t.Errorf("expected the state filter to be kept when adding tag filters")
+	}
+
+	w = get("/")
+	if query.Get("Filter.1.Name") != "" {
+		t.Errorf("expected no filter by default, got %v", query)
+	}
+	if !regexp.MustCompile(`class="btn btn-default active">All<`).MatchString(w.Body.String()) {
+		t.Errorf("expected all states to be shown by default")
+	}
+	if w := get("/?state=sleeping"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown state to be rejected, got %d", w.Code)
+	}
+}
+
+func TestRegionInvalid(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+
+	for _, region := range []string{"", "mars-north-1"} {
+		form := url.Values{"region": {region}}
+		r, _ := http.NewRequest("POST", "/region", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.handleRegion(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("region %q: expected status 400, got %d", region, w.Code)
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "yhat-resize" {
+				t.Errorf("region %q: expected session to be unchanged", region)
+			}
+		}
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	ec2Cli, ok := app.creds(r)
+	if !ok || ec2Cli.Region.Name != defaultRegion.Name {
+		t.Errorf("expected session region to remain %s", defaultRegion.Name)
+	}
+}
+
+func TestIndexPagination(t *testing.T) {
+	var query url.Values
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("expected signed request")
+		}
+		if query.Get("NextToken") == "" {
+			fmt.Fprint(w, `<DescribeInstancesResponse><reservationSet><item><instancesSet><item>
+<instanceId>i-first</instanceId></item></instancesSet></item></reservationSet>
+<nextToken>page 2</nextToken></DescribeInstancesResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<DescribeInstancesResponse><reservationSet><item><instancesSet><item>
+<instanceId>i-second</instanceId></item></instancesSet></item></reservationSet>
+</DescribeInstancesResponse>`)
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path string) string {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	link := func(body, class string) string {
+		m := regexp.MustCompile(`<li class="` + class + `"><a href="([^"]*)"`).FindStringSubmatch(body)
+		if m == nil {
+			return ""
+		}
+		return html.UnescapeString(m[1])
+	}
+
+	body := get("/?tag-key=Team&tag-value=platform&max=1000")
+	if query.Get("MaxResults") != "100" {
+		t.Errorf("expected MaxResults to be capped at 100, got %s", query.Get("MaxResults"))
+	}
+	if !strings.Contains(body, "i-first") {
+		t.Errorf("expected first page of instances")
+	}
+	next := link(body, "next")
+	if want := pageURL([]tagFilter{{"Team", "platform"}}, nil, 100, "page 2", []string{""}); next != want {
+		t.Fatalf("expected next link %s, got %q", want, next)
+	}
+	if link(body, "previous") != "" {
+		t.Errorf("expected no previous link on first page")
+	}
+
+	body = get(next)
+	if query.Get("NextToken") != "page 2" || query.Get("Filter.1.Name") != "tag:Team" {
+		t.Errorf("expected token and tag filters to be preserved, got %v", query)
+	}
+	if !strings.Contains(body, "i-second") {
+		t.Errorf("expected second page of instances")
+	}
+	if want := pageURL([]tagFilter{{"Team", "platform"}}, nil, 100, "", nil); link(body, "previous") != want {
+		t.Errorf("expected previous link %s, got %q", want, link(body, "previous"))
+	}
+	if link(body, "next") != "" {
+		t.Errorf("expected no next link on last page")
+	}
+}
+
+func TestIndexInstanceNames(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<DescribeInstancesResponse><reservationSet><item><instancesSet>
+<item><instanceId>i-3</instanceId></item>
+<item><instanceId>i-1</instanceId><tagSet><item><key>Name</key><value>web</value></item></tagSet></item>
+<item><instanceId>i-2</instanceId><tagSet><item><key>Team</key><value>data</value></item><item><key>Name</key><value>API</value></item></tagSet></item>
+<item><instanceId>i-0</instanceId><tagSet><item><key>Name</key><value> </value></item></tagSet></item>
+</instancesSet></item></reservationSet></DescribeInstancesResponse>`)
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	names := regexp.MustCompile(`<strong>([^<]*)</strong>`).FindAllStringSubmatch(w.Body.String(), -1)
+	var got []string
+	for _, m := range names {
+		got = append(got, m[1])
+	}
+	// named instances come first, blank names fall back to the ID
+	if want := []string{"API", "web", "i-0", "i-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected instances %v, got %v", want, got)
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	var authz string
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		authz = r.Header.Get("Authorization")
+		fmt.Fprintf(w, describeInstancesResponse, "i-local")
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = s.URL
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.USWest2)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "i-local") {
+		t.Fatalf("expected instances from the overridden endpoint")
+	}
+	if !strings.Contains(authz, "/us-west-2/ec2/") {
+		t.Errorf("expected request to be signed for us-west-2, got %q", authz)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, describeInstancesResponse, "i-compare")
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m1.small", CPUs: 1, Memory: 1.7},
+		{Name: "m1.large", CPUs: 2, Memory: 7.5},
+	}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+
+	for _, tt := range []struct {
+		to     string
+		status int
+		want   string
+	}{
+		{"m1.large", http.StatusOK, "<strong>7.5</strong>"},
+		{"x9.huge", http.StatusNotFound, "no instance type named &#39;x9.huge&#39;"},
+	} {
+		r, _ := http.NewRequest("GET", "/instance/i-compare/compare?to="+tt.to, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.to, tt.status, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: expected %q in response", tt.to, tt.want)
+		}
+	}
+}
+
+func TestResizeInvalidType(t *testing.T) {
+	ec2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, taggedInstanceResponse, "running")
+	}))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DryRun = true
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	// serve requests path, keeping the cookies the response sets
+	serve := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		for _, c := range w.Result().Cookies() {
+			replaced := false
+			for i, old := range cookies {
+				if old.Name == c.Name {
+					cookies[i], replaced = c, true
+				}
+			}
+			if !replaced {
+				cookies = append(cookies, c)
+			}
+		}
+		return w
+	}
+
+	body := serve("GET", "/instance/i-confirm/resize/confirm?new-type=m1.large", nil).Body.String()
+	n := nonceField.FindStringSubmatch(body)
+	m := csrfMeta.FindStringSubmatch(body)
+	if n == nil || m == nil {
+		t.Fatal("no nonce or CSRF token on confirmation page")
+	}
+	form := url.Values{"nonce": {html.UnescapeString(n[1])}, DefaultCSRFFieldName: {html.UnescapeString(m[1])}}
+	for _, newType := range []string{"x9.huge", "M1.LARGE", "m1.small"} {
+		form.Set("new-type", newType)
+		if w := serve("POST", "/instance/i-confirm/resize", form); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", newType, w.Code)
+		}
+	}
+	// the rejected types didn't use up the confirmation
+	form.Set("new-type", "m1.large")
+	if w := serve("POST", "/instance/i-confirm/resize", form); w.Code != http.StatusOK {
+		t.Errorf("expected the confirmed resize to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,39 @@
+package resize
+
+import "net/http"
+
+// A Pinger is a session store which can report if its backend is reachable.
+type Pinger interface {
+	Ping() error
+}
+
+// Path: /healthz
+//
+// handleHealthz reports that the app is serving and its templates compiled.
+// It doesn't require a login or make any requests to AWS.
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if len(app.templates()) == 0 {
+		app.renderJSON(w, map[string]string{"status": "no templates compiled"}, http.StatusServiceUnavailable)
+		return
+	}
+	app.renderJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+// Path: /readyz
+//
+// handleReadyz additionally checks that the session store is reachable if it
+// implements Pinger.
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if len(app.templates()) == 0 {
+		app.renderJSON(w, map[string]string{"status": "no templates compiled"}, http.StatusServiceUnavailable)
+		return
+	}
+	if p, ok := app.store.(Pinger); ok {
+		if err := p.Ping(); err != nil {
+			app.LogfContext(r.Context(), "session store not ready: %v", err)
+			app.renderJSON(w, map[string]string{"status": "session store unreachable"}, http.StatusServiceUnavailable)
+			return
+		}
+	}
+	app.renderJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
@@ -0,0 +1,70 @@
+package resize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", path, w.Code)
+		}
+		if body := w.Body.String(); body != `{"status":"ok"}` {
+			t.Errorf("%s: unexpected body %s", path, body)
+		}
+	}
+}
+
+func TestReadyzUnreachableStore(t *testing.T) {
+	// nothing is listening on port 1
+	app, err := NewApp("../public", "../templates", NewRedisStore("127.0.0.1:1", []byte("secret-key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/readyz", nil)
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(version, commit string) { Version, Commit = version, commit }(Version, Commit)
+	Version, Commit = "1.2.0", "abc123"
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/version", nil)
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var info buildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	want := buildInfo{
+		Version:   "1.2.0",
+		Commit:    "abc123",
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if info != want {
+		t.Errorf("expected %+v, got %+v", want, info)
+	}
+}
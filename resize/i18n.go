@@ -0,0 +1,186 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale templates are written in, used when a request
+// doesn't ask for any locale the app has a catalog for.
+const DefaultLocale = "en"
+
+// A Catalog translates the UI text of templates into a locale. It maps each
+// English message, as written in the templates, to its translation. Messages
+// taking arguments are fmt format strings, and their translations must use
+// the same verbs.
+type Catalog map[string]string
+
+// LoadCatalogs reads a catalog for each JSON file in fsys, such as the
+// os.DirFS of a directory holding fr.json and pt-br.json. The locale of a
+// catalog is its file name without the extension, in lower case.
+func LoadCatalogs(fsys fs.FS) (map[string]Catalog, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(map[string]Catalog)
+	for _, name := range files {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var c Catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid catalog %s: %v", name, err)
+		}
+		locale := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+		catalogs[locale] = c
+	}
+	return catalogs, nil
+}
+
+// acceptedLocales returns the language tags of an Accept-Language header in
+// order of preference, lower cased. Tags with a quality of zero are left out.
+func acceptedLocales(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var tags []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, accepted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	locales := make([]string, len(tags))
+	for i, t := range tags {
+		locales[i] = t.tag
+	}
+	return locales
+}
+
+// matchLocale returns the first of the locales, such as those from
+// acceptedLocales, with a catalog. A tag with a region, such as "fr-ca",
+// matches the catalog of its language, "fr", if there's none for the region.
+// If none match DefaultLocale is returned.
+func (app *App) matchLocale(locales []string) string {
+	for _, locale := range locales {
+		if locale == DefaultLocale || strings.HasPrefix(locale, DefaultLocale+"-") {
+			return DefaultLocale
+		}
+		if _, ok := app.Catalogs[locale]; ok {
+			return locale
+		}
+		if i := strings.Index(locale, "-"); i > 0 {
+			if _, ok := app.Catalogs[locale[:i]]; ok {
+				return locale[:i]
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// locale returns the locale a request's pages are rendered in: the one chosen
+// through /locale if its catalog is still loaded, otherwise the best match
+// for the Accept-Language header.
+func (app *App) locale(r *http.Request) string {
+	session, _ := app.store.Get(r, "yhat-resize")
+	if locale, ok := session.Values["locale"].(string); ok {
+		if _, ok := app.Catalogs[locale]; ok || locale == DefaultLocale {
+			return locale
+		}
+	}
+	return app.matchLocale(acceptedLocales(r.Header.Get("Accept-Language")))
+}
+
+// locales returns the locales the app can render pages in, sorted, for the
+// locale selector.
+func (app *App) locales() []string {
+	locales := []string{DefaultLocale}
+	for locale := range app.Catalogs {
+		if locale != DefaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// translate is the "t" template helper. It looks up message in the catalog of
+// the locale held by a template's data, falling back to the English message
+// if the catalog doesn't translate it. Any args are formatted into the
+// message as by fmt.Sprintf:
+//
+//	{{ t . "Instance %s" .Instance.InstanceId }}
+func translate(data map[string]interface{}, message string, args ...interface{}) string {
+	catalog, _ := data["Catalog"].(Catalog)
+	if s, ok := catalog[message]; ok && s != "" {
+		message = s
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Path: /locale
+//
+// handleLocale sets the locale of the session's pages, overriding the
+// browser's Accept-Language header, then redirects back to the page the
+// request came from. An empty locale removes the override.
+func (app *App) handleLocale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	locale := strings.ToLower(r.PostFormValue("locale"))
+	if _, ok := app.Catalogs[locale]; !ok && locale != DefaultLocale && locale != "" {
+		app.render400(w, r, fmt.Errorf("No translation for locale %s", locale))
+		return
+	}
+	session, _ := app.store.Get(r, "yhat-resize")
+	if locale == "" {
+		delete(session.Values, "locale")
+	} else {
+		session.Values["locale"] = locale
+	}
+	if err := app.saveSession(w, r, session, nil); err != nil {
+		app.LogfContext(r.Context(), "could not set locale for cookie: %v", err)
+		http.Error(w, "internal error setting cookie", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, localRedirect(r.Referer(), app.pathTo("/")), http.StatusSeeOther)
+}
+
+// localRedirect returns the path and query of a Referer header, so only pages
+// of the app are redirected to, or fallback if it has none.
+func localRedirect(referer, fallback string) string {
+	u, err := url.Parse(referer)
+	if err != nil || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return fallback
+	}
+	return u.RequestURI()
+}
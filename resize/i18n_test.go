@@ -0,0 +1,133 @@
+package resize
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAcceptedLocales(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", []string{}},
+		{"fr", []string{"fr"}},
+		{"en-US,en;q=0.9,fr;q=0.8", []string{"en-us", "en", "fr"}},
+		{"de;q=0.5, PT-BR, fr;q=0.7", []string{"pt-br", "fr", "de"}},
+		{"fr;q=0, de", []string{"de"}},
+	}
+	for _, test := range tests {
+		if got := acceptedLocales(test.header); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("acceptedLocales(%q): expected %q, got %q", test.header, test.expected, got)
+		}
+	}
+}
+
+func TestLoadCatalogs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fr.json":    {Data: []byte(`{"About": "À propos"}`)},
+		"pt-BR.json": {Data: []byte(`{"About": "Sobre"}`)},
+		"README":     {Data: []byte("not a catalog")},
+	}
+	catalogs, err := LoadCatalogs(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Catalog{
+		"fr":    {"About": "À propos"},
+		"pt-br": {"About": "Sobre"},
+	}
+	if !reflect.DeepEqual(catalogs, expected) {
+		t.Errorf("expected catalogs %v, got %v", expected, catalogs)
+	}
+
+	fsys["de.json"] = &fstest.MapFile{Data: []byte(`["About"]`)}
+	if _, err := LoadCatalogs(fsys); err == nil {
+		t.Errorf("expected an error loading an invalid catalog")
+	}
+}
+
+func TestLocale(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Catalogs = map[string]Catalog{
+		"fr": {
+			"Enter your EC2 credentials to begin.": "Saisissez vos identifiants EC2 pour commencer.",
+		},
+	}
+	s := httptest.NewServer(app)
+	defer s.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{Jar: jar}
+
+	get := func(acceptLanguage string) (string, *http.Response) {
+		req, _ := http.NewRequest("GET", s.URL+"/login", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		resp, err := cli.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), resp
+	}
+
+	// a regional tag falls back to the catalog of its language, and text
+	// the catalog lacks is left in English
+	body, resp := get("fr-CA,en;q=0.5")
+	if !strings.Contains(body, "Saisissez vos identifiants EC2") {
+		t.Errorf("expected the login page in French")
+	}
+	if !strings.Contains(body, "Access Key ID") {
+		t.Errorf("expected untranslated text in English")
+	}
+	if lang := resp.Header.Get("Content-Language"); lang != "fr" {
+		t.Errorf("expected Content-Language fr, got %q", lang)
+	}
+	if body, _ := get("de"); !strings.Contains(body, "Enter your EC2 credentials") {
+		t.Errorf("expected the login page in English for a locale without a catalog")
+	}
+
+	// choosing a locale overrides Accept-Language
+	m := csrfMeta.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token rendered in page")
+	}
+	form := url.Values{"locale": {"en"}, DefaultCSRFFieldName: {m[1]}}
+	resp, err = cli.PostForm(s.URL+"/locale", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/login" {
+		t.Errorf("expected to be redirected to the login page, got %s %s", resp.Status, resp.Request.URL)
+	}
+	if body, _ := get("fr"); !strings.Contains(body, "Enter your EC2 credentials") {
+		t.Errorf("expected the chosen locale to override Accept-Language")
+	}
+
+	form.Set("locale", "xx")
+	resp, err = cli.PostForm(s.URL+"/locale", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown locale to be rejected, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,69 @@
+package resize
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// ErrInstanceBusy is returned by an InstanceLocker when another operation
+// holds the lock of an instance.
+var ErrInstanceBusy = errors.New("another operation is in progress on this instance, try again once it has finished")
+
+// An InstanceLocker ensures only one resize, stop or start runs on an
+// instance at a time, so their steps can't interleave. It must be safe for
+// concurrent use.
+//
+// The default locker keeps its locks in memory, so it only guards against
+// operations made through the same replica of the app. Deployments running
+// several replicas should provide a locker backed by shared storage, such as
+// Redis or DynamoDB, so that all replicas see the same locks.
+type InstanceLocker interface {
+	// TryLock acquires the lock identified by key without waiting,
+	// returning ErrInstanceBusy if it's held. unlock releases it.
+	TryLock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// memoryLocker is an InstanceLocker holding its locks in memory. A lock's
+// entry is removed once it's released, so the map only holds the instances
+// being operated on.
+type memoryLocker struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func (l *memoryLocker) TryLock(_ context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked[key] {
+		return nil, ErrInstanceBusy
+	}
+	if l.locked == nil {
+		l.locked = make(map[string]bool)
+	}
+	l.locked[key] = true
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.locked, key)
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// instanceLocker returns the app's InstanceLocker.
+func (app *App) instanceLocker() InstanceLocker {
+	if app.Locker == nil {
+		return &app.locks
+	}
+	return app.Locker
+}
+
+// lockInstance acquires the lock of inst, which is keyed by region as well as
+// ID since IDs are only unique within a region.
+func (app *App) lockInstance(ctx context.Context, ec2Cli *ec2.EC2, inst ec2.Instance) (func(), error) {
+	return app.instanceLocker().TryLock(ctx, ec2Cli.Region.Name+"/"+inst.InstanceId)
+}
@@ -0,0 +1,269 @@
+package resize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"golang.org/x/net/websocket"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	var l memoryLocker
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var unlocks []func()
+	busy := 0
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			unlock, err := l.TryLock(context.Background(), "us-east-1/i-one")
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrInstanceBusy {
+				busy++
+			} else if err == nil {
+				unlocks = append(unlocks, unlock)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if len(unlocks) != 1 || busy != 49 {
+		t.Fatalf("expected exactly one lock to be acquired, got %d and %d busy", len(unlocks), busy)
+	}
+	if _, err := l.TryLock(context.Background(), "us-east-1/i-two"); err != nil {
+		t.Errorf("expected other instances not to be locked, got %v", err)
+	}
+	unlocks[0]()
+	unlocks[0]()
+	if l.locked["us-east-1/i-one"] {
+		t.Errorf("expected the released lock to be removed")
+	}
+	if _, err := l.TryLock(context.Background(), "us-east-1/i-one"); err != nil {
+		t.Errorf("expected a released lock to be acquired again, got %v", err)
+	}
+}
+
+func TestResizeLocked(t *testing.T) {
+	modifying := make(chan struct{}, 1)
+	release := make(chan struct{})
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("Action") {
+		case "DescribeInstances":
+			fmt.Fprintf(w, stoppedInstanceResponse, "i-lock", "m1.small")
+		case "ModifyInstanceAttribute":
+			modifying <- struct{}{}
+			<-release
+			fmt.Fprint(w, modifyInstanceResponse)
+		case "StartInstances":
+			fmt.Fprint(w, `<StartInstancesResponse><requestId>1</requestId><instancesSet/></StartInstancesResponse>`)
+		default:
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2Cli); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	cli := &http.Client{Jar: jar}
+	resp, err := cli.Get(s.URL + "/about")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := csrfMeta.FindSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token rendered")
+	}
+	form := url.Values{DefaultCSRFFieldName: {string(m[1])}}
+	start := func() int {
+		resp, err := cli.PostForm(s.URL+"/instance/i-lock/start", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	inst := ec2.Instance{InstanceId: "i-lock", InstanceType: "m1.small", State: ec2.InstanceState{Name: "stopped"}}
+	done := make(chan error)
+	go func() {
+		var steps eventLog
+		done <- app.resizeInstance(context.Background(), ec2Cli, &steps, inst, "m1.large", "", false)
+	}()
+	<-modifying
+
+	var steps eventLog
+	if err := app.resizeInstance(context.Background(), ec2Cli, &steps, inst, "m1.large", "", false); err != ErrInstanceBusy {
+		t.Errorf("expected a concurrent resize to be rejected, got %v", err)
+	}
+	if err := app.resizeInstance(context.Background(), ec2Cli, &steps, inst, "m1.large", "", true); err != nil {
+		t.Errorf("expected a dry run not to wait for the lock, got %v", err)
+	}
+	if code := start(); code != http.StatusConflict {
+		t.Errorf("expected starting the instance to conflict, got %d", code)
+	}
+	r, _ = http.NewRequest("POST", "/api/resize", strings.NewReader(`{"instance_ids":["i-lock"],"new_type":"m1.large"}`))
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	bw := httptest.NewRecorder()
+	app.handleAPIBulkResize(bw, r)
+	var bulk bulkResizeResponse
+	if err := json.Unmarshal(bw.Body.Bytes(), &bulk); err != nil {
+		t.Fatalf("%v: %s", err, bw.Body.String())
+	}
+	if bulk.Failed != 1 || !strings.Contains(bulk.Results[0].Error, "in progress") {
+		t.Errorf("expected the bulk resize to report the operation in progress, got %+v", bulk.Results)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("expected the first resize to succeed, got %v", err)
+	}
+	if len(app.locks.locked) != 0 {
+		t.Errorf("expected the lock to be released, got %v", app.locks.locked)
+	}
+	if code := start(); code != http.StatusOK {
+		t.Errorf("expected the instance to start once the resize finished, got %d", code)
+	}
+}
+
+func TestAssignIpLocked(t *testing.T) {
+	modifying := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	associated := 0
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("Action") {
+		case "DescribeInstances":
+			fmt.Fprintf(w, stoppedInstanceResponse, "i-lock", "m1.small")
+		case "ModifyInstanceAttribute":
+			modifying <- struct{}{}
+			<-release
+			fmt.Fprint(w, modifyInstanceResponse)
+		case "AssociateAddress":
+			mu.Lock()
+			associated++
+			mu.Unlock()
+			fmt.Fprint(w, `<AssociateAddressResponse><requestId>1</requestId><return>true</return><associationId>eipassoc-1</associationId></AssociateAddressResponse>`)
+		default:
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2Cli); err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.csrfToken(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cookies []string
+	for _, c := range w.Result().Cookies() {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	assign := func() Event {
+		u := "ws" + strings.TrimPrefix(s.URL, "http") + "/instance/i-lock/assign-ip?" +
+			url.Values{"status": {"stopped"}, app.csrfFieldName(): {token}}.Encode()
+		config, err := websocket.NewConfig(u, s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Set("Cookie", strings.Join(cookies, "; "))
+		ws, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		if err := websocket.Message.Send(ws, "eipalloc-1"); err != nil {
+			t.Fatal(err)
+		}
+		var e Event
+		for e.Status == "" || e.Status == "message" {
+			if err := websocket.JSON.Receive(ws, &e); err != nil {
+				t.Fatalf("expected an event: %v", err)
+			}
+		}
+		return e
+	}
+
+	inst := ec2.Instance{InstanceId: "i-lock", InstanceType: "m1.small", State: ec2.InstanceState{Name: "stopped"}}
+	done := make(chan error)
+	go func() {
+		var steps eventLog
+		done <- app.resizeInstance(context.Background(), ec2Cli, &steps, inst, "m1.large", "", false)
+	}()
+	<-modifying
+
+	if e := assign(); e.Status != "error" || !strings.Contains(e.Message, "in progress") {
+		t.Errorf("expected assigning an IP during a resize to be rejected, got %+v", e)
+	}
+	mu.Lock()
+	if associated != 0 {
+		t.Errorf("expected no address to be associated during the resize")
+	}
+	mu.Unlock()
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("expected the resize to succeed, got %v", err)
+	}
+	if e := assign(); e.Status != "success" {
+		t.Errorf("expected the IP to be assigned once the resize finished, got %+v", e)
+	}
+	if len(app.locks.locked) != 0 {
+		t.Errorf("expected the lock to be released, got %v", app.locks.locked)
+	}
+}
@@ -0,0 +1,140 @@
+package resize
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// Page sizes of the instance list.
+const (
+	defaultPageSize = 50
+	minPageSize     = 5 // the smallest MaxResults EC2 accepts
+	maxPageSize     = 100
+)
+
+// instancePage is a page of a DescribeInstances response.
+type instancePage struct {
+	Reservations []ec2.Reservation `xml:"reservationSet>item"`
+	NextToken    string            `xml:"nextToken"`
+}
+
+// describeInstancesPage lists at most max instances matching tags and in one
+// of states, if any are given, starting at the page identified by token. The returned token identifies the next
+// page, and is empty on the last page.
+//
+// The vendored EC2 client doesn't support paginating DescribeInstances, so
+// the request is made directly.
+// On an error returned by AWS, error will be of type *ec2.Error.
+func describeInstancesPage(client *http.Client, ec2Cli *ec2.EC2, tags []tagFilter, states []string, max int, token string) ([]ec2.Instance, string, error) {
+	params := url.Values{
+		"Action":     {"DescribeInstances"},
+		"Version":    {"2014-06-15"},
+		"MaxResults": {strconv.Itoa(max)},
+	}
+	if token != "" {
+		params.Set("NextToken", token)
+	}
+	addTagFilterParams(params, tags)
+	addStateFilterParams(params, states, len(tags))
+
+	resp, err := ec2Query(client, ec2Cli, params)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", ec2ResponseError(resp)
+	}
+	var page instancePage
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", err
+	}
+	return allInstances(&ec2.InstancesResp{Reservations: page.Reservations}), page.NextToken, nil
+}
+
+// ec2Query makes a signed request to the EC2 query API of ec2Cli's region,
+// for actions the vendored EC2 client doesn't support. The caller must close
+// the response's body.
+func ec2Query(client *http.Client, ec2Cli *ec2.EC2, params url.Values) (*http.Response, error) {
+	endpoint, err := url.Parse(ec2Cli.Region.EC2Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Path == "" {
+		endpoint.Path = "/"
+	}
+	// SigV4 requires spaces to be encoded as %20
+	endpoint.RawQuery = strings.Replace(params.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, "", ec2Cli.Auth, ec2Cli.Region.Name, "ec2", time.Now().UTC())
+	return client.Do(req)
+}
+
+// ec2ResponseError reads the error of an unsuccessful EC2 query API response.
+// If the body holds an error returned by AWS, it's of type *ec2.Error.
+func ec2ResponseError(resp *http.Response) error {
+	var errResp struct {
+		Errors    []ec2.Error `xml:"Errors>Error"`
+		RequestId string      `xml:"RequestID"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&errResp); err != nil || len(errResp.Errors) == 0 {
+		return fmt.Errorf("bad response from AWS EC2: %s", resp.Status)
+	}
+	e := errResp.Errors[0]
+	e.StatusCode = resp.StatusCode
+	e.RequestId = errResp.RequestId
+	return &e
+}
+
+// parsePageSize reads the max query parameter, clamped to the page sizes
+// EC2 accepts and maxPageSize.
+func parsePageSize(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil || n <= 0 {
+		return defaultPageSize
+	}
+	if n < minPageSize {
+		return minPageSize
+	}
+	if n > maxPageSize {
+		return maxPageSize
+	}
+	return n
+}
+
+// pageURL returns the URL of a page of the instance list. EC2 only returns
+// tokens for following pages, so prev holds the tokens of every page before
+// the linked one.
+func pageURL(tags []tagFilter, states []string, max int, token string, prev []string) string {
+	q := url.Values{}
+	for _, t := range tags {
+		q.Add("tag-key", t.Key)
+		q.Add("tag-value", t.Value)
+	}
+	if len(states) > 0 {
+		q.Set("state", strings.Join(states, ","))
+	}
+	if max != defaultPageSize {
+		q.Set("max", strconv.Itoa(max))
+	}
+	if token != "" {
+		q.Set("token", token)
+	}
+	for _, p := range prev {
+		q.Add("prev", p)
+	}
+	if len(q) == 0 {
+		return "/"
+	}
+	return "/?" + q.Encode()
+}
@@ -0,0 +1,94 @@
+package resize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields are the key-value pairs attached to a logged event.
+type Fields map[string]interface{}
+
+// sensitive reports if a field's name suggests it holds a credential. Such
+// fields are never logged.
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "secret") || strings.Contains(key, "token") ||
+		strings.Contains(key, "password")
+}
+
+// LogEvent logs a named event, such as "login" or "resize", along with its
+// fields. If JSONLog is set the event is written as a single line JSON
+// object, otherwise as "event key=value ...". Fields which may hold
+// credentials are dropped.
+func (app *App) LogEvent(event string, fields Fields) {
+	record := make(Fields, len(fields)+1)
+	for k, v := range fields {
+		if sensitive(k) {
+			continue
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		record[k] = v
+	}
+	if app.JSONLog {
+		record["event"] = event
+		app.writeJSONLog(record)
+		return
+	}
+
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, record[k])
+	}
+	app.logf("%s %s", event, strings.Join(pairs, " "))
+}
+
+// LogEventContext behaves like LogEvent, adding the ID of the request ctx
+// belongs to, if any, as the event's request_id field.
+func (app *App) LogEventContext(ctx context.Context, event string, fields Fields) {
+	if id := RequestID(ctx); id != "" {
+		withID := make(Fields, len(fields)+1)
+		for k, v := range fields {
+			withID[k] = v
+		}
+		withID["request_id"] = id
+		fields = withID
+	}
+	app.LogEvent(event, fields)
+}
+
+// writeJSONLog writes a record to the app's logger as a line of JSON.
+func (app *App) writeJSONLog(record Fields) {
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	b, err := json.Marshal(record)
+	if err != nil {
+		b, _ = json.Marshal(Fields{"msg": fmt.Sprintf("error marshalling log record: %v", err)})
+	}
+	var w io.Writer
+	if app.Logger == nil {
+		w = log.Writer()
+	} else {
+		w = app.Logger.Writer()
+	}
+	app.logMu.Lock()
+	w.Write(append(b, '\n'))
+	app.logMu.Unlock()
+}
+
+// durationMS returns the milliseconds elapsed since start for use as a
+// duration_ms field.
+func durationMS(start time.Time) int64 {
+	return int64(time.Since(start) / time.Millisecond)
+}
@@ -0,0 +1,50 @@
+package resize
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogEventJSON(t *testing.T) {
+	var buf bytes.Buffer
+	app := &App{Logger: log.New(&buf, "", log.LstdFlags), JSONLog: true}
+	app.LogEvent("login", Fields{
+		"region":      "us-east-1",
+		"secret_key":  "wJalrXUtnFEMI",
+		"duration_ms": 12,
+	})
+	app.Logf("hello %s", "world")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %q", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if record["event"] != "login" || record["region"] != "us-east-1" {
+		t.Errorf("unexpected record %v", record)
+	}
+	if _, ok := record["secret_key"]; ok || strings.Contains(buf.String(), "wJalrXUtnFEMI") {
+		t.Errorf("secret was logged: %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if record["msg"] != "hello world" {
+		t.Errorf("expected Logf message in msg field, got %v", record)
+	}
+}
+
+func TestLogEventText(t *testing.T) {
+	var buf bytes.Buffer
+	app := &App{Logger: log.New(&buf, "", 0)}
+	app.LogEvent("resize", Fields{"instance_id": "i-1234", "to_type": "t2.medium"})
+	if got := buf.String(); got != "resize instance_id=i-1234 to_type=t2.medium\n" {
+		t.Errorf("unexpected log output %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+package resize
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The vendored dependencies don't include the Prometheus client library, so
+// the app's metrics are kept here and written in the Prometheus text
+// exposition format.
+
+// defaultBuckets are the upper bounds, in seconds, of latency histograms.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// counter is a monotonically increasing metric.
+type counter struct {
+	name, help string
+	v          uint64
+}
+
+func (c *counter) inc() { atomic.AddUint64(&c.v, 1) }
+
+func (c *counter) value() uint64 { return atomic.LoadUint64(&c.v) }
+
+func (c *counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name, c.help, c.name, c.name, c.value())
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: defaultBuckets,
+		counts:  make([]uint64, len(defaultBuckets)),
+	}
+}
+
+// observeSince records the time elapsed since start.
+func (h *histogram) observeSince(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(h.sum), h.name, h.count)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metrics are the usage statistics of an App.
+type metrics struct {
+	logins         counter
+	logouts        counter
+	resizeAttempts counter
+	resizeFailures counter
+	scrapeFailures counter
+
+	scrapeLatency *histogram
+	ec2Latency    *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		logins:         counter{name: "resize_logins_total", help: "Number of successful logins."},
+		logouts:        counter{name: "resize_logouts_total", help: "Number of logouts."},
+		resizeAttempts: counter{name: "resize_resize_attempts_total", help: "Number of instance resizes attempted."},
+		resizeFailures: counter{name: "resize_resize_failures_total", help: "Number of instance resizes which failed."},
+		scrapeFailures: counter{name: "resize_scrape_failures_total", help: "Number of failed instance type fetches."},
+		scrapeLatency:  newHistogram("resize_scrape_duration_seconds", "Time taken to fetch instance types."),
+		ec2Latency:     newHistogram("resize_ec2_request_duration_seconds", "Time taken by requests to the EC2 API."),
+	}
+}
+
+// write writes all metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	for _, c := range []*counter{&m.logins, &m.logouts, &m.resizeAttempts, &m.resizeFailures, &m.scrapeFailures} {
+		c.write(w)
+	}
+	m.scrapeLatency.write(w)
+	m.ec2Latency.write(w)
+}
+
+// timedTransport records the latency of each request in a histogram.
+type timedTransport struct {
+	rt http.RoundTripper
+	h  *histogram
+}
+
+func (t timedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	defer t.h.observeSince(time.Now())
+	return t.rt.RoundTrip(r)
+}
+
+// ec2HTTPClient returns the app's HTTP client, instrumented to record the
+// latency of EC2 API requests.
+func (app *App) ec2HTTPClient() *http.Client {
+	client := *app.httpClient()
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	client.Transport = timedTransport{rt: rt, h: app.metrics.ec2Latency}
+	return &client
+}
+
+// Path: /metrics
+//
+// handleMetrics exposes the app's metrics to Prometheus. It doesn't require a
+// login.
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	app.metrics.write(bw)
+	bw.Flush()
+}
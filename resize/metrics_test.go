@@ -0,0 +1,65 @@
+package resize
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram("test_seconds", "Test.")
+	h.observe(0.004)
+	h.observe(0.3)
+	h.observe(20)
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, line := range []string{
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{le="0.005"} 1`,
+		`test_seconds_bucket{le="0.25"} 1`,
+		`test_seconds_bucket{le="0.5"} 2`,
+		`test_seconds_bucket{le="10"} 2`,
+		`test_seconds_bucket{le="+Inf"} 3`,
+		"test_seconds_sum 20.304",
+		"test_seconds_count 3",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = sourceFunc(func(*http.Client) ([]InstanceType, error) {
+		return nil, errors.New("scrape failed")
+	})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/instance-types", nil)
+	app.handleAPIInstanceTypes(w, r)
+
+	// metrics don't require a login
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/metrics", nil)
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"resize_logins_total 0",
+		"resize_scrape_failures_total 1",
+		"resize_scrape_duration_seconds_count 1",
+		"resize_ec2_request_duration_seconds_count 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %q in metrics", line)
+		}
+	}
+}
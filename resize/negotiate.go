@@ -0,0 +1,195 @@
+package resize
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// wantsJSON reports if the Accept header of r prefers application/json to
+// HTML. HTML is preferred when the header is missing or both are equally
+// acceptable, so browsers keep getting pages.
+func wantsJSON(r *http.Request) bool {
+	var jsonQ, htmlQ float64
+	for _, header := range r.Header["Accept"] {
+		for _, part := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil {
+					continue
+				}
+			}
+			switch mediaType {
+			case "application/json":
+				jsonQ = maxFloat(jsonQ, q)
+			case "text/html", "application/xhtml+xml", "text/*", "*/*":
+				htmlQ = maxFloat(htmlQ, q)
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// pageDataKeys are the keys render and renderStatus add to the data of every
+// page for the layout, which aren't part of a page's JSON.
+var pageDataKeys = []string{
+	"CSRFFieldName", "CSRFToken", "CSPNonce", "BasePath", "Locale", "Catalog",
+	"Locales", "Flashes", "TemplateError", "Regions", "TypesAvailable",
+	"ReadOnly",
+}
+
+// renderPageJSON serializes the data of the named page as JSON, for requests
+// which prefer JSON to HTML. Pages with a pageShape are serialized in their
+// stable shape, others as their data without what's only used by the
+// layout. Error pages are written as a JSON error.
+func (app *App) renderPageJSON(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}, status int) {
+	if status >= http.StatusBadRequest {
+		msg, _ := data["Error"].(string)
+		if msg == "" {
+			msg = http.StatusText(status)
+		}
+		app.renderJSONError(w, msg, status)
+		return
+	}
+	if shape, ok := pageShapes[name]; ok {
+		app.renderJSON(w, shape(app, r, data), status)
+		return
+	}
+	v := make(map[string]interface{}, len(data))
+	for k, val := range data {
+		v[k] = val
+	}
+	for _, k := range pageDataKeys {
+		delete(v, k)
+	}
+	app.renderJSON(w, v, status)
+}
+
+// pageShapes convert the data of pages whose JSON is relied on by clients to
+// a stable shape, which doesn't change with the templates' needs.
+var pageShapes = map[string]func(app *App, r *http.Request, data map[string]interface{}) interface{}{
+	"index.html":       (*App).indexJSON,
+	"instance.html":    (*App).instanceJSON,
+	"all-regions.html": (*App).allRegionsJSON,
+}
+
+// instanceSummary is the JSON shape of an instance.
+type instanceSummary struct {
+	InstanceID       string            `json:"instance_id"`
+	Name             string            `json:"name"`
+	InstanceType     string            `json:"instance_type"`
+	State            string            `json:"state"`
+	Architecture     string            `json:"architecture"`
+	Virtualization   string            `json:"virtualization"`
+	AvailabilityZone string            `json:"availability_zone"`
+	PrivateIP        string            `json:"private_ip"`
+	PublicIP         string            `json:"public_ip"`
+	LaunchTime       time.Time         `json:"launch_time"`
+	Tags             map[string]string `json:"tags"`
+}
+
+func summarizeInstance(inst ec2.Instance) instanceSummary {
+	tags := make(map[string]string, len(inst.Tags))
+	for _, tag := range inst.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return instanceSummary{
+		InstanceID:       inst.InstanceId,
+		Name:             nameTag(inst),
+		InstanceType:     inst.InstanceType,
+		State:            inst.State.Name,
+		Architecture:     inst.Architecture,
+		Virtualization:   inst.VirtType,
+		AvailabilityZone: inst.AvailZone,
+		PrivateIP:        inst.PrivateIpAddress,
+		PublicIP:         inst.PublicIpAddress,
+		LaunchTime:       inst.LaunchTime,
+		Tags:             tags,
+	}
+}
+
+func summarizeInstances(instances []ec2.Instance) []instanceSummary {
+	summaries := make([]instanceSummary, len(instances))
+	for i, inst := range instances {
+		summaries[i] = summarizeInstance(inst)
+	}
+	return summaries
+}
+
+// regionPage is the JSON shape of the instance list of the selected region.
+// NextPage and PrevPage are the URLs of the neighbouring pages, if any.
+type regionPage struct {
+	Region    string            `json:"region"`
+	Instances []instanceSummary `json:"instances"`
+	NextPage  string            `json:"next_page,omitempty"`
+	PrevPage  string            `json:"prev_page,omitempty"`
+}
+
+func (app *App) indexJSON(r *http.Request, data map[string]interface{}) interface{} {
+	instances, _ := data["Instances"].([]ec2.Instance)
+	page := regionPage{Instances: summarizeInstances(instances)}
+	page.Region, _ = app.Region(r)
+	page.NextPage, _ = data["NextPage"].(string)
+	page.PrevPage, _ = data["PrevPage"].(string)
+	return page
+}
+
+// instanceDetail is the JSON shape of the instance page. Targets are the
+// types the instance can be resized to, with their prices if known.
+type instanceDetail struct {
+	Region    string          `json:"region"`
+	Instance  instanceSummary `json:"instance"`
+	ElasticIP string          `json:"elastic_ip,omitempty"`
+	CanResize bool            `json:"can_resize"`
+	Targets   []InstanceType  `json:"targets"`
+}
+
+func (app *App) instanceJSON(r *http.Request, data map[string]interface{}) interface{} {
+	inst, _ := data["Instance"].(ec2.Instance)
+	detail := instanceDetail{Instance: summarizeInstance(inst)}
+	detail.Region, _ = app.Region(r)
+	if addr, ok := data["Address"].(ec2.Address); ok {
+		detail.ElasticIP = addr.PublicIp
+	}
+	detail.CanResize, _ = data["CanResize"].(bool)
+	detail.Targets, _ = data["InstanceTypes"].([]InstanceType)
+	if detail.Targets == nil {
+		detail.Targets = []InstanceType{}
+	}
+	return detail
+}
+
+// regionResult is the JSON shape of a region of the all regions view. Error
+// is set if the region's instances couldn't be listed.
+type regionResult struct {
+	Region    string            `json:"region"`
+	Instances []instanceSummary `json:"instances"`
+	Error     string            `json:"error,omitempty"`
+}
+
+func (app *App) allRegionsJSON(r *http.Request, data map[string]interface{}) interface{} {
+	results, _ := data["Results"].([]RegionInstances)
+	regions := make([]regionResult, len(results))
+	for i, result := range results {
+		regions[i] = regionResult{Region: result.Region, Instances: summarizeInstances(result.Instances)}
+		if result.Err != nil {
+			regions[i].Error = result.Err.Error()
+		}
+	}
+	return map[string]interface{}{"regions": regions}
+}
@@ -0,0 +1,136 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", true},
+		{"application/json, text/plain, */*;q=0.5", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/html, application/json", false},
+		{"text/html;q=0.5, application/json", true},
+		{"application/json;q=0", false},
+		{"*/*", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsJSON(r); got != tt.want {
+			t.Errorf("wantsJSON(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestPageJSON(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, describeInstancesResponse, "i-json")
+	}))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large", CPUs: 2}}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path, accept string, login bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if login {
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v interface{}) {
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("expected a JSON response, got %s: %s", ct, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+	}
+
+	var page regionPage
+	decode(get("/", "application/json", true), &page)
+	if page.Region != "test-region" || len(page.Instances) != 1 ||
+		page.Instances[0].InstanceID != "i-json" || page.Instances[0].State != "running" {
+		t.Errorf("unexpected instance list %+v", page)
+	}
+
+	w = get("/instance/i-json", "application/json", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var detail instanceDetail
+	decode(w, &detail)
+	if detail.Instance.InstanceType != "m1.small" || len(detail.Targets) != 1 || detail.Targets[0].Name != "m1.large" {
+		t.Errorf("unexpected instance detail %+v", detail)
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &raw)
+	for _, key := range []string{"region", "instance", "can_resize", "targets"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("expected the instance detail to have %q", key)
+		}
+	}
+
+	// pages without a shape are served as their data
+	decode(get("/diagnostics", "application/json", true), &raw)
+	if _, ok := raw["Settings"]; !ok {
+		t.Errorf("expected the page's data, got %v", raw)
+	}
+	for _, key := range pageDataKeys {
+		if _, ok := raw[key]; ok {
+			t.Errorf("expected %s to be left out of JSON pages", key)
+		}
+	}
+
+	var apiErr map[string]string
+	w = get("/instance/i-json/resize/confirm?new-type=x9.huge", "application/json", true)
+	decode(w, &apiErr)
+	if w.Code != http.StatusBadRequest || !strings.Contains(apiErr["error"], "unknown instance type") {
+		t.Errorf("expected a JSON error, got %d %v", w.Code, apiErr)
+	}
+	if w := get("/", "application/json", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected JSON clients not to be redirected to log in, got %d", w.Code)
+	}
+
+	for _, accept := range []string{"", "text/html"} {
+		w := get("/", accept, true)
+		if ct := w.Header().Get("Content-Type"); ct != "text/html" || !strings.Contains(w.Body.String(), "i-json") {
+			t.Errorf("Accept %q: expected an HTML page, got %s", accept, ct)
+		}
+		if vary := strings.Join(w.Header()["Vary"], ","); !strings.Contains(vary, "Accept,") && !strings.HasSuffix(vary, "Accept") {
+			t.Errorf("Accept %q: expected the response to vary by Accept, got %q", accept, vary)
+		}
+	}
+}
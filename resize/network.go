@@ -0,0 +1,89 @@
+package resize
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NetworkTier is a comparable level of the network performance of an
+// instance type, parsed from the free text of its NetworkSpec.
+type NetworkTier int
+
+// Network tiers, from slowest to fastest. NetworkUnknown sorts before all
+// others.
+const (
+	NetworkUnknown NetworkTier = iota
+	NetworkLow
+	NetworkModerate
+	NetworkHigh
+	Network10G
+	Network25G
+	Network100G
+)
+
+var networkTierNames = []string{"Unknown", "Low", "Moderate", "High", "10G", "25G", "100G"}
+
+func (t NetworkTier) String() string {
+	if t < 0 || int(t) >= len(networkTierNames) {
+		return networkTierNames[NetworkUnknown]
+	}
+	return networkTierNames[t]
+}
+
+// ParseNetworkTier maps a network performance description such as
+// "Moderate", "10 Gigabit" or a tier name such as "25G" to its tier. Speeds
+// stated as "Up to" a number of gigabits are only bursts, so they're placed
+// one tier below the stated speed, but no lower than NetworkHigh. Speeds
+// between the named tiers round down. Unrecognized descriptions are
+// NetworkUnknown.
+func ParseNetworkTier(spec string) NetworkTier {
+	s := strings.ToLower(strings.TrimSpace(spec))
+	burst := strings.HasPrefix(s, "up to ")
+	s = strings.TrimSpace(strings.TrimPrefix(s, "up to "))
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		num := leadingNumber(s)
+		gbps, err := strconv.ParseFloat(num, 64)
+		unit := strings.TrimSpace(s[len(num):])
+		if err != nil || !strings.HasPrefix(unit, "g") {
+			return NetworkUnknown
+		}
+		tier := NetworkHigh
+		switch {
+		case gbps >= 100:
+			tier = Network100G
+		case gbps >= 25:
+			tier = Network25G
+		case gbps >= 10:
+			tier = Network10G
+		}
+		if burst && tier > NetworkHigh {
+			tier--
+		}
+		return tier
+	}
+	switch s {
+	case "very low", "low", "low to moderate":
+		return NetworkLow
+	case "moderate":
+		return NetworkModerate
+	case "high":
+		return NetworkHigh
+	}
+	return NetworkUnknown
+}
+
+// FilterByNetwork returns the instance types whose network performance is at
+// least min. Types with an unrecognized NetworkSpec are excluded. A min of
+// NetworkUnknown returns types unchanged.
+func FilterByNetwork(types []InstanceType, min NetworkTier) []InstanceType {
+	if min == NetworkUnknown {
+		return types
+	}
+	filtered := []InstanceType{}
+	for _, t := range types {
+		if ParseNetworkTier(t.NetworkSpec) >= min {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,110 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestParseNetworkTier(t *testing.T) {
+	for spec, want := range map[string]NetworkTier{
+		"Very Low":          NetworkLow,
+		"Low":               NetworkLow,
+		"Low to Moderate":   NetworkLow,
+		"Moderate":          NetworkModerate,
+		"High":              NetworkHigh,
+		"Up to 5 Gigabit":   NetworkHigh,
+		"Up to 10 Gigabit":  NetworkHigh,
+		"10 Gigabit":        Network10G,
+		"Up to 25 Gigabit":  Network10G,
+		"25 Gigabit":        Network25G,
+		"50 Gigabit":        Network25G,
+		"100 Gigabit":       Network100G,
+		" 10G ":             Network10G,
+		"25g":               Network25G,
+		"10 Megabit":        NetworkUnknown,
+		"Depends on region": NetworkUnknown,
+		"":                  NetworkUnknown,
+	} {
+		if got := ParseNetworkTier(spec); got != want {
+			t.Errorf("ParseNetworkTier(%q) = %s, want %s", spec, got, want)
+		}
+	}
+}
+
+func TestFilterByNetwork(t *testing.T) {
+	types := []InstanceType{
+		{Name: "t2.micro", NetworkSpec: "Low to Moderate"},
+		{Name: "m5.large", NetworkSpec: "Up to 10 Gigabit"},
+		{Name: "c4.8xlarge", NetworkSpec: "10 Gigabit"},
+		{Name: "c5n.18xlarge", NetworkSpec: "100 Gigabit"},
+		{Name: "x9.huge", NetworkSpec: "Unknown"},
+	}
+	var names []string
+	for _, typ := range FilterByNetwork(types, Network10G) {
+		names = append(names, typ.Name)
+	}
+	if got := strings.Join(names, ","); got != "c4.8xlarge,c5n.18xlarge" {
+		t.Errorf("expected types with at least 10 Gigabit networking, got %s", got)
+	}
+	if len(FilterByNetwork(types, NetworkUnknown)) != len(types) {
+		t.Errorf("expected no minimum to return every type")
+	}
+	sorted := SortTypes(types, "network", "asc")
+	if sorted[0].Name != "x9.huge" {
+		t.Errorf("expected an unrecognized network to sort first, got %s", sorted[0].Name)
+	}
+}
+
+func TestInstanceTypesNetworkMin(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m5.large", NetworkSpec: "Up to 10 Gigabit"},
+		{Name: "c5n.18xlarge", NetworkSpec: "100 Gigabit"},
+	}, nil)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+
+	for _, tt := range []struct {
+		query  string
+		status int
+		listed []string
+		hidden []string
+	}{
+		{"", http.StatusOK, []string{"m5.large", "c5n.18xlarge"}, nil},
+		{"?network_min=10G", http.StatusOK, []string{"c5n.18xlarge"}, []string{"m5.large"}},
+		{"?network_min=fast", http.StatusBadRequest, nil, nil},
+	} {
+		r, _ := http.NewRequest("GET", "/instance-types"+tt.query, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, w.Code)
+		}
+		body := w.Body.String()
+		for _, name := range tt.listed {
+			if !strings.Contains(body, "/instance-types/"+name+"/regions") {
+				t.Errorf("%q: expected %s to be listed", tt.query, name)
+			}
+		}
+		for _, name := range tt.hidden {
+			if strings.Contains(body, "/instance-types/"+name+"/regions") {
+				t.Errorf("%q: expected %s to be filtered out", tt.query, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package resize
+
+import (
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func init() {
+	gob.Register(map[string]bool{})
+}
+
+var errResizeDenied = errors.New("your credentials aren't permitted to change the type of instances (ec2:ModifyInstanceAttribute)")
+
+// checkResizePermission reports if ec2Cli's principal may change the type of
+// inst. It asks EC2 to dry run setting the instance's type to its current
+// one, which makes no changes whether or not it's permitted.
+func checkResizePermission(client *http.Client, ec2Cli *ec2.EC2, inst ec2.Instance) (bool, error) {
+	params := url.Values{
+		"Action":             {"ModifyInstanceAttribute"},
+		"Version":            {"2014-06-15"},
+		"InstanceId":         {inst.InstanceId},
+		"InstanceType.Value": {inst.InstanceType},
+		"DryRun":             {"true"},
+	}
+	resp, err := ec2Query(client, ec2Cli, params)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	err = ec2ResponseError(resp)
+	if e, ok := err.(*ec2.Error); ok {
+		switch e.Code {
+		case "DryRunOperation":
+			return true, nil
+		case "UnauthorizedOperation":
+			return false, nil
+		}
+	}
+	return false, err
+}
+
+// maxCachedPermissions bounds the number of instances whose resize
+// permission is cached in a session, as the session is stored in a cookie.
+const maxCachedPermissions = 50
+
+// cachedPermissions returns the resize permissions cached in the request's
+// session, keyed by permissionKey. Requests authenticated with a bearer token
+// or basic auth have no cached permissions, as the session's cookie may
+// belong to another identity.
+func (app *App) cachedPermissions(r *http.Request) (*sessions.Session, map[string]bool) {
+	if bearerToken(r) != "" || basicAuthRequest(r) {
+		return nil, nil
+	}
+	session, _ := app.store.Get(r, "yhat-resize")
+	permissions, _ := session.Values["canResize"].(map[string]bool)
+	return session, permissions
+}
+
+// permissionKey is the key of inst's resize permission in a session, which
+// includes the region as instance IDs are only unique within one.
+func permissionKey(ec2Cli *ec2.EC2, instanceId string) string {
+	return ec2Cli.Region.Name + "/" + instanceId
+}
+
+// canResize reports if the request session's credentials may resize inst.
+// IAM policies may allow resizing some instances and not others, so the
+// answer is cached in the session for inst alone, until the session's
+// credentials change. With w nil, or for requests authenticated without a
+// session, it's only looked up and not stored. When it can't be determined,
+// resizing is allowed and left to fail if AWS denies it.
+func (app *App) canResize(w http.ResponseWriter, r *http.Request, ec2Cli *ec2.EC2, inst ec2.Instance) bool {
+	key := permissionKey(ec2Cli, inst.InstanceId)
+	session, permissions := app.cachedPermissions(r)
+	if allowed, ok := permissions[key]; ok {
+		return allowed
+	}
+	allowed, err := checkResizePermission(app.ec2HTTPClient(), ec2Cli, inst)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not check resize permission: %v", err)
+		return true
+	}
+	if w != nil && session != nil {
+		if permissions == nil || len(permissions) >= maxCachedPermissions {
+			permissions = make(map[string]bool)
+		}
+		permissions[key] = allowed
+		session.Values["canResize"] = permissions
+		if err := app.saveSession(w, r, session, nil); err != nil {
+			app.LogfContext(r.Context(), "could not save resize permission: %v", err)
+		}
+	}
+	return allowed
+}
+
+// resizeDenied reports if the request session is known to lack permission to
+// resize the instance in the request's path, without making any requests to
+// AWS.
+func (app *App) resizeDenied(r *http.Request) bool {
+	ec2Cli, ok := app.creds(r)
+	instanceId := mux.Vars(r)["instance"]
+	if !ok || instanceId == "" {
+		return false
+	}
+	_, permissions := app.cachedPermissions(r)
+	allowed, ok := permissions[permissionKey(ec2Cli, instanceId)]
+	return ok && !allowed
+}
+
+// requireResize only serves h to sessions which aren't known to lack
+// permission to resize the instance in the request's path, see canResize.
+func (app *App) requireResize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.resizeDenied(r) {
+			app.render403(w, r, errResizeDenied)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
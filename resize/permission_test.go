@@ -0,0 +1,128 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestResizePermission(t *testing.T) {
+	var mu sync.Mutex
+	checks := 0
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("Action") != "ModifyInstanceAttribute" {
+			fmt.Fprintf(w, taggedInstanceResponse, "running")
+			return
+		}
+		mu.Lock()
+		checks++
+		mu.Unlock()
+		if r.FormValue("DryRun") != "true" || r.FormValue("InstanceType.Value") != "m1.small" {
+			t.Errorf("expected a dry run keeping the instance's type, got %v", r.URL.Query())
+		}
+		code, status := "UnauthorizedOperation", http.StatusForbidden
+		// IAM policies may allow resizing some instances only
+		if r.FormValue("AWSAccessKeyId") == "admin" || strings.Contains(r.Header.Get("Authorization"), "Credential=admin/") ||
+			r.FormValue("InstanceId") == "i-other" {
+			code, status = "DryRunOperation", http.StatusPreconditionFailed
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>dry run</Message></Error></Errors><RequestID>1</RequestID></Response>`, code)
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	login := func(key string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: key, SecretKey: "bar"}, region)); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()
+	}
+	// get requests path, replacing cookies with those the response sets
+	get := func(cookies *[]*http.Cookie, path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range *cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		for _, c := range w.Result().Cookies() {
+			replaced := false
+			for i, old := range *cookies {
+				if old.Name == c.Name {
+					(*cookies)[i], replaced = c, true
+				}
+			}
+			if !replaced {
+				*cookies = append(*cookies, c)
+			}
+		}
+		return w
+	}
+
+	readOnly := login("reader")
+	for i := 0; i < 2; i++ {
+		w := get(&readOnly, "/instance/i-confirm")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, `id="resize-denied"`) || !strings.Contains(body, `id="change-type" disabled`) {
+			t.Errorf("expected the resize controls to be disabled")
+		}
+	}
+	if checks != 1 {
+		t.Errorf("expected the permission to be checked once per session, got %d checks", checks)
+	}
+	if w := get(&readOnly, "/instance/i-confirm/resize/confirm?new-type=m1.large"); w.Code != http.StatusForbidden {
+		t.Errorf("expected resizing to be forbidden, got %d", w.Code)
+	}
+
+	// the permission is cached per instance
+	newReq := func(path string) *http.Request {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range readOnly {
+			r.AddCookie(c)
+		}
+		return r
+	}
+	cookieReq := newReq("/instance/i-other")
+	ec2Cli, _ := app.creds(cookieReq)
+	other := ec2.Instance{InstanceId: "i-other", InstanceType: "m1.small"}
+	if !app.canResize(httptest.NewRecorder(), cookieReq, ec2Cli, other) {
+		t.Errorf("expected another instance's permission to be checked separately")
+	}
+	if checks != 2 {
+		t.Errorf("expected a check for the other instance, got %d checks", checks)
+	}
+	// requests authenticated with a token don't use the session's permissions
+	tokenReq := newReq("/api/instance/i-confirm/targets")
+	tokenReq.Header.Set("Authorization", "Bearer token")
+	if _, permissions := app.cachedPermissions(tokenReq); permissions != nil {
+		t.Errorf("expected no cached permissions for a token, got %v", permissions)
+	}
+	if _, permissions := app.cachedPermissions(newReq("/")); len(permissions) != 1 {
+		t.Errorf("expected the cookie session's permission to be cached, got %v", permissions)
+	}
+
+	admin := login("admin")
+	if body := get(&admin, "/instance/i-confirm").Body.String(); strings.Contains(body, `id="resize-denied"`) || strings.Contains(body, `id="change-type" disabled`) {
+		t.Errorf("expected the resize controls to be enabled")
+	}
+	if w := get(&admin, "/instance/i-confirm/resize/confirm?new-type=m1.large"); w.Code != http.StatusOK {
+		t.Errorf("expected resizing to be allowed, got %d", w.Code)
+	}
+}
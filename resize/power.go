@@ -0,0 +1,209 @@
+package resize
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// powerAction stops or starts an instance without changing its type.
+type powerAction struct {
+	name     string // "stop" or "start"
+	from     string // state the instance must be in
+	to       string // state the instance ends up in
+	progress string // describes the action while it's taken
+}
+
+var (
+	stopAction  = powerAction{"stop", "running", "stopped", "Stopping"}
+	startAction = powerAction{"start", "stopped", "running", "Starting"}
+)
+
+// powerStateError is returned by powerAction.do when the instance isn't in
+// the state the action is taken from.
+type powerStateError struct {
+	a     powerAction
+	state string
+}
+
+func (e powerStateError) Error() string {
+	return fmt.Sprintf("The server must be '%s' to %s it, but it is '%s'.", e.a.from, e.a.name, e.state)
+}
+
+// do takes the action on inst, or reports the step it would take if dryRun is
+// set. It returns without waiting for the instance to reach the new state.
+func (a powerAction) do(ctx context.Context, ec2Cli *ec2.EC2, inst ec2.Instance, dryRun bool, retry RetryPolicy) ([]string, error) {
+	step := a.name + " instance " + inst.InstanceId
+	if inst.State.Name != a.from {
+		return nil, powerStateError{a, inst.State.Name}
+	}
+	if dryRun {
+		var steps eventLog
+		err := writeDryRun(&steps, []string{step})
+		return steps, err
+	}
+	err := retry.do(ctx, func() error {
+		var err error
+		if a.name == "stop" {
+			_, err = ec2Cli.StopInstances(inst.InstanceId)
+		} else {
+			_, err = ec2Cli.StartInstances(inst.InstanceId)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error %s instance: %v", strings.ToLower(a.progress), err)
+	}
+	return []string{step}, nil
+}
+
+// powerInstance takes action on inst, logging the outcome as an event named
+// after the action. Like resizeInstance, it returns ErrInstanceBusy if
+// another operation holds the instance's lock.
+func (app *App) powerInstance(ctx context.Context, ec2Cli *ec2.EC2, a powerAction, inst ec2.Instance, dryRun bool) ([]string, error) {
+	if !dryRun {
+		unlock, err := app.lockInstance(ctx, ec2Cli, inst)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	start := time.Now()
+	app.observeState(ec2Cli, inst, start)
+	steps, err := a.do(ctx, ec2Cli, inst, dryRun, app.retryPolicy())
+	fields := Fields{
+		"region":      ec2Cli.Region.Name,
+		"instance_id": inst.InstanceId,
+		"dry_run":     dryRun,
+		"duration_ms": durationMS(start),
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	app.LogEventContext(ctx, a.name, fields)
+	return steps, err
+}
+
+// handlePower takes action on the instance of a POST request and renders its
+// state as it changes.
+func (app *App) handlePower(w http.ResponseWriter, r *http.Request, a powerAction) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+	if a.name == "stop" {
+		if err := app.checkConfirmation(w, r, "stop", instance, "", r.PostFormValue("nonce"), errStopConfirmation); err != nil {
+			app.render400(w, r, err)
+			return
+		}
+	}
+
+	dryRun := app.DryRun || r.URL.Query().Get("dryrun") != ""
+	steps, err := app.powerInstance(r.Context(), ec2Cli, a, instance, dryRun)
+	if err != nil {
+		if _, ok := err.(powerStateError); ok {
+			app.render400(w, r, err)
+		} else if err == ErrInstanceBusy {
+			app.render409(w, r, err)
+		} else {
+			app.render500(w, r, err)
+		}
+		return
+	}
+	data := map[string]interface{}{
+		"Instance":   instance,
+		"Action":     a.progress,
+		"DryRun":     dryRun,
+		"Steps":      steps,
+		"FinalState": a.to,
+	}
+	app.render(w, r, "power.html", data)
+}
+
+// Path: /instance/{instance}/stop
+//
+// handleStop stops a running instance without changing its type. A GET asks
+// the user to confirm stopping the instance, and confirming posts the nonce
+// of the confirmation in the "nonce" form field. If the "dryrun" query
+// parameter is set, or the app is in dry run mode, the instance isn't
+// stopped.
+func (app *App) handleStop(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		app.handleStopConfirm(w, r)
+	case "POST":
+		app.handlePower(w, r, stopAction)
+	default:
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+	}
+}
+
+// handleStopConfirm asks the user to confirm stopping an instance.
+func (app *App) handleStopConfirm(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+	if instance.State.Name != stopAction.from {
+		app.render400(w, r, powerStateError{stopAction, instance.State.Name})
+		return
+	}
+	nonce, err := app.confirmAction(w, r, "stop", instance, "")
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("could not save confirmation: %v", err))
+		return
+	}
+	action := app.pathTo("/instance/" + url.PathEscape(instanceId) + "/stop")
+	if r.URL.Query().Get("dryrun") != "" {
+		action += "?dryrun=1"
+	}
+	data := map[string]interface{}{
+		"Instance": instance,
+		"Name":     nameTag(instance),
+		"Nonce":    nonce,
+		"Action":   action,
+		"DryRun":   r.URL.Query().Get("dryrun") != "",
+	}
+	app.render(w, r, "confirm-stop.html", data)
+}
+
+// Path: /instance/{instance}/start
+//
+// handleStart starts a stopped instance without changing its type. If the
+// "dryrun" query parameter is set, or the app is in dry run mode, the
+// instance isn't started.
+func (app *App) handleStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	app.handlePower(w, r, startAction)
+}
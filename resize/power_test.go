@@ -0,0 +1,180 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestStopStart(t *testing.T) {
+	var mu sync.Mutex
+	state := "running"
+	var calls []string
+	failCalls := false
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch action := r.FormValue("Action"); action {
+		case "StopInstances", "StartInstances":
+			calls = append(calls, action)
+			if failCalls {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>You are not authorized to perform this operation.</Message></Error></Errors><RequestID>1</RequestID></Response>`)
+				return
+			}
+			fmt.Fprintf(w, `<%sResponse><requestId>1</requestId><instancesSet/></%sResponse>`, action, action)
+		default:
+			fmt.Fprintf(w, taggedInstanceResponse, state)
+		}
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+	setState := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		state = s
+		calls = nil
+	}
+	called := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(calls, ",")
+	}
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	cli := &http.Client{Jar: jar}
+
+	get := func(path string) (int, string) {
+		resp, err := cli.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+	// confirm returns the nonce and CSRF token of a confirmation page
+	confirm := func(path string) (nonce, token string) {
+		code, body := get(path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, code)
+		}
+		m := nonceField.FindStringSubmatch(body)
+		c := csrfMeta.FindStringSubmatch(body)
+		if m == nil || c == nil {
+			t.Fatalf("%s: no nonce or CSRF token rendered", path)
+		}
+		return m[1], c[1]
+	}
+	post := func(path string, form url.Values) (int, string) {
+		resp, err := cli.PostForm(s.URL+path, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	_, body := get("/instance/i-confirm")
+	if !strings.Contains(body, `href="/instance/i-confirm/stop"`) {
+		t.Error("expected a running instance to offer stopping")
+	}
+	nonce, token := confirm("/instance/i-confirm/stop")
+	form := url.Values{"nonce": {nonce}, DefaultCSRFFieldName: {token}}
+	code, body := post("/instance/i-confirm/stop", form)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200 stopping, got %d: %s", code, body)
+	}
+	if !strings.Contains(body, "Stopping i-confirm") || !strings.Contains(body, `data-final-state="stopped"`) {
+		t.Error("expected the instance to be shown stopping")
+	}
+	if got := called(); got != "StopInstances" {
+		t.Errorf("expected the instance to be stopped, got calls %q", got)
+	}
+
+	setState("running")
+	if code, _ := post("/instance/i-confirm/stop", form); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 reusing a confirmation, got %d", code)
+	}
+	resizeNonce, token := confirm("/instance/i-confirm/resize/confirm?new-type=m1.large")
+	form = url.Values{"nonce": {resizeNonce}, DefaultCSRFFieldName: {token}}
+	if code, _ := post("/instance/i-confirm/stop", form); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 stopping with a resize confirmation, got %d", code)
+	}
+	if got := called(); got != "" {
+		t.Errorf("expected unconfirmed stops to make no calls, got %q", got)
+	}
+
+	setState("stopped")
+	if code, _ := get("/instance/i-confirm/stop"); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 confirming a stop of a stopped instance, got %d", code)
+	}
+	_, body = get("/instance/i-confirm")
+	if !strings.Contains(body, `action="/instance/i-confirm/start"`) {
+		t.Error("expected a stopped instance to offer starting")
+	}
+	form = url.Values{DefaultCSRFFieldName: {token}}
+	code, body = post("/instance/i-confirm/start?dryrun=1", form)
+	if code != http.StatusOK || !strings.Contains(body, "dry run: would start instance i-confirm") {
+		t.Errorf("expected a dry run start, got %d", code)
+	}
+	if got := called(); got != "" {
+		t.Errorf("expected a dry run to make no calls, got %q", got)
+	}
+	code, body = post("/instance/i-confirm/start", form)
+	if code != http.StatusOK || !strings.Contains(body, `data-final-state="running"`) {
+		t.Errorf("expected the instance to be shown starting, got %d", code)
+	}
+	if got := called(); got != "StartInstances" {
+		t.Errorf("expected the instance to be started, got calls %q", got)
+	}
+
+	setState("running")
+	if code, _ := post("/instance/i-confirm/start", form); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 starting a running instance, got %d", code)
+	}
+
+	// failures calling EC2 aren't the client's fault
+	setState("stopped")
+	mu.Lock()
+	failCalls = true
+	mu.Unlock()
+	if code, _ := post("/instance/i-confirm/start", form); code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when EC2 fails to start the instance, got %d", code)
+	}
+}
@@ -0,0 +1,242 @@
+package resize
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriceListSource is an InstanceTypeSource which reads the instance types of
+// a region from its AWS Price List offer file. Unlike the instance types
+// matrix the offer file is machine readable, and it prices each type too.
+//
+// Offer files are hundreds of megabytes, so each is parsed once and kept for
+// the source's TTL. After that it's requested again with the ETag it was
+// served with, and only downloaded if it has changed.
+type PriceListSource struct {
+	// Region is the region whose instance types are offered.
+	// If empty, us-east-1 is used.
+	Region string
+	// URL is the location of the offer files, such as an internal mirror,
+	// with "%s" in place of the region.
+	// If empty, the AWS Price List API is used.
+	URL string
+	// TTL is how long a downloaded offer file is used before checking it
+	// for changes.
+	// If zero, DefaultTypeCacheTTL is used.
+	TTL time.Duration
+
+	mu     sync.Mutex
+	offers map[string]*offerEntry
+}
+
+// offerEntry holds the parsed contents of an offer file.
+type offerEntry struct {
+	types     []InstanceType
+	prices    map[string]Price
+	etag      string
+	fetchedAt time.Time
+}
+
+// NewPriceListSource returns a PriceListSource for region. If rawURL is
+// non-empty it must be an absolute http or https URL holding "%s" in place of
+// the region.
+func NewPriceListSource(region, rawURL string) (*PriceListSource, error) {
+	if rawURL != "" {
+		if !strings.Contains(rawURL, "%s") {
+			return nil, fmt.Errorf("invalid price list URL '%s': expected %%s in place of the region", rawURL)
+		}
+		u, err := url.Parse(fmt.Sprintf(rawURL, region))
+		if err != nil {
+			return nil, fmt.Errorf("invalid price list URL: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid price list URL '%s': expected an http or https URL", rawURL)
+		}
+	}
+	return &PriceListSource{Region: region, URL: rawURL}, nil
+}
+
+func (s *PriceListSource) region() string {
+	if s.Region == "" {
+		return defaultRegion.Name
+	}
+	return s.Region
+}
+
+func (s *PriceListSource) url(region string) string {
+	if s.URL == "" {
+		return fmt.Sprintf(priceListURL, region)
+	}
+	return fmt.Sprintf(s.URL, region)
+}
+
+func (s *PriceListSource) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultTypeCacheTTL
+	}
+	return s.TTL
+}
+
+// Fetch reads the instance types of the source's region from its offer file
+// with the provided client.
+func (s *PriceListSource) Fetch(client *http.Client) ([]InstanceType, error) {
+	return s.FetchContext(context.Background(), client)
+}
+
+// FetchContext behaves like Fetch, with the request bound by ctx.
+func (s *PriceListSource) FetchContext(ctx context.Context, client *http.Client) ([]InstanceType, error) {
+	e, err := s.offer(ctx, client, s.region())
+	if err != nil {
+		return nil, err
+	}
+	return append([]InstanceType(nil), e.types...), nil
+}
+
+// Prices returns the on-demand prices of instance types in region from its
+// offer file.
+func (s *PriceListSource) Prices(client *http.Client, region string) (map[string]Price, error) {
+	e, err := s.offer(context.Background(), client, region)
+	if err != nil {
+		return nil, err
+	}
+	return e.prices, nil
+}
+
+// offer returns the parsed offer file of region, downloading it if it isn't
+// cached or has changed since it was last checked.
+func (s *PriceListSource) offer(ctx context.Context, client *http.Client, region string) (*offerEntry, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// the source isn't locked during downloads, so the offer files of
+	// several regions can be fetched at once
+	s.mu.Lock()
+	cached, ok := s.offers[region]
+	fresh := ok && time.Since(cached.fetchedAt) < s.ttl()
+	s.mu.Unlock()
+	if fresh {
+		return cached, nil
+	}
+
+	req, err := http.NewRequest("GET", s.url(region), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if ok && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if ok && resp.StatusCode == http.StatusNotModified {
+		s.mu.Lock()
+		cached.fetchedAt = time.Now()
+		s.mu.Unlock()
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response from AWS price list: %s", resp.Status)
+	}
+	o, err := decodeOffer(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	types, prices := offerTypes(o)
+	if len(types) == 0 {
+		return nil, fmt.Errorf("price list for %s holds no instance types", region)
+	}
+	e := &offerEntry{
+		types:     types,
+		prices:    prices,
+		etag:      resp.Header.Get("ETag"),
+		fetchedAt: time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offers == nil {
+		s.offers = make(map[string]*offerEntry)
+	}
+	s.offers[region] = e
+	return e, nil
+}
+
+// offerTypes returns the instance types of an offer file, sorted by name, and
+// their on-demand prices.
+func offerTypes(o *offer) ([]InstanceType, map[string]Price) {
+	byName := make(map[string]InstanceType)
+	prices := make(map[string]Price)
+	o.eachInstance(func(attrs map[string]string, price Price, priced bool) {
+		t := productType(attrs)
+		if t.Name == "" {
+			return
+		}
+		if priced {
+			t.Price, t.PriceUnit = price.Amount, price.Unit
+			prices[t.Name] = price
+		} else if prev, ok := byName[t.Name]; ok {
+			t.Price, t.PriceUnit = prev.Price, prev.PriceUnit
+		}
+		byName[t.Name] = t
+	})
+	types := make([]InstanceType, 0, len(byName))
+	for _, t := range byName {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types, prices
+}
+
+// productType reads an instance type from the attributes of an offer file's
+// product, such as "vcpu": "2", "memory": "8 GiB", "clockSpeed": "Up to 3.1
+// GHz" and "processorFeatures": "Intel AVX; Intel AVX2; Intel Turbo".
+// Attributes which are missing or can't be parsed are left at zero.
+func productType(attrs map[string]string) InstanceType {
+	number := func(key string) float64 {
+		s := strings.Replace(attrs[key], ",", "", -1)
+		s = strings.TrimPrefix(strings.TrimSpace(s), "Up to ")
+		n, _ := strconv.ParseFloat(leadingNumber(s), 64)
+		return n
+	}
+	t := InstanceType{
+		Name:               attrs["instanceType"],
+		CPUs:               int(number("vcpu")),
+		Memory:             number("memory"),
+		Storage:            attrs["storage"],
+		NetworkSpec:        attrs["networkPerformance"],
+		Processor:          attrs["physicalProcessor"],
+		ClockSpeed:         number("clockSpeed"),
+		EnhancedNetworking: attrs["enhancedNetworkingSupported"] == "Yes",
+		Accelerators:       int(number("gpu")),
+		EBSBandwidthMbps:   parseMbps(attrs["dedicatedEbsThroughput"]),
+	}
+	if t.Accelerators == 0 {
+		t.Accelerators = int(number("fpga"))
+	}
+	for _, feature := range strings.Split(attrs["processorFeatures"], ";") {
+		switch strings.TrimSpace(feature) {
+		case "Intel AVX":
+			t.IntelAVX = true
+		case "Intel AVX2":
+			t.IntelAVX2 = true
+		case "Intel Turbo":
+			t.IntelTurbo = true
+		}
+	}
+	t.EBSOPT = t.EBSBandwidthMbps > 0
+	t.EBSOnly, t.InstanceStorageGB = parseStorage(t.Storage)
+	t.Hypervisor = typeHypervisor(t.Name)
+	t.Architecture = ArchitectureOf(t)
+	t.Burstable = IsBurstable(t)
+	t.BaselinePercent = BaselineOf(t)
+	return t
+}
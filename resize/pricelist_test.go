@@ -0,0 +1,125 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPriceListSource(t *testing.T) {
+	var downloads, revalidations int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eu-west-1/index.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		http.ServeFile(w, r, filepath.Join("testdata", "offer.json"))
+	}))
+	defer s.Close()
+
+	src, err := NewPriceListSource("eu-west-1", s.URL+"/%s/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ PriceSource = src
+	types, err := src.Fetch(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []InstanceType{
+		{
+			Name:               "m5.large",
+			CPUs:               2,
+			Memory:             8,
+			Storage:            "EBS only",
+			EBSOnly:            true,
+			NetworkSpec:        "Up to 10 Gigabit",
+			Processor:          "Intel Xeon Platinum 8175",
+			ClockSpeed:         3.1,
+			IntelAVX:           true,
+			IntelAVX2:          true,
+			IntelTurbo:         true,
+			EBSOPT:             true,
+			EnhancedNetworking: true,
+			EBSBandwidthMbps:   2120,
+			Hypervisor:         "nitro",
+			Architecture:       "x86_64",
+			Price:              0.096,
+			PriceUnit:          "Hrs",
+		},
+		{
+			Name:            "t2.micro",
+			CPUs:            1,
+			Memory:          1,
+			Storage:         "EBS only",
+			EBSOnly:         true,
+			NetworkSpec:     "Low to Moderate",
+			Processor:       "Intel Xeon Family",
+			ClockSpeed:      3.3,
+			IntelAVX:        true,
+			IntelTurbo:      true,
+			Hypervisor:      "xen",
+			Architecture:    "x86_64",
+			Burstable:       true,
+			BaselinePercent: 10,
+			Price:           0.0116,
+			PriceUnit:       "Hrs",
+		},
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected types\n%+v\ngot\n%+v", expected, types)
+	}
+
+	prices, err := src.Prices(nil, "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices["m5.large"] != (Price{0.096, "Hrs"}) {
+		t.Errorf("expected m5.large to be priced, got %v", prices["m5.large"])
+	}
+	if downloads != 1 || revalidations != 0 {
+		t.Errorf("expected the offer to be downloaded once, got %d downloads and %d revalidations", downloads, revalidations)
+	}
+
+	// once the offer expires it's revalidated rather than downloaded again
+	src.TTL = time.Nanosecond
+	if _, err := src.Fetch(nil); err != nil {
+		t.Fatal(err)
+	}
+	if downloads != 1 || revalidations != 1 {
+		t.Errorf("expected the offer to be revalidated, got %d downloads and %d revalidations", downloads, revalidations)
+	}
+
+	if _, err := src.Prices(nil, "us-west-2"); err == nil {
+		t.Errorf("expected an error fetching a missing offer file")
+	}
+}
+
+func TestNewPriceListSource(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"", true},
+		{"https://mirror.example.com/offers/%s/index.json", true},
+		{"https://mirror.example.com/offers/index.json", false},
+		{"ftp://mirror.example.com/%s.json", false},
+		{"/offers/%s.json", false},
+	}
+	for _, test := range tests {
+		_, err := NewPriceListSource("us-east-1", test.url)
+		if (err == nil) != test.ok {
+			t.Errorf("NewPriceListSource(%q): expected ok %v, got error %v", test.url, test.ok, err)
+		}
+	}
+}
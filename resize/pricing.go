@@ -0,0 +1,189 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// priceListURL is the location of the AWS Price List offer file for EC2 in a
+// given region.
+const priceListURL = "https://pricing.us-east-1.amazonaws.com/offers/v1.0/aws/AmazonEC2/current/%s/index.json"
+
+// Price is the on-demand price of an instance type.
+type Price struct {
+	Amount float64 // USD
+	Unit   string  // e.g. "Hrs"
+}
+
+// A PriceSource is an InstanceTypeSource which can also provide on-demand
+// prices for the instance types of a region.
+type PriceSource interface {
+	InstanceTypeSource
+
+	// Prices retrieves the on-demand prices of instance types in
+	// region, keyed by instance type name.
+	Prices(client *http.Client, region string) (map[string]Price, error)
+}
+
+// Prices calls OnDemandPrices with the provided client.
+func (WebScraperSource) Prices(client *http.Client, region string) (map[string]Price, error) {
+	return OnDemandPrices(client, region)
+}
+
+// OnDemandPrices retrieves the on-demand prices of Linux instances with shared
+// tenancy in a region from the AWS Price List API.
+func OnDemandPrices(client *http.Client, region string) (map[string]Price, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(fmt.Sprintf(priceListURL, region))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response from AWS price list: %s", resp.Status)
+	}
+	return parseOffer(resp.Body)
+}
+
+// offer holds the parts of an AWS Price List offer file needed to price
+// instance types.
+type offer struct {
+	Products map[string]struct {
+		ProductFamily string            `json:"productFamily"`
+		Attributes    map[string]string `json:"attributes"`
+	} `json:"products"`
+	Terms struct {
+		OnDemand map[string]map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// parseOffer reads an EC2 offer file, returning the on-demand price of each
+// instance type running Linux with shared tenancy.
+func parseOffer(r io.Reader) (map[string]Price, error) {
+	o, err := decodeOffer(r)
+	if err != nil {
+		return nil, err
+	}
+	prices := make(map[string]Price)
+	o.eachInstance(func(attrs map[string]string, price Price, priced bool) {
+		if priced {
+			prices[attrs["instanceType"]] = price
+		}
+	})
+	return prices, nil
+}
+
+func decodeOffer(r io.Reader) (*offer, error) {
+	var o offer
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, fmt.Errorf("decoding price list: %v", err)
+	}
+	return &o, nil
+}
+
+// eachInstance calls fn with the attributes of each instance type product
+// running Linux with shared tenancy, and its on-demand price if it has one.
+func (o *offer) eachInstance(fn func(attrs map[string]string, price Price, priced bool)) {
+	for sku, product := range o.Products {
+		attrs := product.Attributes
+		if product.ProductFamily != "Compute Instance" ||
+			attrs["operatingSystem"] != "Linux" ||
+			attrs["tenancy"] != "Shared" {
+			continue
+		}
+		// newer offer files list variants with pre-installed software and
+		// capacity reservations, which aren't the plain on-demand price
+		if sw, ok := attrs["preInstalledSw"]; ok && sw != "NA" {
+			continue
+		}
+		if status, ok := attrs["capacitystatus"]; ok && status != "Used" {
+			continue
+		}
+		var price Price
+		priced := false
+		for _, term := range o.Terms.OnDemand[sku] {
+			for _, dim := range term.PriceDimensions {
+				amount, err := strconv.ParseFloat(dim.PricePerUnit["USD"], 64)
+				if err != nil || amount == 0 {
+					continue
+				}
+				price, priced = Price{Amount: amount, Unit: dim.Unit}, true
+			}
+		}
+		fn(attrs, price, priced)
+	}
+}
+
+// MergePrices returns a copy of types with the Price and PriceUnit of each
+// instance type found in prices set. Types without a price are left at zero.
+func MergePrices(types []InstanceType, prices map[string]Price) []InstanceType {
+	merged := make([]InstanceType, len(types))
+	for i, t := range types {
+		if p, ok := prices[t.Name]; ok {
+			t.Price = p.Amount
+			t.PriceUnit = p.Unit
+		}
+		merged[i] = t
+	}
+	return merged
+}
+
+// priceCache holds the prices of each region for a fixed duration, since
+// offer files are large and change rarely.
+type priceCache struct {
+	mu      sync.Mutex
+	entries map[string]priceEntry
+}
+
+type priceEntry struct {
+	prices    map[string]Price
+	fetchedAt time.Time
+}
+
+// get returns the cached prices for key, usually a region, calling fetch if
+// they are missing or older than ttl. The cache isn't locked during fetch,
+// so the prices of several regions can be fetched at once.
+func (c *priceCache) get(key string, ttl time.Duration, fetch func() (map[string]Price, error)) (map[string]Price, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < ttl {
+		return e.prices, nil
+	}
+	prices, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]priceEntry)
+	}
+	c.entries[key] = priceEntry{prices: prices, fetchedAt: time.Now()}
+	return prices, nil
+}
+
+// prices returns the on-demand prices for region if the app's Source provides
+// them. If it doesn't, ok is false.
+func (app *App) prices(region string) (prices map[string]Price, ok bool, err error) {
+	src, ok := app.Source.(PriceSource)
+	if !ok {
+		return nil, false, nil
+	}
+	prices, err = app.priceCache.get(region, DefaultTypeCacheTTL, func() (map[string]Price, error) {
+		return src.Prices(app.scrapeClient(), region)
+	})
+	return prices, err == nil, err
+}
@@ -0,0 +1,45 @@
+package resize
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOffer(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "offer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	prices, err := parseOffer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Price{
+		"m5.large": {Amount: 0.096, Unit: "Hrs"},
+		"t2.micro": {Amount: 0.0116, Unit: "Hrs"},
+	}
+	if len(prices) != len(expected) {
+		t.Errorf("expected %d prices, got %d", len(expected), len(prices))
+	}
+	for name, p := range expected {
+		if prices[name] != p {
+			t.Errorf("expected price %v for %s, got %v", p, name, prices[name])
+		}
+	}
+}
+
+func TestMergePrices(t *testing.T) {
+	types := []InstanceType{{Name: "m5.large"}, {Name: "x1.32xlarge"}}
+	merged := MergePrices(types, map[string]Price{"m5.large": {0.096, "Hrs"}})
+	if merged[0].Price != 0.096 || merged[0].PriceUnit != "Hrs" {
+		t.Errorf("expected m5.large to be priced, got %v", merged[0])
+	}
+	if merged[1].Price != 0 || merged[1].PriceUnit != "" {
+		t.Errorf("expected x1.32xlarge to have no price, got %v", merged[1])
+	}
+	if types[0].Price != 0 {
+		t.Errorf("expected MergePrices not to modify its input")
+	}
+}
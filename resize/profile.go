@@ -0,0 +1,218 @@
+package resize
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func init() {
+	gob.Register(map[string]profile{})
+}
+
+// maxProfiles bounds the number of profiles of a session. Sessions stored in
+// a cookie may fit fewer, as temporary credentials have long session tokens.
+const maxProfiles = 5
+
+// maxProfileName bounds the length of profile names.
+const maxProfileName = 64
+
+// profile is a named set of credentials saved in a session, so users can
+// switch between AWS accounts without logging in again. It holds the
+// session's login state at the time it was saved. Only the name of the
+// region is kept, to keep sessions small.
+type profile struct {
+	Auth    aws.Auth
+	Region  string
+	User    string
+	Version int64
+	Expires time.Time
+	Server  bool
+	Saved   time.Time
+}
+
+// profileInfo describes a saved profile to the profiles template.
+type profileInfo struct {
+	Name   string
+	Region string
+	Active bool
+	Saved  time.Time
+}
+
+// sessionProfiles returns the profiles saved in a session. The map is a
+// copy, so changes must be stored back.
+func sessionProfiles(session *sessions.Session) map[string]profile {
+	profiles := make(map[string]profile)
+	saved, _ := session.Values["profiles"].(map[string]profile)
+	for name, p := range saved {
+		profiles[name] = p
+	}
+	return profiles
+}
+
+// activeProfile returns the name of the profile whose credentials the session
+// holds, if any.
+func activeProfile(session *sessions.Session) string {
+	name, _ := session.Values["profile"].(string)
+	return name
+}
+
+// saveProfile saves the session's current credentials under name and makes it
+// the active profile.
+func (app *App) saveProfile(w http.ResponseWriter, r *http.Request, name string) error {
+	if name == "" {
+		return errors.New("no profile name provided")
+	}
+	if len(name) > maxProfileName {
+		return fmt.Errorf("profile names can be at most %d characters", maxProfileName)
+	}
+	session, _ := app.store.Get(r, "yhat-resize")
+	ec2Cli, ok := session.Values["ec2"].(*ec2.EC2)
+	if !ok {
+		return errors.New("no credentials to save")
+	}
+	profiles := sessionProfiles(session)
+	if _, exists := profiles[name]; !exists && len(profiles) >= maxProfiles {
+		return fmt.Errorf("at most %d profiles can be saved", maxProfiles)
+	}
+	p := profile{Auth: ec2Cli.Auth, Region: ec2Cli.Region.Name, Saved: time.Now()}
+	p.User, _ = session.Values["user"].(string)
+	p.Version, _ = session.Values["version"].(int64)
+	p.Expires, _ = session.Values["expires"].(time.Time)
+	p.Server, _ = session.Values["server"].(bool)
+	profiles[name] = p
+	session.Values["profiles"] = profiles
+	session.Values["profile"] = name
+	return app.saveSession(w, r, session, nil)
+}
+
+// selectProfile replaces the session's credentials with those of the named
+// profile. The profile's credentials are still subject to expiry and
+// revocation, which creds checks. If the profile's region is unknown, the
+// session's current region is kept.
+func (app *App) selectProfile(w http.ResponseWriter, r *http.Request, name string) error {
+	session, _ := app.store.Get(r, "yhat-resize")
+	p, ok := sessionProfiles(session)[name]
+	if !ok {
+		return fmt.Errorf("no profile named '%s'", name)
+	}
+	region, ok := aws.Regions[p.Region]
+	if !ok {
+		current, ok := session.Values["ec2"].(*ec2.EC2)
+		if !ok {
+			return fmt.Errorf("unknown region '%s'", p.Region)
+		}
+		region = current.Region
+	}
+	session.Values["ec2"] = &ec2.EC2{Auth: p.Auth, Region: region}
+	session.Values["user"] = p.User
+	session.Values["version"] = p.Version
+	if p.Expires.IsZero() {
+		delete(session.Values, "expires")
+	} else {
+		session.Values["expires"] = p.Expires
+	}
+	if p.Server {
+		session.Values["server"] = true
+	} else {
+		delete(session.Values, "server")
+	}
+	session.Values["profile"] = name
+	// the profile's credentials may not have the same permissions
+	delete(session.Values, "canResize")
+	return app.saveSession(w, r, session, nil)
+}
+
+// removeProfile deletes the named profile. Removing the active profile keeps
+// its credentials in use until another profile is selected or the user logs
+// out.
+func (app *App) removeProfile(w http.ResponseWriter, r *http.Request, name string) error {
+	session, _ := app.store.Get(r, "yhat-resize")
+	profiles := sessionProfiles(session)
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("no profile named '%s'", name)
+	}
+	delete(profiles, name)
+	session.Values["profiles"] = profiles
+	if activeProfile(session) == name {
+		delete(session.Values, "profile")
+	}
+	return app.saveSession(w, r, session, nil)
+}
+
+// Path: /profile
+//
+// handleProfile lists the credential profiles saved in the session. POSTs
+// with the "action" form field set to "save", "select" or "remove" save the
+// current credentials under the "name" field, switch to the named profile or
+// delete it. To add a profile for another account, users log in again from
+// /login?add=1 and save the new credentials.
+func (app *App) handleProfile(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.creds(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		name := r.PostFormValue("name")
+		var err error
+		switch action := r.PostFormValue("action"); action {
+		case "save":
+			err = app.saveProfile(w, r, name)
+		case "select":
+			err = app.selectProfile(w, r, name)
+		case "remove":
+			err = app.removeProfile(w, r, name)
+		default:
+			err = fmt.Errorf("unknown profile action '%s'", action)
+		}
+		if err != nil {
+			app.render400(w, r, err)
+			return
+		}
+		app.LogEventContext(r.Context(), "profile", Fields{"action": r.PostFormValue("action")})
+		to := app.pathTo("/profile")
+		switch r.PostFormValue("action") {
+		case "save":
+			app.addFlash(w, r, flashSuccess, fmt.Sprintf("Saved profile %s.", name))
+		case "select":
+			app.addFlash(w, r, flashSuccess, fmt.Sprintf("Switched to profile %s.", name))
+			to = app.pathTo("/")
+		case "remove":
+			app.addFlash(w, r, flashSuccess, fmt.Sprintf("Removed profile %s.", name))
+		}
+		http.Redirect(w, r, to, http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	session, _ := app.store.Get(r, "yhat-resize")
+	active := activeProfile(session)
+	profiles := []profileInfo{}
+	for name, p := range sessionProfiles(session) {
+		profiles = append(profiles, profileInfo{
+			Name:   name,
+			Region: p.Region,
+			Active: name == active,
+			Saved:  p.Saved,
+		})
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	_, cookieStore := app.store.(*sessions.CookieStore)
+	data := map[string]interface{}{
+		"Profiles":    profiles,
+		"CanSave":     len(profiles) < maxProfiles,
+		"CookieStore": cookieStore,
+	}
+	app.render(w, r, "profiles.html", data)
+}
@@ -0,0 +1,159 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	// each account has a single instance named after its access key
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		// only prod may resize instances
+		if r.FormValue("Action") == "ModifyInstanceAttribute" {
+			code, status := "UnauthorizedOperation", http.StatusForbidden
+			if strings.Contains(r.Header.Get("Authorization"), "Credential=prod/") {
+				code, status = "DryRunOperation", http.StatusPreconditionFailed
+			}
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>dry run</Message></Error></Errors><RequestID>1</RequestID></Response>`, code)
+			return
+		}
+		for _, key := range []string{"prod", "dev"} {
+			if r.FormValue("AWSAccessKeyId") == key ||
+				strings.Contains(r.Header.Get("Authorization"), "Credential="+key+"/") {
+				fmt.Fprintf(w, describeInstancesResponse, "i-"+key)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, ec2ErrorResponse)
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	get := func(path string) (*http.Response, string) {
+		resp, err := cli.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	post := func(path, page string, form url.Values) int {
+		_, body := get(page)
+		m := csrfMeta.FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no CSRF token rendered in %s", page)
+		}
+		form.Set(DefaultCSRFFieldName, m[1])
+		resp, err := cli.PostForm(s.URL+path, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	login := func(key string) {
+		form := url.Values{"accessKey": {key}, "secretKey": {"s3cr3t"}}
+		if code := post("/login", "/login?add=1", form); code != http.StatusOK {
+			t.Fatalf("login as %s: expected 200, got %d", key, code)
+		}
+	}
+	profile := func(action, name string, want int) {
+		form := url.Values{"action": {action}, "name": {name}}
+		if code := post("/profile", "/profile", form); code != want {
+			t.Fatalf("%s profile '%s': expected %d, got %d", action, name, want, code)
+		}
+	}
+	index := func() string {
+		_, body := get("/")
+		return body
+	}
+
+	login("prod")
+	profile("save", "production", http.StatusSeeOther)
+	login("dev")
+	if body := index(); !strings.Contains(body, "i-dev") {
+		t.Fatal("expected the new login's instances to be listed")
+	}
+	profile("save", "development", http.StatusSeeOther)
+
+	_, body := get("/profile")
+	for _, name := range []string{"production", "development"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected profile '%s' to be listed", name)
+		}
+	}
+	if !strings.Contains(body, "stored in a cookie") {
+		t.Error("expected a warning that profiles are stored in the cookie")
+	}
+
+	profile("select", "production", http.StatusSeeOther)
+	if body := index(); !strings.Contains(body, "i-prod") || strings.Contains(body, "i-dev") {
+		t.Error("expected the selected profile's instances to be listed")
+	}
+	profile("select", "development", http.StatusSeeOther)
+	if body := index(); !strings.Contains(body, "i-dev") {
+		t.Error("expected the selected profile's instances to be listed")
+	}
+
+	// the permission to resize is checked again for the selected profile
+	if _, body := get("/instance/i-dev"); !strings.Contains(body, `id="resize-denied"`) {
+		t.Error("expected the development profile not to be allowed to resize")
+	}
+	profile("select", "production", http.StatusSeeOther)
+	if resp, _ := get("/instance/i-prod/resize/confirm?new-type=m1.large"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the production profile to be allowed to resize, got %d", resp.StatusCode)
+	}
+	if _, body := get("/instance/i-prod"); strings.Contains(body, `id="resize-denied"`) {
+		t.Error("expected the production profile's resize controls to be enabled")
+	}
+	profile("select", "development", http.StatusSeeOther)
+
+	profile("remove", "production", http.StatusSeeOther)
+	profile("select", "production", http.StatusBadRequest)
+	profile("save", "", http.StatusBadRequest)
+	profile("save", strings.Repeat("x", maxProfileName+1), http.StatusBadRequest)
+	profile("rename", "development", http.StatusBadRequest)
+	for i := 0; i < maxProfiles-1; i++ {
+		profile("save", fmt.Sprintf("p%d", i), http.StatusSeeOther)
+	}
+	profile("save", "one-too-many", http.StatusBadRequest)
+
+	resp, _ := get("/logout")
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("logout: expected 303, got %d", resp.StatusCode)
+	}
+	login("dev")
+	if _, body := get("/profile"); strings.Contains(body, "development") {
+		t.Error("expected profiles to be deleted on logout")
+	}
+}
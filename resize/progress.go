@@ -0,0 +1,191 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+	"golang.org/x/net/websocket"
+)
+
+// progressHub relays the progress of resizes to the websockets watching their
+// instance, see handleInstanceWS. Instances are keyed by region and ID. The
+// zero value is ready to use.
+type progressHub struct {
+	mu       sync.Mutex
+	active   map[string]Event // the latest state of each resize in progress
+	watchers map[string]map[chan Event]bool
+}
+
+// watcherBuffer is the number of events a watcher may fall behind by before
+// further transitions are dropped for it. The final event is always sent.
+const watcherBuffer = 16
+
+// begin records that a resize of the instance identified by key started.
+func (h *progressHub) begin(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active == nil {
+		h.active = make(map[string]Event)
+	}
+	h.active[key] = Event{}
+}
+
+// publish relays a state transition of a resize in progress. Repeats of the
+// latest state, such as those reported while polling, are dropped.
+func (h *progressHub) publish(key string, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, ok := h.active[key]
+	if !ok || last == e {
+		return
+	}
+	h.active[key] = e
+	for ch := range h.watchers[key] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// finish relays the outcome of a resize and stops its watchers.
+func (h *progressHub) finish(key string, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.active, key)
+	for ch := range h.watchers[key] {
+		// the final event is sent after whatever is buffered, at the cost
+		// of dropping the oldest transition if the watcher is behind
+		select {
+		case ch <- e:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- e
+		}
+		close(ch)
+	}
+	delete(h.watchers, key)
+}
+
+// watch returns the events of the resize of the instance identified by key
+// which is in progress, starting with its latest state. The channel is closed
+// after the resize's final event. If no resize is in progress, ok is false.
+// stop must be called once the events are no longer read.
+func (h *progressHub) watch(key string) (events <-chan Event, stop func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, ok := h.active[key]
+	if !ok {
+		return nil, nil, false
+	}
+	ch := make(chan Event, watcherBuffer)
+	if last != (Event{}) {
+		ch <- last
+	}
+	if h.watchers == nil {
+		h.watchers = make(map[string]map[chan Event]bool)
+	}
+	if h.watchers[key] == nil {
+		h.watchers[key] = make(map[chan Event]bool)
+	}
+	h.watchers[key][ch] = true
+	stop = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.watchers[key], ch)
+	}
+	return ch, stop, true
+}
+
+// progressKey identifies an instance in the progressHub.
+func progressKey(region, id string) string {
+	return region + "/" + id
+}
+
+// progressWriter passes the JSON encoded Events of a resize on to w, and
+// publishes the states they report to a progressHub.
+type progressWriter struct {
+	w   io.Writer
+	hub *progressHub
+	key string
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	var e Event
+	if err := json.Unmarshal(b, &e); err == nil && e.Status == "message" {
+		p.hub.publish(p.key, Event{Status: "state", Message: e.Message})
+	}
+	return p.w.Write(b)
+}
+
+// Path: /instance/{instance}/ws
+//
+// handleInstanceWS streams the progress of a resize of the instance, however
+// it was started, as JSON encoded Events. Each state it passes through, such
+// as "stopping", "stopped", "modifying", "starting" and "running", is sent as
+// an Event with the "state" status. The connection is closed after an Event
+// with the "success" or "error" status describing the outcome. If no resize
+// of the instance is in progress an Event with the "idle" status is sent and
+// the connection closed.
+func (app *App) handleInstanceWS(ws *websocket.Conn) {
+	defer ws.Close()
+
+	r := ws.Request()
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.wsErr(ws, "Unauthorized")
+		return
+	}
+	if !app.validCSRF(r) {
+		app.wsErr(ws, errCSRF.Error())
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	if instanceId == "" {
+		app.wsErr(ws, "No instance ID included")
+		return
+	}
+	events, stop, ok := app.progress.watch(progressKey(ec2Cli.Region.Name, instanceId))
+	if !ok {
+		websocket.JSON.Send(ws, &Event{Status: "idle", Message: "No resize of " + instanceId + " is in progress"})
+		return
+	}
+	defer stop()
+	ctx, cancel := wsContext(ws)
+	defer cancel()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(ws, &e); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// trackResize returns a writer publishing the progress of a resize of inst
+// written to w, and a function to call with the resize's outcome.
+func (app *App) trackResize(ec2Cli *ec2.EC2, w io.Writer, inst ec2.Instance, newType string) (io.Writer, func(error)) {
+	key := progressKey(ec2Cli.Region.Name, inst.InstanceId)
+	app.progress.begin(key)
+	done := func(err error) {
+		if err != nil {
+			app.progress.finish(key, Event{Status: "error", Message: fmt.Sprintf("error resizing instance: %v", err)})
+			return
+		}
+		app.progress.finish(key, Event{Status: "success", Message: fmt.Sprintf("Resized %s to %s", inst.InstanceId, newType)})
+	}
+	return progressWriter{w: w, hub: &app.progress, key: key}, done
+}
@@ -0,0 +1,108 @@
+package resize
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"golang.org/x/net/websocket"
+)
+
+func TestInstanceWS(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.USEast)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2Cli); err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.csrfToken(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cookies []string
+	for _, c := range w.Result().Cookies() {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	dial := func(id string) *websocket.Conn {
+		u := "ws" + strings.TrimPrefix(s.URL, "http") + "/instance/" + id + "/ws?" +
+			url.Values{app.csrfFieldName(): {token}}.Encode()
+		config, err := websocket.NewConfig(u, s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Set("Cookie", strings.Join(cookies, "; "))
+		ws, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	receive := func(ws *websocket.Conn) Event {
+		var e Event
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatalf("expected an event: %v", err)
+		}
+		return e
+	}
+
+	ws := dial("i-idle")
+	if e := receive(ws); e.Status != "idle" {
+		t.Errorf("expected no resize to be in progress, got %v", e)
+	}
+	ws.Close()
+
+	inst := ec2.Instance{InstanceId: "i-watched", InstanceType: "m1.small", State: ec2.InstanceState{Name: "running"}}
+	progress, done := app.trackResize(ec2Cli, ioutil.Discard, inst, "m1.large")
+	writeEvent(progress, Event{Status: "message", Message: "stopping"})
+	ws = dial("i-watched")
+	defer ws.Close()
+	// a watcher joining late starts with the latest state
+	if e := receive(ws); e != (Event{Status: "state", Message: "stopping"}) {
+		t.Fatalf("expected the current state, got %v", e)
+	}
+	// a dry run meanwhile leaves the resize's progress alone
+	if err := app.resizeInstance(context.Background(), ec2Cli, ioutil.Discard, inst, "m1.large", "", true); err != nil {
+		t.Fatal(err)
+	}
+	late := dial("i-watched")
+	if e := receive(late); e != (Event{Status: "state", Message: "stopping"}) {
+		t.Errorf("expected the resize to still be in progress after a dry run, got %v", e)
+	}
+	late.Close()
+	for _, state := range []string{"stopping", "stopped", "stopped", "modifying", "starting", "pending", "running"} {
+		writeEvent(progress, Event{Status: "message", Message: state})
+	}
+	done(nil)
+	var states []string
+	for {
+		e := receive(ws)
+		if e.Status != "state" {
+			if e.Status != "success" {
+				t.Errorf("expected the resize to succeed, got %v", e)
+			}
+			break
+		}
+		states = append(states, e.Message)
+	}
+	if got, want := strings.Join(states, ","), "stopped,modifying,starting,pending,running"; got != want {
+		t.Errorf("expected transitions %s, got %s", want, got)
+	}
+	var e Event
+	if err := websocket.JSON.Receive(ws, &e); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v %v", e, err)
+	}
+}
@@ -0,0 +1,147 @@
+package resize
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// LoginRateLimit bounds the number of failed logins a client IP may make.
+// Each IP has a bucket of Attempts tokens which refills over Window. A failed
+// login takes a token, and once the bucket is empty further attempts are
+// rejected with 429 Too Many Requests until a token is refilled. A successful
+// login refills the bucket.
+type LoginRateLimit struct {
+	Attempts int
+	Window   time.Duration
+}
+
+// DefaultLoginRateLimit is used if App.LoginRateLimit is nil.
+var DefaultLoginRateLimit = LoginRateLimit{Attempts: 10, Window: 15 * time.Minute}
+
+// bucket holds the tokens left to a client at the time they were last
+// counted.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// loginLimiter tracks the failed logins of each client IP. It is safe for
+// concurrent use.
+type loginLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// refill returns the tokens of a bucket at the given time, up to the
+// limit's Attempts.
+func (l LoginRateLimit) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens
+	if l.Window > 0 {
+		tokens += float64(l.Attempts) * float64(now.Sub(b.updated)) / float64(l.Window)
+	}
+	if tokens > float64(l.Attempts) {
+		tokens = float64(l.Attempts)
+	}
+	return tokens
+}
+
+// allow reports if ip has a login attempt left.
+func (ll *loginLimiter) allow(limit LoginRateLimit, ip string, now time.Time) bool {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	b, ok := ll.buckets[ip]
+	return !ok || limit.refill(b, now) >= 1
+}
+
+// fail takes a token from the bucket of ip.
+func (ll *loginLimiter) fail(limit LoginRateLimit, ip string, now time.Time) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	// drop buckets which have refilled, so the map only holds clients with
+	// recent failures
+	for key, b := range ll.buckets {
+		if limit.refill(b, now) >= float64(limit.Attempts) {
+			delete(ll.buckets, key)
+		}
+	}
+	if ll.buckets == nil {
+		ll.buckets = make(map[string]*bucket)
+	}
+	b, ok := ll.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Attempts)}
+	} else {
+		b.tokens = limit.refill(b, now)
+	}
+	b.tokens--
+	b.updated = now
+	ll.buckets[ip] = b
+}
+
+// reset refills the bucket of ip.
+func (ll *loginLimiter) reset(ip string) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	delete(ll.buckets, ip)
+}
+
+func (app *App) loginRateLimit() LoginRateLimit {
+	if app.LoginRateLimit == nil {
+		return DefaultLoginRateLimit
+	}
+	return *app.LoginRateLimit
+}
+
+// clientIP returns the IP address of the client making a request. If the app
+// is behind a proxy (TrustProxy is set) the address the proxy appended to the
+// X-Forwarded-For header is used, as earlier entries are set by the client.
+func (app *App) clientIP(r *http.Request) string {
+	if app.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowLogin reports if the request's client may attempt to log in.
+func (app *App) allowLogin(r *http.Request) bool {
+	return app.loginLimiter.allow(app.loginRateLimit(), app.clientIP(r), time.Now())
+}
+
+// retryAfter is the number of seconds until a client which ran out of login
+// attempts has another one.
+func (app *App) retryAfter() string {
+	limit := app.loginRateLimit()
+	if limit.Attempts <= 0 {
+		return strconv.Itoa(int(limit.Window / time.Second))
+	}
+	return strconv.Itoa(int((limit.Window/time.Duration(limit.Attempts) + time.Second - 1) / time.Second))
+}
+
+// recordLogin counts a login attempt by the request's client. Credentials
+// rejected by AWS use up the client's attempts, and a success resets them.
+// Other errors, such as AWS being unreachable, aren't counted.
+func (app *App) recordLogin(r *http.Request, err error) {
+	ip := app.clientIP(r)
+	if err == nil {
+		app.loginLimiter.reset(ip)
+		return
+	}
+	if _, ok := err.(*ec2.Error); ok {
+		app.loginLimiter.fail(app.loginRateLimit(), ip, time.Now())
+	}
+}
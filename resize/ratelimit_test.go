@@ -0,0 +1,98 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginRateLimit(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("AWSAccessKeyId") != "good-key" && !strings.Contains(r.Header.Get("Authorization"), "Credential=good-key/") {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-limit")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	app.LoginRateLimit = &LoginRateLimit{Attempts: 2, Window: time.Hour}
+
+	login := func(key, remoteAddr, forwardedFor string) int {
+		body := fmt.Sprintf(`{"accessKey":%q,"secretKey":"s3cr3t"}`, key)
+		r, _ := http.NewRequest("POST", "/api/login", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := login("bad-key", "10.0.0.1:1234", ""); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, code)
+		}
+	}
+	if code := login("good-key", "10.0.0.1:1234", ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected login after too many failures to return 429, got %d", code)
+	}
+	if code := login("bad-key", "10.0.0.2:1234", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected another IP to not be limited, got %d", code)
+	}
+	if code := login("good-key", "10.0.0.2:1234", ""); code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d", code)
+	}
+	// the success reset the failure from 10.0.0.2
+	for i := 0; i < 2; i++ {
+		if code := login("bad-key", "10.0.0.2:1234", ""); code != http.StatusUnauthorized {
+			t.Errorf("attempt %d after successful login: expected 401, got %d", i, code)
+		}
+	}
+
+	// X-Forwarded-For is ignored unless the app trusts a proxy
+	if code := login("bad-key", "10.0.0.1:1234", "10.0.0.3"); code != http.StatusTooManyRequests {
+		t.Errorf("expected X-Forwarded-For to be ignored, got %d", code)
+	}
+	app.TrustProxy = true
+	if code := login("bad-key", "10.0.0.1:1234", "10.0.0.1, 10.0.0.3"); code != http.StatusUnauthorized {
+		t.Errorf("expected client IP to be read from X-Forwarded-For, got %d", code)
+	}
+}
+
+func TestLoginLimiterRefill(t *testing.T) {
+	limit := LoginRateLimit{Attempts: 3, Window: 30 * time.Minute}
+	var ll loginLimiter
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !ll.allow(limit, "10.0.0.1", now) {
+			t.Fatalf("attempt %d: expected login to be allowed", i)
+		}
+		ll.fail(limit, "10.0.0.1", now)
+	}
+	if ll.allow(limit, "10.0.0.1", now) {
+		t.Error("expected login to be limited after 3 failures")
+	}
+	if ll.allow(limit, "10.0.0.1", now.Add(9*time.Minute)) {
+		t.Error("expected login to be limited before a token is refilled")
+	}
+	if !ll.allow(limit, "10.0.0.1", now.Add(10*time.Minute)) {
+		t.Error("expected a token to be refilled after 10 minutes")
+	}
+
+	ll.fail(limit, "10.0.0.2", now.Add(time.Hour))
+	if _, ok := ll.buckets["10.0.0.1"]; ok {
+		t.Error("expected refilled bucket to be dropped")
+	}
+}
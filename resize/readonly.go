@@ -0,0 +1,29 @@
+package resize
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errReadOnly is the reason mutating requests are forbidden when the app is
+// read-only.
+var errReadOnly = errors.New("this app is read-only, instances can't be resized, stopped, started or changed")
+
+// denyReadOnly forbids requests to h, a handler which changes instances,
+// while App.ReadOnly is set. Requests for the API are refused with a JSON
+// error, others with the 403 page. Dry runs are refused too.
+func (app *App) denyReadOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.ReadOnly {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			app.LogfContext(r.Context(), "%s forbidden: %v", r.RequestURI, errReadOnly)
+			app.renderJSONError(w, errReadOnly.Error(), http.StatusForbidden)
+			return
+		}
+		app.render403(w, r, errReadOnly)
+	})
+}
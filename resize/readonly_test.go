@@ -0,0 +1,112 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestReadOnly(t *testing.T) {
+	ec2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch action := r.FormValue("Action"); action {
+		case "StopInstances", "StartInstances", "ModifyInstanceAttribute", "AssociateAddress", "CreateTags":
+			t.Errorf("unexpected mutating action %q", action)
+		case "DescribeAddresses":
+			fmt.Fprint(w, `<DescribeAddressesResponse><requestId>1</requestId><addressesSet/></DescribeAddressesResponse>`)
+		default:
+			fmt.Fprintf(w, taggedInstanceResponse, "running")
+		}
+	}))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ReadOnly = true
+	app.DryRun = true
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	cli := &http.Client{Jar: jar}
+	read := func(resp *http.Response, err error) (int, string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := read(cli.Get(s.URL + "/instance/i-confirm"))
+	if status != http.StatusOK {
+		t.Fatalf("expected instances to be browsable, got %d", status)
+	}
+	for _, id := range []string{`id="stop-instance"`, `id="resize"`, `id="change-type"`} {
+		if strings.Contains(body, id) {
+			t.Errorf("expected the instance page not to have %s", id)
+		}
+	}
+	if !strings.Contains(body, `id="read-only-note"`) || !strings.Contains(body, `id="read-only"`) {
+		t.Errorf("expected the instance page to say the app is read-only")
+	}
+	m := csrfMeta.FindSubmatch([]byte(body))
+	if m == nil {
+		t.Fatal("no CSRF token rendered")
+	}
+	form := url.Values{DefaultCSRFFieldName: {string(m[1])}, "new-type": {"m1.large"}}
+
+	// dry runs are refused too
+	for _, path := range []string{
+		"/instance/i-confirm/stop",
+		"/instance/i-confirm/resize/confirm?new-type=m1.large",
+		"/instance/i-confirm/resize/confirm?new-type=m1.large&dryrun=1",
+	} {
+		if status, body := read(cli.Get(s.URL + path)); status != http.StatusForbidden || !strings.Contains(body, "read-only") {
+			t.Errorf("GET %s: expected status 403 for a read-only app, got %d", path, status)
+		}
+	}
+	for _, path := range []string{"/instance/i-confirm/start", "/instance/i-confirm/stop", "/instance/i-confirm/resize?dryrun=1"} {
+		if status, body := read(cli.PostForm(s.URL+path, form)); status != http.StatusForbidden || !strings.Contains(body, "read-only") {
+			t.Errorf("POST %s: expected status 403 for a read-only app, got %d", path, status)
+		}
+	}
+	for _, path := range []string{"/instance/i-confirm/resize", "/instance/i-confirm/assign-ip"} {
+		req, _ := http.NewRequest("GET", s.URL+path, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if status, _ := read(cli.Do(req)); status != http.StatusForbidden {
+			t.Errorf("%s: expected websocket upgrades to be refused, got %d", path, status)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", s.URL+"/api/resize", strings.NewReader(`{"instance_ids":["i-confirm"],"new_type":"m1.large","dry_run":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, string(m[1]))
+	status, body = read(cli.Do(req))
+	if status != http.StatusForbidden || !strings.Contains(body, `"error"`) {
+		t.Errorf("expected the bulk resize API to refuse with a JSON error, got %d: %s", status, body)
+	}
+}
@@ -0,0 +1,33 @@
+package resize
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// minReasonLength returns the number of characters a resize reason must have
+// if App.RequireReason is set.
+func (app *App) minReasonLength() int {
+	if app.MinReasonLength < 1 {
+		return 1
+	}
+	return app.MinReasonLength
+}
+
+// validateReason checks the reason given for a resize, which must have at
+// least minReasonLength characters, ignoring surrounding whitespace, if
+// App.RequireReason is set.
+func (app *App) validateReason(reason string) error {
+	if !app.RequireReason {
+		return nil
+	}
+	n := app.minReasonLength()
+	if utf8.RuneCountInString(strings.TrimSpace(reason)) >= n {
+		return nil
+	}
+	if n == 1 {
+		return fmt.Errorf("a reason is required to resize instances")
+	}
+	return fmt.Errorf("a reason of at least %d characters is required to resize instances", n)
+}
@@ -0,0 +1,122 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestValidateReason(t *testing.T) {
+	app := &App{}
+	if err := app.validateReason(""); err != nil {
+		t.Errorf("expected no reason to be needed by default, got %v", err)
+	}
+	app.RequireReason = true
+	for reason, ok := range map[string]bool{"": false, "   ": false, "x": true} {
+		if err := app.validateReason(reason); (err == nil) != ok {
+			t.Errorf("validateReason(%q) = %v", reason, err)
+		}
+	}
+	app.MinReasonLength = 5
+	for reason, ok := range map[string]bool{"disk": false, " disk ": false, "déjà vu": true, "memory": true} {
+		if err := app.validateReason(reason); (err == nil) != ok {
+			t.Errorf("validateReason(%q) with a minimum of 5 = %v", reason, err)
+		}
+	}
+}
+
+func TestRequireReason(t *testing.T) {
+	ec2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, taggedInstanceResponse, "stopped")
+	}))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DryRun = true
+	app.RequireReason = true
+	app.MinReasonLength = 10
+	var audit bytes.Buffer
+	app.AuditSink = NewJSONAuditSink(&audit)
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	cli := &http.Client{Jar: jar}
+
+	resp, err := cli.Get(s.URL + "/instance/i-confirm/resize/confirm?new-type=m1.large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `minlength="10" required`) {
+		t.Errorf("expected a required reason field on the confirmation page")
+	}
+	n := nonceField.FindSubmatch(body)
+	m := csrfMeta.FindSubmatch(body)
+	if n == nil || m == nil {
+		t.Fatal("no nonce or CSRF token on confirmation page")
+	}
+	nonce, token := html.UnescapeString(string(n[1])), html.UnescapeString(string(m[1]))
+	post := func(reason string) int {
+		form := url.Values{"new-type": {"m1.large"}, "nonce": {nonce}, "reason": {reason}, DefaultCSRFFieldName: {token}}
+		resp, err := cli.PostForm(s.URL+"/instance/i-confirm/resize", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(""); status != http.StatusBadRequest {
+		t.Errorf("expected a resize without a reason to be rejected, got %d", status)
+	}
+	if status := post("  bigger  "); status != http.StatusBadRequest {
+		t.Errorf("expected a resize with a short reason to be rejected, got %d", status)
+	}
+	if audit.Len() != 0 {
+		t.Errorf("expected rejected resizes not to be audited, got %s", audit.String())
+	}
+	// the confirmation isn't used up by a rejected reason
+	if status := post("CHG-1234 needs more memory"); status != http.StatusOK {
+		t.Fatalf("expected a resize with a reason to succeed, got %d", status)
+	}
+	if !strings.Contains(audit.String(), `"reason":"CHG-1234 needs more memory"`) {
+		t.Errorf("expected the reason to be audited, got %s", audit.String())
+	}
+
+	r, _ = http.NewRequest("POST", "/api/resize", strings.NewReader(`{"instance_ids":["i-confirm"],"new_type":"m1.large"}`))
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	app.handleAPIBulkResize(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at least 10 characters") {
+		t.Errorf("expected a bulk resize without a reason to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
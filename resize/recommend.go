@@ -0,0 +1,271 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// DefaultRecommendLookback is the utilization history recommendations are
+// based on if App.RecommendLookback is zero.
+const DefaultRecommendLookback = 14 * 24 * time.Hour
+
+// maxRecommendLookback is the longest lookback a request may ask for, the
+// retention of CloudWatch's hourly datapoints.
+const maxRecommendLookback = 455 * 24 * time.Hour
+
+// minRecommendHistory is the least utilization history a recommendation is
+// made from, a day.
+const minRecommendHistory = 24 * time.Hour
+
+// targetUtilization is the utilization a recommended type should peak at,
+// leaving the rest as headroom.
+const targetUtilization = 0.8
+
+// utilizationPercentile is the percentile of the period maximums taken as an
+// instance's peak utilization, so a few brief spikes don't force a larger
+// type.
+const utilizationPercentile = 0.95
+
+func (app *App) recommendLookback() time.Duration {
+	if app.RecommendLookback <= 0 {
+		return DefaultRecommendLookback
+	}
+	return app.RecommendLookback
+}
+
+// metricPeriod returns the period datapoints are requested at to cover
+// lookback, an hour unless that would exceed the 1,440 datapoints CloudWatch
+// returns per request.
+func metricPeriod(lookback time.Duration) time.Duration {
+	period := time.Hour
+	for lookback/period > 1440 {
+		period += time.Hour
+	}
+	return period
+}
+
+// utilization summarizes the datapoints of a percentage metric.
+type utilization struct {
+	Average    float64 `json:"average"`
+	Peak       float64 `json:"peak"`
+	Datapoints int     `json:"datapoints"`
+}
+
+// summarize returns the utilization of points, or nil if they span less than
+// minRecommendHistory.
+func summarize(points []datapoint, period time.Duration) *utilization {
+	if time.Duration(len(points))*period < minRecommendHistory {
+		return nil
+	}
+	var sum float64
+	maxima := make([]float64, len(points))
+	for i, p := range points {
+		sum += p.Average
+		maxima[i] = p.Maximum
+	}
+	sort.Float64s(maxima)
+	return &utilization{
+		Average:    sum / float64(len(points)),
+		Peak:       maxima[int(float64(len(maxima)-1)*utilizationPercentile)],
+		Datapoints: len(points),
+	}
+}
+
+// recommendation is the JSON response of handleRecommend.
+type recommendation struct {
+	InstanceID    string       `json:"instance_id"`
+	InstanceType  string       `json:"instance_type"`
+	LookbackHours int          `json:"lookback_hours"`
+	CPU           *utilization `json:"cpu"`
+	Memory        *utilization `json:"memory"`
+	// Action is "upsize", "downsize", "keep" or "none" if no recommendation
+	// can be made.
+	Action          string  `json:"action"`
+	RecommendedType string  `json:"recommended_type,omitempty"`
+	Price           float64 `json:"price,omitempty"`
+	CurrentPrice    float64 `json:"current_price,omitempty"`
+	Rationale       string  `json:"rationale"`
+}
+
+// recommend picks the type of candidates an instance of type current should
+// run as given its utilization. Candidates must have enough vCPUs and memory
+// for the peak utilization to stay within targetUtilization; if memory is
+// nil, at least the current memory is kept. Of those, the cheapest is chosen,
+// or the smallest if any is unpriced. current is recommended if it's the best
+// fit.
+func recommend(current InstanceType, candidates []InstanceType, cpu, memory *utilization, resp *recommendation) {
+	needCPUs := float64(current.CPUs) * cpu.Peak / 100 / targetUtilization
+	needMemory := current.Memory
+	memoryNote := "Memory isn't reported by the CloudWatch agent, so at least the current memory is kept."
+	if memory != nil {
+		needMemory = current.Memory * memory.Peak / 100 / targetUtilization
+		memoryNote = fmt.Sprintf("Memory peaked at %.1f%% of %g GiB, needing %.1f GiB.",
+			memory.Peak, current.Memory, needMemory)
+	}
+	fits := func(t InstanceType) bool {
+		return float64(t.CPUs) >= needCPUs && t.Memory >= needMemory
+	}
+
+	var fitting []InstanceType
+	priced := true
+	for _, t := range append([]InstanceType{current}, candidates...) {
+		if t.CPUs == 0 || !fits(t) {
+			continue
+		}
+		fitting = append(fitting, t)
+		priced = priced && t.Price > 0
+	}
+	cpuNote := fmt.Sprintf("CPU peaked at %.1f%% of %d vCPUs, needing %.1f vCPUs to stay under %g%%.",
+		cpu.Peak, current.CPUs, needCPUs, targetUtilization*100)
+	if len(fitting) == 0 {
+		resp.Action = "none"
+		resp.Rationale = cpuNote + " " + memoryNote + " No compatible type is large enough."
+		return
+	}
+	sort.SliceStable(fitting, func(i, j int) bool {
+		a, b := fitting[i], fitting[j]
+		if priced && a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		if a.CPUs != b.CPUs {
+			return a.CPUs < b.CPUs
+		}
+		return a.Memory < b.Memory
+	})
+	best := fitting[0]
+	resp.RecommendedType = best.Name
+	resp.Price = best.Price
+	resp.CurrentPrice = current.Price
+	choice := "smallest"
+	if priced {
+		choice = "cheapest"
+	}
+	switch {
+	case best.Name == current.Name:
+		resp.Action = "keep"
+		resp.Rationale = cpuNote + " " + memoryNote + " The current type is the " + choice + " with enough headroom."
+	case fits(current):
+		resp.Action = "downsize"
+		resp.Rationale = cpuNote + " " + memoryNote + " " + best.Name + " is the " + choice + " compatible type with enough headroom."
+	default:
+		resp.Action = "upsize"
+		resp.Rationale = cpuNote + " " + memoryNote + " The current type lacks headroom, " +
+			best.Name + " is the " + choice + " compatible type with enough."
+	}
+}
+
+// Path: /instance/{instance}/recommend
+//
+// handleRecommend recommends a type to resize an instance to based on its
+// CloudWatch utilization over the lookback window, App.RecommendLookback or
+// the number of days given by the "days" query parameter. CPU utilization is
+// required, as is a day of history. Memory utilization is used if the
+// CloudWatch agent reports mem_used_percent with an InstanceId dimension.
+// If no recommendation can be made the action is "none" and the rationale
+// says why.
+func (app *App) handleRecommend(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.renderJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	lookback := app.recommendLookback()
+	if s := r.URL.Query().Get("days"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 1 || time.Duration(days)*24*time.Hour > maxRecommendLookback {
+			app.renderJSONError(w, fmt.Sprintf("days must be between 1 and %d", maxRecommendLookback/(24*time.Hour)), http.StatusBadRequest)
+			return
+		}
+		lookback = time.Duration(days) * 24 * time.Hour
+	}
+
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance %s: %v", instanceId, err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		app.renderJSONError(w, "no instance with ID "+instanceId, http.StatusNotFound)
+		return
+	}
+	resp := recommendation{
+		InstanceID:    instance.InstanceId,
+		InstanceType:  instance.InstanceType,
+		LookbackHours: int(lookback / time.Hour),
+		Action:        "none",
+	}
+	if instance.State.Name != "running" {
+		resp.Rationale = "The instance isn't running, so its utilization can't be measured."
+		app.renderJSON(w, resp, http.StatusOK)
+		return
+	}
+
+	end := time.Now()
+	period := metricPeriod(lookback)
+	query := func(namespace, metric string) ([]datapoint, error) {
+		return app.metricStatistics(ec2Cli.Auth, ec2Cli.Region.Name, metricQuery{
+			Namespace:  namespace,
+			MetricName: metric,
+			InstanceID: instance.InstanceId,
+			Start:      end.Add(-lookback),
+			End:        end,
+			Period:     period,
+		})
+	}
+	cpuPoints, err := query("AWS/EC2", "CPUUtilization")
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get CPU utilization of %s: %v", instanceId, err)
+		if err, ok := err.(*ec2.Error); ok {
+			app.renderJSONError(w, fmt.Sprintf("bad response from AWS CloudWatch '%s'", err.Message), http.StatusBadGateway)
+		} else {
+			app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	// the memory metric is only reported by the CloudWatch agent, so it's
+	// treated as missing if it can't be read
+	memPoints, err := query("CWAgent", "mem_used_percent")
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get memory utilization of %s: %v", instanceId, err)
+	}
+	resp.CPU = summarize(cpuPoints, period)
+	resp.Memory = summarize(memPoints, period)
+	if resp.CPU == nil {
+		resp.Rationale = "CloudWatch holds less than a day of CPU utilization for the instance, so no recommendation can be made."
+		app.renderJSON(w, resp, http.StatusOK)
+		return
+	}
+
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	prices, ok, err := app.prices(ec2Cli.Region.Name)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get prices for %s: %v", ec2Cli.Region.Name, err)
+	} else if ok {
+		types = MergePrices(types, prices)
+	}
+	current, ok := FindType(types, instance.InstanceType)
+	if !ok {
+		resp.Rationale = "The instance's type " + instance.InstanceType + " is unknown, so no recommendation can be made."
+		app.renderJSON(w, resp, http.StatusOK)
+		return
+	}
+	recommend(current, compatibleTypes(instance, types), resp.CPU, resp.Memory, &resp)
+	app.renderJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,182 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// metricStatisticsResponse formats hourly datapoints as a GetMetricStatistics
+// response.
+func metricStatisticsResponse(start time.Time, averages, maxima []float64) string {
+	var members string
+	for i := range averages {
+		members += fmt.Sprintf(`<member><Timestamp>%s</Timestamp><Average>%g</Average><Maximum>%g</Maximum><Unit>Percent</Unit></member>`,
+			start.Add(time.Duration(i)*time.Hour).Format(time.RFC3339), averages[i], maxima[i])
+	}
+	return `<GetMetricStatisticsResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <GetMetricStatisticsResult>
+    <Datapoints>` + members + `</Datapoints>
+    <Label>CPUUtilization</Label>
+  </GetMetricStatisticsResult>
+</GetMetricStatisticsResponse>`
+}
+
+// constant returns n copies of v.
+func constant(n int, v float64) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func TestRecommend(t *testing.T) {
+	types := []InstanceType{
+		{Name: "m5.large", CPUs: 2, Memory: 8, Price: 0.096},
+		{Name: "m5.xlarge", CPUs: 4, Memory: 16, Price: 0.192},
+		{Name: "m5.2xlarge", CPUs: 8, Memory: 32, Price: 0.384},
+		{Name: "r5.large", CPUs: 2, Memory: 16, Price: 0.126},
+	}
+	candidates := func(current InstanceType) []InstanceType {
+		return CompatibleTypes(current, types)
+	}
+	tests := []struct {
+		current     InstanceType
+		cpu, memory float64 // peaks, memory is missing if negative
+		action      string
+		recommended string
+	}{
+		{types[1], 30, 40, "downsize", "m5.large"},
+		// without memory metrics, the memory of a downsize is kept
+		{types[1], 30, -1, "downsize", "r5.large"},
+		{types[1], 60, 40, "keep", "m5.xlarge"},
+		{types[1], 90, 40, "upsize", "m5.2xlarge"},
+		{types[0], 30, 90, "upsize", "r5.large"},
+		{types[2], 95, 10, "none", ""},
+	}
+	for _, test := range tests {
+		cpu := &utilization{Peak: test.cpu}
+		var memory *utilization
+		if test.memory >= 0 {
+			memory = &utilization{Peak: test.memory}
+		}
+		var resp recommendation
+		recommend(test.current, candidates(test.current), cpu, memory, &resp)
+		if resp.Action != test.action || resp.RecommendedType != test.recommended {
+			t.Errorf("%s at %g%% CPU, %g%% memory: expected %s %s, got %s %s: %s",
+				test.current.Name, test.cpu, test.memory, test.action, test.recommended,
+				resp.Action, resp.RecommendedType, resp.Rationale)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	if summarize(make([]datapoint, 23), time.Hour) != nil {
+		t.Error("expected less than a day of datapoints to be too little history")
+	}
+	points := make([]datapoint, 100)
+	for i := range points {
+		points[i] = datapoint{Average: 10, Maximum: float64(i + 1)}
+	}
+	u := summarize(points, time.Hour)
+	if u == nil {
+		t.Fatal("expected a summary of 100 hours of datapoints")
+	}
+	// the largest few maximums are spikes
+	if u.Average != 10 || u.Peak != 95 || u.Datapoints != 100 {
+		t.Errorf("expected average 10, peak 95 of 100 datapoints, got %+v", u)
+	}
+	if got := metricPeriod(14 * 24 * time.Hour); got != time.Hour {
+		t.Errorf("expected an hourly period for two weeks, got %s", got)
+	}
+	if got := metricPeriod(90 * 24 * time.Hour); got != 2*time.Hour {
+		t.Errorf("expected a two hour period for 90 days, got %s", got)
+	}
+}
+
+func TestHandleRecommend(t *testing.T) {
+	start := time.Now().Add(-48 * time.Hour)
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("Action") {
+		case "GetMetricStatistics":
+			if r.FormValue("Dimensions.member.1.Value") != "i-busy" || r.FormValue("Namespace") != "AWS/EC2" {
+				fmt.Fprint(w, metricStatisticsResponse(start, nil, nil))
+				return
+			}
+			fmt.Fprint(w, metricStatisticsResponse(start, constant(48, 70), constant(48, 95)))
+		default:
+			fmt.Fprintf(w, describeInstancesResponse, r.FormValue("InstanceId.1"))
+		}
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = s.URL
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m1.small", CPUs: 1, Memory: 1.7, Price: 0.044, PriceUnit: "Hrs"},
+		{Name: "m1.large", CPUs: 2, Memory: 7.5, Price: 0.175, PriceUnit: "Hrs"},
+	}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path string) (int, recommendation) {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		var resp recommendation
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, resp
+	}
+
+	code, resp := get("/instance/i-busy/recommend")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.Action != "upsize" || resp.RecommendedType != "m1.large" {
+		t.Errorf("expected a busy m1.small to be upsized to m1.large, got %s %s", resp.Action, resp.RecommendedType)
+	}
+	if resp.CPU == nil || resp.CPU.Datapoints != 48 || resp.Memory != nil {
+		t.Errorf("expected 48 CPU datapoints and no memory, got %+v, %+v", resp.CPU, resp.Memory)
+	}
+	if resp.LookbackHours != int(DefaultRecommendLookback/time.Hour) {
+		t.Errorf("expected the default lookback, got %d hours", resp.LookbackHours)
+	}
+
+	code, resp = get("/instance/i-new/recommend?days=3")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.Action != "none" || resp.RecommendedType != "" || !strings.Contains(resp.Rationale, "no recommendation") {
+		t.Errorf("expected no recommendation without metrics, got %s %s: %s", resp.Action, resp.RecommendedType, resp.Rationale)
+	}
+	if resp.LookbackHours != 72 {
+		t.Errorf("expected a lookback of 72 hours, got %d", resp.LookbackHours)
+	}
+
+	if code, _ := get("/instance/i-busy/recommend?days=0"); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a lookback of 0 days, got %d", code)
+	}
+}
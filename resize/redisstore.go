@@ -0,0 +1,248 @@
+package resize
+
+import (
+	"bufio"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// maxIdleRedisConns is the number of idle connections a RedisStore keeps
+// open for reuse.
+const maxIdleRedisConns = 8
+
+// RedisStore is a sessions.Store which keeps session values in Redis. The
+// session cookie only holds a signed session ID, so sessions can be larger
+// than a cookie allows and can be invalidated server side.
+type RedisStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+
+	// KeyPrefix is prepended to session IDs to form Redis keys.
+	KeyPrefix string
+
+	addr string
+	idle chan *redisConn
+}
+
+// NewRedisStore returns a RedisStore which connects to the Redis server at
+// addr.
+//
+// See sessions.NewCookieStore for a description of keyPairs.
+func NewRedisStore(addr string, keyPairs ...[]byte) *RedisStore {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, c := range codecs {
+		// values are stored in Redis, not in the cookie
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxLength(0)
+		}
+	}
+	return &RedisStore{
+		Codecs: codecs,
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		KeyPrefix: "session_",
+		addr:      addr,
+		idle:      make(chan *redisConn, maxIdleRedisConns),
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See sessions.CookieStore.Get.
+func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+//
+// See sessions.CookieStore.New.
+func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	var err error
+	if c, errCookie := r.Cookie(name); errCookie == nil {
+		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+		if err == nil {
+			err = s.load(session)
+			if err == nil {
+				session.IsNew = false
+			}
+		}
+	}
+	return session, err
+}
+
+// Save writes the session values to Redis and adds the session ID cookie to
+// the response. A session with a negative MaxAge is deleted.
+func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.do("DEL", s.KeyPrefix+session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(
+				securecookie.GenerateRandomKey(32)), "=")
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
+		s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Ping checks that the Redis server is reachable.
+func (s *RedisStore) Ping() error {
+	_, err := s.do("PING")
+	return err
+}
+
+// Close closes the store's idle connections to Redis.
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// save writes encoded session.Values to Redis.
+func (s *RedisStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
+		s.Codecs...)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", s.KeyPrefix + session.ID, encoded}
+	if session.Options.MaxAge > 0 {
+		args = append(args, "EX", strconv.Itoa(session.Options.MaxAge))
+	}
+	_, err = s.do(args...)
+	return err
+}
+
+// load reads a session from Redis and decodes its content into
+// session.Values.
+func (s *RedisStore) load(session *sessions.Session) error {
+	reply, err := s.do("GET", s.KeyPrefix+session.ID)
+	if err != nil {
+		return err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return errors.New("redis: session not found")
+	}
+	return securecookie.DecodeMulti(session.Name(), data, &session.Values,
+		s.Codecs...)
+}
+
+// do sends a command to Redis and returns its reply.
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-s.idle:
+	default:
+		conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		c = &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	}
+	reply, err := c.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// the connection is in an unknown state, don't reuse it
+		c.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- c:
+	default:
+		c.Close()
+	}
+	return reply, err
+}
+
+// redisError is an error reply from the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn speaks the Redis serialization protocol over a connection.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply reads a single reply. Bulk strings are returned as strings,
+// with a nil bulk string returned as nil.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,173 @@
+package resize
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a minimal Redis server supporting GET, SET, INCR and DEL.
+type fakeRedis struct {
+	net.Listener
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{Listener: l, data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			v, ok := s.data[args[1]]
+			if ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				io.WriteString(conn, "$-1\r\n")
+			}
+		case "SET":
+			s.data[args[1]] = args[2]
+			io.WriteString(conn, "+OK\r\n")
+		case "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case "INCR":
+			n, _ := strconv.Atoi(s.data[args[1]])
+			s.data[args[1]] = strconv.Itoa(n + 1)
+			fmt.Fprintf(conn, ":%d\r\n", n+1)
+		case "DEL":
+			delete(s.data, args[1])
+			io.WriteString(conn, ":1\r\n")
+		default:
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		return strings.TrimSuffix(line, "\r\n"), err
+	}
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "*"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := readLine(); err != nil {
+			return nil, err
+		}
+		if args[i], err = readLine(); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+	store := NewRedisStore(server.Addr().String(), []byte("secret-key"))
+	defer store.Close()
+
+	if err := store.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	session, err := store.New(r, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["foo"] = "bar"
+	if err := store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected 1 cookie, got %d", len(cookies))
+	}
+	if len(server.data) != 1 {
+		t.Fatalf("expected 1 session in redis, got %d", len(server.data))
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	session, err = store.New(r, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.IsNew {
+		t.Errorf("expected existing session")
+	}
+	if v := session.Values["foo"]; v != "bar" {
+		t.Errorf("expected session value 'bar', got %v", v)
+	}
+
+	session.Options.MaxAge = -1
+	if err := store.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.data) != 0 {
+		t.Errorf("expected session to be deleted from redis")
+	}
+	if _, err = store.New(r, "test"); err == nil {
+		t.Errorf("expected error loading deleted session")
+	}
+}
+
+func TestRedisStoreSessionVersions(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+	store := NewRedisStore(server.Addr().String(), []byte("secret-key"))
+	defer store.Close()
+
+	for want := int64(0); want < 3; want++ {
+		v, err := store.SessionVersion("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Errorf("expected version %d, got %d", want, v)
+		}
+		if err := store.RevokeSessions("user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
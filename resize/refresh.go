@@ -0,0 +1,141 @@
+package resize
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultRefreshInterval is how often the refresher started by
+// StartBackgroundRefresh checks if the instance types are due to be fetched.
+const DefaultRefreshInterval = time.Minute
+
+// maxRefreshBackoff bounds the wait between attempts after repeated failed
+// refreshes.
+const maxRefreshBackoff = 30 * time.Minute
+
+// RefreshStatus describes the background refresher of the instance types
+// cache, see StartBackgroundRefresh.
+type RefreshStatus struct {
+	// Running reports if the refresher was started and the app hasn't been
+	// shut down since.
+	Running bool
+
+	// LastAttempt and LastSuccess are when the instance types were last
+	// fetched, and last fetched successfully. They're zero until then.
+	LastAttempt time.Time
+	LastSuccess time.Time
+
+	// Err is the error of the last attempt, or nil if it succeeded.
+	Err error
+
+	// Failures counts the attempts which failed since the last success.
+	Failures int
+
+	// NextAttempt is the earliest time another attempt is made after a
+	// failure. It's zero if the last attempt succeeded.
+	NextAttempt time.Time
+}
+
+// StartBackgroundRefresh starts a goroutine which fetches the instance types
+// shortly before the cached ones expire, so requests don't wait for the
+// source. Every interval, or DefaultRefreshInterval if interval is zero, it
+// checks if the types are within a tenth of the TypeCache's TTL, or two
+// intervals if that's longer, of expiring. After a failure the interval is
+// doubled for each further attempt, up to 30 minutes.
+//
+// The goroutine stops when the app is shut down. Calling
+// StartBackgroundRefresh again while it's running has no effect.
+func (app *App) StartBackgroundRefresh(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	app.refreshMu.Lock()
+	defer app.refreshMu.Unlock()
+	if app.refresh.Running || app.bgCtx.Err() != nil {
+		return
+	}
+	app.refresh.Running = true
+	app.goBackground(func(ctx context.Context) {
+		app.runRefresh(ctx, interval)
+	})
+}
+
+// RefreshStatus returns the status of the background refresher.
+func (app *App) RefreshStatus() RefreshStatus {
+	app.refreshMu.Lock()
+	defer app.refreshMu.Unlock()
+	return app.refresh
+}
+
+func (app *App) runRefresh(ctx context.Context, interval time.Duration) {
+	defer func() {
+		app.refreshMu.Lock()
+		app.refresh.Running = false
+		app.refreshMu.Unlock()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if app.refreshDue(interval, time.Now()) {
+			app.refreshTypes(ctx, interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshDue reports if the instance types should be fetched at now.
+func (app *App) refreshDue(interval time.Duration, now time.Time) bool {
+	if now.Before(app.RefreshStatus().NextAttempt) {
+		return false
+	}
+	fetchedAt, _ := app.TypeCache.FetchedAt()
+	if fetchedAt.IsZero() {
+		return true
+	}
+	ttl := app.TypeCache.ttl()
+	lead := ttl / 10
+	if lead < 2*interval {
+		lead = 2 * interval
+	}
+	return now.Sub(fetchedAt) >= ttl-lead
+}
+
+func (app *App) refreshTypes(ctx context.Context, interval time.Duration) {
+	err := app.TypeCache.Refresh(ctx)
+	if ctx.Err() != nil {
+		// the app is shutting down
+		return
+	}
+	now := time.Now()
+	app.refreshMu.Lock()
+	defer app.refreshMu.Unlock()
+	app.refresh.LastAttempt = now
+	app.refresh.Err = err
+	if err == nil {
+		app.refresh.LastSuccess = now
+		app.refresh.Failures = 0
+		app.refresh.NextAttempt = time.Time{}
+		return
+	}
+	app.refresh.Failures++
+	wait := refreshBackoff(interval, app.refresh.Failures)
+	app.refresh.NextAttempt = now.Add(wait)
+	app.LogfContext(ctx, "could not refresh instance types, trying again in %v: %v", wait, err)
+}
+
+// refreshBackoff returns the wait before another attempt after failures
+// consecutive failed refreshes.
+func refreshBackoff(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 1; i < failures && wait < maxRefreshBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxRefreshBackoff {
+		wait = maxRefreshBackoff
+	}
+	return wait
+}
@@ -0,0 +1,120 @@
+package resize
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackgroundRefresh(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	calls := 0
+	app.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return []InstanceType{{Name: "t2.micro"}}, nil
+	})
+	app.TypeCache.TTL = 50 * time.Millisecond
+	fetches := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	if app.RefreshStatus().Running || fetches() != 0 {
+		t.Fatal("expected no refresh before it's started")
+	}
+	app.StartBackgroundRefresh(5 * time.Millisecond)
+	app.StartBackgroundRefresh(5 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fetches() < 3 {
+		t.Fatalf("expected the types to be refreshed repeatedly, got %d fetches", fetches())
+	}
+	status := app.RefreshStatus()
+	if !status.Running || status.LastSuccess.IsZero() || status.Err != nil || status.Failures != 0 {
+		t.Errorf("expected a running refresher whose last attempt succeeded, got %+v", status)
+	}
+
+	// requests are served from the cache without fetching
+	n := fetches()
+	if _, err := app.TypeCache.InstanceTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if fetches() > n+1 {
+		t.Errorf("expected the request to be served from the cache")
+	}
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if app.RefreshStatus().Running {
+		t.Errorf("expected the refresher to stop on shutdown")
+	}
+	n = fetches()
+	time.Sleep(30 * time.Millisecond)
+	if fetches() != n {
+		t.Errorf("expected no fetches after shutdown")
+	}
+	app.StartBackgroundRefresh(5 * time.Millisecond)
+	if app.RefreshStatus().Running {
+		t.Errorf("expected the refresher not to start after shutdown")
+	}
+}
+
+func TestBackgroundRefreshFailures(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Logger = log.New(ioutil.Discard, "", 0)
+	app.Source = NewStaticSource(nil, errors.New("scrape failed"))
+	defer app.Shutdown(context.Background())
+
+	app.StartBackgroundRefresh(10 * time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for app.RefreshStatus().Failures == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	status := app.RefreshStatus()
+	if status.Failures != 1 || status.Err == nil || !status.LastSuccess.IsZero() {
+		t.Fatalf("expected a single failed attempt, got %+v", status)
+	}
+	if got := status.NextAttempt.Sub(status.LastAttempt); got != 10*time.Minute {
+		t.Errorf("expected the next attempt an interval later, got %v", got)
+	}
+	if app.refreshDue(10*time.Minute, status.LastAttempt.Add(time.Minute)) {
+		t.Errorf("expected no attempt before the backoff has passed")
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 16 * time.Minute},
+		{6, maxRefreshBackoff},
+		{100, maxRefreshBackoff},
+	}
+	for _, test := range tests {
+		if got := refreshBackoff(time.Minute, test.failures); got != test.want {
+			t.Errorf("%d failures: expected %v, got %v", test.failures, test.want, got)
+		}
+	}
+}
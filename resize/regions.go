@@ -0,0 +1,126 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// DefaultRegionConcurrency is the number of regions queried at once when
+// listing instances across regions.
+const DefaultRegionConcurrency = 4
+
+// RegionInstances holds the instances of a single region. If the region
+// couldn't be queried, Err is set.
+type RegionInstances struct {
+	Region    string
+	Instances []ec2.Instance
+	Err       error
+}
+
+// instancesByRegion calls DescribeInstances in each region using at most
+// workers concurrent requests. The results are returned in the order of
+// regions, and a failure in one region doesn't affect the others.
+func instancesByRegion(auth aws.Auth, regions []aws.Region, client *http.Client, workers int) []RegionInstances {
+	results := make([]RegionInstances, len(regions))
+	forEachConcurrently(len(regions), workers, func(i int) {
+		region := regions[i]
+		result := RegionInstances{Region: region.Name}
+		resp, err := ec2.NewWithClient(auth, region, client).Instances(nil, nil)
+		if err != nil {
+			result.Err = err
+		} else {
+			result.Instances = allInstances(resp)
+			sortByName(result.Instances)
+		}
+		results[i] = result
+	})
+	return results
+}
+
+// forEachConcurrently calls f with each index from 0 to n-1 using at least
+// one and at most workers goroutines, returning once every call has.
+func forEachConcurrently(n, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				f(j)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// regions returns the regions instances are listed in, sorted by name, with
+// the app's endpoints applied.
+func (app *App) regions() ([]aws.Region, error) {
+	names := app.Regions
+	if len(names) == 0 {
+		for name := range aws.Regions {
+			names = append(names, name)
+		}
+	}
+	regions := make([]aws.Region, len(names))
+	for i, name := range names {
+		region, ok := aws.Regions[name]
+		if !ok {
+			return nil, fmt.Errorf("No AWS region named %s", name)
+		}
+		regions[i] = app.region(region)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Name < regions[j].Name })
+	return regions, nil
+}
+
+func (app *App) regionConcurrency() int {
+	if app.RegionConcurrency <= 0 {
+		return DefaultRegionConcurrency
+	}
+	return app.RegionConcurrency
+}
+
+// Path: /all-regions
+func (app *App) handleAllRegions(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	regions, err := app.regions()
+	if err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	results := instancesByRegion(ec2Cli.Auth, regions, app.ec2HTTPClient(), app.regionConcurrency())
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			app.LogfContext(r.Context(), "could not list instances in %s: %v", result.Region, result.Err)
+			failed++
+		}
+	}
+	data := map[string]interface{}{
+		"Results": results,
+		"Failed":  failed,
+	}
+	app.render(w, r, "all-regions.html", data)
+}
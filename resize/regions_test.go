@@ -0,0 +1,144 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+const describeInstancesResponse = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2014-02-01/">
+  <requestId>98e3c9a4-848c-4d6d-8e8a-b1bdEXAMPLE</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-b27e30d9</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>%s</instanceId>
+          <instanceState><code>16</code><name>running</name></instanceState>
+          <instanceType>m1.small</instanceType>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`
+
+const ec2ErrorResponse = `<Response><Errors><Error><Code>AuthFailure</Code><Message>not authorized</Message></Error></Errors><RequestID>1</RequestID></Response>`
+
+func TestInstancesByRegion(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		region := strings.TrimPrefix(r.URL.Path, "/")
+		if region == "bad-region" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-"+region)
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	names := []string{"region-a", "region-b", "bad-region", "region-c", "region-d", "region-e"}
+	regions := make([]aws.Region, len(names))
+	for i, name := range names {
+		regions[i] = aws.Region{Name: name, EC2Endpoint: s.URL + "/" + name}
+	}
+	auth := aws.Auth{AccessKey: "key", SecretKey: "secret"}
+	results := instancesByRegion(auth, regions, http.DefaultClient, 2)
+
+	if len(results) != len(names) {
+		t.Fatalf("expected %d results, got %d", len(names), len(results))
+	}
+	for i, result := range results {
+		if result.Region != names[i] {
+			t.Errorf("result %d: expected region %s, got %s", i, names[i], result.Region)
+			continue
+		}
+		if result.Region == "bad-region" {
+			if result.Err == nil {
+				t.Errorf("expected error for %s", result.Region)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.Region, result.Err)
+			continue
+		}
+		if len(result.Instances) != 1 || result.Instances[0].InstanceId != "i-"+result.Region {
+			t.Errorf("%s: unexpected instances %v", result.Region, result.Instances)
+		}
+	}
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 concurrent requests, got %d", maxRunning)
+	}
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 20} {
+		var mu sync.Mutex
+		calls := make([]int, 10)
+		forEachConcurrently(len(calls), workers, func(i int) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[i]++
+		})
+		for i, n := range calls {
+			if n != 1 {
+				t.Errorf("%d workers: expected index %d to be visited once, got %d", workers, i, n)
+			}
+		}
+	}
+	forEachConcurrently(0, 2, func(i int) { t.Errorf("unexpected call with %d", i) })
+}
+
+func TestRegionEndpoints(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Regions = []string{"us-east-1", "us-gov-west-1", "cn-north-1"}
+	app.RegionEndpoints = map[string]string{
+		"us-gov-west-1": "https://vpce-gov.example.com",
+		"cn-north-1":    "https://proxy-cn.example.com",
+	}
+	regions, err := app.regions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"us-east-1":     aws.USEast.EC2Endpoint,
+		"us-gov-west-1": "https://vpce-gov.example.com",
+		"cn-north-1":    "https://proxy-cn.example.com",
+	}
+	for _, r := range regions {
+		if r.EC2Endpoint != want[r.Name] {
+			t.Errorf("%s: expected endpoint %s, got %s", r.Name, want[r.Name], r.EC2Endpoint)
+		}
+	}
+
+	app.Endpoint = "http://localhost:4566"
+	if got := app.region(aws.USEast).EC2Endpoint; got != app.Endpoint {
+		t.Errorf("expected Endpoint for regions without an override, got %s", got)
+	}
+	if got := app.region(aws.Regions["cn-north-1"]).EC2Endpoint; got != "https://proxy-cn.example.com" {
+		t.Errorf("expected region override to take precedence over Endpoint, got %s", got)
+	}
+}
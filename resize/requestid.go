@@ -0,0 +1,77 @@
+package resize
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+// RequestIDHeader is the header a request's ID is read from, if the client
+// or a proxy in front of the app assigned one, and echoed in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs honored from clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or an empty string
+// if it has none, such as for work started outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns a copy of ctx carrying the request ID id.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validRequestID reports if a request ID sent by a client can be used as is.
+// IDs are logged, so only short tokens of letters, digits and "-_.:" are
+// accepted.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID assigns each request an ID, which is stored in its context and
+// echoed in the X-Request-ID header of the response. An ID sent by the client
+// in the X-Request-ID header is honored if it's valid; otherwise a new one
+// is generated.
+func (app *App) requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = newRequestID(); err != nil {
+				app.Logf("could not create request ID: %v", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
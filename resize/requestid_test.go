@@ -0,0 +1,92 @@
+package resize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	app := &App{}
+	var got string
+	h := app.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+	}))
+	serve := func(id string) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if id != "" {
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if echoed := w.Header().Get(RequestIDHeader); echoed != got {
+			t.Errorf("expected the response to echo request ID %q, got %q", got, echoed)
+		}
+		return got
+	}
+
+	first, second := serve(""), serve("")
+	if len(first) != 32 || first == second {
+		t.Errorf("expected random request IDs, got %q and %q", first, second)
+	}
+	if id := serve("edge-1234:abc_DEF.5"); id != "edge-1234:abc_DEF.5" {
+		t.Errorf("expected the incoming request ID to be honored, got %q", id)
+	}
+	for _, id := range []string{"id with spaces", "id\nforged=1", strings.Repeat("a", maxRequestIDLength+1)} {
+		if got := serve(id); got == id || len(got) != 32 {
+			t.Errorf("expected invalid request ID %q to be replaced, got %q", id, got)
+		}
+	}
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("expected no request ID outside of requests, got %q", id)
+	}
+}
+
+func TestLogRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	app := &App{Logger: log.New(&buf, "", 0)}
+	ctx := withRequestID(context.Background(), "req-1")
+	app.LogfContext(ctx, "could not get %s", "prices")
+	app.LogEventContext(ctx, "login", Fields{"region": "us-east-1"})
+	app.LogfContext(context.Background(), "no request")
+	want := "could not get prices request_id=req-1\nlogin region=us-east-1 request_id=req-1\nno request\n"
+	if buf.String() != want {
+		t.Errorf("expected log\n%s\ngot\n%s", want, buf.String())
+	}
+
+	buf.Reset()
+	app.JSONLog = true
+	app.LogfContext(ctx, "hello")
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "hello" || record["request_id"] != "req-1" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestRequestIDLogged(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	app.Logger = log.New(&buf, "", 0)
+	r, _ := http.NewRequest("GET", "/no-such-page", nil)
+	r.RequestURI = "/no-such-page"
+	r.Header.Set(RequestIDHeader, "trace-42")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	if w.Header().Get(RequestIDHeader) != "trace-42" {
+		t.Errorf("expected the request ID to be echoed, got %q", w.Header().Get(RequestIDHeader))
+	}
+	if !strings.Contains(buf.String(), "/no-such-page not found request_id=trace-42") {
+		t.Errorf("expected the request ID to be logged, got %q", buf.String())
+	}
+}
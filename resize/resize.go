@@ -1,17 +1,22 @@
 package resize
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"path/filepath"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
 	"golang.org/x/net/websocket"
 )
 
@@ -22,31 +27,296 @@ type App struct {
 	// standard logger.
 	Logger *log.Logger
 
+	// JSONLog specifies if log output should be written as JSON records,
+	// one per line, instead of plain text.
+	JSONLog bool
+
 	// ReloadTemplates specifies if the App will recompile
 	// the templates before rendering each response.
 	// This option is intended for development, and should
 	// not be used on a production server.
 	ReloadTemplates bool
 
-	// The HTTP client used for all request to AWS.
-	// If nil, the aws.Retrying client is used.
+	// DryRun specifies if mutating EC2 operations should only be simulated.
+	// When true, the handlers which change instances (resizing and
+	// associating Elastic IPs) report the steps they would have taken instead
+	// of calling AWS, and every rendered page is badged "DRY RUN".
+	// The vendored EC2 client doesn't support the API's DryRun parameter, so
+	// no permission checks are made by AWS.
+	DryRun bool
+
+	// ReadOnly disables every handler which changes instances, such as
+	// resizing, stopping, starting or associating Elastic IPs, for demos
+	// where instances are browsed without risk. Their requests are
+	// forbidden, and pages don't show the controls making them.
+	// Unlike DryRun, which keeps the controls and reports the steps they
+	// would have taken, ReadOnly refuses even simulated changes.
+	ReadOnly bool
+
+	// Endpoint overrides the EC2 endpoint of every region, e.g. to send all
+	// EC2 requests to LocalStack. Requests are still signed for the selected
+	// region. Instance types and prices are fetched by Source, which is
+	// unaffected.
+	// If empty, each region's own endpoint is used.
+	Endpoint string
+
+	// RegionEndpoints maps region names to the EC2 endpoint used for that
+	// region, e.g. to reach GovCloud or China regions through a VPC endpoint
+	// or proxy. An entry overrides Endpoint for its region. Regions without
+	// an entry use Endpoint if set, otherwise their own endpoint.
+	RegionEndpoints map[string]string
+
+	// The HTTP client used for requests to the AWS APIs, such as EC2, STS
+	// and CloudWatch.
+	// If nil, a client pooling connections as configured by Transport is
+	// used, which retries requests like aws.RetryingClient.
 	HTTPClient *http.Client
 
-	store *sessions.CookieStore
+	// ScrapeClient is the HTTP client Source uses to fetch instance types
+	// and prices, such as the AWS website or the price list offer files.
+	// It's separate from HTTPClient so that scraping can go through a
+	// different proxy or egress than the API calls.
+	// If nil, a client of its own, configured by Transport like the default
+	// HTTPClient, is used.
+	ScrapeClient *http.Client
+
+	// Transport configures the connection pool and timeouts of the clients
+	// used when HTTPClient or ScrapeClient is nil. It must be set before the
+	// app serves requests.
+	// If nil, DefaultTransportOptions are used.
+	Transport *TransportOptions
+
+	// SessionOptions configures the login session cookie.
+	// If nil, DefaultSessionOptions are used.
+	SessionOptions *SessionOptions
+
+	// BasePath is the path prefix the app is served under, such as "/resize"
+	// when a reverse proxy mounts it there without stripping the prefix.
+	// Requests outside it aren't found, and links, redirects and asset URLs
+	// include it.
+	// If empty, the app is served at the root.
+	BasePath string
+
+	// ContentSecurityPolicy is the Content-Security-Policy header sent with
+	// every rendered page. Each occurrence of "{nonce}" is replaced by a
+	// random nonce, which templates add to inline scripts and styles through
+	// the CSPNonce value.
+	// If empty, DefaultContentSecurityPolicy is used.
+	ContentSecurityPolicy string
+
+	// CSRFFieldName is the name of the form field holding the CSRF token
+	// required by all state-changing requests.
+	// If empty, DefaultCSRFFieldName is used.
+	CSRFFieldName string
+
+	// CSRFCookieName is the name of the session holding the CSRF token.
+	// If empty, DefaultCSRFCookieName is used.
+	CSRFCookieName string
+
+	// CSRFCookieOptions specifies the cookie options used when saving a new
+	// CSRF token. If nil, the app's SessionOptions are used.
+	CSRFCookieOptions *sessions.Options
+
+	// Source provides the EC2 instance types offered as resize targets.
+	// If nil, the types are scraped from the AWS website.
+	Source InstanceTypeSource
+
+	// DisableScraper stops the app fetching instance types from Source, e.g.
+	// in air-gapped environments where the AWS website can't be reached.
+	// StaticTypes are offered as resize targets instead. If there are none,
+	// the instance types views are hidden and instances can't be resized.
+	DisableScraper bool
+
+	// StaticTypes are the instance types offered if DisableScraper is set.
+	// See ReadInstanceTypes for loading them from a file.
+	StaticTypes []InstanceType
+
+	// Baseline is a snapshot of known-good instance types, e.g. saved with
+	// "resize-types -json", which the /diagnostics/drift view compares the
+	// current types against to catch the instance types page changing in
+	// ways which don't fail scraping. See CompareBaseline. If nil, the view
+	// is unavailable.
+	Baseline []InstanceType
+
+	// Regions lists the names of the regions searched for instances by the
+	// all regions view. If empty, every region known to goamz is used.
+	Regions []string
+
+	// RegionConcurrency bounds the number of regions queried at once by the
+	// all regions view, to avoid being throttled by AWS.
+	// If zero, DefaultRegionConcurrency is used.
+	RegionConcurrency int
+
+	// AuditSink records every resize action, including dry runs, before and
+	// after it is taken. If nil, resizes are only logged as events.
+	AuditSink AuditSink
+
+	// TagResized specifies if instances are tagged after a successful resize
+	// with ResizedBy, the prefix of the access key ID which resized them,
+	// ResizedAt, the time in RFC 3339 format, ResizedFrom, their previous
+	// type, and ResizeReason if a reason was given. A resize isn't undone if
+	// tagging fails, e.g. because the instance has as many tags as EC2
+	// allows.
+	TagResized bool
+
+	// RequireReason specifies if a reason must be given for every resize,
+	// including dry runs and bulk resizes. Resizes without one are rejected.
+	// The reason is recorded in the audit log and, if TagResized is set, the
+	// instance's ResizeReason tag.
+	RequireReason bool
+
+	// MinReasonLength is the number of characters a reason must have if
+	// RequireReason is set. If zero, any reason which isn't blank is
+	// accepted.
+	MinReasonLength int
+
+	// Locker ensures only one resize, stop or start runs on an instance at
+	// a time. Requests for an instance which is being operated on are
+	// rejected with 409 Conflict.
+	// If nil, locks are kept in memory, which only guards a single replica
+	// of the app, see InstanceLocker.
+	Locker InstanceLocker
+
+	// BulkConcurrency bounds the number of instances resized at once by the
+	// bulk resize endpoint.
+	// If zero, DefaultBulkConcurrency is used.
+	BulkConcurrency int
+
+	// RecommendLookback is the utilization history which resize
+	// recommendations are based on.
+	// If zero, DefaultRecommendLookback is used.
+	RecommendLookback time.Duration
+
+	// Versions records the version of each user's sessions, allowing a user
+	// to log out of all their sessions at once.
+	// If nil, the session store is used if it implements SessionVersions,
+	// otherwise versions are kept in memory. See SessionVersions for the
+	// tradeoffs of each.
+	Versions SessionVersions
+
+	// RetryPolicy controls how the EC2 calls which stop, modify and start
+	// instances are retried after a transient error.
+	// If nil, DefaultRetryPolicy is used.
+	RetryPolicy *RetryPolicy
+
+	// AllowServerCreds offers a login option which uses the server's own AWS
+	// credentials, read from its shared credentials file, environment or
+	// instance role, instead of credentials typed into the login form.
+	//
+	// Enabling it lets anyone who can reach the login page act with the
+	// server's AWS permissions, so it should only be set if access to the app
+	// is restricted by other means, such as an authenticating proxy or a
+	// private network, and the credentials are limited to the permissions
+	// the app needs. The server's credentials are never sent to the
+	// browser, sessions only record that they're in use. Disabling it again
+	// invalidates those sessions.
+	AllowServerCreds bool
+
+	// SkipCredentialCheck specifies if credentials entered at login are
+	// accepted without asking AWS if they're valid, e.g. to try the app
+	// offline against a mock EC2 endpoint. Logins with an MFA code or a role
+	// still call STS.
+	SkipCredentialCheck bool
+
+	// LoginRateLimit bounds the failed logins allowed from each client IP.
+	// If nil, DefaultLoginRateLimit is used.
+	LoginRateLimit *LoginRateLimit
+
+	// TrustProxy specifies if the app is served behind a reverse proxy, in
+	// which case client IPs are read from the X-Forwarded-For header. It
+	// must not be set otherwise, as clients could claim any IP.
+	TrustProxy bool
+
+	// TokenKey is the secret key used to sign and encrypt the bearer tokens
+	// issued by /api/login. If empty, a random key is used, so tokens are
+	// invalidated when the app restarts and aren't accepted by other
+	// processes.
+	TokenKey []byte
+
+	// TokenMaxAge is how long tokens issued by /api/login are valid. Tokens
+	// holding temporary credentials expire with them if that's sooner.
+	// If zero, DefaultTokenMaxAge is used.
+	TokenMaxAge time.Duration
+
+	// Catalogs holds the translations of the UI text, keyed by lower case
+	// locale such as "fr" or "pt-br". Pages are rendered in the locale
+	// chosen through /locale, otherwise the best match for the browser's
+	// Accept-Language header, and text a catalog lacks is left in English.
+	// See LoadCatalogs for reading them from a directory.
+	// If empty, pages are only rendered in English.
+	Catalogs map[string]Catalog
 
-	tmplDir string
+	// TypeCache holds the instance types fetched from Source.
+	// NewApp initializes it with a TTL of DefaultTypeCacheTTL.
+	TypeCache *TypeCache
 
+	metrics         *metrics
+	priceCache      priceCache
+	spotCache       priceCache
+	versions        memoryVersions
+	usedNonces      usedNonces
+	locks           memoryLocker
+	states          stateTracker
+	loginLimiter    loginLimiter
+	serverAuthCache serverAuthCache
+	basicAuth       basicAuthCache
+	progress        progressHub
+	clientOnce      sync.Once
+	client          *http.Client
+	scrapeOnce      sync.Once
+	scraper         *http.Client
+	tokenKey        []byte
+	logMu           sync.Mutex
+
+	// background work, stopped by Shutdown
+	bgCtx     context.Context
+	bgCancel  context.CancelFunc
+	bg        sync.WaitGroup
+	closeOnce sync.Once
+	refreshMu sync.Mutex
+	refresh   RefreshStatus
+
+	store sessions.Store
+
+	// templates are read from tmplFS, tmplName names it in errors
+	tmplFS   fs.FS
+	tmplName string
+
+	tmplMu sync.RWMutex
 	tmpl   map[string]*template.Template
 	router http.Handler
 }
 
 // NewApp initializes an App by parsing templates, and initializing
 // the internal path router.
+// Any sessions.Store may be used, such as a CookieStore or a RedisStore.
 // If store is nil, a CookieStore with a random secret key is provided.
-func NewApp(static, templates string, store *sessions.CookieStore) (*App, error) {
-	app := &App{tmplDir: templates}
+func NewApp(static, templates string, store sessions.Store) (*App, error) {
+	return newApp(os.DirFS(static), os.DirFS(templates), templates, store)
+}
 
-	err := app.compileTemplates(templates)
+// NewAppFS is like NewApp, but serves static content and templates from file
+// systems, such as an embed.FS, rather than directories:
+//
+//	//go:embed public templates
+//	var assets embed.FS
+//
+//	static, _ := fs.Sub(assets, "public")
+//	templates, _ := fs.Sub(assets, "templates")
+//	app, err := resize.NewAppFS(static, templates, nil)
+//
+// The file systems are laid out like the directories passed to NewApp.
+func NewAppFS(static, templates fs.FS, store sessions.Store) (*App, error) {
+	return newApp(static, templates, "templates", store)
+}
+
+func newApp(static, templates fs.FS, tmplName string, store sessions.Store) (*App, error) {
+	app := &App{Source: WebScraperSource{}, tmplFS: templates, tmplName: tmplName, metrics: newMetrics()}
+	app.bgCtx, app.bgCancel = context.WithCancel(context.Background())
+	app.TypeCache = NewTypeCache(nil, DefaultTypeCacheTTL)
+	app.TypeCache.Source = appSource{app}
+
+	err := app.compileTemplates()
 	if err != nil {
 		return nil, fmt.Errorf("compiling templates %v", err)
 	}
@@ -61,20 +331,36 @@ func NewApp(static, templates string, store *sessions.CookieStore) (*App, error)
 		}
 		app.store = sessions.NewCookieStore(secretKey)
 	}
+	app.tokenKey = make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, app.tokenKey); err != nil {
+		return nil, err
+	}
 
 	// helper functions for serving static assets
 	serveDir := func(path string) http.Handler {
-		return http.FileServer(http.Dir(filepath.Join(static, path)))
+		dir, err := fs.Sub(static, path)
+		if err != nil {
+			// path is a constant, so this only happens if it's malformed
+			panic(err)
+		}
+		return http.FileServer(http.FS(dir))
 	}
 	serveFile := func(path string) http.Handler {
-		fp := filepath.Join(static, path)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, fp)
+			http.ServeFileFS(w, r, static, path)
 		})
 	}
 
 	// restrict a handle to only those which have logged in
 	restrict := func(hf http.HandlerFunc) http.Handler { return app.restrict(hf) }
+	// and to those which may resize instances
+	restrictResize := func(hf http.HandlerFunc) http.Handler {
+		return app.restrict(app.denyReadOnly(app.requireResize(hf)))
+	}
+	// and not at all while the app is read-only
+	restrictMutate := func(hf http.HandlerFunc) http.Handler {
+		return app.restrict(app.denyReadOnly(hf))
+	}
 
 	// Define routes
 	r := mux.NewRouter()
@@ -87,29 +373,104 @@ func NewApp(static, templates string, store *sessions.CookieStore) (*App, error)
 
 	r.HandleFunc("/login", app.handleLogin)
 	r.HandleFunc("/logout", app.handleLogout)
+	r.HandleFunc("/logout-everywhere", app.handleLogoutEverywhere)
 	r.HandleFunc("/about", app.handleAbout)
+	r.HandleFunc("/healthz", app.handleHealthz)
+	r.HandleFunc("/readyz", app.handleReadyz)
+	r.HandleFunc("/metrics", app.handleMetrics)
+	r.HandleFunc("/version", app.handleVersion)
+	r.HandleFunc("/locale", app.handleLocale)
+	r.HandleFunc("/api/login", app.handleAPILogin)
 
 	r.Handle("/", restrict(app.handleIndex))
 	r.Handle("/region", restrict(app.handleRegion))
+	r.Handle("/all-regions", restrict(app.handleAllRegions))
+	r.Handle("/profile", restrict(app.handleProfile))
+	r.Handle("/diagnostics", restrict(app.handleDiagnostics))
+	r.Handle("/diagnostics/drift", restrict(app.handleDrift))
 	r.Handle("/instance/{instance}", restrict(app.handleInstance))
+	r.Handle("/instance/{instance}/compare", restrict(app.handleCompare))
+	r.Handle("/instance/{instance}/state", restrict(app.handleInstanceState))
+	r.Handle("/instance/{instance}/recommend", restrict(app.handleRecommend))
+	r.Handle("/instance/{instance}/stop", restrictMutate(app.handleStop))
+	r.Handle("/instance/{instance}/start", restrictMutate(app.handleStart))
+	r.Handle("/instance-types", restrict(app.handleInstanceTypes))
+	r.Handle("/instance-types/{type}/regions", restrict(app.handleTypeRegions))
+	r.Handle("/api/instance-types", restrict(app.handleAPIInstanceTypes))
+	r.Handle("/api/instance-types.csv", restrict(app.handleAPIInstanceTypesCSV))
+	r.Handle("/api/instance-types.ndjson", restrict(app.handleAPIInstanceTypesNDJSON))
+	r.Handle("/api/instance-types/search", restrict(app.handleAPIInstanceTypesSearch))
+	r.Handle("/api/instance/{instance}/targets", restrict(app.handleAPITargets))
+	// the bulk API isn't restricted to sessions which may resize, as the
+	// permission is known per instance and its clients mostly authenticate
+	// without a session; denied resizes fail in the instance's result
+	r.Handle("/api/resize", restrictMutate(app.handleAPIBulkResize))
+	r.Handle("/instance/{instance}/resize/confirm", restrictResize(app.handleResizeConfirm))
 	r.Handle("/instance/{instance}/resize",
-		websocket.Handler(app.handleResize))
+		restrictResize(app.handleResize)).Methods("POST")
+	r.Handle("/instance/{instance}/resize",
+		app.denyReadOnly(websocket.Handler(app.handleResizeWS)))
+	r.Handle("/instance/{instance}/ws",
+		websocket.Handler(app.handleInstanceWS))
 	r.Handle("/instance/{instance}/assign-ip",
-		websocket.Handler(app.handleAssignIp))
+		app.denyReadOnly(websocket.Handler(app.handleAssignIp)))
 
 	r.NotFoundHandler = http.HandlerFunc(app.render404)
-	app.router = r
+	app.router = app.requestID(app.compress(app.csrf(r)))
 
 	return app, nil
 }
 
-// App implements the http.Handler interface
+// App implements the http.Handler interface. Responses to authenticated
+// requests carry the region they pertain to in the X-AWS-Region header. It's
+// the region selected when the request was made, so a response switching
+// regions names the previous one. Every response carries the ID of its
+// request in the X-Request-ID header, see RequestID.
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if base := app.basePath(); base != "" {
+		app.servePrefixed(w, r, base)
+		return
+	}
+	app.route(w, r)
+}
+
+// route serves r, whose path has had any base path stripped, with the app's
+// router. The region is looked up from the stripped path, as API credentials
+// are only accepted for paths under /api/.
+func (app *App) route(w http.ResponseWriter, r *http.Request) {
+	if region, ok := app.Region(r); ok {
+		w.Header().Set("X-AWS-Region", region)
+	}
 	app.router.ServeHTTP(w, r)
 }
 
-// Logf prints a message to the apps declared logger
+// Logf prints a message to the apps declared logger.
+// If JSONLog is set the message is logged as the "msg" field of a JSON
+// record.
 func (app *App) Logf(format string, a ...interface{}) {
+	if app.JSONLog {
+		app.writeJSONLog(Fields{"msg": fmt.Sprintf(format, a...)})
+		return
+	}
+	app.logf(format, a...)
+}
+
+// LogfContext behaves like Logf, adding the ID of the request ctx belongs
+// to, if any, as its request_id.
+func (app *App) LogfContext(ctx context.Context, format string, a ...interface{}) {
+	id := RequestID(ctx)
+	if id == "" {
+		app.Logf(format, a...)
+		return
+	}
+	if app.JSONLog {
+		app.writeJSONLog(Fields{"msg": fmt.Sprintf(format, a...), "request_id": id})
+		return
+	}
+	app.logf("%s request_id=%s", fmt.Sprintf(format, a...), id)
+}
+
+func (app *App) logf(format string, a ...interface{}) {
 	if app.Logger == nil {
 		log.Printf(format, a...)
 	} else {
@@ -117,9 +478,33 @@ func (app *App) Logf(format string, a ...interface{}) {
 	}
 }
 
+// region returns r with its EC2 endpoint replaced by the app's endpoint for
+// the region in RegionEndpoints or by the app's Endpoint, if set.
+func (app *App) region(r aws.Region) aws.Region {
+	if endpoint, ok := app.RegionEndpoints[r.Name]; ok {
+		r.EC2Endpoint = endpoint
+	} else if app.Endpoint != "" {
+		r.EC2Endpoint = app.Endpoint
+	}
+	return r
+}
+
+// newEC2 returns an EC2 client for region using the app's endpoint and HTTP
+// client.
+func (app *App) newEC2(auth aws.Auth, region aws.Region) *ec2.EC2 {
+	return ec2.NewWithClient(auth, app.region(region), app.ec2HTTPClient())
+}
+
 func (app *App) httpClient() *http.Client {
 	if app.HTTPClient == nil {
-		return aws.RetryingClient
+		return app.defaultHTTPClient()
 	}
 	return app.HTTPClient
 }
+
+func (app *App) scrapeClient() *http.Client {
+	if app.ScrapeClient == nil {
+		return app.defaultScrapeClient()
+	}
+	return app.ScrapeClient
+}
@@ -0,0 +1,65 @@
+package resize
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// The tags applied to an instance after it's resized if App.TagResized is
+// set.
+const (
+	resizedByTag    = "ResizedBy"
+	resizedAtTag    = "ResizedAt"
+	resizedFromTag  = "ResizedFrom"
+	resizeReasonTag = "ResizeReason"
+)
+
+// maxTagValueLength is the number of characters EC2 allows in a tag value.
+const maxTagValueLength = 256
+
+// resizeTags returns the tags recording that inst was resized from its
+// current type by principal at t. The reason tag is only included if reason
+// isn't blank, and is cut to the length EC2 allows.
+func resizeTags(inst ec2.Instance, principal, reason string, t time.Time) []ec2.Tag {
+	tags := []ec2.Tag{
+		{Key: resizedByTag, Value: principal},
+		{Key: resizedAtTag, Value: t.UTC().Format(time.RFC3339)},
+		{Key: resizedFromTag, Value: inst.InstanceType},
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return tags
+	}
+	if r := []rune(reason); len(r) > maxTagValueLength {
+		reason = string(r[:maxTagValueLength])
+	}
+	return append(tags, ec2.Tag{Key: resizeReasonTag, Value: reason})
+}
+
+// tagStep describes tagging inst after a resize, as a step of resizePlan.
+func tagStep(inst ec2.Instance) string {
+	return fmt.Sprintf("tag instance %s with %s, %s and %s", inst.InstanceId, resizedByTag, resizedAtTag, resizedFromTag)
+}
+
+// tagResized tags inst, which has just been resized, with resizeTags. The
+// resize has already succeeded, so a failure, such as the instance having as
+// many tags as EC2 allows, is logged and reported to w as a "warning" Event
+// instead of being returned.
+func (app *App) tagResized(ctx context.Context, ec2Cli *ec2.EC2, w io.Writer, inst ec2.Instance, reason string) {
+	tags := resizeTags(inst, principal(ec2Cli.Auth.AccessKey), reason, time.Now())
+	_, err := ec2Cli.CreateTags([]string{inst.InstanceId}, tags)
+	if err == nil {
+		return
+	}
+	app.LogfContext(ctx, "could not tag resized instance %s: %v", inst.InstanceId, err)
+	msg := fmt.Sprintf("The instance was resized, but could not be tagged: %v", err)
+	if e, ok := err.(*ec2.Error); ok && e.Code == "TagLimitExceeded" {
+		msg = "The instance was resized, but already has as many tags as EC2 allows, so the resize wasn't recorded in its tags."
+	}
+	writeEvent(w, Event{Status: "warning", Message: msg})
+}
@@ -0,0 +1,121 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const tagLimitResponse = `<Response><Errors><Error><Code>TagLimitExceeded</Code><Message>The maximum number of tags has been reached.</Message></Error></Errors><RequestID>1</RequestID></Response>`
+
+func TestResizeTags(t *testing.T) {
+	inst := ec2.Instance{InstanceId: "i-one", InstanceType: "m1.small"}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	tags := resizeTags(inst, "AKIDEXAM...", "  ", at)
+	want := []ec2.Tag{
+		{Key: "ResizedBy", Value: "AKIDEXAM..."},
+		{Key: "ResizedAt", Value: "2024-03-01T11:30:00Z"},
+		{Key: "ResizedFrom", Value: "m1.small"},
+	}
+	if fmt.Sprint(tags) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, tags)
+	}
+
+	tags = resizeTags(inst, "AKIDEXAM...", strings.Repeat("é", 300), at)
+	if len(tags) != 4 || tags[3].Key != "ResizeReason" || len([]rune(tags[3].Value)) != maxTagValueLength {
+		t.Errorf("expected the reason to be cut to %d characters, got %v", maxTagValueLength, tags)
+	}
+}
+
+func TestTagResized(t *testing.T) {
+	var mu sync.Mutex
+	tagged := map[string]map[string]string{}
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("Action") {
+		case "DescribeInstances":
+			fmt.Fprintf(w, stoppedInstanceResponse, r.FormValue("InstanceId.1"), "m1.small")
+		case "ModifyInstanceAttribute":
+			fmt.Fprint(w, modifyInstanceResponse)
+		case "CreateTags":
+			id := r.FormValue("ResourceId.1")
+			if id == "i-full" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, tagLimitResponse)
+				return
+			}
+			tags := map[string]string{}
+			for i := 1; r.FormValue(fmt.Sprintf("Tag.%d.Key", i)) != ""; i++ {
+				tags[r.FormValue(fmt.Sprintf("Tag.%d.Key", i))] = r.FormValue(fmt.Sprintf("Tag.%d.Value", i))
+			}
+			mu.Lock()
+			tagged[id] = tags
+			mu.Unlock()
+			fmt.Fprint(w, `<CreateTagsResponse><requestId>1</requestId><return>true</return></CreateTagsResponse>`)
+		default:
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TagResized = true
+	app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}, nil)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "AKIDEXAMPLE", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	post := func(body string) bulkResizeResponse {
+		r, _ := http.NewRequest("POST", "/api/resize", strings.NewReader(body))
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.handleAPIBulkResize(w, r)
+		var resp bulkResizeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+		return resp
+	}
+
+	resp := post(`{"instance_ids":["i-one","i-full"],"new_type":"m1.large","reason":"more memory for the batch jobs"}`)
+	if resp.Succeeded != 2 {
+		t.Fatalf("expected both resizes to succeed, got %+v", resp.Results)
+	}
+	tags := tagged["i-one"]
+	if tags["ResizedBy"] != principal("AKIDEXAMPLE") || tags["ResizedFrom"] != "m1.small" || tags["ResizeReason"] != "more memory for the batch jobs" {
+		t.Errorf("expected i-one to be tagged with the resize, got %v", tags)
+	}
+	if _, err := time.Parse(time.RFC3339, tags["ResizedAt"]); err != nil {
+		t.Errorf("expected an RFC 3339 ResizedAt tag, got %q", tags["ResizedAt"])
+	}
+	steps := strings.Join(resp.Results[1].Steps, "\n")
+	if !strings.Contains(steps, "already has as many tags as EC2 allows") {
+		t.Errorf("expected the tag limit to be reported, got %q", steps)
+	}
+
+	delete(tagged, "i-one")
+	resp = post(`{"instance_ids":["i-one"],"new_type":"m1.large","dry_run":true}`)
+	if len(tagged) != 0 {
+		t.Errorf("expected a dry run not to tag instances, got %v", tagged)
+	}
+	if steps := strings.Join(resp.Results[0].Steps, "\n"); !strings.Contains(steps, "dry run: would tag instance i-one") {
+		t.Errorf("expected the dry run to report the tagging, got %q", steps)
+	}
+}
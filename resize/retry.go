@@ -0,0 +1,117 @@
+package resize
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// RetryPolicy controls how mutating EC2 calls are retried after a transient
+// error, such as the request being throttled. The delay before each retry is
+// chosen at random between zero and BaseDelay doubled for every failed
+// attempt, up to MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts caps the number of times a call is made, including the
+	// first. Values less than one are treated as one.
+	MaxAttempts int
+
+	// BaseDelay is the upper bound of the delay before the first retry.
+	BaseDelay time.Duration
+
+	// MaxDelay is the upper bound of the delay before any retry.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used if App.RetryPolicy is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// retryableCodes are the EC2 error codes which indicate a call may succeed if
+// it's made again.
+var retryableCodes = map[string]bool{
+	"RequestLimitExceeded": true,
+	"Throttling":           true,
+	"InternalError":        true,
+	"ServiceUnavailable":   true,
+	"Unavailable":          true,
+}
+
+// retryable reports if err is a transient error returned by EC2.
+func retryable(err error) bool {
+	ec2Err, ok := err.(*ec2.Error)
+	return ok && retryableCodes[ec2Err.Code]
+}
+
+// sleep waits for d, or until ctx is done in which case ctx's error is
+// returned. It's replaced by tests to avoid waiting between attempts.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callContext calls op in a goroutine and returns its error, or ctx's error
+// if ctx is done first. The vendored EC2 client doesn't accept a context, so
+// op is left running when ctx is done, and the action it asked AWS to take
+// may still complete.
+func callContext(ctx context.Context, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do calls op until it succeeds, returns an error which isn't retryable, or
+// the policy's attempts are exhausted. The last error is returned. Once ctx is
+// done op isn't called again, and its error is returned instead.
+func (p RetryPolicy) do(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = callContext(ctx, op); err == nil || !retryable(err) || attempt+1 >= p.MaxAttempts {
+			return err
+		}
+		if err := sleep(ctx, p.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns a random delay to wait after the given failed attempt,
+// counting from zero.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (app *App) retryPolicy() RetryPolicy {
+	if app.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *app.RetryPolicy
+}
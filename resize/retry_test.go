@@ -0,0 +1,116 @@
+package resize
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const throttledResponse = `<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`
+
+const modifyInstanceResponse = `<ModifyInstanceAttributeResponse><requestId>1</requestId><return>true</return></ModifyInstanceAttributeResponse>`
+
+// failingEC2 returns a client for a fake EC2 endpoint which responds to the
+// first failures requests with errBody, and succeeds after that. The number
+// of requests made is recorded in calls.
+func failingEC2(t *testing.T, failures int32, status int, errBody string, calls *int32) *ec2.EC2 {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			w.WriteHeader(status)
+			fmt.Fprint(w, errBody)
+			return
+		}
+		fmt.Fprint(w, modifyInstanceResponse)
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	t.Cleanup(s.Close)
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	return ec2.NewWithClient(aws.Auth{AccessKey: "key", SecretKey: "secret"}, region, http.DefaultClient)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var delays []time.Duration
+	defer func(orig func(context.Context, time.Duration) error) { sleep = orig }(sleep)
+	sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		body     string
+		calls    int32
+		ok       bool
+	}{
+		{"succeeds after throttling", 3, http.StatusServiceUnavailable, throttledResponse, 4, true},
+		{"gives up after max attempts", 10, http.StatusServiceUnavailable, throttledResponse, 4, false},
+		{"fails fast on other errors", 10, http.StatusUnauthorized, ec2ErrorResponse, 1, false},
+	}
+	for _, tt := range tests {
+		delays = nil
+		var calls int32
+		ec2Cli := failingEC2(t, tt.failures, tt.status, tt.body, &calls)
+		err := resize(context.Background(), ec2Cli, "i-1234", "t2.medium", policy)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+		if calls != tt.calls {
+			t.Errorf("%s: expected %d requests, got %d", tt.name, tt.calls, calls)
+		}
+		if len(delays) != int(tt.calls)-1 {
+			t.Errorf("%s: expected %d retries, got %d", tt.name, tt.calls-1, len(delays))
+		}
+		for _, d := range delays {
+			if d < 0 || d >= policy.MaxDelay {
+				t.Errorf("%s: delay %s outside [0, %s)", tt.name, d, policy.MaxDelay)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func(orig func(context.Context, time.Duration) error) { sleep = orig }(sleep)
+	// the client goes away while waiting to retry
+	sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	var calls int32
+	ec2Cli := failingEC2(t, 10, http.StatusServiceUnavailable, throttledResponse, &calls)
+	err := resize(ctx, ec2Cli, "i-1234", "t2.medium", DefaultRetryPolicy)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected the resize to be canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected no retries once canceled, got %d requests", calls)
+	}
+
+	// a call which doesn't return is abandoned once the context is done
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	err = callContext(ctx, func() error {
+		<-block
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package resize
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// SessionVersions keeps a version number per user, which is stored in each
+// session at login. Incrementing a user's version revokes all of their
+// existing sessions, including copies of a session cookie held by someone
+// else.
+//
+// With a sessions.CookieStore the session lives entirely in the client's
+// cookie, so logging out can only clear the cookie of the current browser and
+// the version check is the only way to reject other copies. The App's default
+// in-memory versions are neither shared between processes nor kept across
+// restarts; after a restart revoked sessions stay revoked, but sessions
+// created before any revocation become valid again. A server-side store such
+// as RedisStore implements SessionVersions itself, so versions are shared by
+// every process using the same Redis server and survive restarts.
+type SessionVersions interface {
+	// SessionVersion returns the current version of user's sessions.
+	SessionVersion(user string) (int64, error)
+
+	// RevokeSessions increments the version of user's sessions.
+	RevokeSessions(user string) error
+}
+
+// memoryVersions holds session versions in memory.
+type memoryVersions struct {
+	mu       sync.Mutex
+	versions map[string]int64
+}
+
+func (m *memoryVersions) SessionVersion(user string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.versions[user], nil
+}
+
+func (m *memoryVersions) RevokeSessions(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.versions == nil {
+		m.versions = make(map[string]int64)
+	}
+	m.versions[user]++
+	return nil
+}
+
+// SessionVersion returns the version of a user's sessions, stored in Redis
+// under the store's KeyPrefix.
+func (s *RedisStore) SessionVersion(user string) (int64, error) {
+	reply, err := s.do("GET", s.KeyPrefix+"version_"+user)
+	if err != nil {
+		return 0, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(data, 10, 64)
+}
+
+// RevokeSessions increments the version of a user's sessions.
+func (s *RedisStore) RevokeSessions(user string) error {
+	_, err := s.do("INCR", s.KeyPrefix+"version_"+user)
+	return err
+}
+
+// sessionVersions returns the app's SessionVersions.
+func (app *App) sessionVersions() SessionVersions {
+	if app.Versions != nil {
+		return app.Versions
+	}
+	if v, ok := app.store.(SessionVersions); ok {
+		return v
+	}
+	return &app.versions
+}
+
+// revoked reports if the request session was created before its user's
+// sessions were last revoked. Sessions are treated as revoked if their
+// version can't be checked.
+func (app *App) revoked(r *http.Request) bool {
+	session, _ := app.store.Get(r, "yhat-resize")
+	user, ok := session.Values["user"].(string)
+	if !ok {
+		return false
+	}
+	version, _ := session.Values["version"].(int64)
+	current, err := app.sessionVersions().SessionVersion(user)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not check session version: %v", err)
+		return true
+	}
+	return version != current
+}
+
+// logoutEverywhere revokes all sessions of the request's user, then logs out
+// the current session.
+func (app *App) logoutEverywhere(w http.ResponseWriter, r *http.Request) error {
+	session, _ := app.store.Get(r, "yhat-resize")
+	if user, ok := session.Values["user"].(string); ok {
+		if err := app.sessionVersions().RevokeSessions(user); err != nil {
+			return err
+		}
+	}
+	app.logout(w, r)
+	return nil
+}
@@ -0,0 +1,156 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits on the number of matches returned by handleAPIInstanceTypesSearch.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// Ranks of matches of a search query against an instance type's name, best
+// first.
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+)
+
+// typeMatch is an instance type matching a search query.
+type typeMatch struct {
+	typ  InstanceType
+	rank int
+	// span is the length of the part of the name holding the query, so
+	// tighter fuzzy matches rank first
+	span int
+}
+
+// matchName matches query, which must be lower case, against name ignoring
+// case. If fuzzy is set the query's characters may be spread through the
+// name, so "m5l" matches "m5.large".
+func matchName(name, query string, fuzzy bool) (rank, span int, ok bool) {
+	name = strings.ToLower(name)
+	switch {
+	case name == query:
+		return matchExact, len(name), true
+	case strings.HasPrefix(name, query):
+		return matchPrefix, len(name), true
+	case strings.Contains(name, query):
+		return matchSubstring, len(name), true
+	case !fuzzy:
+		return 0, 0, false
+	}
+	start, i := -1, 0
+	for j := 0; j < len(name) && i < len(query); j++ {
+		if name[j] == query[i] {
+			if start < 0 {
+				start = j
+			}
+			i++
+			if i == len(query) {
+				return matchFuzzy, j + 1 - start, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// searchTypes returns at most limit instance types whose name matches query,
+// ranking exact matches first, then those starting with the query, containing
+// it and, if fuzzy is set, containing its characters in order. Types ranked
+// the same are sorted by the length of their name, then by name.
+func searchTypes(types []InstanceType, query string, fuzzy bool, limit int) []InstanceType {
+	query = strings.ToLower(strings.TrimSpace(query))
+	var matches []typeMatch
+	for _, t := range types {
+		if rank, span, ok := matchName(t.Name, query, fuzzy); ok {
+			matches = append(matches, typeMatch{typ: t, rank: rank, span: span})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.span != b.span {
+			return a.span < b.span
+		}
+		if len(a.typ.Name) != len(b.typ.Name) {
+			return len(a.typ.Name) < len(b.typ.Name)
+		}
+		return a.typ.Name < b.typ.Name
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	found := make([]InstanceType, len(matches))
+	for i, m := range matches {
+		found[i] = m.typ
+	}
+	return found
+}
+
+// searchResponse is the JSON response of handleAPIInstanceTypesSearch.
+type searchResponse struct {
+	Query         string         `json:"query"`
+	InstanceTypes []InstanceType `json:"instance_types"`
+}
+
+// Path: /api/instance-types/search
+//
+// handleAPIInstanceTypesSearch serves the instance types whose name contains
+// the "q" query parameter, ignoring case, as JSON for autocompletion. Names
+// starting with it are listed first. If the "fuzzy" parameter is true, names
+// containing its characters in order match too. At most "limit" types are
+// returned, 10 by default.
+func (app *App) handleAPIInstanceTypesSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		app.renderJSONError(w, errNoTypes.Error(), http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		app.renderJSONError(w, "no search query given in q", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			app.renderJSONError(w, fmt.Sprintf("expected positive number for limit, got '%s'", s), http.StatusBadRequest)
+			return
+		}
+		if n > maxSearchLimit {
+			n = maxSearchLimit
+		}
+		limit = n
+	}
+	fuzzy := false
+	if s := q.Get("fuzzy"); s != "" {
+		var err error
+		if fuzzy, err = strconv.ParseBool(s); err != nil {
+			app.renderJSONError(w, fmt.Sprintf("expected boolean for fuzzy, got '%s'", s), http.StatusBadRequest)
+			return
+		}
+	}
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get instance types: %v", err)
+		app.renderJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	resp := searchResponse{Query: query, InstanceTypes: searchTypes(types, query, fuzzy, limit)}
+	app.renderJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package resize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAPIInstanceTypesSearch(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m5.large"},
+		{Name: "m5.xlarge"},
+		{Name: "m5"},
+		{Name: "am5.large"},
+		{Name: "c5.large"},
+		{Name: "m5d.24xlarge"},
+		{Name: "r5.large"},
+	}, nil)
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		// exact, then prefix, then substring matches
+		{"?q=M5", http.StatusOK, []string{"m5", "m5.large", "m5.xlarge", "m5d.24xlarge", "am5.large"}},
+		{"?q=m5&limit=2", http.StatusOK, []string{"m5", "m5.large"}},
+		{"?q=.large", http.StatusOK, []string{"c5.large", "m5.large", "r5.large", "am5.large"}},
+		{"?q=m5l", http.StatusOK, []string{}},
+		// tighter fuzzy matches first
+		{"?q=m5l&fuzzy=true", http.StatusOK, []string{"m5.large", "am5.large", "m5.xlarge", "m5d.24xlarge"}},
+		{"?q=", http.StatusBadRequest, nil},
+		{"?q=m5&limit=0", http.StatusBadRequest, nil},
+		{"?q=m5&fuzzy=maybe", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/instance-types/search"+tt.query, nil)
+		app.handleAPIInstanceTypesSearch(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp searchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: decoding response: %v", tt.query, err)
+			continue
+		}
+		got := []string{}
+		for _, typ := range resp.InstanceTypes {
+			got = append(got, typ.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package resize
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// serverUser is the user sessions logged in with the server's credentials
+// belong to, so logging out everywhere ends all of them at once.
+const serverUser = "server"
+
+// serverAuthTTL is how long the server's credentials are reused before they
+// are looked up again. Credentials from an instance role are rotated, and
+// looking them up means a request to the instance metadata service, so
+// they're neither kept forever nor read on every request.
+const serverAuthTTL = 5 * time.Minute
+
+// serverAuthCache holds the server's credentials. It is safe for concurrent
+// use.
+type serverAuthCache struct {
+	mu        sync.Mutex
+	auth      aws.Auth
+	fetchedAt time.Time
+}
+
+// serverAuth returns the credentials of the server itself, read from the
+// shared credentials file, the environment or the instance's role, in that
+// order. See aws.GetAuth.
+func (app *App) serverAuth() (aws.Auth, error) {
+	c := &app.serverAuthCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < serverAuthTTL {
+		return c.auth, nil
+	}
+	auth, err := aws.GetAuth("", "")
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	c.auth, c.fetchedAt = auth, time.Now()
+	return auth, nil
+}
+
+// loginServer logs in with the server's credentials. The session only records
+// that they're in use, the credentials themselves are never sent to the
+// browser.
+// On an authentication error, error will be of type *ec2.Error
+func (app *App) loginServer(w http.ResponseWriter, r *http.Request) error {
+	auth, err := app.serverAuth()
+	if err != nil {
+		return err
+	}
+	ec2Cli := app.newEC2(auth, defaultRegion)
+	if err := app.validateCreds(ec2Cli); err != nil {
+		return err
+	}
+	return app.startSession(w, r, serverUser, ec2Cli, time.Time{}, true)
+}
+
+// usesServerCreds reports if the request's session was logged in with the
+// server's credentials.
+func (app *App) usesServerCreds(r *http.Request) bool {
+	session, _ := app.store.Get(r, "yhat-resize")
+	server, _ := session.Values["server"].(bool)
+	return server
+}
@@ -0,0 +1,122 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestServerCreds(t *testing.T) {
+	t.Setenv("AWS_CREDENTIAL_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "server-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "server-s3cr3t")
+
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		// instances are listed with signature version 4, other calls with
+		// version 2
+		signed := r.FormValue("AWSAccessKeyId") == "server-key" ||
+			strings.Contains(r.Header.Get("Authorization"), "Credential=server-key/")
+		if !signed {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-server")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	s := httptest.NewServer(app)
+	defer s.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	get := func(path string) (*http.Response, string) {
+		resp, err := cli.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	_, body := get("/login")
+	if strings.Contains(body, `id="serverCreds"`) {
+		t.Error("expected no server credentials option unless enabled")
+	}
+	m := csrfMeta.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token rendered in page")
+	}
+	form := url.Values{"serverCreds": {"1"}, DefaultCSRFFieldName: {m[1]}}
+	resp, err := cli.PostForm(s.URL+"/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected server credentials to be refused unless enabled, got %s", resp.Status)
+	}
+
+	app.AllowServerCreds = true
+	if _, body := get("/login"); !strings.Contains(body, `id="serverCreds"`) {
+		t.Error("expected server credentials option once enabled")
+	}
+	resp, err = cli.PostForm(s.URL+"/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected login with server credentials to succeed, got %s", resp.Status)
+	}
+	if resp, body := get("/"); resp.StatusCode != http.StatusOK || !strings.Contains(body, "i-server") {
+		t.Errorf("expected instances listed with server credentials, got %s: %s", resp.Status, body)
+	}
+
+	// the session records that server credentials are in use without
+	// holding them
+	r, _ := http.NewRequest("GET", s.URL+"/", nil)
+	for _, c := range jar.Cookies(r.URL) {
+		r.AddCookie(c)
+	}
+	session, err := app.store.Get(r, "yhat-resize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, _ := session.Values["server"].(bool); !server {
+		t.Error("expected session to record server credentials")
+	}
+	if ec2Cli, _ := session.Values["ec2"].(*ec2.EC2); ec2Cli == nil || ec2Cli.Auth.SecretKey != "" {
+		t.Errorf("expected session to not hold the server's credentials, got %+v", ec2Cli)
+	}
+
+	app.AllowServerCreds = false
+	if resp, _ := get("/"); resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("expected sessions with server credentials to end once disabled, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,109 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionOptions configures the cookies of the sessions an App creates.
+type SessionOptions struct {
+	Path   string
+	Domain string
+
+	// MaxAge is the number of seconds a session lasts without being used.
+	// Each request to a page requiring a login extends the session by
+	// MaxAge. Zero makes sessions last until the browser is closed.
+	MaxAge int
+
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// DefaultSessionOptions are used when an App's SessionOptions are nil.
+var DefaultSessionOptions = SessionOptions{
+	Path:     "/",
+	MaxAge:   86400 * 30,
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode,
+}
+
+// SessionKeyEnv is the environment variable the resize command reads the
+// session secret from if it isn't given on the command line.
+const SessionKeyEnv = "RESIZE_SESSION_KEY"
+
+// MinSessionKeyLength is the length in bytes of the shortest session secret
+// CheckSessionKey accepts.
+const MinSessionKeyLength = 32
+
+// CheckSessionKey returns an error if secret is too short to sign session
+// cookies and API tokens with. Apps given the same secret, because they're
+// restarted or run behind a load balancer, accept each other's sessions, so it
+// must be hard to guess.
+func CheckSessionKey(secret []byte) error {
+	if len(secret) < MinSessionKeyLength {
+		return fmt.Errorf("session key is %d bytes long, it must be at least %d", len(secret), MinSessionKeyLength)
+	}
+	return nil
+}
+
+func (app *App) sessionOptions() SessionOptions {
+	if app.SessionOptions == nil {
+		return DefaultSessionOptions
+	}
+	return *app.SessionOptions
+}
+
+// cookieOptions returns the options understood by the sessions package.
+func (o SessionOptions) cookieOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   o.Secure,
+		HttpOnly: o.HttpOnly,
+	}
+}
+
+// saveSession saves a session with the given cookie options, adding the
+// app's SameSite attribute to its cookie. If opts is nil the app's
+// SessionOptions are used.
+func (app *App) saveSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, opts *sessions.Options) error {
+	if opts == nil {
+		opts = app.sessionOptions().cookieOptions()
+	}
+	session.Options = opts
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+	sameSite := app.sessionOptions().SameSite
+	if sameSite == http.SameSiteDefaultMode {
+		return nil
+	}
+	// the vendored sessions package predates SameSite, so add it to the
+	// cookie written by Save
+	attr := (&http.Cookie{Name: "x", SameSite: sameSite}).String()
+	attr = attr[strings.Index(attr, ";"):]
+	cookies := w.Header()["Set-Cookie"]
+	for i, c := range cookies {
+		if strings.HasPrefix(c, session.Name()+"=") && !strings.Contains(c, "SameSite=") {
+			cookies[i] = c + attr
+		}
+	}
+	return nil
+}
+
+// refreshSession extends the lifetime of the request's login session by
+// saving it again, so only idle sessions expire.
+func (app *App) refreshSession(w http.ResponseWriter, r *http.Request) {
+	if app.sessionOptions().MaxAge <= 0 {
+		return
+	}
+	session, _ := app.store.Get(r, "yhat-resize")
+	if err := app.saveSession(w, r, session, nil); err != nil {
+		app.LogfContext(r.Context(), "could not refresh session: %v", err)
+	}
+}
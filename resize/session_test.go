@@ -0,0 +1,85 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestSessionCookieFlags(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)
+
+	tests := []struct {
+		opts *SessionOptions
+		want []string
+	}{
+		{nil, []string{"HttpOnly", "SameSite=Lax", "Max-Age=2592000"}},
+		{
+			&SessionOptions{Path: "/", MaxAge: 600, Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode},
+			[]string{"HttpOnly", "Secure", "SameSite=Strict", "Max-Age=600"},
+		},
+	}
+	for _, tt := range tests {
+		app.SessionOptions = tt.opts
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if err := app.set(w, r, ec2Cli); err != nil {
+			t.Fatal(err)
+		}
+		var header string
+		for _, c := range w.Header()["Set-Cookie"] {
+			if strings.HasPrefix(c, "yhat-resize=") {
+				header = c
+			}
+		}
+		if header == "" {
+			t.Fatal("no session cookie set")
+		}
+		for _, attr := range tt.want {
+			if !strings.Contains(header, "; "+attr) {
+				t.Errorf("expected %s in Set-Cookie header %q", attr, header)
+			}
+		}
+	}
+}
+
+func TestCheckSessionKey(t *testing.T) {
+	if err := CheckSessionKey([]byte("too short")); err == nil {
+		t.Errorf("expected a short key to be rejected")
+	}
+	key := []byte(strings.Repeat("k", MinSessionKeyLength))
+	if err := CheckSessionKey(key); err != nil {
+		t.Errorf("expected a key of %d bytes to be accepted: %v", MinSessionKeyLength, err)
+	}
+
+	// apps sharing a key accept each other's sessions
+	apps := make([]*App, 2)
+	for i := range apps {
+		app, err := NewApp("../public", "../templates", sessions.NewCookieStore(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps[i] = app
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := apps[0].set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if _, ok := apps[1].creds(r); !ok {
+		t.Errorf("expected the session to be valid for another app with the same key")
+	}
+}
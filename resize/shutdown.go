@@ -0,0 +1,54 @@
+package resize
+
+import (
+	"context"
+	"io"
+)
+
+// goBackground runs f in a goroutine which Shutdown cancels and waits for.
+// ctx is canceled once Shutdown is called.
+func (app *App) goBackground(f func(ctx context.Context)) {
+	app.bg.Add(1)
+	go func() {
+		defer app.bg.Done()
+		f(app.bgCtx)
+	}()
+}
+
+// Shutdown stops the app's background work, such as refreshing cached
+// instance types, and closes the session store if it implements io.Closer,
+// e.g. a RedisStore. It waits for background goroutines to return until ctx
+// is done, in which case ctx's error is returned after the store is closed.
+//
+// Shutdown doesn't wait for requests being served. To drain them, serve the
+// app with an http.Server and shut the server down first:
+//
+//	srv := &http.Server{Addr: ":8080", Handler: app}
+//	go srv.ListenAndServe()
+//	<-interrupt
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	srv.Shutdown(ctx)
+//	app.Shutdown(ctx)
+func (app *App) Shutdown(ctx context.Context) error {
+	app.bgCancel()
+	done := make(chan struct{})
+	go func() {
+		app.bg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	app.closeOnce.Do(func() {
+		if c, ok := app.store.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
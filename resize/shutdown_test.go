@@ -0,0 +1,67 @@
+package resize
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// closingStore records whether the store was closed.
+type closingStore struct {
+	*sessions.CookieStore
+	closed int
+}
+
+func (s *closingStore) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	store := &closingStore{CookieStore: sessions.NewCookieStore([]byte("secret"))}
+	app, err := NewApp("../public", "../templates", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	app.goBackground(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("expected Shutdown to wait for background goroutines")
+	}
+	if store.closed != 1 {
+		t.Errorf("expected the session store to be closed once, got %d", store.closed)
+	}
+	if err := app.Shutdown(context.Background()); err != nil || store.closed != 1 {
+		t.Errorf("expected a second Shutdown not to close the store again")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	store := &closingStore{CookieStore: sessions.NewCookieStore([]byte("secret"))}
+	app, err := NewApp("../public", "../templates", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	app.goBackground(func(ctx context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := app.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if store.closed != 1 {
+		t.Errorf("expected the session store to be closed after timing out")
+	}
+}
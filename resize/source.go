@@ -0,0 +1,236 @@
+package resize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// An InstanceTypeSource provides the EC2 instance types which can be offered
+// as resize targets.
+type InstanceTypeSource interface {
+	// Fetch retrieves the current instance types, using client for any
+	// HTTP requests.
+	Fetch(client *http.Client) ([]InstanceType, error)
+}
+
+// A ContextSource is an InstanceTypeSource whose fetches can be canceled.
+type ContextSource interface {
+	InstanceTypeSource
+
+	// FetchContext behaves like Fetch, with any requests bound by ctx.
+	FetchContext(ctx context.Context, client *http.Client) ([]InstanceType, error)
+}
+
+// A FallbackSource is a ContextSource which may fall back to secondary data,
+// such as an archived copy of a page, when its primary data can't be used.
+type FallbackSource interface {
+	ContextSource
+
+	// FetchFallback behaves like FetchContext. If the types came from the
+	// fallback, primaryErr is the error which made it necessary.
+	FetchFallback(ctx context.Context, client *http.Client) (types []InstanceType, primaryErr error, err error)
+}
+
+// fetchTypes fetches instance types from src, passing ctx along if src is a
+// ContextSource. primaryErr is set if src is a FallbackSource which served
+// its fallback.
+func fetchTypes(ctx context.Context, src InstanceTypeSource, client *http.Client) (types []InstanceType, primaryErr error, err error) {
+	switch src := src.(type) {
+	case FallbackSource:
+		return src.FetchFallback(ctx, client)
+	case ContextSource:
+		types, err = src.FetchContext(ctx, client)
+	default:
+		types, err = src.Fetch(client)
+	}
+	return types, nil, err
+}
+
+// typesRetryAfter is how long clients are asked to wait before trying again
+// when the instance types can't be fetched.
+const typesRetryAfter = time.Minute
+
+// typesUnavailableError is returned when the instance types needed to check
+// a request can't be fetched, such as when the AWS website is down. It's
+// usually temporary, unlike the request being invalid.
+type typesUnavailableError struct {
+	err error
+}
+
+func (e typesUnavailableError) Error() string {
+	return fmt.Sprintf("could not get instance types: %v", e.err)
+}
+
+// WebScraperSource is an InstanceTypeSource which scrapes the instance types
+// matrix from the AWS website.
+type WebScraperSource struct {
+	// URL is the page holding the instance types matrix, such as an internal
+	// mirror or an archived copy of the AWS page.
+	// If empty, DefaultInstanceTypeURL is used.
+	URL string
+
+	// FallbackURL is an archived copy of the page, such as a pinned
+	// web.archive.org snapshot, which is scraped if the page at URL is
+	// fetched but can't be parsed, e.g. because its layout changed. The
+	// types it lists may be out of date.
+	// If empty, there is no fallback.
+	FallbackURL string
+}
+
+// NewWebScraperSource returns a WebScraperSource which scrapes the page at
+// rawURL. An error is returned unless rawURL is an absolute http or https
+// URL.
+func NewWebScraperSource(rawURL string) (WebScraperSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return WebScraperSource{}, fmt.Errorf("invalid instance types URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebScraperSource{}, fmt.Errorf("invalid instance types URL '%s': expected an http or https URL", rawURL)
+	}
+	return WebScraperSource{URL: rawURL}, nil
+}
+
+func (s WebScraperSource) url() string {
+	if s.URL == "" {
+		return DefaultInstanceTypeURL
+	}
+	return s.URL
+}
+
+// Fetch scrapes the instance types from the source's URL with the provided
+// client, see InstanceTypes.
+func (s WebScraperSource) Fetch(client *http.Client) ([]InstanceType, error) {
+	return s.FetchContext(context.Background(), client)
+}
+
+// FetchContext behaves like Fetch, with the request bound by ctx.
+func (s WebScraperSource) FetchContext(ctx context.Context, client *http.Client) ([]InstanceType, error) {
+	types, _, err := s.FetchFallback(ctx, client)
+	return types, err
+}
+
+// FetchFallback behaves like FetchContext, scraping the page at FallbackURL
+// if the page at URL can't be parsed. primaryErr is the *ScrapeError of the
+// page at URL if the fallback was used. If the fallback fails too, its error
+// is returned.
+func (s WebScraperSource) FetchFallback(ctx context.Context, client *http.Client) (types []InstanceType, primaryErr error, err error) {
+	types, err = scrapeInstanceTypes(ctx, client, s.url(), false)
+	if err == nil || s.FallbackURL == "" || !isParseError(err) {
+		return types, nil, err
+	}
+	types, ferr := scrapeInstanceTypes(ctx, client, s.FallbackURL, false)
+	if ferr != nil {
+		return nil, nil, fmt.Errorf("%v; fallback: %v", err, ferr)
+	}
+	return types, err, nil
+}
+
+// isParseError reports if err is a *ScrapeError for a page which was fetched
+// but couldn't be parsed, or which requires JavaScript.
+func isParseError(err error) bool {
+	var scrapeErr *ScrapeError
+	var challenge *ChallengeError
+	return errors.As(err, &challenge) || errors.As(err, &scrapeErr) && scrapeErr.StatusCode == http.StatusOK
+}
+
+// StaticSource is an InstanceTypeSource which always returns the same
+// instance types, or error. It makes no requests, so it can be used to test
+// handlers without network access.
+type StaticSource struct {
+	types []InstanceType
+	err   error
+}
+
+// NewStaticSource returns a StaticSource which provides types. If err is
+// non-nil, every fetch fails with err instead.
+func NewStaticSource(types []InstanceType, err error) *StaticSource {
+	return &StaticSource{types: types, err: err}
+}
+
+// Fetch returns the source's instance types or error, ignoring client.
+func (s *StaticSource) Fetch(*http.Client) ([]InstanceType, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.types, nil
+}
+
+// ReadInstanceTypes reads a JSON array of instance types, such as a list of
+// StaticTypes or a saved /api/instance-types response's "instance_types".
+// Types must be named, other fields may be left out.
+func ReadInstanceTypes(r io.Reader) ([]InstanceType, error) {
+	var types []InstanceType
+	if err := json.NewDecoder(r).Decode(&types); err != nil {
+		return nil, fmt.Errorf("invalid instance types: %v", err)
+	}
+	for i, t := range types {
+		if t.Name == "" {
+			return nil, fmt.Errorf("invalid instance types: type %d has no name", i)
+		}
+	}
+	return types, nil
+}
+
+var errNoTypes = errors.New("instance types are unavailable, as scraping is disabled")
+
+// typesAvailable reports if the app has instance types to offer, which is
+// only false if scraping is disabled and no static types are given.
+func (app *App) typesAvailable() bool {
+	return !app.DisableScraper || len(app.StaticTypes) > 0
+}
+
+// appSource fetches instance types from an App's configured Source using the
+// App's HTTP client. Both are looked up on each fetch since they may be set
+// after NewApp returns.
+type appSource struct {
+	app *App
+}
+
+func (s appSource) Fetch(client *http.Client) ([]InstanceType, error) {
+	return s.FetchContext(context.Background(), client)
+}
+
+func (s appSource) FetchContext(ctx context.Context, client *http.Client) ([]InstanceType, error) {
+	types, _, err := s.FetchFallback(ctx, client)
+	return types, err
+}
+
+// FetchFallback fetches from the App's Source, logging a "scrape_fallback"
+// event if it served its fallback.
+func (s appSource) FetchFallback(ctx context.Context, _ *http.Client) ([]InstanceType, error, error) {
+	if s.app.DisableScraper {
+		return s.app.StaticTypes, nil, nil
+	}
+	src := s.app.Source
+	if src == nil {
+		src = WebScraperSource{}
+	}
+	start := time.Now()
+	types, primaryErr, err := fetchTypes(ctx, src, s.app.scrapeClient())
+	s.app.metrics.scrapeLatency.observeSince(start)
+	// a page requiring JavaScript won't be scraped without changing
+	// sources, so it's flagged for operators
+	var challenge *ChallengeError
+	if err != nil {
+		s.app.metrics.scrapeFailures.inc()
+		s.app.LogEventContext(ctx, "scrape_failure", Fields{
+			"error":       err,
+			"requires_js": errors.As(err, &challenge),
+			"duration_ms": durationMS(start),
+		})
+	} else if primaryErr != nil {
+		s.app.LogEventContext(ctx, "scrape_fallback", Fields{
+			"error":       primaryErr,
+			"requires_js": errors.As(primaryErr, &challenge),
+			"duration_ms": durationMS(start),
+		})
+	}
+	return types, primaryErr, err
+}
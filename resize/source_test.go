@@ -0,0 +1,301 @@
+package resize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestStaticSource(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource([]InstanceType{
+		{Name: "m5.large", CPUs: 2, Memory: 8},
+		{Name: "c5.xlarge", CPUs: 4, Memory: 8},
+	}, nil)
+
+	// log in without contacting AWS
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, defaultRegion)); err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	req, _ := http.NewRequest("GET", s.URL+"/instance-types?sort=cpus&order=desc", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(b)
+	c5, m5 := strings.Index(body, "c5.xlarge"), strings.Index(body, "m5.large")
+	if c5 < 0 || m5 < 0 || c5 > m5 {
+		t.Errorf("expected c5.xlarge to be listed before m5.large")
+	}
+}
+
+func TestStaticSourceError(t *testing.T) {
+	src := NewStaticSource([]InstanceType{{Name: "m5.large"}}, errors.New("unavailable"))
+	if _, err := src.Fetch(nil); err == nil || err.Error() != "unavailable" {
+		t.Errorf("expected source error, got %v", err)
+	}
+}
+
+func TestWebScraperSourceURL(t *testing.T) {
+	s := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer s.Close()
+
+	src, err := NewWebScraperSource(s.URL + "/instance_types.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := src.Fetch(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || types[0].Name != "t2.micro" {
+		t.Errorf("expected the instance types of the saved page, got %v", types)
+	}
+
+	for _, rawURL := range []string{"", "aws.amazon.com/ec2", "/instance-types", "ftp://mirror/types.html", "http://", "http://%zz"} {
+		if _, err := NewWebScraperSource(rawURL); err == nil {
+			t.Errorf("expected %q to be rejected", rawURL)
+		}
+	}
+}
+
+func TestWebScraperSourceFallback(t *testing.T) {
+	var archived int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live":
+			fmt.Fprint(w, "<html><body><p>The page moved</p></body></html>")
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/archive":
+			archived++
+			http.ServeFile(w, r, "testdata/instance_types.html")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	src := WebScraperSource{URL: s.URL + "/live", FallbackURL: s.URL + "/archive"}
+	types, primaryErr, err := src.FetchFallback(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || primaryErr == nil || archived != 1 {
+		t.Errorf("expected the archived types and the parse error, got %v, %v", types, primaryErr)
+	}
+
+	// the fallback is only for pages which can't be parsed
+	src.URL = s.URL + "/down"
+	if _, _, err := src.FetchFallback(context.Background(), nil); err == nil || archived != 1 {
+		t.Errorf("expected an unavailable page not to fall back, got %v", err)
+	}
+	src = WebScraperSource{URL: s.URL + "/live", FallbackURL: s.URL + "/gone"}
+	if _, err := src.Fetch(nil); err == nil || !strings.Contains(err.Error(), "fallback") {
+		t.Errorf("expected the fallback's failure to be reported, got %v", err)
+	}
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	app.Logger = log.New(&logs, "", 0)
+	app.Source = WebScraperSource{URL: s.URL + "/live", FallbackURL: s.URL + "/archive"}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.USEast)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/api/instance-types", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	var resp instanceTypesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if !resp.Fallback || len(resp.InstanceTypes) != 3 {
+		t.Errorf("expected the response to come from the fallback, got %+v", resp)
+	}
+	if !strings.Contains(logs.String(), "scrape_fallback") {
+		t.Errorf("expected the fallback to be logged, got %q", logs.String())
+	}
+}
+
+func TestScrapeClient(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = &http.Client{}
+	app.ScrapeClient = &http.Client{}
+	var got *http.Client
+	app.Source = sourceFunc(func(client *http.Client) ([]InstanceType, error) {
+		got = client
+		return []InstanceType{{Name: "m5.large"}}, nil
+	})
+	if _, err := app.TypeCache.InstanceTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if got != app.ScrapeClient {
+		t.Errorf("expected instance types to be fetched with the ScrapeClient")
+	}
+
+	// by default the clients are separate
+	app, err = NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.scrapeClient() == nil || app.scrapeClient() == app.httpClient() {
+		t.Errorf("expected a default ScrapeClient separate from the AWS API client")
+	}
+	if app.scrapeClient() != app.scrapeClient() {
+		t.Errorf("expected the default ScrapeClient to be reused")
+	}
+}
+
+func TestDisableScraper(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, describeInstancesResponse, "i-offline")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DisableScraper = true
+	app.Source = NewStaticSource(nil, errors.New("expected the source not to be used"))
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path string) (int, string) {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	// without static types the views are hidden and nothing can be resized
+	for _, path := range []string{"/instance-types", "/api/instance-types", "/api/instance-types.csv"} {
+		if code, _ := get(path); code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", path, code)
+		}
+	}
+	code, body := get("/instance/i-offline")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", code, body)
+	}
+	if strings.Contains(body, `href="/instance-types"`) || strings.Contains(body, `id="change-type"`) {
+		t.Error("expected no instance types or resize options to be offered")
+	}
+
+	app.StaticTypes = []InstanceType{{Name: "m1.small"}, {Name: "m1.large"}}
+	app.TypeCache.ForceRefresh()
+	if code, body := get("/instance-types"); code != http.StatusOK || !strings.Contains(body, "m1.large") {
+		t.Errorf("expected the static types to be listed, got %d", code)
+	}
+	_, body = get("/instance/i-offline")
+	if !strings.Contains(body, `href="/instance-types"`) || !strings.Contains(body, `<option value="m1.large">`) {
+		t.Error("expected the static types to be offered")
+	}
+}
+
+func TestReadInstanceTypes(t *testing.T) {
+	types, err := ReadInstanceTypes(strings.NewReader(`[{"Name": "m5.large", "CPUs": 2, "Memory": 8}, {"Name": "c5.xlarge"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InstanceType{{Name: "m5.large", CPUs: 2, Memory: 8}, {Name: "c5.xlarge"}}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("expected %+v, got %+v", want, types)
+	}
+	for _, bad := range []string{`{"Name": "m5.large"}`, `[{"CPUs": 2}]`, `[`} {
+		if _, err := ReadInstanceTypes(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestTypesUnavailable(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, describeInstancesResponse, "i-unavailable")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Source = NewStaticSource(nil, errors.New("the scraper is down"))
+	region := aws.Region{Name: "test-region", EC2Endpoint: ec2Server.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+
+	for _, path := range []string{"/instance-types", "/instance/i-unavailable", "/instance/i-unavailable/resize/confirm?new-type=m5.large"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status 503, got %d", path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Retry-After"); got != "60" {
+			t.Errorf("%s: expected Retry-After 60, got %q", path, got)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "Temporarily Unavailable") || strings.Contains(body, "the scraper is down") {
+			t.Errorf("%s: expected a friendly page without the raw error, got %s", path, body)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package resize
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// spotPriceTTL is how long spot prices are cached. Spot prices change
+// throughout the day, so they're only cached to avoid describing them on
+// every page view.
+const spotPriceTTL = 5 * time.Minute
+
+// spotProduct is the product description of the spot prices shown, matching
+// the Linux on-demand prices.
+const spotProduct = "Linux/UNIX"
+
+// spotPrices returns the latest spot price of each of the named instance types
+// in an availability zone. Types which can't be bought as spot instances in
+// the zone are missing from the result.
+func spotPrices(ctx context.Context, ec2Cli *ec2.EC2, zone string, names []string, retry RetryPolicy) (map[string]Price, error) {
+	// a start time of now returns only the current price of each type
+	opts := &ec2.DescribeSpotPriceHistory{
+		InstanceType:       names,
+		ProductDescription: []string{spotProduct},
+		AvailabilityZone:   zone,
+		StartTime:          time.Now(),
+	}
+	var resp *ec2.DescribeSpotPriceHistoryResp
+	err := retry.do(ctx, func() (err error) {
+		resp, err = ec2Cli.DescribeSpotPriceHistory(opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	prices := make(map[string]Price)
+	latest := make(map[string]time.Time)
+	for _, h := range resp.History {
+		amount, err := strconv.ParseFloat(h.SpotPrice, 64)
+		if err != nil || amount == 0 {
+			continue
+		}
+		if at, ok := latest[h.InstanceType]; ok && h.Timestamp.Before(at) {
+			continue
+		}
+		latest[h.InstanceType] = h.Timestamp
+		prices[h.InstanceType] = Price{Amount: amount, Unit: "Hrs"}
+	}
+	return prices, nil
+}
+
+// MergeSpotPrices returns a copy of types with the SpotPrice of each instance
+// type found in prices set. Types without a spot price are left at zero.
+func MergeSpotPrices(types []InstanceType, prices map[string]Price) []InstanceType {
+	merged := make([]InstanceType, len(types))
+	for i, t := range types {
+		if p, ok := prices[t.Name]; ok {
+			t.SpotPrice = p.Amount
+		}
+		merged[i] = t
+	}
+	return merged
+}
+
+// spotPrices returns the spot prices of types in the availability zone of
+// inst, which it stays in when resized. Prices are cached by zone and types.
+func (app *App) spotPrices(ctx context.Context, ec2Cli *ec2.EC2, inst ec2.Instance, types []InstanceType) (map[string]Price, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.Name
+	}
+	sort.Strings(names)
+	key := ec2Cli.Region.Name + "/" + inst.AvailZone + "/" + strings.Join(names, ",")
+	return app.spotCache.get(key, spotPriceTTL, func() (map[string]Price, error) {
+		return spotPrices(ctx, ec2Cli, inst.AvailZone, names, app.retryPolicy())
+	})
+}
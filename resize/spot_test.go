@@ -0,0 +1,108 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const spotPriceHistoryResponse = `<DescribeSpotPriceHistoryResponse xmlns="http://ec2.amazonaws.com/doc/2014-02-01/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <spotPriceHistorySet>
+    <item>
+      <instanceType>m1.large</instanceType>
+      <productDescription>Linux/UNIX</productDescription>
+      <spotPrice>0.0300</spotPrice>
+      <timestamp>2014-01-06T04:32:53.000Z</timestamp>
+      <availabilityZone>us-east-1a</availabilityZone>
+    </item>
+    <item>
+      <instanceType>m1.large</instanceType>
+      <productDescription>Linux/UNIX</productDescription>
+      <spotPrice>0.0260</spotPrice>
+      <timestamp>2014-01-06T05:12:09.000Z</timestamp>
+      <availabilityZone>us-east-1a</availabilityZone>
+    </item>
+  </spotPriceHistorySet>
+</DescribeSpotPriceHistoryResponse>`
+
+func TestInstanceSpotPrices(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		spotError bool
+		want      []string
+		dontWant  []string
+	}{
+		{"spot prices", false, []string{"spot $0.026/hr", "(no spot)"}, []string{"spot $0.030"}},
+		{"no spot access", true, nil, []string{"(spot ", "(no spot)"}},
+	} {
+		spotCalls := 0
+		hf := func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("Action") != "DescribeSpotPriceHistory" {
+				fmt.Fprintf(w, describeInstancesResponse, "i-spot")
+				return
+			}
+			spotCalls++
+			if r.FormValue("ProductDescription.1") != spotProduct {
+				t.Errorf("%s: expected %s prices, got %q", tt.name, spotProduct, r.FormValue("ProductDescription.1"))
+			}
+			if tt.spotError {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, ec2ErrorResponse)
+				return
+			}
+			fmt.Fprint(w, spotPriceHistoryResponse)
+		}
+		s := httptest.NewServer(http.HandlerFunc(hf))
+
+		app, err := NewApp("../public", "../templates", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+		app.Source = NewStaticSource([]InstanceType{
+			{Name: "m1.small", CPUs: 1, Memory: 1.7},
+			{Name: "m1.medium", CPUs: 1, Memory: 3.75},
+			{Name: "m1.large", CPUs: 2, Memory: 7.5},
+		}, nil)
+		region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+			t.Fatal(err)
+		}
+		cookies := w.Result().Cookies()
+
+		for i := 0; i < 2; i++ {
+			r, _ := http.NewRequest("GET", "/instance/i-spot", nil)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected status 200, got %d", tt.name, w.Code)
+			}
+			body := w.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s: expected %q in response", tt.name, want)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(body, dontWant) {
+					t.Errorf("%s: didn't expect %q in response", tt.name, dontWant)
+				}
+			}
+		}
+		s.Close()
+		if !tt.spotError && spotCalls != 1 {
+			t.Errorf("%s: expected spot prices to be cached, described them %d times", tt.name, spotCalls)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package resize
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// maxStateTransitions is the number of state transitions kept per instance.
+const maxStateTransitions = 10
+
+// stateHistoryTTL is how long the transitions of an instance are kept after
+// its state was last checked.
+const stateHistoryTTL = time.Hour
+
+// stateTransition records when the app first saw an instance in a state.
+// EC2 doesn't report when an instance's state changed, so the times are only
+// as accurate as the polling which observed them.
+type stateTransition struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+// stateHistory holds the observed state transitions of an instance.
+type stateHistory struct {
+	transitions []stateTransition
+	checked     time.Time
+}
+
+// stateTracker records the state transitions of instances, keyed by region and
+// instance ID. It is safe for concurrent use.
+type stateTracker struct {
+	mu        sync.Mutex
+	instances map[string]*stateHistory
+}
+
+// observe records that the instance was in state at the given time, and
+// returns its transitions, oldest first.
+func (s *stateTracker) observe(region, id, state string, at time.Time) []stateTransition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, h := range s.instances {
+		if at.Sub(h.checked) > stateHistoryTTL {
+			delete(s.instances, key)
+		}
+	}
+	if s.instances == nil {
+		s.instances = make(map[string]*stateHistory)
+	}
+	key := region + "/" + id
+	h, ok := s.instances[key]
+	if !ok {
+		h = &stateHistory{}
+		s.instances[key] = h
+	}
+	h.checked = at
+	if n := len(h.transitions); n == 0 || h.transitions[n-1].State != state {
+		h.transitions = append(h.transitions, stateTransition{State: state, At: at})
+	}
+	if n := len(h.transitions); n > maxStateTransitions {
+		h.transitions = h.transitions[n-maxStateTransitions:]
+	}
+	return append([]stateTransition(nil), h.transitions...)
+}
+
+// instanceState is the JSON response of handleInstanceState.
+type instanceState struct {
+	InstanceID  string            `json:"instance_id"`
+	State       string            `json:"state"`
+	Code        int               `json:"code"`
+	Since       time.Time         `json:"since"`
+	CheckedAt   time.Time         `json:"checked_at"`
+	Transitions []stateTransition `json:"transitions"`
+}
+
+// observeState records the state of inst at the given time.
+func (app *App) observeState(ec2Cli *ec2.EC2, inst ec2.Instance, at time.Time) []stateTransition {
+	return app.states.observe(ec2Cli.Region.Name, inst.InstanceId, inst.State.Name, at)
+}
+
+// Path: /instance/{instance}/state
+//
+// handleInstanceState returns the current state of an instance as JSON, along
+// with the state transitions observed by the app, for polling while the
+// instance changes.
+func (app *App) handleInstanceState(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.renderJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	instance, ok, err := findInstance(ec2Cli, instanceId)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not get state of %s: %v", instanceId, err)
+		app.renderJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		app.renderJSONError(w, "no instance with ID "+instanceId, http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	transitions := app.observeState(ec2Cli, instance, now)
+	resp := instanceState{
+		InstanceID:  instance.InstanceId,
+		State:       instance.State.Name,
+		Code:        instance.State.Code,
+		Since:       transitions[len(transitions)-1].At,
+		CheckedAt:   now,
+		Transitions: transitions,
+	}
+	app.renderJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,111 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const noInstancesResponse = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2014-02-01/">
+  <requestId>1</requestId>
+  <reservationSet></reservationSet>
+</DescribeInstancesResponse>`
+
+func TestStateTracker(t *testing.T) {
+	var s stateTracker
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.observe("us-east-1", "i-1", "stopping", start)
+	s.observe("us-east-1", "i-1", "stopping", start.Add(time.Second))
+	s.observe("us-east-1", "i-1", "stopped", start.Add(2*time.Second))
+	got := s.observe("us-east-1", "i-1", "pending", start.Add(3*time.Second))
+	want := []stateTransition{
+		{"stopping", start},
+		{"stopped", start.Add(2 * time.Second)},
+		{"pending", start.Add(3 * time.Second)},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected transitions %v, got %v", want, got)
+	}
+	// instances are tracked per region
+	if got := s.observe("us-west-2", "i-1", "running", start); len(got) != 1 {
+		t.Errorf("expected a different region to have its own history, got %v", got)
+	}
+	// histories which haven't been checked for a while are dropped
+	later := start.Add(2 * stateHistoryTTL)
+	if got := s.observe("us-east-1", "i-1", "running", later); len(got) != 1 {
+		t.Errorf("expected old history to be dropped, got %v", got)
+	}
+}
+
+func TestInstanceState(t *testing.T) {
+	var mu sync.Mutex
+	state := "stopping"
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if state == "" {
+			fmt.Fprint(w, noInstancesResponse)
+			return
+		}
+		fmt.Fprintf(w, taggedInstanceResponse, state)
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test-region", EC2Endpoint: s.URL}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, region)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/instance/i-confirm/state", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	get()
+	mu.Lock()
+	state = "running"
+	mu.Unlock()
+	w = get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var resp instanceState
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.State != "running" || resp.Code != 16 {
+		t.Errorf("expected running state, got %s (%d)", resp.State, resp.Code)
+	}
+	if len(resp.Transitions) != 2 || resp.Transitions[0].State != "stopping" {
+		t.Errorf("expected transition from stopping to running, got %v", resp.Transitions)
+	}
+	if !resp.Since.Equal(resp.Transitions[1].At) || resp.CheckedAt.Before(resp.Since) {
+		t.Errorf("expected the time of the last transition, got since %v checked at %v", resp.Since, resp.CheckedAt)
+	}
+
+	mu.Lock()
+	state = ""
+	mu.Unlock()
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("expected a missing instance to return 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package resize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// stsEndpoint is the URL of the global AWS Security Token Service endpoint.
+// The vendored goamz has no STS client, so requests are made directly.
+var stsEndpoint = "https://sts.amazonaws.com/"
+
+// stsCredentials are temporary credentials issued by STS.
+type stsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// auth returns the credentials for use with goamz.
+func (c stsCredentials) auth() aws.Auth {
+	return aws.Auth{
+		AccessKey: c.AccessKeyId,
+		SecretKey: c.SecretAccessKey,
+		Token:     c.SessionToken,
+	}
+}
+
+// getSessionToken exchanges long term credentials and a code from the MFA
+// device identified by serial for temporary credentials.
+// On an error returned by AWS, error will be of type *ec2.Error.
+func getSessionToken(client *http.Client, auth aws.Auth, serial, code string) (stsCredentials, error) {
+	params := map[string]string{
+		"Action":       "GetSessionToken",
+		"SerialNumber": serial,
+		"TokenCode":    code,
+	}
+	var resp struct {
+		Credentials stsCredentials `xml:"GetSessionTokenResult>Credentials"`
+	}
+	err := stsQuery(client, auth, params, &resp)
+	return resp.Credentials, err
+}
+
+// roleSessionName identifies sessions of roles assumed by the app in
+// CloudTrail.
+const roleSessionName = "yhat-resize"
+
+// assumeRole exchanges credentials for temporary credentials of the role
+// identified by roleARN. externalID is required if the role's trust policy
+// demands it, and serial and code if it requires MFA; both may be empty.
+// On an error returned by AWS, error will be of type *ec2.Error.
+func assumeRole(client *http.Client, auth aws.Auth, roleARN, externalID, serial, code string) (stsCredentials, error) {
+	params := map[string]string{
+		"Action":          "AssumeRole",
+		"RoleArn":         roleARN,
+		"RoleSessionName": roleSessionName,
+	}
+	if externalID != "" {
+		params["ExternalId"] = externalID
+	}
+	if serial != "" {
+		params["SerialNumber"] = serial
+		params["TokenCode"] = code
+	}
+	var resp struct {
+		Credentials stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	}
+	err := stsQuery(client, auth, params, &resp)
+	return resp.Credentials, err
+}
+
+// stsQuery makes a signed STS request and decodes the XML response into
+// result.
+func stsQuery(client *http.Client, auth aws.Auth, params map[string]string, result interface{}) error {
+	return awsQuery(client, auth, awsService{"STS", "sts", "2011-06-15", stsEndpoint, "us-east-1"}, params, result)
+}
+
+// awsService identifies an AWS query API endpoint which requests are signed
+// for with signature version 4.
+type awsService struct {
+	name     string // for error messages
+	signName string // service name in the signature's scope
+	version  string // API version
+	endpoint string
+	region   string
+}
+
+// awsQuery makes a signed request to a query API and decodes the XML response
+// into result.
+// On an error returned by AWS, error will be of type *ec2.Error.
+func awsQuery(client *http.Client, auth aws.Auth, svc awsService, params map[string]string, result interface{}) error {
+	form := url.Values{"Version": {svc.version}}
+	for k, v := range params {
+		form.Set(k, v)
+	}
+	body := form.Encode()
+	req, err := http.NewRequest("POST", svc.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, auth, svc.region, svc.signName, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error ec2.Error
+		}
+		if err := xml.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return fmt.Errorf("bad response from AWS %s: %s", svc.name, resp.Status)
+		}
+		errResp.Error.StatusCode = resp.StatusCode
+		return &errResp.Error
+	}
+	return xml.NewDecoder(resp.Body).Decode(result)
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req.
+func signV4(req *http.Request, body string, auth aws.Auth, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if auth.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", auth.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+auth.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+auth.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const getSessionTokenResponse = `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetSessionTokenResult>
+    <Credentials>
+      <SessionToken>session-token</SessionToken>
+      <SecretAccessKey>temp-secret</SecretAccessKey>
+      <Expiration>2011-07-11T19:55:29.611Z</Expiration>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+    </Credentials>
+  </GetSessionTokenResult>
+</GetSessionTokenResponse>`
+
+const stsErrorResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>MultiFactorAuthentication failed with invalid MFA one time pass code.</Message>
+  </Error>
+  <RequestId>c6104cbe-af31-11e0-8154-cbc7ccf896c7</RequestId>
+</ErrorResponse>`
+
+func TestGetSessionToken(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("request not signed: %q", r.Header.Get("Authorization"))
+		}
+		if r.FormValue("Action") != "GetSessionToken" {
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+		if r.FormValue("TokenCode") != "123456" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(stsErrorResponse))
+			return
+		}
+		w.Write([]byte(getSessionTokenResponse))
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+	defer func(endpoint string) { stsEndpoint = endpoint }(stsEndpoint)
+	stsEndpoint = s.URL + "/"
+
+	auth := aws.Auth{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"}
+	creds, err := getSessionToken(http.DefaultClient, auth, "arn:aws:iam::123456789012:mfa/user", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := aws.Auth{AccessKey: "ASIAEXAMPLE", SecretKey: "temp-secret", Token: "session-token"}
+	if creds.auth() != expected {
+		t.Errorf("expected credentials %v, got %v", expected, creds.auth())
+	}
+	if creds.Expiration.Year() != 2011 {
+		t.Errorf("expiration not parsed, got %v", creds.Expiration)
+	}
+
+	_, err = getSessionToken(http.DefaultClient, auth, "arn:aws:iam::123456789012:mfa/user", "000000")
+	ec2Err, ok := err.(*ec2.Error)
+	if !ok {
+		t.Fatalf("expected error of type *ec2.Error, got %v", err)
+	}
+	if ec2Err.Code != "AccessDenied" {
+		t.Errorf("expected AccessDenied error code, got %q", ec2Err.Code)
+	}
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/resize/yhat-resize</Arn>
+      <AssumedRoleId>ARO123EXAMPLE123:yhat-resize</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <SessionToken>role-token</SessionToken>
+      <SecretAccessKey>role-secret</SecretAccessKey>
+      <Expiration>2019-11-09T13:34:41Z</Expiration>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestAssumeRole(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("Action") != "AssumeRole" {
+			t.Errorf("unexpected action %q", r.FormValue("Action"))
+		}
+		if r.FormValue("RoleArn") != "arn:aws:iam::123456789012:role/resize" {
+			t.Errorf("unexpected role %q", r.FormValue("RoleArn"))
+		}
+		if r.FormValue("RoleSessionName") == "" {
+			t.Errorf("expected a role session name")
+		}
+		if r.FormValue("ExternalId") != "external" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(stsErrorResponse))
+			return
+		}
+		w.Write([]byte(assumeRoleResponse))
+	}
+	s := httptest.NewServer(http.HandlerFunc(hf))
+	defer s.Close()
+	defer func(endpoint string) { stsEndpoint = endpoint }(stsEndpoint)
+	stsEndpoint = s.URL + "/"
+
+	auth := aws.Auth{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"}
+	creds, err := assumeRole(http.DefaultClient, auth, "arn:aws:iam::123456789012:role/resize", "external", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := aws.Auth{AccessKey: "ASIAROLE", SecretKey: "role-secret", Token: "role-token"}
+	if creds.auth() != expected {
+		t.Errorf("expected credentials %v, got %v", expected, creds.auth())
+	}
+	if creds.Expiration.IsZero() {
+		t.Errorf("expected expiration to be parsed")
+	}
+
+	_, err = assumeRole(http.DefaultClient, auth, "arn:aws:iam::123456789012:role/resize", "", "", "")
+	if _, ok := err.(*ec2.Error); !ok {
+		t.Errorf("expected error of type *ec2.Error, got %v", err)
+	}
+}
@@ -1,17 +1,30 @@
 package resize
 
 import (
+	"fmt"
 	"html/template"
-	"io/ioutil"
+	"io/fs"
 	"net/http"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/goamz/aws"
 	"golang.org/x/net/websocket"
 )
 
 var helpers = template.FuncMap{
+	"csrfField":   csrfField,
+	"timeAgo":     timeAgo,
+	"humanMemory": humanMemory,
+	"humanPrice":  humanPrice,
+	"humanClock":  humanClock,
+	"t":           translate,
+	"url":         urlFor,
+	"nameTag":     nameTag,
 	"buttonForState": func(state string) string {
 		switch state {
 		case "running":
@@ -26,33 +39,129 @@ var helpers = template.FuncMap{
 	},
 }
 
-// CompileTemplates parses a template directory
-func (app *App) compileTemplates(tmplDir string) error {
-	tmpl, err := compileTemplates(tmplDir)
+// timeAgo describes how long ago t was, e.g. "12 minutes ago".
+func timeAgo(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch d := time.Since(t); {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// trimFloat formats f with at most prec decimals, dropping trailing zeros.
+func trimFloat(f float64, prec int) string {
+	s := strconv.FormatFloat(f, 'f', prec, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// humanMemory formats an amount of memory given in GiB, e.g. "7.5 GiB".
+// Amounts of 1024 GiB or more are given in TiB.
+func humanMemory(gib float64) string {
+	if gib >= 1024 {
+		return trimFloat(gib/1024, 2) + " TiB"
+	}
+	return trimFloat(gib, 2) + " GiB"
+}
+
+// humanPrice formats a price in USD per unit, the unit of AWS price lists
+// being "Hrs", e.g. "$0.096/hr". At least two decimals are shown.
+func humanPrice(amount float64, unit string) string {
+	s := trimFloat(amount, 4)
+	if i := strings.Index(s, "."); i < 0 {
+		s += ".00"
+	} else if len(s)-i < 3 {
+		s += "0"
+	}
+	switch unit {
+	case "", "Hrs":
+		unit = "hr"
+	}
+	return "$" + s + "/" + unit
+}
+
+// humanClock formats a clock speed given in GHz, e.g. "2.5 GHz".
+func humanClock(ghz float64) string {
+	return trimFloat(ghz, 2) + " GHz"
+}
+
+// CompileTemplates parses the app's templates. The app's templates are only
+// replaced if all of them compile, so a failed reload keeps the last good
+// set.
+func (app *App) compileTemplates() error {
+	tmpl, err := compileTemplates(app.tmplFS, app.tmplName)
 	if err != nil {
 		return err
 	}
+	app.tmplMu.Lock()
 	app.tmpl = tmpl
+	app.tmplMu.Unlock()
 	return nil
 }
 
-func compileTemplates(tmplDir string) (map[string]*template.Template, error) {
-	join := filepath.Join
+// templates returns the current set of compiled templates. The returned map
+// is never modified, reloading templates replaces it.
+func (app *App) templates() map[string]*template.Template {
+	app.tmplMu.RLock()
+	defer app.tmplMu.RUnlock()
+	return app.tmpl
+}
 
-	includes := join(tmplDir, "includes")
-	layouts := join(tmplDir, "layouts")
+// checkTemplateDir checks that fsys has the structure compileTemplates
+// expects, returning an error naming the first missing path. Paths are named
+// relative to root.
+func checkTemplateDir(fsys fs.FS, root string) error {
+	for _, dir := range []string{".", "includes", "layouts"} {
+		info, err := fs.Stat(fsys, dir)
+		if err != nil {
+			return fmt.Errorf("missing template directory %s", filepath.Join(root, dir))
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("expected %s to be a template directory", filepath.Join(root, dir))
+		}
+	}
+	base := path.Join("layouts", "base.html")
+	if info, err := fs.Stat(fsys, base); err != nil || info.IsDir() {
+		return fmt.Errorf("missing base layout %s", filepath.Join(root, base))
+	}
+	return nil
+}
+
+// compileTemplates parses the templates of fsys, which holds a page template
+// per file along with the includes and layouts directories. root names fsys
+// in errors.
+func compileTemplates(fsys fs.FS, root string) (map[string]*template.Template, error) {
+	if err := checkTemplateDir(fsys, root); err != nil {
+		return nil, err
+	}
 
 	tmpl := template.New("").Funcs(helpers)
 	var err error
-	_, err = tmpl.ParseGlob(join(includes, "*.html"))
+	_, err = tmpl.ParseFS(fsys, "includes/*.html")
 	if err != nil {
 		return nil, err
 	}
-	if _, err = tmpl.ParseGlob(join(layouts, "*.html")); err != nil {
+	if _, err = tmpl.ParseFS(fsys, "layouts/*.html"); err != nil {
 		return nil, err
 	}
 
-	files, err := ioutil.ReadDir(tmplDir)
+	files, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +176,7 @@ func compileTemplates(tmplDir string) (map[string]*template.Template, error) {
 		if err != nil {
 			return nil, err
 		}
-		_, err = t.ParseFiles(join(tmplDir, name))
+		_, err = t.ParseFS(fsys, name)
 		if err != nil {
 			return nil, err
 		}
@@ -76,37 +185,34 @@ func compileTemplates(tmplDir string) (map[string]*template.Template, error) {
 	return m, nil
 }
 
+// region is an entry of the region selector rendered in the nav bar.
+type region struct {
+	Name     string
+	Selected bool
+}
+
+// regionList returns the regions known to goamz sorted by name, with the
+// named region marked as selected.
+func regionList(selected string) []region {
+	regions := make([]region, 0, len(aws.Regions))
+	for name := range aws.Regions {
+		regions = append(regions, region{Name: name, Selected: name == selected})
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Name < regions[j].Name })
+	return regions
+}
+
 // Render renders a template to the ResponseWriter with a 200 status code.
 func (app *App) render(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
 	ec2Cli, ok := app.creds(r)
 	if ok {
 		// if the user is logged in display the list of available regions
-		regions := []struct {
-			Name     string
-			Selected bool
-		}{
-			{aws.APNortheast.Name, false},
-			{aws.APSoutheast.Name, false},
-			{aws.APSoutheast2.Name, false},
-			{aws.EUWest.Name, false},
-			{aws.EUCentral.Name, false},
-			{aws.USEast.Name, false},
-			{aws.USWest.Name, false},
-			{aws.USWest2.Name, false},
-			{aws.SAEast.Name, false},
-			{aws.USGovWest.Name, false},
-			{aws.CNNorth.Name, false},
-		}
-		for i, r := range regions {
-			if r.Name == ec2Cli.Region.Name {
-				regions[i].Selected = true
-				break
-			}
-		}
+		regions := regionList(ec2Cli.Region.Name)
 		if data == nil {
 			data = make(map[string]interface{})
 		}
 		data["Regions"] = regions
+		data["TypesAvailable"] = app.typesAvailable()
 	}
 	app.renderStatus(w, r, name, data, http.StatusOK)
 }
@@ -114,50 +220,136 @@ func (app *App) render(w http.ResponseWriter, r *http.Request, name string, data
 // Render500 renders the 500.html template with the error message displayed to
 // the user.
 func (app *App) render500(w http.ResponseWriter, r *http.Request, err error) {
-	data := map[string]string{
+	data := map[string]interface{}{
 		"Error": err.Error(),
 	}
 	app.renderStatus(w, r, "500.html", data, http.StatusInternalServerError)
 }
 
+// Render400 renders the 400.html template with the reason the request was
+// rejected displayed to the user.
+func (app *App) render400(w http.ResponseWriter, r *http.Request, err error) {
+	data := map[string]interface{}{
+		"Error": err.Error(),
+	}
+	app.renderStatus(w, r, "400.html", data, http.StatusBadRequest)
+}
+
+// Render403 renders the 403.html template with the reason the request was
+// forbidden displayed to the user.
+func (app *App) render403(w http.ResponseWriter, r *http.Request, err error) {
+	app.LogfContext(r.Context(), "%s forbidden: %v", r.RequestURI, err)
+	data := map[string]interface{}{
+		"Error": err.Error(),
+	}
+	app.renderStatus(w, r, "403.html", data, http.StatusForbidden)
+}
+
+// Render409 renders the 409.html template, telling the user that the
+// request conflicts with an operation in progress.
+func (app *App) render409(w http.ResponseWriter, r *http.Request, err error) {
+	data := map[string]interface{}{
+		"Error": err.Error(),
+	}
+	app.renderStatus(w, r, "409.html", data, http.StatusConflict)
+}
+
+// Render503 renders the 503.html template, telling the user that the
+// instance types source is temporarily unavailable and to try again. The
+// error is logged rather than displayed, and Retry-After is set to
+// typesRetryAfter.
+func (app *App) render503(w http.ResponseWriter, r *http.Request, err error) {
+	app.LogfContext(r.Context(), "%s: instance types unavailable: %v", r.RequestURI, err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(typesRetryAfter.Seconds())))
+	app.renderStatus(w, r, "503.html", nil, http.StatusServiceUnavailable)
+}
+
 // Render404 renders the 404.html template to the user.
 func (app *App) render404(w http.ResponseWriter, r *http.Request) {
-	app.Logf("%s not found", r.RequestURI)
+	app.LogfContext(r.Context(), "%s not found", r.RequestURI)
 	app.renderStatus(w, r, "404.html", nil, http.StatusNotFound)
 }
 
 func (app *App) wsErr(ws *websocket.Conn, err string) {
-	app.Logf(err)
+	app.LogfContext(ws.Request().Context(), "%s", err)
 	e := Event{Status: "error", Message: err}
 	websocket.JSON.Send(ws, &e)
 }
 
+// renderStatus renders the named template with the given status. Requests
+// which prefer JSON get the page's data as JSON instead, see renderPageJSON.
 func (app *App) renderStatus(
 	w http.ResponseWriter,
 	r *http.Request,
 	name string,
-	data interface{},
+	data map[string]interface{},
 	status int) {
 
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		app.renderPageJSON(w, r, name, data, status)
+		return
+	}
+
+	var reloadErr error
 	if app.ReloadTemplates {
-		err := app.compileTemplates(app.tmplDir)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		reloadErr = app.compileTemplates()
+		if reloadErr != nil {
+			app.LogfContext(r.Context(), "could not reload templates, using last good templates: %v", reloadErr)
 		}
 	}
 
-	tmpl, ok := app.tmpl[name]
+	tmpl, ok := app.templates()[name]
+	if !ok && reloadErr != nil {
+		http.Error(w, reloadErr.Error(), http.StatusInternalServerError)
+		return
+	}
 	if !ok {
-		app.Logf("no template named %s", name)
+		app.LogfContext(r.Context(), "no template named %s", name)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := cspNonce()
+	if err != nil {
+		app.LogfContext(r.Context(), "could not create CSP nonce: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	token, err := app.csrfToken(w, r)
+	if err != nil {
+		app.LogfContext(r.Context(), "could not create CSRF token: %v", err)
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["DryRun"] = app.DryRun || data["DryRun"] == true
+	data["ReadOnly"] = app.ReadOnly
+	data["CSRFFieldName"] = app.csrfFieldName()
+	data["CSRFToken"] = token
+	data["CSPNonce"] = nonce
+	data["BasePath"] = app.basePath()
+	locale := app.locale(r)
+	data["Locale"] = locale
+	data["Catalog"] = app.Catalogs[locale]
+	if len(app.Catalogs) > 0 {
+		data["Locales"] = app.locales()
+	}
+	if flashes := app.flashes(w, r); len(flashes) > 0 {
+		data["Flashes"] = flashes
+	}
+	if reloadErr != nil {
+		data["TemplateError"] = reloadErr.Error()
+	}
+
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Language", locale)
+	w.Header().Set("Content-Security-Policy", app.contentSecurityPolicy(nonce))
 	w.WriteHeader(status)
 
-	err := tmpl.ExecuteTemplate(w, "base.html", data)
+	err = tmpl.ExecuteTemplate(w, "base.html", data)
 	if err != nil {
-		app.Logf("error rendering template %s %v", name, err)
+		app.LogfContext(r.Context(), "error rendering template %s %v", name, err)
 	}
 }
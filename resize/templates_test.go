@@ -2,14 +2,22 @@ package resize
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
 )
 
 func TestCompilteTemplates(t *testing.T) {
 	tmplDir := "../templates"
-	tmpl, err := compileTemplates(tmplDir)
+	tmpl, err := compileTemplates(os.DirFS(tmplDir), tmplDir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,3 +44,215 @@ func TestCompilteTemplates(t *testing.T) {
 		}
 	}
 }
+
+func TestRegionList(t *testing.T) {
+	regions := regionList("us-west-2")
+	if len(regions) != len(aws.Regions) {
+		t.Fatalf("expected %d regions, got %d", len(aws.Regions), len(regions))
+	}
+	selected := 0
+	for i, r := range regions {
+		if i > 0 && regions[i-1].Name >= r.Name {
+			t.Errorf("regions not sorted: %s before %s", regions[i-1].Name, r.Name)
+		}
+		if r.Selected {
+			selected++
+			if r.Name != "us-west-2" {
+				t.Errorf("expected us-west-2 to be selected, got %s", r.Name)
+			}
+		}
+	}
+	if selected != 1 {
+		t.Errorf("expected 1 selected region, got %d", selected)
+	}
+}
+
+// copyTemplates copies the app's templates to a temporary directory.
+func copyTemplates(t *testing.T) string {
+	dir := t.TempDir()
+	for _, sub := range []string{"", "includes", "layouts"} {
+		files, err := ioutil.ReadDir(filepath.Join("../templates", sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join("../templates", sub, file.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, sub, file.Name()), b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestNewAppTemplateDir(t *testing.T) {
+	for _, missing := range []string{"", "includes", "layouts", filepath.Join("layouts", "base.html")} {
+		tmplDir := copyTemplates(t)
+		path := filepath.Join(tmplDir, missing)
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatal(err)
+		}
+		_, err := NewApp("../public", tmplDir, nil)
+		if err == nil {
+			t.Errorf("%s: expected an error for a missing path", path)
+			continue
+		}
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: expected the error to name the missing path, got %v", path, err)
+		}
+	}
+}
+
+func TestReloadTemplatesKeepsLastGood(t *testing.T) {
+	tmplDir := copyTemplates(t)
+	app, err := NewApp("../public", tmplDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ReloadTemplates = true
+
+	broken := `{{ define "content" }}{{ if }}{{ end }}`
+	if err := ioutil.WriteFile(filepath.Join(tmplDir, "500.html"), []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/about", nil)
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Templates failed to reload") {
+		t.Errorf("expected the compile error to be displayed")
+	}
+	if !strings.Contains(body, "<html") {
+		t.Errorf("expected page to be rendered with the base layout")
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonceAttr := regexp.MustCompile(`<script nonce="([^"]+)"`)
+	render := func() (policy, nonce string) {
+		r, _ := http.NewRequest("GET", "/about", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		m := nonceAttr.FindStringSubmatch(w.Body.String())
+		if m == nil {
+			t.Fatal("expected scripts to be marked with a nonce")
+		}
+		return w.Header().Get("Content-Security-Policy"), m[1]
+	}
+
+	policy, nonce := render()
+	if !strings.Contains(policy, "script-src 'self' 'nonce-"+nonce+"'") {
+		t.Errorf("expected policy to allow scripts with nonce %q, got %q", nonce, policy)
+	}
+	if strings.Contains(policy, "unsafe-inline") {
+		t.Errorf("expected policy to block inline scripts, got %q", policy)
+	}
+	if _, next := render(); next == nonce {
+		t.Errorf("expected a new nonce for each request, got %q twice", nonce)
+	}
+
+	app.ContentSecurityPolicy = "script-src 'nonce-{nonce}'"
+	policy, nonce = render()
+	if want := "script-src 'nonce-" + nonce + "'"; policy != want {
+		t.Errorf("expected custom policy %q, got %q", want, policy)
+	}
+}
+
+func TestTimeAgo(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Time{}, "never"},
+		{now.Add(-10 * time.Second), "just now"},
+		{now.Add(-time.Minute), "1 minute ago"},
+		{now.Add(-12*time.Minute - 30*time.Second), "12 minutes ago"},
+		{now.Add(-2 * time.Hour), "2 hours ago"},
+		{now.Add(-72 * time.Hour), "3 days ago"},
+	}
+	for _, tt := range tests {
+		if got := timeAgo(tt.t); got != tt.want {
+			t.Errorf("timeAgo(%v): expected %q, got %q", tt.t, tt.want, got)
+		}
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{humanMemory(7.5), "7.5 GiB"},
+		{humanMemory(0.613), "0.61 GiB"},
+		{humanMemory(16), "16 GiB"},
+		{humanMemory(24576), "24 TiB"},
+		{humanPrice(0.096, "Hrs"), "$0.096/hr"},
+		{humanPrice(0.0116, ""), "$0.0116/hr"},
+		{humanPrice(1.5, "Hrs"), "$1.50/hr"},
+		{humanPrice(2, "Hrs"), "$2.00/hr"},
+		{humanPrice(0.12345, "Quantity"), "$0.1235/Quantity"},
+		{humanClock(2.5), "2.5 GHz"},
+		{humanClock(3), "3 GHz"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, tt.got)
+		}
+	}
+}
+
+func TestNewAppFS(t *testing.T) {
+	static := fstest.MapFS{
+		"favicon.ico":      {Data: []byte("icon")},
+		"css/site.css":     {Data: []byte("body {}")},
+		"js/global.js":     {Data: []byte("// js")},
+		"img/.placeholder": {},
+	}
+	app, err := NewAppFS(static, os.DirFS("../templates"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/favicon.ico":  "icon",
+		"/css/site.css": "body {}",
+		"/js/global.js": "// js",
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: expected %q, got %d %q", path, want, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/about", nil)
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("expected the about page to render from the template file system, got %d", w.Code)
+	}
+
+	_, err = NewAppFS(static, fstest.MapFS{"index.html": {}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "includes") {
+		t.Errorf("expected an error naming the missing includes directory, got %v", err)
+	}
+}
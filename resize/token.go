@@ -0,0 +1,214 @@
+package resize
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// DefaultTokenMaxAge is how long API tokens are valid if App.TokenMaxAge is
+// zero.
+const DefaultTokenMaxAge = 12 * time.Hour
+
+// tokenName is the name API tokens are signed under, so a token can't be
+// presented as a session cookie and vice versa.
+const tokenName = "yhat-resize-token"
+
+var errToken = errors.New("invalid or expired token")
+
+// apiToken is the content of a bearer token issued by /api/login. Tokens are
+// encrypted, as they carry the user's AWS credentials.
+type apiToken struct {
+	User    string
+	Auth    aws.Auth
+	Region  string
+	Version int64
+	Expires time.Time
+}
+
+func (app *App) tokenMaxAge() time.Duration {
+	if app.TokenMaxAge <= 0 {
+		return DefaultTokenMaxAge
+	}
+	return app.TokenMaxAge
+}
+
+// tokenCodec returns the codec API tokens are signed and encrypted with.
+func (app *App) tokenCodec() *securecookie.SecureCookie {
+	key := app.TokenKey
+	if len(key) == 0 {
+		key = app.tokenKey
+	}
+	blockKey := sha256.Sum256(key)
+	codec := securecookie.New(key, blockKey[:])
+	return codec.MaxAge(int(app.tokenMaxAge() / time.Second))
+}
+
+// bearerToken returns the token of a request's "Authorization: Bearer"
+// header, if any.
+func bearerToken(r *http.Request) string {
+	const prefix = "bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// tokenCreds returns the EC2 credentials held by an API token. ok is false if
+// the token is invalid, has expired or has been revoked.
+func (app *App) tokenCreds(token string) (ec2Cli *ec2.EC2, ok bool) {
+	var t apiToken
+	if err := app.tokenCodec().Decode(tokenName, token, &t); err != nil {
+		return nil, false
+	}
+	if !time.Now().Before(t.Expires) {
+		return nil, false
+	}
+	region, ok := aws.Regions[t.Region]
+	if !ok {
+		return nil, false
+	}
+	current, err := app.sessionVersions().SessionVersion(t.User)
+	if err != nil {
+		app.Logf("could not check session version: %v", err)
+		return nil, false
+	}
+	if t.Version != current {
+		return nil, false
+	}
+	return app.newEC2(t.Auth, region), true
+}
+
+// apiLogin holds the credentials posted to /api/login.
+type apiLogin struct {
+	AccessKey  string `json:"accessKey"`
+	SecretKey  string `json:"secretKey"`
+	MFASerial  string `json:"mfaSerial"`
+	MFAToken   string `json:"mfaToken"`
+	RoleARN    string `json:"roleArn"`
+	ExternalID string `json:"externalId"`
+	Region     string `json:"region"`
+}
+
+// issueToken validates the credentials of a login with AWS, exchanging them
+// for temporary credentials if an MFA code or role is given, and returns a
+// token holding them.
+// On an authentication error, error will be of type *ec2.Error
+func (app *App) issueToken(login apiLogin, region aws.Region) (string, time.Time, error) {
+	auth := aws.Auth{AccessKey: login.AccessKey, SecretKey: login.SecretKey}
+	expires := time.Now().Add(app.tokenMaxAge())
+	var creds stsCredentials
+	var err error
+	switch {
+	case login.RoleARN != "":
+		creds, err = assumeRole(app.httpClient(), auth, login.RoleARN, login.ExternalID, login.MFASerial, login.MFAToken)
+	case login.MFASerial != "":
+		creds, err = getSessionToken(app.httpClient(), auth, login.MFASerial, login.MFAToken)
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if creds.AccessKeyId != "" {
+		auth = creds.auth()
+		if creds.Expiration.Before(expires) {
+			expires = creds.Expiration
+		}
+	}
+
+	if err := app.validateCreds(app.newEC2(auth, region)); err != nil {
+		return "", time.Time{}, err
+	}
+	version, err := app.sessionVersions().SessionVersion(login.AccessKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	t := apiToken{
+		User:    login.AccessKey,
+		Auth:    auth,
+		Region:  region.Name,
+		Version: version,
+		Expires: expires,
+	}
+	token, err := app.tokenCodec().Encode(tokenName, t)
+	return token, expires, err
+}
+
+// Path: /api/login
+//
+// handleAPILogin exchanges JSON encoded credentials for a bearer token, which
+// authenticates requests to the other /api/ endpoints through the
+// "Authorization: Bearer <token>" header. The token is bound to the requested
+// region, us-east-1 by default.
+func (app *App) handleAPILogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	var login apiLogin
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		app.renderJSONError(w, "expected a JSON object holding credentials", http.StatusBadRequest)
+		return
+	}
+	if login.AccessKey == "" || login.SecretKey == "" {
+		app.renderJSONError(w, "an access key and secret key are required", http.StatusBadRequest)
+		return
+	}
+	if (login.MFASerial == "") != (login.MFAToken == "") {
+		app.renderJSONError(w, "both an MFA device serial number and token are required", http.StatusBadRequest)
+		return
+	}
+	if login.ExternalID != "" && login.RoleARN == "" {
+		app.renderJSONError(w, "an external ID requires a role ARN", http.StatusBadRequest)
+		return
+	}
+	if login.Region == "" {
+		login.Region = defaultRegion.Name
+	}
+	region, ok := aws.Regions[login.Region]
+	if !ok {
+		app.renderJSONError(w, fmt.Sprintf("unknown region '%s'", login.Region), http.StatusBadRequest)
+		return
+	}
+
+	if !app.allowLogin(r) {
+		app.LogEventContext(r.Context(), "login_rate_limited", Fields{"ip": app.clientIP(r), "api": true})
+		w.Header().Set("Retry-After", app.retryAfter())
+		app.renderJSONError(w, "too many failed login attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	start := time.Now()
+	token, expires, err := app.issueToken(login, region)
+	app.recordLogin(r, err)
+	fields := Fields{
+		"region":      region.Name,
+		"mfa":         login.MFASerial != "",
+		"role":        login.RoleARN != "",
+		"api":         true,
+		"duration_ms": durationMS(start),
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	app.LogEventContext(r.Context(), "login", fields)
+	if err != nil {
+		msg, status := loginFailure(err)
+		app.renderJSONError(w, msg, status)
+		return
+	}
+	app.metrics.logins.inc()
+	resp := map[string]interface{}{
+		"token":   token,
+		"region":  region.Name,
+		"expires": expires.UTC().Format(time.RFC3339),
+	}
+	app.renderJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,136 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestAPILogin(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("AWSAccessKeyId") != "good-key" && !strings.Contains(r.Header.Get("Authorization"), "Credential=good-key/") {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, ec2ErrorResponse)
+			return
+		}
+		fmt.Fprintf(w, describeInstancesResponse, "i-api")
+	}
+	ec2Server := httptest.NewServer(http.HandlerFunc(hf))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	app.TokenMaxAge = time.Hour
+	app.Source = NewStaticSource([]InstanceType{{Name: "m1.small"}}, nil)
+
+	login := func(body string) (*httptest.ResponseRecorder, map[string]string) {
+		r, _ := http.NewRequest("POST", "/api/login", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		var resp map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("expected JSON response, got %q", w.Body.String())
+		}
+		return w, resp
+	}
+
+	w, resp := login(`{"accessKey":"bad-key","secretKey":"s3cr3t"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected failed login to return 401, got %d", w.Code)
+	}
+	if resp["error"] == "" || strings.Contains(w.Body.String(), "s3cr3t") {
+		t.Errorf("expected an error without the secret key, got %q", w.Body.String())
+	}
+
+	w, resp = login(`{"accessKey":"good-key","secretKey":"s3cr3t","region":"us-west-2"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp["region"] != "us-west-2" {
+		t.Errorf("expected token for us-west-2, got %q", resp["region"])
+	}
+	expires, err := time.Parse(time.RFC3339, resp["expires"])
+	if err != nil || expires.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expected token to expire within TokenMaxAge, got %q", resp["expires"])
+	}
+	ec2Cli, ok := app.tokenCreds(resp["token"])
+	if !ok || ec2Cli.Region.Name != "us-west-2" {
+		t.Errorf("expected the token to hold the selected region")
+	}
+
+	expired, err := app.tokenCodec().Encode(tokenName, apiToken{
+		User:    "good-key",
+		Auth:    aws.Auth{AccessKey: "good-key", SecretKey: "s3cr3t"},
+		Region:  "us-west-2",
+		Expires: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		token  string
+		status int
+	}{
+		{resp["token"], http.StatusOK},
+		{"not-a-token", http.StatusUnauthorized},
+		{expired, http.StatusUnauthorized},
+	} {
+		r, _ := http.NewRequest("GET", "/api/instance-types", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAPILoginCredentialCheck(t *testing.T) {
+	calls := 0
+	ec2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, ec2ErrorResponse)
+	}))
+	defer ec2Server.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Endpoint = ec2Server.URL
+	login := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/api/login", strings.NewReader(`{"accessKey":"key","secretKey":"s3cr3t"}`))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := login(); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid credentials") {
+		t.Errorf("expected rejected credentials to return 401, got %d: %s", w.Code, w.Body.String())
+	}
+
+	app.SkipCredentialCheck = true
+	calls = 0
+	if w := login(); w.Code != http.StatusOK {
+		t.Errorf("expected the credential check to be skipped, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 0 {
+		t.Errorf("expected no calls to AWS, got %d", calls)
+	}
+
+	app.SkipCredentialCheck = false
+	ec2Server.Close()
+	if w := login(); w.Code != http.StatusBadGateway {
+		t.Errorf("expected unreachable AWS to return 502, got %d: %s", w.Code, w.Body.String())
+	}
+}
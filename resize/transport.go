@@ -0,0 +1,145 @@
+package resize
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// TransportOptions tunes the connection pools of the HTTP clients used for
+// requests to AWS when App.HTTPClient or App.ScrapeClient is nil.
+// Connections are kept alive and reused, unlike those of aws.RetryingClient,
+// which opens a connection for every request and so runs out of ports when
+// many regions are listed at once.
+type TransportOptions struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts.
+	// Zero means no limit.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost bounds the idle connections kept to each host,
+	// each region's endpoint being a separate host. It should be at least
+	// the number of concurrent requests made to a region.
+	// If zero, http.DefaultMaxIdleConnsPerHost is used.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept before it's
+	// closed. Zero means no limit.
+	IdleConnTimeout time.Duration
+
+	// DialTimeout bounds the time taken to connect to AWS.
+	// Zero means no timeout.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout bounds the time taken by the TLS handshake.
+	// Zero means no timeout.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds the time waited for a response's headers
+	// once a request is sent. Response bodies, such as large price list
+	// offer files, may take longer. Zero means no timeout.
+	ResponseHeaderTimeout time.Duration
+
+	// MaxTries is the number of times a request is made if it fails with a
+	// temporary network error or a 5xx response, as aws.RetryingClient does.
+	// Values less than one are treated as one.
+	MaxTries int
+}
+
+// DefaultTransportOptions are used if App.Transport is nil.
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           10 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	MaxTries:              3,
+}
+
+// newTransport returns a pooling transport configured by opts, which retries
+// requests as aws.RetryingClient does.
+func newTransport(opts TransportOptions) http.RoundTripper {
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	return retryTransport{
+		rt: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          opts.MaxIdleConns,
+			MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+			IdleConnTimeout:       opts.IdleConnTimeout,
+			TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		},
+		maxTries: opts.MaxTries,
+	}
+}
+
+// retryTransport retries requests which fail with a temporary network error
+// or a 5xx response, waiting with aws.ExpBackoff between attempts. Requests
+// whose body can't be rewound are only made once.
+type retryTransport struct {
+	rt       http.RoundTripper
+	maxTries int
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for try := 0; ; try++ {
+		resp, err := t.rt.RoundTrip(req)
+		if try+1 >= t.maxTries || !shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, berr := req.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		aws.ExpBackoff(try)
+	}
+}
+
+// shouldRetry reports if a request which got resp or err may succeed if it's
+// made again, following the criteria of aws.RetryingClient.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		neterr, ok := err.(net.Error)
+		return ok && neterr.Temporary()
+	}
+	return resp.StatusCode >= 500 && resp.StatusCode < 600
+}
+
+func (app *App) transportOptions() TransportOptions {
+	if app.Transport == nil {
+		return DefaultTransportOptions
+	}
+	return *app.Transport
+}
+
+// defaultHTTPClient returns the client used for requests to AWS if
+// App.HTTPClient is nil. It's built on first use, so App.Transport must be
+// set before the app serves requests.
+func (app *App) defaultHTTPClient() *http.Client {
+	app.clientOnce.Do(func() {
+		app.client = &http.Client{Transport: newTransport(app.transportOptions())}
+	})
+	return app.client
+}
+
+// defaultScrapeClient returns the client used by Source if App.ScrapeClient
+// is nil. It doesn't share its connection pool with the API client.
+func (app *App) defaultScrapeClient() *http.Client {
+	app.scrapeOnce.Do(func() {
+		app.scraper = &http.Client{Transport: newTransport(app.transportOptions())}
+	})
+	return app.scraper
+}
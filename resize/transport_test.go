@@ -0,0 +1,71 @@
+package resize
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	var attempts int
+	var bodies []string
+	addrs := make(map[string]bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		addrs[r.RemoteAddr] = true
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if attempts%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultTransportOptions
+	app.Transport = &opts
+	client := app.httpClient()
+	if client != app.httpClient() {
+		t.Errorf("expected the client to be reused")
+	}
+
+	resp, err := client.Post(s.URL, "text/plain", strings.NewReader("Action=DescribeInstances"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != "ok" {
+		t.Fatalf("expected the request to succeed after retries, got %s %q", resp.Status, b)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	for i, body := range bodies {
+		if body != "Action=DescribeInstances" {
+			t.Errorf("attempt %d: expected the body to be resent, got %q", i, body)
+		}
+	}
+	if len(addrs) != 1 {
+		t.Errorf("expected attempts to reuse one connection, got %d", len(addrs))
+	}
+
+	// requests whose body can't be rewound are only made once
+	attempts = 0
+	req, _ := http.NewRequest("POST", s.URL, ioutil.NopCloser(strings.NewReader("x")))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if attempts != 1 || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a single attempt, got %d attempts and %s", attempts, resp.Status)
+	}
+}
@@ -0,0 +1,128 @@
+package resize
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/aws"
+)
+
+var errNoPrices = errors.New("the instance types source doesn't provide prices, so regions can't be compared")
+
+// RegionPrice holds the on-demand price of an instance type in a single
+// region. Available is false if the region's price list doesn't include the
+// type. If the price list couldn't be fetched, Err is set.
+type RegionPrice struct {
+	Region    string
+	Available bool
+	Price     Price
+	Err       error
+}
+
+// pricesByRegion looks up the on-demand price of instance type name in each
+// region using at most workers concurrent lookups. The results are returned
+// in the order of regions, and a failure in one region doesn't affect the
+// others.
+func (app *App) pricesByRegion(name string, regions []string, workers int) []RegionPrice {
+	results := make([]RegionPrice, len(regions))
+	forEachConcurrently(len(regions), workers, func(i int) {
+		result := RegionPrice{Region: regions[i]}
+		prices, _, err := app.prices(regions[i])
+		if err != nil {
+			result.Err = err
+		} else {
+			result.Price, result.Available = prices[name]
+		}
+		results[i] = result
+	})
+	return results
+}
+
+// regionChoice is an entry of the region picker of the compare regions view.
+type regionChoice struct {
+	Name    string
+	Checked bool
+}
+
+// Path: /instance-types/{type}/regions
+//
+// handleTypeRegions shows the regions an instance type is available in and
+// its on-demand price in each. The regions compared are given by the
+// "region" query parameter, which may be repeated. If there are none the
+// app's Regions are compared, or the selected region if those aren't set.
+func (app *App) handleTypeRegions(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if !app.typesAvailable() {
+		app.render404(w, r)
+		return
+	}
+	if _, ok := app.Source.(PriceSource); !ok {
+		app.render500(w, r, errNoPrices)
+		return
+	}
+	name := mux.Vars(r)["type"]
+	types, err := app.TypeCache.InstanceTypesContext(r.Context())
+	if err != nil {
+		app.render503(w, r, err)
+		return
+	}
+	typ, ok := FindType(types, name)
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+
+	selected := r.URL.Query()["region"]
+	for _, region := range selected {
+		if _, ok := aws.Regions[region]; !ok {
+			app.render400(w, r, fmt.Errorf("No AWS region named %s", region))
+			return
+		}
+	}
+	if len(selected) == 0 {
+		selected = app.Regions
+	}
+	if len(selected) == 0 {
+		selected = []string{ec2Cli.Region.Name}
+	}
+	checked := make(map[string]bool)
+	for _, region := range selected {
+		checked[region] = true
+	}
+	selected = make([]string, 0, len(checked))
+	for region := range checked {
+		selected = append(selected, region)
+	}
+	sort.Strings(selected)
+	choices := make([]regionChoice, 0, len(aws.Regions))
+	for _, region := range regionList("") {
+		choices = append(choices, regionChoice{Name: region.Name, Checked: checked[region.Name]})
+	}
+
+	results := app.pricesByRegion(typ.Name, selected, app.regionConcurrency())
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			app.LogfContext(r.Context(), "could not get prices for %s: %v", result.Region, result.Err)
+			failed++
+		}
+	}
+	data := map[string]interface{}{
+		"Type":    typ,
+		"Results": results,
+		"Failed":  failed,
+		"Choices": choices,
+	}
+	app.render(w, r, "type-regions.html", data)
+}
@@ -0,0 +1,100 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const m5OnlyOffer = `{
+  "products": {
+    "SKU1": {
+      "productFamily": "Compute Instance",
+      "attributes": {"instanceType": "m5.large", "vcpu": "2", "memory": "8 GiB", "operatingSystem": "Linux", "tenancy": "Shared"}
+    }
+  },
+  "terms": {"OnDemand": {"SKU1": {"SKU1.T": {"priceDimensions": {"SKU1.T.D": {"unit": "Hrs", "pricePerUnit": {"USD": "0.1070000000"}}}}}}}
+}`
+
+func TestTypeRegions(t *testing.T) {
+	offers := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/us-east-1/index.json":
+			http.ServeFile(w, r, filepath.Join("testdata", "offer.json"))
+		case "/eu-west-1/index.json":
+			fmt.Fprint(w, m5OnlyOffer)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer offers.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Transport = &TransportOptions{MaxTries: 1}
+	app.Source, err = NewPriceListSource("us-east-1", offers.URL+"/%s/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := app.set(w, r, ec2.New(aws.Auth{AccessKey: "foo", SecretKey: "bar"}, aws.USEast)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	w = get("/instance-types/t2.micro/regions?region=us-east-1&region=us-west-2&region=eu-west-1&region=us-east-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	rows := regexp.MustCompile(`(?s)<tr>\s*<td>([a-z0-9-]+)</td>(.*?)</tr>`).FindAllStringSubmatch(body, -1)
+	if len(rows) != 3 {
+		t.Fatalf("expected a row per region, got %d", len(rows))
+	}
+	expected := []struct{ region, cell string }{
+		{"eu-west-1", "<td>no</td>"},
+		{"us-east-1", "$0.0116/hr"},
+		{"us-west-2", "text-danger"},
+	}
+	for i, e := range expected {
+		if rows[i][1] != e.region || !strings.Contains(rows[i][2], e.cell) {
+			t.Errorf("row %d: expected %s with %q, got %s %q", i, e.region, e.cell, rows[i][1], rows[i][2])
+		}
+	}
+	if !strings.Contains(body, "could not be fetched in 1 region(s)") {
+		t.Errorf("expected a warning about the failed region")
+	}
+	if !strings.Contains(body, `value="eu-west-1" checked`) || strings.Contains(body, `value="ap-southeast-1" checked`) {
+		t.Errorf("expected only the compared regions to be checked")
+	}
+
+	// the selected region is compared by default
+	if body := get("/instance-types/t2.micro/regions").Body.String(); !strings.Contains(body, "<td>us-east-1</td>") {
+		t.Errorf("expected the selected region to be compared by default")
+	}
+	if w := get("/instance-types/x9.huge/regions"); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown type to be not found, got %d", w.Code)
+	}
+	if w := get("/instance-types/t2.micro/regions?region=moon-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown region to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,379 @@
+package resize
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// processorVendors maps a lower cased vendor name to the substrings which
+// identify its processors in the Processor field of an InstanceType.
+var processorVendors = map[string][]string{
+	"intel": {"intel"},
+	"amd":   {"amd"},
+	"aws":   {"aws", "graviton"},
+}
+
+// virtualizationTypes lists the virtualization types supported by previous
+// generation instance families. All other families only support HVM.
+var virtualizationTypes = map[string][]string{
+	"t1":  {"paravirtual"},
+	"m1":  {"paravirtual"},
+	"m2":  {"paravirtual"},
+	"c1":  {"paravirtual"},
+	"m3":  {"hvm", "paravirtual"},
+	"c3":  {"hvm", "paravirtual"},
+	"hi1": {"hvm", "paravirtual"},
+	"hs1": {"hvm", "paravirtual"},
+}
+
+// nitroFamilies lists the instance families built on the Nitro system which
+// predate the fifth generation. Families of the fifth generation and later are
+// all Nitro based.
+var nitroFamilies = map[string]bool{
+	"a1":   true,
+	"t3":   true,
+	"t3a":  true,
+	"t4g":  true,
+	"z1d":  true,
+	"g4ad": true,
+	"g4dn": true,
+	"i3en": true,
+	"p3dn": true,
+	"inf1": true,
+}
+
+// typeFamily returns the family of an instance type, e.g. "m3" for
+// "m3.large".
+func typeFamily(name string) string {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// FindType looks up an instance type by name. Names are compared ignoring
+// case and whitespace, so " M5.Large" finds "m5.large", though an exact match
+// is preferred. If there's no such type, it returns an InstanceType with only
+// the given name set and false.
+func FindType(types []InstanceType, name string) (InstanceType, bool) {
+	for _, t := range types {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	key := normalizeTypeName(name)
+	for _, t := range types {
+		if normalizeTypeName(t.Name) == key {
+			return t, true
+		}
+	}
+	return InstanceType{Name: name}, false
+}
+
+// normalizeTypeName lower cases an instance type name and removes any
+// whitespace from it.
+func normalizeTypeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// GroupByFamily groups instance types by their family, derived from the
+// prefix of their name, e.g. "m5" for both "m5.24xlarge" and "m5.metal".
+// Types keep their order within each family.
+func GroupByFamily(types []InstanceType) map[string][]InstanceType {
+	groups := make(map[string][]InstanceType)
+	for _, t := range types {
+		family := typeFamily(t.Name)
+		groups[family] = append(groups[family], t)
+	}
+	return groups
+}
+
+// TypeFamily is a family of instance types, as listed by Families.
+type TypeFamily struct {
+	Name  string
+	Types []InstanceType
+}
+
+// Families returns the groups of GroupByFamily ordered by family name, for
+// rendering in a stable order.
+func Families(types []InstanceType) []TypeFamily {
+	groups := GroupByFamily(types)
+	families := make([]TypeFamily, 0, len(groups))
+	for name, types := range groups {
+		families = append(families, TypeFamily{Name: name, Types: types})
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// supportsVirtualization reports if instances of the named type can run AMIs
+// of the given virtualization type ("hvm" or "paravirtual").
+func supportsVirtualization(name, virtType string) bool {
+	for _, v := range typeVirtualizations(name) {
+		if v == virtType {
+			return true
+		}
+	}
+	return false
+}
+
+// typeVirtualizations returns the virtualization types supported by the named
+// instance type.
+func typeVirtualizations(name string) []string {
+	if supported, ok := virtualizationTypes[typeFamily(name)]; ok {
+		return supported
+	}
+	return []string{"hvm"}
+}
+
+// ArchitectureOf returns the architecture of an instance type's processor,
+// "arm64" for AWS Graviton processors and "x86_64" otherwise. The type's
+// Architecture is used if set. When the processor isn't known, Graviton
+// families are recognized by the "g" following their generation, e.g. "m6g"
+// or "c6gn".
+func ArchitectureOf(t InstanceType) string {
+	if t.Architecture != "" {
+		return t.Architecture
+	}
+	if t.Processor != "" {
+		if strings.Contains(strings.ToLower(t.Processor), "graviton") {
+			return "arm64"
+		}
+		return "x86_64"
+	}
+	family := typeFamily(t.Name)
+	if family == "a1" {
+		return "arm64"
+	}
+	i := strings.IndexFunc(family, unicode.IsDigit)
+	if i >= 0 && strings.Contains(family[i+1:], "g") {
+		return "arm64"
+	}
+	return "x86_64"
+}
+
+// typeHypervisor returns the hypervisor of the named instance type, "nitro"
+// for Nitro based families and bare metal types, and "xen" otherwise.
+func typeHypervisor(name string) string {
+	family := typeFamily(name)
+	if nitroFamilies[family] || strings.HasSuffix(name, ".metal") || strings.HasPrefix(family, "u-") {
+		return "nitro"
+	}
+	i := strings.IndexFunc(family, unicode.IsDigit)
+	if i >= 0 && family[i] >= '5' {
+		return "nitro"
+	}
+	return "xen"
+}
+
+// IsNitro reports if t is built on the Nitro system, whose instances require
+// the ENA and NVMe drivers.
+func IsNitro(t InstanceType) bool {
+	if t.Hypervisor != "" {
+		return t.Hypervisor == "nitro"
+	}
+	return typeHypervisor(t.Name) == "nitro"
+}
+
+// burstableBaselines lists the baseline CPU utilization, per vCPU and in
+// percent, of burstable performance types. Instances of these types sustain
+// their baseline and accrue CPU credits while below it, which they spend to
+// burst above it.
+var burstableBaselines = map[string]float64{
+	"t2.nano": 5, "t2.micro": 10, "t2.small": 20, "t2.medium": 20,
+	"t2.large": 30, "t2.xlarge": 22.5, "t2.2xlarge": 17,
+	"t3.nano": 5, "t3.micro": 10, "t3.small": 20, "t3.medium": 20,
+	"t3.large": 30, "t3.xlarge": 40, "t3.2xlarge": 40,
+	"t3a.nano": 5, "t3a.micro": 10, "t3a.small": 20, "t3a.medium": 20,
+	"t3a.large": 30, "t3a.xlarge": 40, "t3a.2xlarge": 40,
+	"t4g.nano": 5, "t4g.micro": 10, "t4g.small": 20, "t4g.medium": 20,
+	"t4g.large": 30, "t4g.xlarge": 40, "t4g.2xlarge": 40,
+}
+
+// IsBurstable reports if t is a burstable performance type. Types are
+// burstable if they're marked as such or have a baseline, and otherwise if
+// they're of the T family, e.g. "t3" or "t4g".
+func IsBurstable(t InstanceType) bool {
+	if t.Burstable || t.BaselinePercent > 0 {
+		return true
+	}
+	family := typeFamily(t.Name)
+	return len(family) > 1 && family[0] == 't' && unicode.IsDigit(rune(family[1]))
+}
+
+// BaselineOf returns the baseline CPU utilization per vCPU of t in percent,
+// from burstableBaselines if t doesn't state it. It's zero for types which
+// aren't burstable or whose baseline isn't known.
+func BaselineOf(t InstanceType) float64 {
+	if t.BaselinePercent > 0 {
+		return t.BaselinePercent
+	}
+	return burstableBaselines[t.Name]
+}
+
+// burstableWarning describes how the CPU performance of an instance changes
+// when it's resized from one type to another, at least one of which is
+// burstable. It returns an empty string if neither is.
+func burstableWarning(from, to InstanceType) string {
+	baseline := func(t InstanceType) string {
+		if b := BaselineOf(t); b > 0 {
+			return trimFloat(b, 1) + "%"
+		}
+		return "an unknown baseline"
+	}
+	switch fromBurst, toBurst := IsBurstable(from), IsBurstable(to); {
+	case fromBurst && toBurst:
+		if BaselineOf(from) == BaselineOf(to) {
+			return ""
+		}
+		return fmt.Sprintf("%s and %s are burstable performance types. The instance's baseline will change from %s to %s of each vCPU, which it can only exceed while it has CPU credits.",
+			from.Name, to.Name, baseline(from), baseline(to))
+	case toBurst:
+		return fmt.Sprintf("%s is a burstable performance type. Unlike %s, it sustains %s of each vCPU and can only exceed that while it has CPU credits.",
+			to.Name, from.Name, baseline(to))
+	case fromBurst:
+		return fmt.Sprintf("%s is not a burstable performance type. Unlike %s, it doesn't depend on CPU credits, and can use all of its vCPUs at any time.",
+			to.Name, from.Name)
+	}
+	return ""
+}
+
+// CompatibleTypes returns the instance types of all which an instance of the
+// current type can be resized to. A type is compatible if it has the same
+// processor architecture and supports one of the virtualization types of the
+// current type. The current type itself is never returned.
+func CompatibleTypes(current InstanceType, all []InstanceType) []InstanceType {
+	arch := ArchitectureOf(current)
+	virtTypes := typeVirtualizations(current.Name)
+	compatible := []InstanceType{}
+	for _, t := range all {
+		if t.Name == current.Name || ArchitectureOf(t) != arch {
+			continue
+		}
+		for _, v := range virtTypes {
+			if supportsVirtualization(t.Name, v) {
+				compatible = append(compatible, t)
+				break
+			}
+		}
+	}
+	return compatible
+}
+
+// FilterByProcessor returns the instance types whose processor is made by
+// vendor. Recognized vendors are "Intel", "AMD" and "AWS" (Graviton), matched
+// case-insensitively. An empty or unknown vendor returns types unchanged.
+func FilterByProcessor(types []InstanceType, vendor string) []InstanceType {
+	substrs, ok := processorVendors[strings.ToLower(strings.TrimSpace(vendor))]
+	if !ok {
+		return types
+	}
+	filtered := []InstanceType{}
+	for _, t := range types {
+		processor := strings.ToLower(t.Processor)
+		for _, substr := range substrs {
+			if strings.Contains(processor, substr) {
+				filtered = append(filtered, t)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// typeSortKeys maps the sort keys accepted by SortTypes to a comparison of
+// two instance types.
+var typeSortKeys = map[string]func(a, b InstanceType) bool{
+	"name":       func(a, b InstanceType) bool { return a.Name < b.Name },
+	"cpus":       func(a, b InstanceType) bool { return a.CPUs < b.CPUs },
+	"memory":     func(a, b InstanceType) bool { return a.Memory < b.Memory },
+	"clockspeed": func(a, b InstanceType) bool { return a.ClockSpeed < b.ClockSpeed },
+	"network": func(a, b InstanceType) bool {
+		return ParseNetworkTier(a.NetworkSpec) < ParseNetworkTier(b.NetworkSpec)
+	},
+}
+
+// SortTypes returns a copy of types sorted by key, one of "name", "cpus",
+// "memory", "clockspeed" or "network". Types are sorted in ascending order unless order
+// is "desc". An unknown key sorts by name, and types which compare equal are
+// ordered by name.
+func SortTypes(types []InstanceType, key, order string) []InstanceType {
+	less, ok := typeSortKeys[key]
+	if !ok {
+		less = typeSortKeys["name"]
+	}
+	desc := order == "desc"
+	sorted := make([]InstanceType, len(types))
+	copy(sorted, types)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Name < b.Name
+	})
+	return sorted
+}
+
+// typeDifference is a row of the comparison of two instance types.
+type typeDifference struct {
+	Field   string
+	From    string
+	To      string
+	Changed bool
+}
+
+// compareTypes lists the attributes of two instance types side by side,
+// marking those which differ.
+func compareTypes(from, to InstanceType) []typeDifference {
+	price := func(t InstanceType) string {
+		if t.Price == 0 {
+			return "n/a"
+		}
+		return humanPrice(t.Price, t.PriceUnit)
+	}
+	burstable := func(t InstanceType) string {
+		if IsBurstable(t) {
+			return "Yes"
+		}
+		return "No"
+	}
+	baseline := func(t InstanceType) string {
+		if !IsBurstable(t) {
+			return "100%"
+		}
+		if b := BaselineOf(t); b > 0 {
+			return trimFloat(b, 1) + "%"
+		}
+		return "n/a"
+	}
+	rows := []struct {
+		field    string
+		from, to string
+	}{
+		{"vCPUs", fmt.Sprint(from.CPUs), fmt.Sprint(to.CPUs)},
+		{"Memory (GiB)", fmt.Sprint(from.Memory), fmt.Sprint(to.Memory)},
+		{"Network", from.NetworkSpec, to.NetworkSpec},
+		{"Price", price(from), price(to)},
+		{"Storage (GB)", from.Storage, to.Storage},
+		{"Processor", from.Processor, to.Processor},
+		{"Clock Speed (GHz)", fmt.Sprint(from.ClockSpeed), fmt.Sprint(to.ClockSpeed)},
+		{"Architecture", ArchitectureOf(from), ArchitectureOf(to)},
+		{"Hypervisor", from.Hypervisor, to.Hypervisor},
+		{"Burstable", burstable(from), burstable(to)},
+		{"Baseline / vCPU", baseline(from), baseline(to)},
+	}
+	diffs := make([]typeDifference, len(rows))
+	for i, r := range rows {
+		diffs[i] = typeDifference{Field: r.field, From: r.from, To: r.to, Changed: r.from != r.to}
+	}
+	return diffs
+}
@@ -0,0 +1,343 @@
+package resize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func typeNames(types []InstanceType) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.Name
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFilterByProcessor(t *testing.T) {
+	types := []InstanceType{
+		{Name: "m5.large", Processor: "Intel Xeon Platinum 8175"},
+		{Name: "m5a.large", Processor: "AMD EPYC 7571"},
+		{Name: "m6g.large", Processor: "AWS Graviton2 Processor"},
+		{Name: "a1.large", Processor: "Custom built Graviton Processor"},
+	}
+	tests := []struct {
+		vendor string
+		want   []string
+	}{
+		{"Intel", []string{"m5.large"}},
+		{"amd", []string{"m5a.large"}},
+		{"AWS", []string{"m6g.large", "a1.large"}},
+		{"", []string{"m5.large", "m5a.large", "m6g.large", "a1.large"}},
+		{"ibm", []string{"m5.large", "m5a.large", "m6g.large", "a1.large"}},
+	}
+	for _, tt := range tests {
+		got := typeNames(FilterByProcessor(types, tt.vendor))
+		if !equalNames(got, tt.want) {
+			t.Errorf("FilterByProcessor(%q) = %v, want %v", tt.vendor, got, tt.want)
+		}
+	}
+}
+
+func TestSupportsVirtualization(t *testing.T) {
+	tests := []struct {
+		name     string
+		virtType string
+		want     bool
+	}{
+		{"m1.small", "paravirtual", true},
+		{"m1.small", "hvm", false},
+		{"m3.large", "paravirtual", true},
+		{"m3.large", "hvm", true},
+		{"t2.micro", "paravirtual", false},
+		{"t2.micro", "hvm", true},
+	}
+	for _, tt := range tests {
+		if got := supportsVirtualization(tt.name, tt.virtType); got != tt.want {
+			t.Errorf("supportsVirtualization(%q, %q) = %v, want %v", tt.name, tt.virtType, got, tt.want)
+		}
+	}
+}
+
+func TestFindType(t *testing.T) {
+	types := []InstanceType{
+		{Name: "m5.large", Memory: 8},
+		{Name: "t2.micro", Memory: 1},
+	}
+	tests := []struct {
+		name  string
+		want  string
+		found bool
+	}{
+		{"m5.large", "m5.large", true},
+		{"t2.micro", "t2.micro", true},
+		{"M5.Large", "m5.large", true},
+		{" m5.large\t", "m5.large", true},
+		{"T2. MICRO", "t2.micro", true},
+		{"m5.xlarge", "m5.xlarge", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := FindType(types, tt.name)
+		if got.Name != tt.want || ok != tt.found {
+			t.Errorf("FindType(%q) = %q, %v, want %q, %v", tt.name, got.Name, ok, tt.want, tt.found)
+		}
+	}
+	if got, _ := FindType(types, "M5.LARGE"); got.Memory != 8 {
+		t.Errorf("expected the matching type to be returned, got %+v", got)
+	}
+}
+
+func TestCompatibleTypes(t *testing.T) {
+	all := []InstanceType{
+		{Name: "m1.small", Processor: "Intel Xeon Family"},
+		{Name: "m1.large", Processor: "Intel Xeon Family"},
+		{Name: "m3.large", Processor: "Intel Xeon E5-2670 v2"},
+		{Name: "t2.micro", Processor: "Intel Xeon Family"},
+		{Name: "m5.large", Processor: "Intel Xeon Platinum 8175"},
+		{Name: "m6g.large", Processor: "AWS Graviton2 Processor"},
+		{Name: "c6g.large"},
+		{Name: "g4dn.xlarge"},
+		{Name: "g5g.xlarge", Architecture: "arm64"},
+	}
+	tests := []struct {
+		current string
+		want    []string
+	}{
+		// PV only types can't move to HVM only types
+		{"m1.small", []string{"m1.large", "m3.large"}},
+		{"m3.large", []string{"m1.small", "m1.large", "t2.micro", "m5.large", "g4dn.xlarge"}},
+		{"t2.micro", []string{"m3.large", "m5.large", "g4dn.xlarge"}},
+		{"m6g.large", []string{"c6g.large", "g5g.xlarge"}},
+	}
+	for _, tt := range tests {
+		current, _ := FindType(all, tt.current)
+		got := typeNames(CompatibleTypes(current, all))
+		if !equalNames(got, tt.want) {
+			t.Errorf("CompatibleTypes(%q) = %v, want %v", tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestArchitectureOf(t *testing.T) {
+	for _, tt := range []struct {
+		typ  InstanceType
+		want string
+	}{
+		{InstanceType{Name: "m6g.large"}, "arm64"},
+		{InstanceType{Name: "c6g.xlarge"}, "arm64"},
+		{InstanceType{Name: "c6gn.medium"}, "arm64"},
+		{InstanceType{Name: "g5g.xlarge"}, "arm64"},
+		{InstanceType{Name: "a1.medium"}, "arm64"},
+		// GPU families are x86 unless they're Graviton based
+		{InstanceType{Name: "g4dn.xlarge"}, "x86_64"},
+		{InstanceType{Name: "g3.4xlarge"}, "x86_64"},
+		{InstanceType{Name: "m5.large"}, "x86_64"},
+		{InstanceType{Name: "m6g.large", Processor: "AWS Graviton2 Processor"}, "arm64"},
+		{InstanceType{Name: "g4dn.xlarge", Processor: "Intel Xeon Family"}, "x86_64"},
+		{InstanceType{Name: "m6g.large", Architecture: "x86_64"}, "x86_64"},
+	} {
+		if got := ArchitectureOf(tt.typ); got != tt.want {
+			t.Errorf("ArchitectureOf(%+v) = %s, want %s", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestCompatibleTypesPVInstance(t *testing.T) {
+	all := []InstanceType{
+		{Name: "m1.large"},
+		{Name: "m3.large"},
+		{Name: "m3.xlarge"},
+		{Name: "m5.large"},
+	}
+	inst := ec2.Instance{InstanceType: "m3.large", VirtType: "paravirtual"}
+	got := typeNames(compatibleTypes(inst, all))
+	want := []string{"m1.large", "m3.xlarge"}
+	if !equalNames(got, want) {
+		t.Errorf("compatibleTypes(paravirtual m3.large) = %v, want %v", got, want)
+	}
+}
+
+func TestSortTypes(t *testing.T) {
+	types := []InstanceType{
+		{Name: "m5.large", CPUs: 2, Memory: 8, ClockSpeed: 3.1},
+		{Name: "c5.xlarge", CPUs: 4, Memory: 8, ClockSpeed: 3.4},
+		{Name: "t2.micro", CPUs: 1, Memory: 1, ClockSpeed: 2.5},
+	}
+	tests := []struct {
+		key, order string
+		want       []string
+	}{
+		{"name", "asc", []string{"c5.xlarge", "m5.large", "t2.micro"}},
+		{"name", "desc", []string{"t2.micro", "m5.large", "c5.xlarge"}},
+		{"cpus", "asc", []string{"t2.micro", "m5.large", "c5.xlarge"}},
+		{"memory", "asc", []string{"t2.micro", "c5.xlarge", "m5.large"}},
+		{"clockspeed", "desc", []string{"c5.xlarge", "m5.large", "t2.micro"}},
+		{"price", "", []string{"c5.xlarge", "m5.large", "t2.micro"}},
+		{"", "", []string{"c5.xlarge", "m5.large", "t2.micro"}},
+	}
+	for _, tt := range tests {
+		got := typeNames(SortTypes(types, tt.key, tt.order))
+		if !equalNames(got, tt.want) {
+			t.Errorf("SortTypes(%q, %q) = %v, want %v", tt.key, tt.order, got, tt.want)
+		}
+	}
+	if types[0].Name != "m5.large" {
+		t.Errorf("SortTypes modified its argument")
+	}
+}
+
+func TestCompareTypes(t *testing.T) {
+	from := InstanceType{Name: "m5.large", CPUs: 2, Memory: 8, NetworkSpec: "Up to 10 Gigabit", Price: 0.096, PriceUnit: "Hrs"}
+	to := InstanceType{Name: "m5.xlarge", CPUs: 4, Memory: 16, NetworkSpec: "Up to 10 Gigabit"}
+	changed := map[string]bool{}
+	for _, d := range compareTypes(from, to) {
+		changed[d.Field] = d.Changed
+		if d.Field == "Price" && d.To != "n/a" {
+			t.Errorf("expected unknown price to be shown as n/a, got %q", d.To)
+		}
+	}
+	for field, want := range map[string]bool{
+		"vCPUs":        true,
+		"Memory (GiB)": true,
+		"Network":      false,
+		"Price":        true,
+	} {
+		if changed[field] != want {
+			t.Errorf("%s: expected changed to be %v", field, want)
+		}
+	}
+}
+
+func TestBurstable(t *testing.T) {
+	micro := InstanceType{Name: "t3.micro", CPUs: 2}
+	small := InstanceType{Name: "t4g.small", CPUs: 2}
+	large := InstanceType{Name: "m5.large", CPUs: 2}
+	for _, tt := range []struct {
+		t         InstanceType
+		burstable bool
+		baseline  float64
+	}{
+		{micro, true, 10},
+		{small, true, 20},
+		{large, false, 0},
+		{InstanceType{Name: "t3.micro", BaselinePercent: 12.5}, true, 12.5},
+		{InstanceType{Name: "t5.large"}, true, 0},
+	} {
+		if got := IsBurstable(tt.t); got != tt.burstable {
+			t.Errorf("IsBurstable(%s) = %v, want %v", tt.t.Name, got, tt.burstable)
+		}
+		if got := BaselineOf(tt.t); got != tt.baseline {
+			t.Errorf("BaselineOf(%s) = %v, want %v", tt.t.Name, got, tt.baseline)
+		}
+	}
+
+	for _, tt := range []struct {
+		from, to InstanceType
+		want     string
+	}{
+		{large, micro, "sustains 10% of each vCPU"},
+		{small, large, "m5.large is not a burstable performance type"},
+		{micro, InstanceType{Name: "t3.small"}, "from 10% to 20% of each vCPU"},
+		{InstanceType{Name: "t3.small"}, InstanceType{Name: "t3.medium"}, ""},
+		{large, InstanceType{Name: "m5.xlarge"}, ""},
+	} {
+		got := burstableWarning(tt.from, tt.to)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("burstableWarning(%s, %s) = %q, want %q", tt.from.Name, tt.to.Name, got, tt.want)
+		}
+	}
+
+	rows := map[string]typeDifference{}
+	for _, d := range compareTypes(large, micro) {
+		rows[d.Field] = d
+	}
+	if d := rows["Baseline / vCPU"]; d.From != "100%" || d.To != "10%" || !d.Changed {
+		t.Errorf("unexpected baseline comparison %+v", d)
+	}
+}
+
+func TestTypeHypervisor(t *testing.T) {
+	for name, want := range map[string]string{
+		"t2.micro":      "xen",
+		"m4.large":      "xen",
+		"c4.8xlarge":    "xen",
+		"p3.16xlarge":   "xen",
+		"i3.large":      "xen",
+		"m1.small":      "xen",
+		"t3.micro":      "nitro",
+		"a1.medium":     "nitro",
+		"z1d.large":     "nitro",
+		"i3en.large":    "nitro",
+		"p3dn.24xlarge": "nitro",
+		"i3.metal":      "nitro",
+		"u-6tb1.metal":  "nitro",
+		"m5.large":      "nitro",
+		"c6gn.medium":   "nitro",
+		"r7i.large":     "nitro",
+	} {
+		if got := typeHypervisor(name); got != want {
+			t.Errorf("typeHypervisor(%q) = %q, want %q", name, got, want)
+		}
+		if got := IsNitro(InstanceType{Name: name}); got != (want == "nitro") {
+			t.Errorf("IsNitro(%q) = %v", name, got)
+		}
+	}
+	// a parsed hypervisor takes precedence over the family
+	if IsNitro(InstanceType{Name: "m5.large", Hypervisor: "xen"}) {
+		t.Errorf("expected the parsed hypervisor to be used")
+	}
+}
+
+func TestFamilies(t *testing.T) {
+	types := []InstanceType{
+		{Name: "m5.large"},
+		{Name: "c5.xlarge"},
+		{Name: "m5.24xlarge"},
+		{Name: "t3.micro"},
+		{Name: "m5.metal"},
+		{Name: "c5n.metal"},
+		{Name: "c5.large"},
+	}
+	groups := GroupByFamily(types)
+	if len(groups) != 4 {
+		t.Errorf("expected 4 families, got %d", len(groups))
+	}
+	want := []struct {
+		family string
+		names  []string
+	}{
+		{"c5", []string{"c5.xlarge", "c5.large"}},
+		{"c5n", []string{"c5n.metal"}},
+		{"m5", []string{"m5.large", "m5.24xlarge", "m5.metal"}},
+		{"t3", []string{"t3.micro"}},
+	}
+	families := Families(types)
+	if len(families) != len(want) {
+		t.Fatalf("expected %d families, got %d", len(want), len(families))
+	}
+	for i, tt := range want {
+		if families[i].Name != tt.family {
+			t.Errorf("family %d: expected %s, got %s", i, tt.family, families[i].Name)
+		}
+		if got := typeNames(families[i].Types); !equalNames(got, tt.names) {
+			t.Errorf("%s: expected %v, got %v", tt.family, tt.names, got)
+		}
+		if got := typeNames(groups[tt.family]); !equalNames(got, tt.names) {
+			t.Errorf("%s: expected group %v, got %v", tt.family, tt.names, got)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package resize
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// Build information, set when building with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/yhat/resize/resize.Version=1.2.0 \
+//		-X github.com/yhat/resize/resize.Commit=$(git rev-parse HEAD) \
+//		-X github.com/yhat/resize/resize.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// buildInfo is the JSON response of handleVersion.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Path: /version
+//
+// handleVersion reports the version of the running build. Like /healthz it
+// doesn't require a login or make any requests to AWS.
+func (app *App) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		app.renderJSONError(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	info := buildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	app.renderJSON(w, info, http.StatusOK)
+}